@@ -0,0 +1,44 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The listActivityHandler() returns the current user's recent activity, for profile
+// pages. It's paginated with a cursor rather than page numbers, so that new activity
+// doesn't shift the pages a client is working through. Clients send the next_cursor
+// from the metadata to get the following page.
+func (app *application) listActivityHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	pageSize := app.readIntRange(qs, "page_size", 20, 1, app.config.pagination.MaxPageSize, v)
+
+	var cursor *data.ActivityCursor
+
+	if s := app.readString(qs, "cursor", ""); s != "" {
+		c, err := data.ParseActivityCursor(s)
+		if err != nil {
+			v.AddError("cursor", "must be a next_cursor from a previous response")
+		}
+		cursor = &c
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	activities, metadata, err := app.models.Activity.GetForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, cursor, pageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "activity": activities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// The adminStatsHandler() summarizes the activity on the service for an operations
+// dashboard. The daily counts cover the number of days in the days query string
+// parameter, ending today.
+func (app *application) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	days := app.readInt(r.URL.Query(), "days", 30, v)
+	v.Check(days > 0, "days", "must be greater than zero")
+	v.Check(days <= 365, "days", "must be a maximum of 365")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, err := app.models.Stats.Users()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokens, err := app.models.Stats.TokensPerDay(days)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := app.models.Stats.MoviesPerDay(days)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	requests, err := app.models.Stats.RequestsPerDay(days)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	genres, err := app.models.Stats.TopGenres(10)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats := struct {
+		Users          data.UserStats    `json:"users"`
+		TokensPerDay   []data.DailyCount `json:"tokens_issued_per_day"`
+		MoviesPerDay   []data.DailyCount `json:"movies_created_per_day"`
+		RequestsPerDay []data.DailyCount `json:"requests_per_day"`
+		TopGenres      []data.GenreCount `json:"top_genres"`
+	}{users, tokens, movies, requests, genres}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The adminSystemHandler() reports on the health of the running process and its
+// database: the connection pool, table sizes, the background and job queues, and the
+// rate limiter. It's a richer version of /debug/vars for operators.
+func (app *application) adminSystemHandler(w http.ResponseWriter, r *http.Request) {
+	tables, err := app.models.Stats.Tables()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	jobs, err := app.models.Jobs.CountByStatus()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	system := map[string]any{
+		"version":    version,
+		"goroutines": runtime.NumGoroutine(),
+		"memory": map[string]any{
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"sys_bytes":        mem.Sys,
+			"num_gc":           mem.NumGC,
+		},
+		"database": map[string]any{
+			"pool":   app.db.Stats(),
+			"tables": tables,
+		},
+		"background": map[string]any{
+			"queued":   app.workers.QueueDepth(),
+			"running":  app.workers.Running(),
+			"capacity": app.config.worker.queueSize,
+		},
+		"jobs": jobs,
+		"rate_limiter": map[string]any{
+			"enabled":         app.config.limiter.enabled,
+			"rps":             app.config.limiter.rps,
+			"burst":           app.config.limiter.burst,
+			"tracked_clients": app.limiterStats.clients.Load(),
+			"rejected_total":  app.limiterStats.rejected.Load(),
+		},
+		"event_subscribers": app.events.count(),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"system": system}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	permission, ok := app.permissionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"permission": permission}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createPermissionHandler() adds a new code to the permission catalog, so that it
+// can be granted to users when the feature it protects is rolled out.
+func (app *application) createPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	permission := &data.Permission{
+		Code:        input.Code,
+		Description: input.Description,
+		Active:      true,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePermission(v, permission); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.Insert(permission)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePermission):
+			v.AddError("code", "a permission with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "permission", permission.ID, map[string]string{"code": permission.Code})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/permissions/%d", permission.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"permission": permission}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updatePermissionHandler() changes a permission's description, or deactivates (or
+// reactivates) it. The code itself can't be changed.
+func (app *application) updatePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	permission, ok := app.permissionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Description *string `json:"description"`
+		Active      *bool   `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Description != nil {
+		permission.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	if input.Active != nil {
+		// Deactivating admin:write would leave nobody able to reactivate it.
+		v.Check(*input.Active || permission.Code != "admin:write", "active", "the admin:write permission cannot be deactivated")
+		permission.Active = *input.Active
+	}
+
+	if data.ValidatePermission(v, permission); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.Update(permission)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "permission", permission.ID, input)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permission": permission}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The grantUserPermissionsHandler() grants permission codes from the catalog to a user.
+// Codes the user already holds are left as they are, so it's safe to retry. Like
+// being given a role, the user is alerted.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	catalog, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	for _, code := range codes {
+		i := slices.IndexFunc(catalog, func(p *data.Permission) bool { return p.Code == code })
+		if i == -1 || !catalog[i].Active {
+			v.AddError("permissions", "must only contain active permissions from GET /v1/admin/permissions; unknown: "+code)
+			break
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "grant_permissions", "user", user.ID, map[string][]string{"permissions": codes})
+	app.securityEvent(r, user.ID, data.SecurityPermissionsGranted, data.SeverityWarning, map[string][]string{"permissions": codes})
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+// The revokeUserPermissionsHandler() takes permission codes away from a user. Codes
+// which one of the user's roles gives them stay in effect until the role is taken away
+// too. Operators can't revoke their own admin:write, which could leave nobody able to
+// grant it back.
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := app.readPermissionCodes(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if user.ID == app.contextGetUser(r).ID {
+		v.Check(!slices.Contains(codes, "admin:write"), "permissions", "must not contain admin:write when revoking your own permissions")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Permissions.DeleteForUser(user.ID, codes...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("permissions", "must contain at least one permission the user was granted directly")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "revoke_permissions", "user", user.ID, map[string][]string{"permissions": codes})
+	app.securityEvent(r, user.ID, data.SecurityPermissionsRevoked, data.SeverityInfo, map[string][]string{"permissions": codes})
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+// The readPermissionCodes() helper reads the list of permission codes in the body of a
+// grant or revoke request. If it's missing or invalid it sends an error response and
+// returns false.
+func (app *application) readPermissionCodes(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least one permission code")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range input.Permissions {
+		if !v.Matches(code, data.PermissionCodeRX) {
+			v.AddError("permissions", "must only contain codes in the form resource:action")
+			break
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.Permissions, true
+}
+
+// The writeUserPermissions() helper responds with all of the permissions a user holds,
+// after their permissions have been changed.
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, userID int64) {
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) permissionForRequest(w http.ResponseWriter, r *http.Request) (*data.Permission, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	permission, err := app.models.Permissions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return permission, true
+}
+
+// The rollupMetrics() method is run by the scheduler. It totals the endpoint usage into
+// the daily metrics for yesterday and today; yesterday is included so that requests
+// counted after its last run are picked up.
+func (app *application) rollupMetrics(ctx context.Context) error {
+	n, err := app.models.Stats.RollupDailyMetrics(time.Now().UTC().AddDate(0, 0, -1))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("rolled up daily metrics", "days", n)
+	return nil
+}
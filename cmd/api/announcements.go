@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// announcementPayload is the payload for jobSendAnnouncement jobs.
+type announcementPayload struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// If set, only users with this permission code receive the announcement.
+	Permission string `json:"permission,omitempty"`
+}
+
+// The createAnnouncementHandler() queues an announcement email to every activated
+// user, or to those holding a given permission, who hasn't turned announcements off.
+// Sending happens in a job, whose progress can be followed at the URL in the Location
+// header.
+func (app *application) createAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	var input announcementPayload
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(strings.TrimSpace(input.Subject) != "", "subject", "must be provided")
+	v.Check(len(input.Subject) <= 200, "subject", "must not be more than 200 bytes long")
+	v.Check(!strings.ContainsAny(input.Subject, "\r\n"), "subject", "must not contain line breaks")
+	v.Check(strings.TrimSpace(input.Body) != "", "body", "must be provided")
+	v.Check(len(input.Body) <= 20_000, "body", "must not be more than 20000 bytes long")
+
+	if input.Permission != "" {
+		v.Check(v.Matches(input.Permission, data.PermissionCodeRX), "permission", "must be in the form resource:action")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	job, err := app.enqueueUserJob(user.ID, jobSendAnnouncement, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "announcement", job.ID, map[string]string{"subject": input.Subject, "permission": input.Permission})
+
+	app.jobAcceptedResponse(w, r, job)
+}
+
+// The sendAnnouncementJob() queues an email, and creates an in-app notification, for
+// each recipient of an announcement.
+// The recipients are processed in ID order and progress is saved as we go, so if the
+// job is retried it carries on from where it stopped rather than emailing people
+// twice.
+func (app *application) sendAnnouncementJob(ctx context.Context, job *data.Job) error {
+	var p announcementPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	var users []*data.User
+	if p.Permission != "" {
+		users, err = app.models.Users.GetAllActivatedWithPermission(p.Permission)
+	} else {
+		users, err = app.models.Users.GetAllActivated()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Split the body into paragraphs for the HTML version of the email.
+	var paragraphs []string
+	for _, para := range strings.Split(strings.ReplaceAll(p.Body, "\r\n", "\n"), "\n\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			paragraphs = append(paragraphs, para)
+		}
+	}
+
+	done := min(job.Progress.Done, len(users))
+	app.reportJobProgress(job, done, len(users))
+
+	for _, user := range users[done:] {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := app.notifyUser(user, data.NotifyAnnouncements, "announcement.tmpl", map[string]any{
+			"name":       user.Name,
+			"subject":    p.Subject,
+			"body":       p.Body,
+			"paragraphs": paragraphs,
+		})
+		if err != nil {
+			return fmt.Errorf("queueing email for user %d: %w", user.ID, err)
+		}
+
+		err = app.notifyInApp(user.ID, data.NotifyAnnouncements, &data.Notification{
+			Kind:  data.NotificationAnnouncement,
+			Title: p.Subject,
+			Body:  p.Body,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("creating notification for user %d: %w", user.ID, err)
+		}
+
+		done++
+		if done%50 == 0 {
+			app.reportJobProgress(job, done, len(users))
+		}
+	}
+
+	app.reportJobProgress(job, done, len(users))
+	return nil
+}
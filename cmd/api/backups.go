@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/objectstore"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The createBackupHandler() starts a backup straight away, rather than waiting for
+// the next scheduled one. It runs as a job, whose progress can be followed at the URL
+// in the Location header.
+func (app *application) createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if app.backups == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "backups are not configured on this server")
+		return
+	}
+
+	job, err := app.enqueueUserJob(app.contextGetUser(r).ID, jobExportBackup, struct{}{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "backup", job.ID, nil)
+
+	app.jobAcceptedResponse(w, r, job)
+}
+
+// The exportBackupJob() runs a backup requested through the admin API.
+func (app *application) exportBackupJob(ctx context.Context, job *data.Job) error {
+	return app.exportBackup(ctx)
+}
+
+// The exportBackup() method uploads each of the backup tables as gzipped NDJSON to
+// the object store, under <prefix>/<time>/<table>.ndjson.gz, and then deletes the
+// backups which are older than -backup-retention. It does nothing if backups aren't
+// configured, so that the schedule can stay on by default.
+func (app *application) exportBackup(ctx context.Context) error {
+	if app.backups == nil {
+		return nil
+	}
+
+	prefix := strings.Trim(app.config.backup.prefix, "/") + "/"
+	dir := prefix + time.Now().UTC().Format("20060102T150405Z") + "/"
+
+	counts, err := app.models.Backups.Export(ctx, func(table string) (io.WriteCloser, error) {
+		return newBackupUpload(ctx, app.backups, dir+table+".ndjson.gz")
+	})
+	if err != nil {
+		return err
+	}
+
+	args := []any{"location", dir}
+	for table, n := range counts {
+		args = append(args, table, n)
+	}
+	app.logger.Info("backup uploaded", args...)
+
+	return app.pruneBackups(ctx, prefix)
+}
+
+// The pruneBackups() method deletes the backup files under prefix which are older
+// than the retention period.
+func (app *application) pruneBackups(ctx context.Context, prefix string) error {
+	objects, err := app.backups.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-app.config.backup.retention)
+
+	var deleted int
+	for _, o := range objects {
+		if !o.LastModified.Before(cutoff) {
+			continue
+		}
+
+		err := app.backups.Delete(ctx, o.Key)
+		if err != nil {
+			return err
+		}
+
+		deleted++
+	}
+
+	app.logger.Info("pruned old backups", "deleted", deleted)
+	return nil
+}
+
+// A backupUpload compresses a table into a temporary file, and uploads it to the
+// object store when it's closed. Uploads need to know the size and hash of the body
+// in advance, so the file can't be streamed straight to the store.
+type backupUpload struct {
+	ctx    context.Context
+	store  *objectstore.Client
+	key    string
+	file   *os.File
+	gz     *gzip.Writer
+	digest hash.Hash
+}
+
+func newBackupUpload(ctx context.Context, store *objectstore.Client, key string) (*backupUpload, error) {
+	file, err := os.CreateTemp("", "greenlight-backup-*.ndjson.gz")
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.New()
+
+	return &backupUpload{
+		ctx:    ctx,
+		store:  store,
+		key:    key,
+		file:   file,
+		gz:     gzip.NewWriter(io.MultiWriter(file, digest)),
+		digest: digest,
+	}, nil
+}
+
+func (u *backupUpload) Write(p []byte) (int, error) {
+	return u.gz.Write(p)
+}
+
+func (u *backupUpload) Close() error {
+	defer os.Remove(u.file.Name())
+	defer u.file.Close()
+
+	err := u.gz.Close()
+	if err != nil {
+		return err
+	}
+
+	size, err := u.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return u.store.Put(u.ctx, u.key, u.file, size, hex.EncodeToString(u.digest.Sum(nil)), "application/gzip")
+}
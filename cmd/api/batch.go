@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"time"
+)
+
+// A batchOperation is a single create, update or delete in a POST /v1/batch request.
+type batchOperation struct {
+	Action   string          `json:"action"`
+	Resource string          `json:"resource"`
+	ID       int64           `json:"id,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// A batchResult reports the outcome of a single operation, using the status code the
+// equivalent standalone request would have responded with.
+type batchResult struct {
+	Index  int `json:"index"`
+	Status int `json:"status"`
+	Result any `json:"result,omitempty"`
+	Error  any `json:"error,omitempty"`
+}
+
+// batchError is returned by an operation which failed, and causes the whole batch to be
+// rolled back.
+type batchError struct {
+	status  int
+	message any
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%v", e.message)
+}
+
+// batchOp carries out an operation inside the batch's transaction. It returns the
+// status and result for the operation, and optionally a function to call once the
+// transaction has been committed (for side effects like emitting webhook events).
+type batchOp func(r *http.Request, tx *sql.Tx, op batchOperation) (status int, result any, after func(), err error)
+
+// A batchResource describes a resource which can be changed in a batch.
+type batchResource struct {
+	permission string
+	actions    map[string]batchOp
+}
+
+func (app *application) batchResources() map[string]batchResource {
+	return map[string]batchResource{
+		"movies": {
+			permission: "movies:write",
+			actions: map[string]batchOp{
+				"create": app.batchCreateMovie,
+				"update": app.batchUpdateMovie,
+				"delete": app.batchDeleteMovie,
+			},
+		},
+	}
+}
+
+// The batchHandler() applies a list of operations in order, inside a single
+// transaction. Either every operation succeeds, or none of them are applied and the
+// response reports the operation which failed.
+func (app *application) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= 100, "operations", "must not contain more than 100 operations")
+
+	resources := app.batchResources()
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		resource, ok := resources[op.Resource]
+		if !ok {
+			v.AddError(key+".resource", "must be a supported resource")
+			continue
+		}
+
+		if _, ok := resource.actions[op.Action]; !ok {
+			v.AddError(key+".action", "must be one of create, update or delete")
+		}
+
+		if op.Action != "create" {
+			v.Check(op.ID > 0, key+".id", "must be provided")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check up front that the user has the permissions for every resource in the
+	// batch, so that we don't start a transaction we know will fail.
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, op := range input.Operations {
+		if !permissions.Include(resources[op.Resource].permission) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	results := make([]batchResult, 0, len(input.Operations))
+	var afterCommit []func()
+
+	for i, op := range input.Operations {
+		status, result, after, err := resources[op.Resource].actions[op.Action](r, tx, op)
+		if err != nil {
+			var bErr *batchError
+			if !errors.As(err, &bErr) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			results = append(results, batchResult{Index: i, Status: bErr.status, Error: bErr.message})
+
+			env := envelope{
+				"error":   fmt.Sprintf("operation %d failed, so no changes were applied", i),
+				"results": results,
+			}
+
+			err = app.writeJSON(w, bErr.status, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		results = append(results, batchResult{Index: i, Status: status, Result: result})
+		if after != nil {
+			afterCommit = append(afterCommit, after)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, fn := range afterCommit {
+		fn()
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The decodeBatchData() helper decodes an operation's data, applying the same rules
+// as readJSON() does for a request body.
+func decodeBatchData(op batchOperation, dst any) error {
+	if len(op.Data) == 0 {
+		return &batchError{http.StatusBadRequest, "data must be provided"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(op.Data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return &batchError{http.StatusBadRequest, jsonDecodeError(err).Error()}
+	}
+
+	return nil
+}
+
+func (app *application) batchCreateMovie(r *http.Request, tx *sql.Tx, op batchOperation) (int, any, func(), error) {
+	var input struct {
+		Title            string            `json:"title"`
+		Year             int32             `json:"year"`
+		Runtime          data.Runtime      `json:"runtime"`
+		Genres           []string          `json:"genres"`
+		Synopsis         string            `json:"synopsis"`
+		Budget           *data.Money       `json:"budget"`
+		BoxOffice        *data.Money       `json:"box_office"`
+		Links            data.MovieLinks   `json:"links"`
+		ReleaseDates     data.ReleaseDates `json:"release_dates"`
+		Certification    string            `json:"certification"`
+		OriginalLanguage string            `json:"original_language"`
+	}
+
+	err := decodeBatchData(op, &input)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	movie := &data.Movie{
+		Title:            input.Title,
+		Year:             input.Year,
+		Runtime:          input.Runtime,
+		Genres:           input.Genres,
+		Synopsis:         input.Synopsis,
+		Budget:           input.Budget,
+		BoxOffice:        input.BoxOffice,
+		Links:            input.Links,
+		ReleaseDates:     input.ReleaseDates,
+		Certification:    input.Certification,
+		OriginalLanguage: input.OriginalLanguage,
+	}
+
+	if movie.Year == 0 {
+		movie.Year = releaseYear(movie.ReleaseDates)
+	}
+
+	v := validator.New()
+
+	err = app.checkGenres(v, movie.Genres)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	data.ValidateCertification(v, movie.Certification, app.config.certifications)
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return 0, nil, nil, &batchError{http.StatusUnprocessableEntity, v.Errors}
+	}
+
+	err = app.movies(r).WithTx(tx).Insert(movie)
+	if err != nil {
+		if errors.Is(err, data.ErrNoOrganization) {
+			return 0, nil, nil, &batchError{http.StatusForbidden, "you must be a member of an organization to add movies"}
+		}
+		return 0, nil, nil, err
+	}
+
+	after := func() {
+		app.audit(r, "create", "movie", movie.ID, map[string]bool{"batch": true})
+		app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieCreated, movie)
+		app.enrichMovie(app.contextGetOrganization(r), movie)
+	}
+
+	return http.StatusCreated, app.movieResource(movie), after, nil
+}
+
+func (app *application) batchUpdateMovie(r *http.Request, tx *sql.Tx, op batchOperation) (int, any, func(), error) {
+	movies := app.movies(r).WithTx(tx)
+
+	movie, err := movies.Get(op.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return 0, nil, nil, &batchError{http.StatusNotFound, "the requested resource could not be found"}
+		}
+		return 0, nil, nil, err
+	}
+
+	var input struct {
+		Title            *string           `json:"title"`
+		Year             *int32            `json:"year"`
+		Runtime          *data.Runtime     `json:"runtime"`
+		Genres           []string          `json:"genres"`
+		Synopsis         *string           `json:"synopsis"`
+		Budget           *data.Money       `json:"budget"`
+		BoxOffice        *data.Money       `json:"box_office"`
+		Links            *data.MovieLinks  `json:"links"`
+		ReleaseDates     data.ReleaseDates `json:"release_dates"`
+		Certification    *string           `json:"certification"`
+		OriginalLanguage *string           `json:"original_language"`
+	}
+
+	err = decodeBatchData(op, &input)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	if input.Synopsis != nil {
+		movie.Synopsis = *input.Synopsis
+	}
+
+	if input.Budget != nil {
+		movie.Budget = input.Budget
+	}
+
+	if input.BoxOffice != nil {
+		movie.BoxOffice = input.BoxOffice
+	}
+
+	if input.Links != nil {
+		movie.Links = *input.Links
+	}
+
+	if input.ReleaseDates != nil {
+		movie.ReleaseDates = input.ReleaseDates
+
+		if year := releaseYear(movie.ReleaseDates); input.Year == nil && year != 0 {
+			movie.Year = year
+		}
+	}
+
+	if input.Certification != nil {
+		movie.Certification = *input.Certification
+	}
+
+	if input.OriginalLanguage != nil {
+		movie.OriginalLanguage = *input.OriginalLanguage
+	}
+
+	v := validator.New()
+
+	if input.Genres != nil {
+		err = app.checkGenres(v, movie.Genres)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	if input.Certification != nil {
+		data.ValidateCertification(v, movie.Certification, app.config.certifications)
+	}
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return 0, nil, nil, &batchError{http.StatusUnprocessableEntity, v.Errors}
+	}
+
+	err = movies.Update(movie)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			return 0, nil, nil, &batchError{http.StatusConflict, "unable to update the record due to an edit conflict, please try again"}
+		}
+		return 0, nil, nil, err
+	}
+
+	after := func() {
+		app.audit(r, "update", "movie", movie.ID, map[string]bool{"batch": true})
+		app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+	}
+
+	return http.StatusOK, app.movieResource(movie), after, nil
+}
+
+func (app *application) batchDeleteMovie(r *http.Request, tx *sql.Tx, op batchOperation) (int, any, func(), error) {
+	err := app.movies(r).WithTx(tx).Delete(op.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return 0, nil, nil, &batchError{http.StatusNotFound, "the requested resource could not be found"}
+		}
+		return 0, nil, nil, err
+	}
+
+	after := func() {
+		app.audit(r, "delete", "movie", op.ID, map[string]bool{"batch": true})
+		app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieDeleted, map[string]int64{"id": op.ID})
+	}
+
+	return http.StatusOK, map[string]int64{"id": op.ID}, after, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{Name: input.Name, Description: input.Description}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.collections(r).Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "collection", collection.ID, nil)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": app.collectionResource(collection)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showCollectionHandler() shows a collection along with its movies, in order.
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+
+	collection.Movies, err = app.models.Collections.GetMovies(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": app.collectionResource(collection)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "name"),
+		SortSafelist: data.CollectionSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.collections(r).GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resources := make([]collectionResource, len(list))
+	for i, collection := range list {
+		resources[i] = app.collectionResource(collection)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "collections": resources, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.collections(r).Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "collection", collection.ID, input)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": app.collectionResource(collection)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.collections(r).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "collection", id, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCollectionMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	movies, err := app.models.Collections.GetMovies(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addCollectionMovieHandler() puts a movie into a collection. Without a position
+// the movie goes at the end, and otherwise the movies from that position on move down
+// to make room.
+func (app *application) addCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieID  int64 `json:"movie_id"`
+		Position int32 `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie := &data.CollectionMovie{MovieID: input.MovieID, Position: input.Position}
+
+	err = app.collections(r).AddMovie(collection.ID, movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrMovieInCollection):
+			v.AddError("movie_id", "is already in a collection")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "add_movie", "collection", collection.ID, input)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The replaceCollectionMoviesHandler() sets the movies in a collection, in the order
+// they're listed. Like replaceWatchProvidersHandler(), the list replaces the current
+// movies, so it's also how a collection is reordered.
+func (app *application) replaceCollectionMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Movies []int64 `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Movies != nil, "movies", "must be provided")
+	v.Check(len(input.Movies) <= 500, "movies", "must not contain more than 500 movies")
+	v.Check(validator.Unique(input.Movies), "movies", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.collections(r).ReplaceMovies(collection.ID, input.Movies)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movies", "must only contain existing movies")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrMovieInCollection):
+			v.AddError("movies", "must not contain movies which are already in another collection")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update_movies", "collection", collection.ID, input)
+
+	movies, err := app.models.Collections.GetMovies(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.collections(r).RemoveMovie(collection.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "remove_movie", "collection", collection.ID, map[string]int64{"movie_id": movieID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from collection"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The collectionForRequest() helper looks up the collection in the :id parameter, in
+// the organization the request is made on behalf of. If there's no such collection it
+// sends a 404 Not Found response and returns false.
+func (app *application) collectionForRequest(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	collection, err := app.collections(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return collection, true
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"greenlight/anaplo/internal/cron"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// validateConfig() checks the parsed configuration for values which would stop the
+// application from working properly, recording any problems in the validator.
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValues(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+	v.Check(!cfg.enableFixtures || cfg.env == "development", "enable-fixtures", "must only be set in development")
+
+	v.Check(cfg.shutdownGrace > 0, "shutdown-grace", "must be greater than zero")
+
+	v.Check(cfg.server.readTimeout > 0, "server-read-timeout", "must be greater than zero")
+	v.Check(cfg.server.readHeaderTimeout >= 0, "server-read-header-timeout", "must not be negative")
+	v.Check(cfg.server.writeTimeout > 0, "server-write-timeout", "must be greater than zero")
+	v.Check(cfg.server.idleTimeout > 0, "server-idle-timeout", "must be greater than zero")
+	v.Check(cfg.server.maxHeaderBytes >= 4096, "server-max-header-bytes", "must be at least 4096")
+	v.Check(cfg.server.maxConns >= 0, "server-max-conns", "must not be negative")
+
+	for key, raw := range map[string]string{"public-url": cfg.publicURL, "frontend-url": cfg.frontendURL} {
+		if raw != "" {
+			u, err := url.Parse(raw)
+			v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", key, "must be an absolute http or https URL")
+		}
+	}
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns", "must not be greater than db-max-open-conns")
+	v.Check(cfg.db.maxIdleTime > 0, "db-max-idle-time", "must be greater than zero")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "rate-limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst > 0, "rate-limiter-burst", "must be greater than zero")
+	}
+
+	if cfg.authThrottle.enabled {
+		v.Check(cfg.authThrottle.window > 0, "auth-throttle-window", "must be greater than zero")
+		v.Check(cfg.authThrottle.freeAttempts >= 0, "auth-throttle-free-attempts", "must not be negative")
+		v.Check(cfg.authThrottle.blockAfter > cfg.authThrottle.freeAttempts, "auth-throttle-block-after", "must be greater than auth-throttle-free-attempts")
+		v.Check(cfg.authThrottle.maxDelay > 0, "auth-throttle-max-delay", "must be greater than zero")
+	}
+
+	v.Check(cfg.quota.daily >= 0, "quota-daily", "must not be negative")
+	v.Check(cfg.quota.monthly >= 0, "quota-monthly", "must not be negative")
+	v.Check(cfg.usageFlushInterval > 0, "usage-flush-interval", "must be greater than zero")
+
+	v.Check(cfg.pagination.MaxPageSize > 0, "max-page-size", "must be greater than zero")
+	v.Check(cfg.pagination.MaxOffset >= cfg.pagination.MaxPageSize, "max-page-offset", "must not be less than max-page-size")
+	v.Check(validator.Unique(cfg.certifications), "certifications", "must not contain duplicate values")
+
+	v.Check(cfg.worker.concurrency > 0, "worker-concurrency", "must be greater than zero")
+	v.Check(cfg.worker.queueSize >= 0, "worker-queue-size", "must not be negative")
+
+	v.Check(cfg.jobs.concurrency > 0, "jobs-concurrency", "must be greater than zero")
+	v.Check(cfg.jobs.pollInterval > 0, "jobs-poll-interval", "must be greater than zero")
+	v.Check(cfg.jobs.lease > 0, "jobs-lease", "must be greater than zero")
+	v.Check(cfg.jobs.retryBase > 0, "jobs-retry-base", "must be greater than zero")
+	v.Check(cfg.jobs.maxAttempts > 0, "jobs-max-attempts", "must be greater than zero")
+
+	schedules := map[string]string{
+		"schedule-token-cleanup":       cfg.scheduler.tokenCleanup,
+		"schedule-account-purge":       cfg.scheduler.accountPurge,
+		"schedule-digest":              cfg.scheduler.digest,
+		"schedule-events-prune":        cfg.scheduler.eventsPrune,
+		"schedule-auth-failures-prune": cfg.scheduler.authFailuresPrune,
+		"schedule-saved-searches":      cfg.scheduler.savedSearches,
+		"schedule-backup":              cfg.scheduler.backup,
+		"schedule-recommendations":     cfg.scheduler.recommendations,
+		"schedule-views-prune":         cfg.scheduler.viewsPrune,
+		"schedule-metrics-rollup":      cfg.scheduler.metricsRollup,
+		"schedule-jobs-prune":          cfg.scheduler.jobsPrune,
+	}
+	for key, expr := range schedules {
+		if expr != "" {
+			_, err := cron.Parse(expr)
+			v.Check(err == nil, key, "must be a valid cron expression")
+		}
+	}
+	v.Check(cfg.scheduler.lease > 0, "schedule-lease", "must be greater than zero")
+	v.Check(cfg.scheduler.unactivatedTTL > 0, "unactivated-account-ttl", "must be greater than zero")
+	v.Check(cfg.scheduler.deletedGrace > 0, "deleted-account-grace", "must be greater than zero")
+	v.Check(cfg.scheduler.eventsTTL > 0, "movie-events-ttl", "must be greater than zero")
+	v.Check(cfg.scheduler.jobsTTL > 0, "jobs-ttl", "must be greater than zero")
+
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	v.Check(cfg.smtp.sender != "", "smtp-sender", "must be provided")
+
+	for key, raw := range map[string]string{"tmdb-base-url": cfg.tmdb.baseURL, "tmdb-image-base-url": cfg.tmdb.imageBaseURL, "omdb-base-url": cfg.omdb.baseURL, "pwned-passwords-base-url": cfg.pwnedPasswords.baseURL} {
+		u, err := url.Parse(raw)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", key, "must be an absolute http or https URL")
+	}
+
+	v.Check(cfg.pwnedPasswords.timeout > 0, "pwned-passwords-timeout", "must be greater than zero")
+
+	_, err := data.ParseTokenKeys(cfg.tokens.keys)
+	v.Check(err == nil, "token-keys", fmt.Sprintf("%v", err))
+	v.Check(cfg.tokens.keyGrace > 0, "token-key-grace", "must be greater than zero")
+	v.Check(cfg.registration.inviteTTL > 0, "invite-ttl", "must be greater than zero")
+	v.Check(cfg.reviews.trustedAfter >= 0, "reviews-trusted-after", "must not be negative")
+	v.Check(cfg.reviews.reportThreshold >= 0, "reviews-report-threshold", "must not be negative")
+
+	_, err = data.NewPIICipher(cfg.pii.key)
+	v.Check(err == nil, "pii-key", fmt.Sprintf("%v", err))
+
+	_, err = data.ParseRateLimitExemptions(cfg.limiter.exemptions)
+	v.Check(err == nil, "rate-limiter-exemptions", fmt.Sprintf("%v", err))
+
+	if cfg.siem.url != "" {
+		u, err := url.Parse(cfg.siem.url)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "siem-webhook-url", "must be an absolute http or https URL")
+	}
+
+	if cfg.backup.endpoint != "" {
+		u, err := url.Parse(cfg.backup.endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "backup-s3-endpoint", "must be an absolute http or https URL")
+		v.Check(cfg.backup.region != "", "backup-s3-region", "must be provided with backup-s3-endpoint")
+		v.Check(cfg.backup.bucket != "", "backup-s3-bucket", "must be provided with backup-s3-endpoint")
+		v.Check(cfg.backup.accessKey != "", "backup-s3-access-key", "must be provided with backup-s3-endpoint")
+		v.Check(cfg.backup.secretKey != "", "backup-s3-secret-key", "must be provided with backup-s3-endpoint")
+		v.Check(strings.Trim(cfg.backup.prefix, "/") != "", "backup-prefix", "must be provided")
+		v.Check(cfg.backup.retention > 0, "backup-retention", "must be greater than zero")
+	}
+
+	v.Check(validator.PermittedValues(cfg.posters.storage, "disk", "s3"), "poster-storage", "must be disk or s3")
+	v.Check(cfg.posters.maxBytes > 0, "poster-max-bytes", "must be greater than zero")
+
+	switch cfg.posters.storage {
+	case "disk":
+		v.Check(cfg.posters.dir != "", "poster-dir", "must be provided with poster-storage=disk")
+	case "s3":
+		u, err := url.Parse(cfg.posters.s3Endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "poster-s3-endpoint", "must be an absolute http or https URL")
+		v.Check(cfg.posters.s3Region != "", "poster-s3-region", "must be provided with poster-storage=s3")
+		v.Check(cfg.posters.s3Bucket != "", "poster-s3-bucket", "must be provided with poster-storage=s3")
+		v.Check(cfg.posters.s3AccessKey != "", "poster-s3-access-key", "must be provided with poster-storage=s3")
+		v.Check(cfg.posters.s3SecretKey != "", "poster-s3-secret-key", "must be provided with poster-storage=s3")
+	}
+
+	if cfg.push.apnsKeyFile != "" {
+		v.Check(cfg.push.apnsKeyID != "", "apns-key-id", "must be provided with apns-key-file")
+		v.Check(cfg.push.apnsTeamID != "", "apns-team-id", "must be provided with apns-key-file")
+		v.Check(cfg.push.apnsTopic != "", "apns-topic", "must be provided with apns-key-file")
+	}
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		ok := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/")
+		v.Check(ok, "cors-trusted-origins", fmt.Sprintf("%q is not a valid origin", origin))
+	}
+}
+
+// Matches the password portion of a key/value style DSN, such as
+// "host=localhost password=pa55word dbname=greenlight".
+var dsnPasswordRX = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// redactDSN() hides the password in a PostgreSQL DSN, whether it's in URL or key/value
+// form, so that the DSN can be printed safely.
+func redactDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "[unparseable]"
+		}
+		return u.Redacted()
+	}
+
+	return dsnPasswordRX.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+// redactTokenKeys() hides the secrets in a list of token keys, keeping the versions
+// so that it's clear which keys are loaded.
+func redactTokenKeys(s string) string {
+	keys, err := data.ParseTokenKeys(s)
+	if err != nil {
+		return redactSecret(s)
+	}
+
+	versions := make([]int, 0, len(keys))
+	for version := range keys {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	parts := make([]string, len(versions))
+	for i, version := range versions {
+		parts[i] = fmt.Sprintf("%d:xxxxx", version)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// printConfig() writes the effective configuration to w, with any secrets redacted.
+func printConfig(w io.Writer, cfg config) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "port:\t%d\n", cfg.port)
+	fmt.Fprintf(tw, "env:\t%s\n", cfg.env)
+	fmt.Fprintf(tw, "enable-fixtures:\t%t\n", cfg.enableFixtures)
+	fmt.Fprintf(tw, "shutdown-grace:\t%s\n", cfg.shutdownGrace)
+	fmt.Fprintf(tw, "server-read-timeout:\t%s\n", cfg.server.readTimeout)
+	fmt.Fprintf(tw, "server-read-header-timeout:\t%s\n", cfg.server.readHeaderTimeout)
+	fmt.Fprintf(tw, "server-write-timeout:\t%s\n", cfg.server.writeTimeout)
+	fmt.Fprintf(tw, "server-idle-timeout:\t%s\n", cfg.server.idleTimeout)
+	fmt.Fprintf(tw, "server-max-header-bytes:\t%d\n", cfg.server.maxHeaderBytes)
+	fmt.Fprintf(tw, "server-max-conns:\t%d\n", cfg.server.maxConns)
+	fmt.Fprintf(tw, "public-url:\t%s\n", cfg.publicURL)
+	fmt.Fprintf(tw, "frontend-url:\t%s\n", cfg.frontendURL)
+	fmt.Fprintf(tw, "db-dsn:\t%s\n", redactDSN(cfg.db.dsn))
+	fmt.Fprintf(tw, "db-max-open-conns:\t%d\n", cfg.db.maxOpenConns)
+	fmt.Fprintf(tw, "db-max-idle-conns:\t%d\n", cfg.db.maxIdleConns)
+	fmt.Fprintf(tw, "db-max-idle-time:\t%s\n", cfg.db.maxIdleTime)
+	fmt.Fprintf(tw, "rate-limiter-enabled:\t%t\n", cfg.limiter.enabled)
+	fmt.Fprintf(tw, "rate-limiter-rps:\t%g\n", cfg.limiter.rps)
+	fmt.Fprintf(tw, "rate-limiter-burst:\t%d\n", cfg.limiter.burst)
+	fmt.Fprintf(tw, "rate-limiter-exemptions:\t%s\n", cfg.limiter.exemptions)
+	fmt.Fprintf(tw, "auth-throttle-enabled:\t%t\n", cfg.authThrottle.enabled)
+	fmt.Fprintf(tw, "auth-throttle-window:\t%s\n", cfg.authThrottle.window)
+	fmt.Fprintf(tw, "auth-throttle-free-attempts:\t%d\n", cfg.authThrottle.freeAttempts)
+	fmt.Fprintf(tw, "auth-throttle-block-after:\t%d\n", cfg.authThrottle.blockAfter)
+	fmt.Fprintf(tw, "auth-throttle-max-delay:\t%s\n", cfg.authThrottle.maxDelay)
+	fmt.Fprintf(tw, "quota-daily:\t%d\n", cfg.quota.daily)
+	fmt.Fprintf(tw, "quota-monthly:\t%d\n", cfg.quota.monthly)
+	fmt.Fprintf(tw, "usage-flush-interval:\t%s\n", cfg.usageFlushInterval)
+	fmt.Fprintf(tw, "max-page-size:\t%d\n", cfg.pagination.MaxPageSize)
+	fmt.Fprintf(tw, "max-page-offset:\t%d\n", cfg.pagination.MaxOffset)
+	fmt.Fprintf(tw, "certifications:\t%s\n", strings.Join(cfg.certifications, " "))
+	fmt.Fprintf(tw, "strict-query-params:\t%t\n", cfg.strictQuery)
+	fmt.Fprintf(tw, "worker-concurrency:\t%d\n", cfg.worker.concurrency)
+	fmt.Fprintf(tw, "worker-queue-size:\t%d\n", cfg.worker.queueSize)
+	fmt.Fprintf(tw, "worker-queue-block:\t%t\n", cfg.worker.block)
+	fmt.Fprintf(tw, "jobs-concurrency:\t%d\n", cfg.jobs.concurrency)
+	fmt.Fprintf(tw, "jobs-poll-interval:\t%s\n", cfg.jobs.pollInterval)
+	fmt.Fprintf(tw, "jobs-lease:\t%s\n", cfg.jobs.lease)
+	fmt.Fprintf(tw, "jobs-retry-base:\t%s\n", cfg.jobs.retryBase)
+	fmt.Fprintf(tw, "jobs-max-attempts:\t%d\n", cfg.jobs.maxAttempts)
+	fmt.Fprintf(tw, "schedule-token-cleanup:\t%s\n", cfg.scheduler.tokenCleanup)
+	fmt.Fprintf(tw, "schedule-account-purge:\t%s\n", cfg.scheduler.accountPurge)
+	fmt.Fprintf(tw, "schedule-digest:\t%s\n", cfg.scheduler.digest)
+	fmt.Fprintf(tw, "schedule-events-prune:\t%s\n", cfg.scheduler.eventsPrune)
+	fmt.Fprintf(tw, "schedule-auth-failures-prune:\t%s\n", cfg.scheduler.authFailuresPrune)
+	fmt.Fprintf(tw, "schedule-saved-searches:\t%s\n", cfg.scheduler.savedSearches)
+	fmt.Fprintf(tw, "schedule-backup:\t%s\n", cfg.scheduler.backup)
+	fmt.Fprintf(tw, "schedule-recommendations:\t%s\n", cfg.scheduler.recommendations)
+	fmt.Fprintf(tw, "schedule-views-prune:\t%s\n", cfg.scheduler.viewsPrune)
+	fmt.Fprintf(tw, "schedule-metrics-rollup:\t%s\n", cfg.scheduler.metricsRollup)
+	fmt.Fprintf(tw, "schedule-jobs-prune:\t%s\n", cfg.scheduler.jobsPrune)
+	fmt.Fprintf(tw, "schedule-lease:\t%s\n", cfg.scheduler.lease)
+	fmt.Fprintf(tw, "unactivated-account-ttl:\t%s\n", cfg.scheduler.unactivatedTTL)
+	fmt.Fprintf(tw, "deleted-account-grace:\t%s\n", cfg.scheduler.deletedGrace)
+	fmt.Fprintf(tw, "movie-events-ttl:\t%s\n", cfg.scheduler.eventsTTL)
+	fmt.Fprintf(tw, "jobs-ttl:\t%s\n", cfg.scheduler.jobsTTL)
+	fmt.Fprintf(tw, "smtp-host:\t%s\n", cfg.smtp.host)
+	fmt.Fprintf(tw, "smtp-port:\t%d\n", cfg.smtp.port)
+	fmt.Fprintf(tw, "smtp-username:\t%s\n", cfg.smtp.username)
+	fmt.Fprintf(tw, "smtp-password:\t%s\n", redactSecret(cfg.smtp.password))
+	fmt.Fprintf(tw, "smtp-sender:\t%s\n", cfg.smtp.sender)
+	fmt.Fprintf(tw, "tmdb-base-url:\t%s\n", cfg.tmdb.baseURL)
+	fmt.Fprintf(tw, "tmdb-image-base-url:\t%s\n", cfg.tmdb.imageBaseURL)
+	fmt.Fprintf(tw, "tmdb-api-key:\t%s\n", redactSecret(cfg.tmdb.apiKey))
+	fmt.Fprintf(tw, "omdb-base-url:\t%s\n", cfg.omdb.baseURL)
+	fmt.Fprintf(tw, "omdb-api-key:\t%s\n", redactSecret(cfg.omdb.apiKey))
+	fmt.Fprintf(tw, "pwned-passwords-enabled:\t%t\n", cfg.pwnedPasswords.enabled)
+	fmt.Fprintf(tw, "pwned-passwords-base-url:\t%s\n", cfg.pwnedPasswords.baseURL)
+	fmt.Fprintf(tw, "pwned-passwords-timeout:\t%s\n", cfg.pwnedPasswords.timeout)
+	fmt.Fprintf(tw, "token-keys:\t%s\n", redactTokenKeys(cfg.tokens.keys))
+	fmt.Fprintf(tw, "token-key-grace:\t%s\n", cfg.tokens.keyGrace)
+	fmt.Fprintf(tw, "registration-invite-only:\t%t\n", cfg.registration.inviteOnly)
+	fmt.Fprintf(tw, "invite-ttl:\t%s\n", cfg.registration.inviteTTL)
+	fmt.Fprintf(tw, "reviews-auto-approve:\t%t\n", cfg.reviews.autoApprove)
+	fmt.Fprintf(tw, "reviews-trusted-after:\t%d\n", cfg.reviews.trustedAfter)
+	fmt.Fprintf(tw, "reviews-report-threshold:\t%d\n", cfg.reviews.reportThreshold)
+	fmt.Fprintf(tw, "siem-webhook-url:\t%s\n", cfg.siem.url)
+	fmt.Fprintf(tw, "backup-s3-endpoint:\t%s\n", cfg.backup.endpoint)
+	fmt.Fprintf(tw, "backup-s3-region:\t%s\n", cfg.backup.region)
+	fmt.Fprintf(tw, "backup-s3-bucket:\t%s\n", cfg.backup.bucket)
+	fmt.Fprintf(tw, "backup-s3-access-key:\t%s\n", cfg.backup.accessKey)
+	fmt.Fprintf(tw, "backup-s3-secret-key:\t%s\n", redactSecret(cfg.backup.secretKey))
+	fmt.Fprintf(tw, "backup-prefix:\t%s\n", cfg.backup.prefix)
+	fmt.Fprintf(tw, "backup-retention:\t%s\n", cfg.backup.retention)
+	fmt.Fprintf(tw, "poster-storage:\t%s\n", cfg.posters.storage)
+	fmt.Fprintf(tw, "poster-dir:\t%s\n", cfg.posters.dir)
+	fmt.Fprintf(tw, "poster-max-bytes:\t%d\n", cfg.posters.maxBytes)
+	fmt.Fprintf(tw, "poster-s3-endpoint:\t%s\n", cfg.posters.s3Endpoint)
+	fmt.Fprintf(tw, "poster-s3-region:\t%s\n", cfg.posters.s3Region)
+	fmt.Fprintf(tw, "poster-s3-bucket:\t%s\n", cfg.posters.s3Bucket)
+	fmt.Fprintf(tw, "poster-s3-access-key:\t%s\n", cfg.posters.s3AccessKey)
+	fmt.Fprintf(tw, "poster-s3-secret-key:\t%s\n", redactSecret(cfg.posters.s3SecretKey))
+	fmt.Fprintf(tw, "fcm-credentials-file:\t%s\n", cfg.push.fcmCredentialsFile)
+	fmt.Fprintf(tw, "apns-key-file:\t%s\n", cfg.push.apnsKeyFile)
+	fmt.Fprintf(tw, "apns-key-id:\t%s\n", cfg.push.apnsKeyID)
+	fmt.Fprintf(tw, "apns-team-id:\t%s\n", cfg.push.apnsTeamID)
+	fmt.Fprintf(tw, "apns-topic:\t%s\n", cfg.push.apnsTopic)
+	fmt.Fprintf(tw, "apns-sandbox:\t%t\n", cfg.push.apnsSandbox)
+	fmt.Fprintf(tw, "pii-key:\t%s\n", redactSecret(cfg.pii.key))
+	fmt.Fprintf(tw, "cors-trusted-origins:\t%s\n", strings.Join(cfg.cors.trustedOrigins, " "))
+
+	tw.Flush()
+}
+
+// runConfigCheck() implements the -check-config mode. It returns the exit status for
+// the process.
+func runConfigCheck(cfg config, checkDB bool) int {
+	printConfig(os.Stdout, cfg)
+
+	v := validator.New()
+	validateConfig(v, cfg)
+
+	if checkDB && cfg.db.dsn != "" {
+		db, err := openDB(cfg, &dsnConnector{dsn: cfg.db.dsn})
+		if err != nil {
+			v.AddError("db-dsn", fmt.Sprintf("database is not reachable: %s", err))
+		} else {
+			db.Close()
+		}
+	}
+
+	if !v.Valid() {
+		keys := make([]string, 0, len(v.Errors))
+		for key := range v.Errors {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(os.Stderr, "\nconfiguration problems:")
+		for _, key := range keys {
+			fmt.Fprintf(os.Stderr, "  -%s: %s\n", key, v.Errors[key])
+		}
+		return 1
+	}
+
+	fmt.Println("\nconfiguration OK")
+	return 0
+}
@@ -34,3 +34,65 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+var grantContextKey = contextKey("grant")
+
+// The contextSetGrant() method adds the restrictions on a third-party application's
+// access token to the request context.
+func (app *application) contextSetGrant(r *http.Request, grant *data.TokenGrant) *http.Request {
+	ctx := context.WithValue(r.Context(), grantContextKey, grant)
+	return r.WithContext(ctx)
+}
+
+// The contextGetGrant() method returns the restrictions on the request's access token,
+// or nil if the token was issued directly to the user (or there is no token).
+func (app *application) contextGetGrant(r *http.Request) *data.TokenGrant {
+	grant, _ := r.Context().Value(grantContextKey).(*data.TokenGrant)
+	return grant
+}
+
+var organizationContextKey = contextKey("organization")
+
+// The contextSetOrganization() method adds the ID of the organization the request is
+// made on behalf of to the request context.
+func (app *application) contextSetOrganization(r *http.Request, id int64) *http.Request {
+	ctx := context.WithValue(r.Context(), organizationContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetOrganization() method returns the ID of the organization the request
+// is made on behalf of. Anonymous requests see the default organization, and it's 0
+// for users who don't belong to any organization.
+func (app *application) contextGetOrganization(r *http.Request) int64 {
+	id, ok := r.Context().Value(organizationContextKey).(int64)
+	if !ok {
+		return data.DefaultOrganizationID
+	}
+
+	return id
+}
+
+// The movies() method returns the movie model scoped to the organization the request
+// is made on behalf of, which records the current user as the author of any updates.
+func (app *application) movies(r *http.Request) data.MovieModel {
+	return app.models.Movies.ForOrganization(app.contextGetOrganization(r)).ByUser(app.contextGetUser(r).ID)
+}
+
+// The series() method returns the series model scoped to the organization the request
+// is made on behalf of.
+func (app *application) series(r *http.Request) data.SeriesModel {
+	return app.models.Series.ForOrganization(app.contextGetOrganization(r))
+}
+
+// The collections() method returns the collection model scoped to the organization the
+// request is made on behalf of.
+func (app *application) collections(r *http.Request) data.CollectionModel {
+	return app.models.Collections.ForOrganization(app.contextGetOrganization(r))
+}
+
+// The sharedWatchlists() method returns the shared watchlist model acting on behalf
+// of the current user in the organization the request is made on behalf of. The model
+// checks the user's role on each list itself.
+func (app *application) sharedWatchlists(r *http.Request) data.SharedWatchlistModel {
+	return app.models.SharedWatchlists.ForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID)
+}
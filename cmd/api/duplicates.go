@@ -0,0 +1,52 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The checkDuplicatesHandler() lists the movies which a movie with the given title and
+// year would probably duplicate, so that clients can ask the user before creating it.
+func (app *application) checkDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	year := app.readInt(qs, "year", 0, v)
+
+	v.Check(title != "", "title", "must be provided")
+	v.Check(len(title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(year != 0, "year", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	candidates, err := app.movies(r).FindDuplicates(title, int32(year))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"candidates": candidates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The duplicateMovieResponse() method sends a 409 Conflict response listing the
+// movies which a new movie probably duplicates. The client can create it anyway by
+// repeating the request with ?allow_duplicate=true.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, candidates []*data.DuplicateCandidate) {
+	env := envelope{
+		"error":      "this movie looks like one which already exists; repeat the request with ?allow_duplicate=true to create it anyway",
+		"candidates": candidates,
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -103,7 +103,17 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) suspendedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) noOrganizationResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be a member of an organization to add movies"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The movieEventBroker fans out movie change events to the clients connected to
+// GET /v1/movies/events.
+type movieEventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.MovieEvent]struct{}
+	closed      bool
+}
+
+func newMovieEventBroker() *movieEventBroker {
+	return &movieEventBroker{
+		subscribers: make(map[chan *data.MovieEvent]struct{}),
+	}
+}
+
+// The subscribe() method returns a channel which receives every published event. The
+// channel is closed if the subscriber falls too far behind, or the broker is closed.
+func (b *movieEventBroker) subscribe() chan *data.MovieEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *data.MovieEvent, 64)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *movieEventBroker) unsubscribe(ch chan *data.MovieEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// The publish() method sends an event to every subscriber without blocking. Slow
+// subscribers are dropped rather than holding up everyone else; their clients will
+// reconnect and catch up using Last-Event-ID.
+func (b *movieEventBroker) publish(event *data.MovieEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// The count() method returns the number of connected subscribers.
+func (b *movieEventBroker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscribers)
+}
+
+// The close() method disconnects every subscriber. It's called when the server shuts
+// down, since http.Server.Shutdown() would otherwise wait for the streams to end.
+func (b *movieEventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// The listenForMovieEvents() method listens for the notifications sent by the database
+// when a movie event is recorded, and publishes the events to the broker until ctx is
+// cancelled. Rather than trusting the notifications to arrive, it reads every event
+// after the last one it published, so nothing is lost if the connection drops.
+func (app *application) listenForMovieEvents(ctx context.Context) {
+	listener := pq.NewListener(app.dsn.get(), 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Error("movie events listener", "error", err.Error())
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(data.MovieEventsChannel)
+	if err != nil {
+		app.logger.Error("unable to listen for movie events", "error", err.Error())
+		return
+	}
+
+	lastID, err := app.models.MovieEvents.LatestID()
+	if err != nil {
+		app.logger.Error("unable to find the latest movie event", "error", err.Error())
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// A nil notification means the connection was re-established, and we may
+			// have missed some notifications. Either way, we catch up from lastID.
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+			continue
+		}
+
+		for {
+			events, err := app.models.MovieEvents.GetSince(lastID, 100)
+			if err != nil {
+				app.logger.Error("unable to read movie events", "error", err.Error())
+				break
+			}
+
+			for _, event := range events {
+				app.events.publish(event)
+				lastID = event.ID
+			}
+
+			if len(events) < 100 {
+				break
+			}
+		}
+	}
+}
+
+// The movieEventsHandler() streams movie changes to the client as server-sent events.
+// A client which reconnects with a Last-Event-ID header (or last_event_id query string
+// parameter, for clients which can't set headers) first receives the events it missed.
+// Only the events for the organization the request is made on behalf of are sent.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	organizationID := app.contextGetOrganization(r)
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || lastID < 0 {
+			app.badRequestResponse(w, r, errors.New("Last-Event-ID must be a positive integer"))
+			return
+		}
+	}
+
+	// The stream stays open indefinitely, so we lift the server's write timeout for
+	// this response.
+	rc := http.NewResponseController(w)
+
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Subscribe before replaying the missed events, so nothing published in between is
+	// lost. Any duplicates are skipped by comparing against lastID.
+	events := app.events.subscribe()
+	defer app.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	if lastEventID != "" {
+		for {
+			missed, err := app.models.MovieEvents.GetSinceForOrganization(organizationID, lastID, 100)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			for _, event := range missed {
+				err = writeMovieEvent(w, event)
+				if err != nil {
+					return
+				}
+				lastID = event.ID
+			}
+
+			if len(missed) < 100 {
+				break
+			}
+		}
+	}
+
+	err = rc.Flush()
+	if err != nil {
+		return
+	}
+
+	// Send a comment periodically, so that proxies don't close the connection for
+	// being idle.
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if event.ID <= lastID || event.OrganizationID != organizationID {
+				continue
+			}
+
+			err = writeMovieEvent(w, event)
+			if err != nil {
+				return
+			}
+			lastID = event.ID
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		}
+
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeMovieEvent(w http.ResponseWriter, event *data.MovieEvent) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, js)
+	return err
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// tokenOwnerTTL is how long the owner of an access token is remembered by
+// exemptionForToken(), so that exempt clients don't need a database query for every
+// request over the general limit.
+const tokenOwnerTTL = time.Minute
+
+// rateLimitExemptions holds the exemptions from the general rate limiter, indexed for
+// lookup by the rateLimit() middleware, along with the limiters for the exemptions
+// which have a limit of their own.
+type rateLimitExemptions struct {
+	mu       sync.RWMutex
+	byKey    map[string]*data.RateLimitExemption
+	cidrs    []exemptNetwork
+	limiters map[string]*rate.Limiter
+
+	// The users and applications which recently seen access tokens belong to, by the
+	// SHA-256 hash of the token.
+	tokensMu sync.Mutex
+	tokens   map[[32]byte]tokenOwner
+}
+
+type tokenOwner struct {
+	userID   int64
+	clientID string // Empty for tokens issued directly to the user
+	expires  time.Time
+}
+
+type exemptNetwork struct {
+	network   *net.IPNet
+	exemption *data.RateLimitExemption
+}
+
+func newRateLimitExemptions() *rateLimitExemptions {
+	return &rateLimitExemptions{
+		byKey:    map[string]*data.RateLimitExemption{},
+		limiters: map[string]*rate.Limiter{},
+		tokens:   map[[32]byte]tokenOwner{},
+	}
+}
+
+// set replaces the exemptions. The limiters of exemptions whose limit hasn't changed
+// are kept, so that reloading doesn't hand everyone a fresh burst.
+func (e *rateLimitExemptions) set(exemptions []*data.RateLimitExemption) {
+	byKey := map[string]*data.RateLimitExemption{}
+	cidrs := []exemptNetwork{}
+	limiters := map[string]*rate.Limiter{}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, exemption := range exemptions {
+		key := exemption.Key()
+		byKey[key] = exemption
+
+		if exemption.Kind == data.ExemptCIDR {
+			_, network, err := net.ParseCIDR(exemption.Value)
+			if err != nil {
+				continue
+			}
+			cidrs = append(cidrs, exemptNetwork{network, exemption})
+		}
+
+		if exemption.RPS != nil {
+			limiter := e.limiters[key]
+			if limiter == nil || float64(limiter.Limit()) != *exemption.RPS || limiter.Burst() != *exemption.Burst {
+				limiter = rate.NewLimiter(rate.Limit(*exemption.RPS), *exemption.Burst)
+			}
+			limiters[key] = limiter
+		}
+	}
+
+	e.byKey, e.cidrs, e.limiters = byKey, cidrs, limiters
+}
+
+// forIP returns the exemption for a CIDR range containing the IP address, if any.
+func (e *rateLimitExemptions) forIP(ip string) *data.RateLimitExemption {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, n := range e.cidrs {
+		if n.network.Contains(addr) {
+			return n.exemption
+		}
+	}
+
+	return nil
+}
+
+// forKey returns the exemption for a user or client, if any.
+func (e *rateLimitExemptions) forKey(kind, value string) *data.RateLimitExemption {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.byKey[kind+":"+value]
+}
+
+// forOwner returns the exemption for a token's owner, if any. An exemption for the
+// application a token was issued to takes precedence over one for the user.
+func (e *rateLimitExemptions) forOwner(owner tokenOwner) *data.RateLimitExemption {
+	if owner.clientID != "" {
+		if exemption := e.forKey(data.ExemptClient, owner.clientID); exemption != nil {
+			return exemption
+		}
+	}
+
+	return e.forKey(data.ExemptUser, strconv.FormatInt(owner.userID, 10))
+}
+
+// tokenOwner returns the remembered owner of a token, if it hasn't expired.
+func (e *rateLimitExemptions) tokenOwner(hash [32]byte) (tokenOwner, bool) {
+	e.tokensMu.Lock()
+	defer e.tokensMu.Unlock()
+
+	owner, ok := e.tokens[hash]
+	if !ok || time.Now().After(owner.expires) {
+		return tokenOwner{}, false
+	}
+
+	return owner, true
+}
+
+// rememberTokenOwner remembers the owner of a token for tokenOwnerTTL.
+func (e *rateLimitExemptions) rememberTokenOwner(hash [32]byte, owner tokenOwner) {
+	owner.expires = time.Now().Add(tokenOwnerTTL)
+
+	e.tokensMu.Lock()
+	defer e.tokensMu.Unlock()
+
+	e.tokens[hash] = owner
+}
+
+// pruneTokenOwners forgets the token owners which have expired.
+func (e *rateLimitExemptions) pruneTokenOwners() {
+	now := time.Now()
+
+	e.tokensMu.Lock()
+	defer e.tokensMu.Unlock()
+
+	for hash, owner := range e.tokens {
+		if now.After(owner.expires) {
+			delete(e.tokens, hash)
+		}
+	}
+}
+
+// allow reports whether a request covered by the exemption may go ahead.
+func (e *rateLimitExemptions) allow(exemption *data.RateLimitExemption) bool {
+	if exemption.RPS == nil {
+		return true
+	}
+
+	e.mu.RLock()
+	limiter := e.limiters[exemption.Key()]
+	e.mu.RUnlock()
+
+	return limiter == nil || limiter.Allow()
+}
+
+// The exemptionForToken() method returns the exemption for the user, or third-party
+// application, that a request's access token belongs to. It's only called once a
+// request has been rejected by the general rate limiter, so most requests don't pay
+// for the extra lookup. The owners of recently seen tokens are remembered, and
+// otherwise the token is only looked up in the database if the lookups limiter for
+// the client's IP address allows it, so that a client over its limit can't make a
+// query for every request by sending made-up tokens.
+func (app *application) exemptionForToken(r *http.Request, lookups *rate.Limiter) (*data.RateLimitExemption, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	if owner, ok := app.exemptions.tokenOwner(hash); ok {
+		return app.exemptions.forOwner(owner), nil
+	}
+
+	if !lookups.Allow() {
+		return nil, nil
+	}
+
+	user, _, grant, err := app.models.Users.GetForAccessToken(token)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	owner := tokenOwner{userID: user.ID}
+
+	if grant != nil {
+		client, err := app.models.OAuth.GetClient(grant.ClientID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		owner.clientID = client.ClientID
+	}
+
+	app.exemptions.rememberTokenOwner(hash, owner)
+
+	return app.exemptions.forOwner(owner), nil
+}
+
+// The loadRateLimitExemptions() method combines the exemptions from the configuration
+// with those created through the admin API.
+func (app *application) loadRateLimitExemptions() error {
+	exemptions, err := data.ParseRateLimitExemptions(app.config.limiter.exemptions)
+	if err != nil {
+		return err
+	}
+
+	stored, err := app.models.RateLimitExemptions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	app.exemptions.set(append(exemptions, stored...))
+	return nil
+}
+
+// The refreshRateLimitExemptions() method reloads the exemptions every minute until
+// ctx is cancelled, so that changes made through the admin API on another instance
+// are picked up.
+func (app *application) refreshRateLimitExemptions(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.loadRateLimitExemptions()
+			if err != nil {
+				app.logger.Error("unable to reload rate limit exemptions", "error", err.Error())
+			}
+
+			app.exemptions.pruneTokenOwners()
+		}
+	}
+}
+
+func (app *application) listRateLimitExemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	exemptions, err := app.models.RateLimitExemptions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The exemptions from the -rate-limiter-exemptions flag can't be changed through
+	// the API, but are listed so that admins can see the whole picture.
+	configured := []string{}
+	for _, field := range strings.Split(app.config.limiter.exemptions, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			configured = append(configured, field)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exemptions": exemptions, "configured": configured}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRateLimitExemptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Kind  string   `json:"kind"`
+		Value string   `json:"value"`
+		RPS   *float64 `json:"rps"`
+		Burst *int     `json:"burst"`
+		Note  string   `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	exemption := &data.RateLimitExemption{
+		Kind:  input.Kind,
+		Value: input.Value,
+		RPS:   input.RPS,
+		Burst: input.Burst,
+		Note:  input.Note,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRateLimitExemption(v, exemption); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.RateLimitExemptions.Insert(exemption)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExemption):
+			v.AddError("value", "already has an exemption")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "rate_limit_exemption", exemption.ID, exemption)
+
+	err = app.loadRateLimitExemptions()
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/rate-limit-exemptions/%d", exemption.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exemption": exemption}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteRateLimitExemptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.RateLimitExemptions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "rate_limit_exemption", id, nil)
+
+	err = app.loadRateLimitExemptions()
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exemption successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/filter"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// movieCSVHeader is the header row of movie CSV exports. POST
+// /v1/admin/movies/import accepts files in the same format.
+var movieCSVHeader = []string{"id", "title", "year", "runtime", "genres", "tmdb_id", "imdb_id", "budget", "box_office", "synopsis", "poster_url", "created_at"}
+
+// exportPayload is the payload for jobExportMovies jobs. The filter expression is
+// kept as it was sent, and parsed again by the job.
+type exportPayload struct {
+	OrganizationID int64    `json:"organization_id"`
+	Format         string   `json:"format"`
+	Title          string   `json:"title"`
+	Genres         []string `json:"genres"`
+	Filter         string   `json:"filter"`
+	Sort           string   `json:"sort"`
+}
+
+// The exportMoviesHandler() exports the movie list as CSV, with the same title, genre
+// and filter expression as GET /v1/movies and in the same order, but all in one go
+// rather than a page at a time. A large catalog takes a while, so the file is made by
+// a job, whose progress can be followed at the URL in the Location header. Once the
+// job has completed, the file can be downloaded from GET /v1/jobs/:id/download.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "csv")
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+	expr := app.readString(qs, "filter", "")
+
+	filters := data.Filters{
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.MovieSortSafelist,
+	}
+
+	if expr != "" {
+		_, err := filter.Parse(expr, data.MovieFilterFields)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+	}
+
+	v.Check(format == "csv", "format", "must be csv")
+	v.Check(validator.PermittedValues(filters.Sort, filters.SortSafelist...), "sort", "must be one of "+strings.Join(filters.SortSafelist, ", "))
+
+	if strings.TrimPrefix(filters.Sort, "-") == "relevance" {
+		v.Check(title != "", "sort", "relevance can only be sorted on with a title search")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	job, err := app.enqueueUserJob(user.ID, jobExportMovies, exportPayload{
+		OrganizationID: app.contextGetOrganization(r),
+		Format:         format,
+		Title:          title,
+		Genres:         genres,
+		Filter:         expr,
+		Sort:           filters.Sort,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "export", "movie", 0, map[string]any{"format": format, "job_id": job.ID})
+
+	app.jobAcceptedResponse(w, r, job)
+}
+
+// The exportMoviesJob() writes the movies matching an export's search to a CSV file
+// and stores it for download. The movies are counted first, so that the job can
+// report its progress as it goes. A retried job starts again from the beginning,
+// since the movies may have changed in the meantime.
+func (app *application) exportMoviesJob(ctx context.Context, job *data.Job) error {
+	var p exportPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	filters := data.Filters{
+		Sort:         p.Sort,
+		SortSafelist: data.MovieSortSafelist,
+	}
+
+	if p.Filter != "" {
+		filters.Expression, err = filter.Parse(p.Filter, data.MovieFilterFields)
+		if err != nil {
+			return err
+		}
+	}
+
+	movies := app.models.Movies.ForOrganization(p.OrganizationID)
+
+	total, err := movies.Count(p.Title, p.Genres, filters)
+	if err != nil {
+		return err
+	}
+
+	app.reportJobProgress(job, 0, total)
+
+	var buf bytes.Buffer
+
+	cw := csv.NewWriter(&buf)
+
+	err = cw.Write(movieCSVHeader)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+
+	err = movies.Each(p.Title, p.Genres, filters, func(movie *data.Movie) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := cw.Write(movieCSVRecord(movie))
+		if err != nil {
+			return err
+		}
+
+		// Movies added since they were counted can take the export past the total.
+		done++
+		if done%500 == 0 {
+			app.reportJobProgress(job, done, max(total, done))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	err = app.models.MovieExports.Insert(job.ID, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	app.reportJobProgress(job, done, max(total, done))
+
+	app.logger.Info("movie export finished", "job_id", job.ID, "movies", done, "bytes", buf.Len())
+	return nil
+}
+
+// movieCSVRecord returns a movie as a row of a CSV export, in the order of
+// movieCSVHeader. Missing values are left empty.
+func movieCSVRecord(movie *data.Movie) []string {
+	record := []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		strings.Join(movie.Genres, ","),
+		"",
+		movie.Links.IMDbID,
+		"",
+		"",
+		movie.Synopsis,
+		movie.PosterURL,
+		movie.CreatedAt.UTC().Format(time.RFC3339),
+	}
+
+	if movie.TMDBID != nil {
+		record[5] = strconv.FormatInt(*movie.TMDBID, 10)
+	}
+
+	if movie.Budget != nil {
+		record[7] = movie.Budget.String()
+	}
+
+	if movie.BoxOffice != nil {
+		record[8] = movie.BoxOffice.String()
+	}
+
+	return record
+}
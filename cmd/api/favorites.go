@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+func (app *application) addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	favorite := &data.Favorite{MovieID: input.MovieID}
+
+	err = app.models.Favorites.Insert(app.contextGetOrganization(r), app.contextGetUser(r).ID, favorite)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateFavorite):
+			v.AddError("movie_id", "is already one of your favorites")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"favorite": favorite}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-added_at"),
+		SortSafelist: data.FavoriteSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	favorites, metadata, err := app.models.Favorites.GetAllForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "favorites": favorites, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Favorites.DeleteForUser(app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from favorites"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The types below describe the parts of an Atom feed (RFC 4287) that we use.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// The movieFeedHandler() serves the most recently added movies as an Atom feed. It's
+// public, since feed readers can't authenticate, and supports conditional requests so
+// that readers polling for updates don't download the feed again if nothing changed.
+func (app *application) movieFeedHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 20, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.movies(r).GetCreatedSince(time.Time{}, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The feed was last updated when the newest movie was added. For an empty
+	// catalog, we fall back to the Unix epoch so the value is stable between requests.
+	updated := time.Unix(0, 0).UTC()
+	if len(movies) > 0 {
+		updated = movies[0].CreatedAt.UTC()
+	}
+
+	feed := atomFeed{
+		ID:      app.publicURL("/v1/movies/feed.atom", nil),
+		Title:   "Greenlight: recently added movies",
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomPerson{Name: "Greenlight"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: app.publicURL(r.URL.Path, r.URL.Query())},
+		},
+	}
+
+	for _, movie := range movies {
+		movieURL := app.publicURL(fmt.Sprintf("/v1/movies/%d", movie.ID), nil)
+
+		entry := atomEntry{
+			ID:        movieURL,
+			Title:     fmt.Sprintf("%s (%d)", movie.Title, movie.Year),
+			Updated:   movie.CreatedAt.UTC().Format(time.RFC3339),
+			Published: movie.CreatedAt.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "alternate", Type: "application/json", Href: movieURL},
+			},
+			Summary: fmt.Sprintf("%s, released in %d. Runtime: %d mins. Genres: %s.", movie.Title, movie.Year, movie.Runtime, strings.Join(movie.Genres, ", ")),
+		}
+
+		for _, genre := range movie.Genres {
+			entry.Categories = append(entry.Categories, atomCategory{Term: genre})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+
+	err = enc.Encode(feed)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Movie edits don't change the feed's updated time, so we use a hash of the body
+	// as the ETag to make sure readers see them.
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !updated.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/validator"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// A fieldset is the list of fields a client asked for with the fields query string
+// parameter, like ?fields=id,title,year, so that responses only carry what the client
+// needs. A nil fieldset means all of the fields.
+type fieldset []string
+
+// The readFields() helper reads the fields query string parameter. Each field must be
+// one of the JSON fields of resource, which is a value of the type being returned.
+func (app *application) readFields(qs url.Values, resource any, v *validator.Validator) fieldset {
+	fields := app.readCSV(qs, "fields", nil)
+	if fields == nil {
+		return nil
+	}
+
+	permitted := map[string]reflect.StructField{}
+	jsonFields(reflect.TypeOf(resource), nil, permitted)
+
+	for _, field := range fields {
+		if _, ok := permitted[field]; !ok {
+			v.AddError("fields", "must only contain fields of the resource; unknown: "+field)
+			break
+		}
+	}
+
+	return fields
+}
+
+// The readInclude() helper reads the include query string parameter, which lists the
+// related resources to embed in a response, like ?include=reviews. Each one must be in
+// permitted.
+func (app *application) readInclude(qs url.Values, permitted []string, v *validator.Validator) []string {
+	include := app.readCSV(qs, "include", []string{})
+
+	for _, name := range include {
+		if !validator.PermittedValues(name, permitted...) {
+			v.AddError("include", "must only contain "+strings.Join(permitted, ", "))
+			break
+		}
+	}
+
+	return include
+}
+
+// The has() method reports whether the field is in the fieldset, so that handlers can
+// skip looking up what won't be in the response.
+func (f fieldset) has(field string) bool {
+	return f == nil || slices.Contains(f, field)
+}
+
+// The apply() method returns a resource with only the fields in the fieldset, plus its
+// _links, or the resource itself if the fieldset is nil. Only the fields which are
+// kept get encoded, and they're included even if they're empty.
+func (f fieldset) apply(resource any) any {
+	if f == nil {
+		return resource
+	}
+
+	fields := map[string]reflect.StructField{}
+	jsonFields(reflect.TypeOf(resource), nil, fields)
+
+	value := reflect.ValueOf(resource)
+	result := map[string]any{}
+
+	for name, field := range fields {
+		if name != "_links" && !f.has(name) {
+			continue
+		}
+
+		// An embedded struct behind a nil pointer has no fields to keep.
+		fv, err := value.FieldByIndexErr(field.Index)
+		if err != nil {
+			continue
+		}
+
+		result[name] = fv.Interface()
+	}
+
+	return result
+}
+
+// The jsonFields() function adds the fields of a struct type to fields, by the name
+// they have in JSON, including those of embedded structs like the *data.Movie in a
+// movieResource. The index of each field is relative to the outermost struct, which
+// starts at index (nil for the outermost struct itself).
+func jsonFields(t reflect.Type, index []int, fields map[string]reflect.StructField) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		field.Index = append(slices.Clone(index), i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		switch {
+		case name == "-":
+			continue
+		case name == "" && field.Anonymous:
+			jsonFields(field.Type, field.Index, fields)
+		case name == "":
+			fields[field.Name] = field
+		default:
+			fields[name] = field
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/data"
+	"net/http"
+	"time"
+)
+
+// fixturePassword is the password of every fixture user.
+const fixturePassword = "pa55word"
+
+// A fixtureUser is a user created by loadFixturesHandler(), along with the
+// authentication token which is issued to them. The tokens are fixed, so that test
+// suites can use them without logging in first.
+type fixtureUser struct {
+	Name        string
+	Email       string
+	Activated   bool
+	Permissions []string
+	Role        string
+	Token       string
+}
+
+var fixtureUsers = []fixtureUser{
+	{
+		Name:        "Admin User",
+		Email:       "admin@example.com",
+		Activated:   true,
+		Permissions: []string{"movies:read", "movies:write", "webhooks:manage", "admin:read", "admin:write", "users:admin", "reviews:moderate"},
+		Role:        data.RoleOwner,
+		Token:       "ADMINADMINADMINADMINADMINA",
+	},
+	{
+		Name:        "Alice Smith",
+		Email:       "alice@example.com",
+		Activated:   true,
+		Permissions: []string{"movies:read", "movies:write"},
+		Role:        data.RoleAdmin,
+		Token:       "ALICEALICEALICEALICEALICEA",
+	},
+	{
+		Name:        "Bob Jones",
+		Email:       "bob@example.com",
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		Role:        data.RoleMember,
+		Token:       "BOBBOBBOBBOBBOBBOBBOBBOBBO",
+	},
+	{
+		Name:  "Carol White",
+		Email: "carol@example.com",
+	},
+}
+
+var fixtureMovies = []data.Movie{
+	{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance", "war"}},
+	{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+	{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure", "sci-fi"}},
+	{Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action", "comedy"}},
+	{Title: "The Breakfast Club", Year: 1985, Runtime: 97, Genres: []string{"comedy", "drama"}},
+}
+
+// The testingOnly() middleware makes sure that the fixture endpoints can't be used
+// outside development, or without -enable-fixtures, even if they're registered by
+// mistake.
+func (app *application) testingOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.enableFixtures || app.config.env != "development" {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The resetFixturesHandler() empties the database, apart from the permission codes
+// and the default organization.
+func (app *application) resetFixturesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Fixtures.Reset()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Warn("database reset by test fixtures endpoint")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "database successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The loadFixturesHandler() resets the database and then loads the same users,
+// tokens and movies every time. Because the ID sequences are restarted, the IDs in the
+// response are the same every time too.
+func (app *application) loadFixturesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Fixtures.Reset()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type loadedUser struct {
+		ID          int64    `json:"id"`
+		Email       string   `json:"email"`
+		Password    string   `json:"password"`
+		Activated   bool     `json:"activated"`
+		Permissions []string `json:"permissions,omitempty"`
+		Role        string   `json:"role,omitempty"`
+		Token       string   `json:"token,omitempty"`
+	}
+
+	users := []loadedUser{}
+
+	for _, f := range fixtureUsers {
+		user := &data.User{
+			Name:      f.Name,
+			Email:     f.Email,
+			Activated: f.Activated,
+		}
+
+		err = user.Password.Set(fixturePassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Users.Insert(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(f.Permissions) > 0 {
+			err = app.models.Permissions.AddForUser(user.ID, f.Permissions...)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if f.Role != "" {
+			err = app.models.Organizations.AddMember(data.DefaultOrganizationID, &data.OrganizationMember{UserID: user.ID, Role: f.Role})
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if f.Token != "" {
+			_, err = app.models.Tokens.NewWithPlaintext(user.ID, f.Token, 30*24*time.Hour, data.ScopeAuthorization)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		users = append(users, loadedUser{
+			ID:          user.ID,
+			Email:       f.Email,
+			Password:    fixturePassword,
+			Activated:   f.Activated,
+			Permissions: f.Permissions,
+			Role:        f.Role,
+			Token:       f.Token,
+		})
+	}
+
+	movies := make([]data.Movie, len(fixtureMovies))
+	copy(movies, fixtureMovies)
+
+	for i := range movies {
+		err = app.models.Movies.ForOrganization(data.DefaultOrganizationID).Insert(&movies[i])
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.logger.Warn("database reset and fixtures loaded by test fixtures endpoint")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
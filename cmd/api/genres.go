@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listGenreMoviesHandler() lists the movies with a genre, in the same shape as
+// GET /v1/movies. Unlike filtering that endpoint on the genre, it sends a 404 Not
+// Found response if the genre isn't in the catalog.
+func (app *application) listGenreMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	genre, err := app.models.Genres.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.MovieSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.movies(r).GetAll("", []string{genre.Slug}, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resources := make([]movieResource, len(movies))
+	for i, movie := range movies {
+		resources[i] = app.movieResource(movie)
+	}
+
+	env := envelope{"genre": genre, "metadata": metadata, "movies": resources, "_links": app.pageLinks(r, metadata)}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkGenres() method adds a validation error if any of a movie's (or series')
+// genres aren't in the catalog. Genres which are already invalid for other reasons
+// are left to data.ValidateMovie().
+func (app *application) checkGenres(v *validator.Validator, genres []string) error {
+	if len(genres) == 0 {
+		return nil
+	}
+
+	unknown, err := app.models.Genres.Unknown(genres)
+	if err != nil {
+		return err
+	}
+
+	if len(unknown) > 0 {
+		v.AddError("genres", "must only contain genres from GET /v1/genres; unknown: "+strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
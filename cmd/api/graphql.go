@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/filter"
+	"greenlight/anaplo/internal/graphql"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A graphqlResolver returns the value for a top-level query field. The value is shaped
+// to match the field's selection set by graphql.Select(), so resolvers can return the
+// same structs as the REST handlers.
+type graphqlResolver func(r *http.Request, f *graphql.Field) (any, error)
+
+// A graphqlQueryField is a top-level query field: the type of its value, or of the
+// items in it for a list, and the resolver which returns it.
+type graphqlQueryField struct {
+	typ     *graphql.Object
+	resolve graphqlResolver
+}
+
+// graphqlCurrentUser is the value of the "me" field.
+type graphqlCurrentUser struct {
+	*data.User
+	Permissions data.Permissions `json:"permissions"`
+}
+
+// The GraphQL object types are declared from the structs returned by the resolvers.
+var (
+	graphqlMovieType  = graphql.ObjectOf("Movie", data.Movie{})
+	graphqlReviewType = graphql.ObjectOf("Review", data.Review{})
+	graphqlUserType   = graphql.ObjectOf("User", graphqlCurrentUser{})
+)
+
+func (app *application) graphqlQueryFields() map[string]graphqlQueryField {
+	return map[string]graphqlQueryField{
+		"movies":  {graphqlMovieType, app.resolveMovies},
+		"movie":   {graphqlMovieType, app.resolveMovie},
+		"search":  {graphqlMovieType, app.resolveSearch},
+		"reviews": {graphqlReviewType, app.resolveReviews},
+		"me":      {graphqlUserType, app.resolveMe},
+	}
+}
+
+// The graphqlHandler() executes a GraphQL query. Each top-level field is resolved
+// separately, so a failure in one of them (like a missing permission) is reported in
+// the "errors" array without preventing the others from returning data.
+func (app *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	fields, err := graphql.Parse(input.Query, input.OperationName, input.Variables)
+	if err != nil {
+		err = app.writeJSON(w, http.StatusBadRequest, envelope{"errors": []*graphql.Error{{Message: err.Error()}}}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	queryFields := app.graphqlQueryFields()
+	result := map[string]any{}
+	var errs []*graphql.Error
+
+	for _, f := range fields {
+		result[f.Key()] = nil
+
+		field, ok := queryFields[f.Name]
+		if !ok {
+			errs = append(errs, &graphql.Error{Message: fmt.Sprintf("cannot query field %q on type \"Query\"", f.Name), Path: []any{f.Key()}})
+			continue
+		}
+
+		val, err := field.resolve(r, f)
+		if err == nil {
+			val, err = graphql.Select(val, field.typ, f.Selections, []any{f.Key()})
+		}
+
+		if err != nil {
+			var gqlErr *graphql.Error
+			if !errors.As(err, &gqlErr) {
+				gqlErr = &graphql.Error{Message: err.Error(), Path: []any{f.Key()}}
+			}
+			errs = append(errs, gqlErr)
+			continue
+		}
+
+		result[f.Key()] = val
+	}
+
+	env := envelope{"data": result}
+	if len(errs) > 0 {
+		env["errors"] = errs
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The graphqlRequirePermission() helper applies the same checks as the
+// requirePermission() middleware, returning an error instead of writing a response.
+func (app *application) graphqlRequirePermission(r *http.Request, code string) error {
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.IsAnonymous():
+		return errors.New("you must be authenticated to access this resource")
+	case !user.Activated:
+		return errors.New("your user account must be activated to access this resource")
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return err
+	}
+
+	if !permissions.Include(code) {
+		return errors.New("your user account doesn't have the necessary permissions to access this resource")
+	}
+
+	return nil
+}
+
+func (app *application) resolveMovies(r *http.Request, f *graphql.Field) (any, error) {
+	return app.graphqlListMovies(r, f, graphqlString(f.Args["title"]))
+}
+
+// The resolveSearch() resolver is a convenience for full-text title search, taking the
+// search terms in a "query" argument.
+func (app *application) resolveSearch(r *http.Request, f *graphql.Field) (any, error) {
+	return app.graphqlListMovies(r, f, graphqlString(f.Args["query"]))
+}
+
+func (app *application) graphqlListMovies(r *http.Request, f *graphql.Field, title string) (any, error) {
+	err := app.graphqlRequirePermission(r, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	filters := data.Filters{
+		Page:         graphqlInt(f.Args["page"], 1, "page", v),
+		PageSize:     graphqlInt(f.Args["page_size"], 20, "page_size", v),
+		Sort:         graphqlString(f.Args["sort"]),
+		SortSafelist: data.MovieSortSafelist,
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+
+	if expr := graphqlString(f.Args["filter"]); expr != "" {
+		var err error
+
+		filters.Expression, err = filter.Parse(expr, data.MovieFilterFields)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+	}
+
+	genres := []string{}
+	if list, ok := f.Args["genres"].([]any); ok {
+		for _, g := range list {
+			genres = append(genres, graphqlString(g))
+		}
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	movies, _, err := app.movies(r).GetAll(title, genres, filters)
+	return movies, err
+}
+
+func (app *application) resolveMovie(r *http.Request, f *graphql.Field) (any, error) {
+	err := app.graphqlRequirePermission(r, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	id := graphqlInt(f.Args["id"], 0, "id", v)
+	if !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	movie, err := app.movies(r).Get(int64(id))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+// The resolveReviews() resolver lists a movie's reviews, taking the movie's ID in a
+// "movie_id" argument. Like the movie field, it's null if there's no such movie.
+func (app *application) resolveReviews(r *http.Request, f *graphql.Field) (any, error) {
+	err := app.graphqlRequirePermission(r, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	movieID := graphqlInt(f.Args["movie_id"], 0, "movie_id", v)
+
+	filters := data.Filters{
+		Page:         graphqlInt(f.Args["page"], 1, "page", v),
+		PageSize:     graphqlInt(f.Args["page_size"], 20, "page_size", v),
+		Sort:         graphqlString(f.Args["sort"]),
+		SortSafelist: data.ReviewSortSafelist,
+	}
+	if filters.Sort == "" {
+		filters.Sort = "-created_at"
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	movie, err := app.movies(r).Get(int64(movieID))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	reviews, _, err := app.models.Reviews.GetAllForMovie(movie.ID, filters)
+	return reviews, err
+}
+
+func (app *application) resolveMe(r *http.Request, f *graphql.Field) (any, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return nil, errors.New("you must be authenticated to access this resource")
+	}
+
+	// Like GET /v1/me, the user's details aren't available to third-party applications.
+	if app.contextGetGrant(r) != nil {
+		return nil, errors.New("your user account doesn't have the necessary permissions to access this resource")
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	return graphqlCurrentUser{user, permissions}, nil
+}
+
+// graphqlString() converts an argument value to a string, returning "" if it's absent.
+func graphqlString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// graphqlInt() converts an argument value to an int. Literals in the query are parsed
+// as int64, but values from the JSON variables arrive as float64.
+func graphqlInt(v any, defaultValue int, key string, val *validator.Validator) int {
+	switch n := v.(type) {
+	case nil:
+		return defaultValue
+	case int64:
+		return int(n)
+	case float64:
+		if n == float64(int(n)) {
+			return int(n)
+		}
+	}
+
+	val.AddError(key, "must be an integer value")
+	return defaultValue
+}
+
+// graphqlValidationError() converts the errors in a validator to a single error.
+func graphqlValidationError(v *validator.Validator) error {
+	messages := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		messages = append(messages, fmt.Sprintf("invalid argument %q: %s", key, message))
+	}
+	sort.Strings(messages)
+
+	return errors.New(strings.Join(messages, "; "))
+}
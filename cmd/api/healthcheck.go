@@ -1,21 +1,186 @@
 package main
 
 import (
+	"context"
+	"greenlight/anaplo/internal/data"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// Define constants for the health of a dependency. A degraded dependency means some
+// features (like sending email) aren't working, while an unhealthy one means the API
+// can't serve requests at all.
+const (
+	healthOK        = "ok"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// A dependencyHealth is the result of checking one of the subsystems the API relies
+// on, with how long the check took.
+type dependencyHealth struct {
+	Status    string         `json:"status"`
+	LatencyMS float64        `json:"latency_ms"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
 // A handler which writes a plain-text response with information about the
-// application status, operating environment and version.
+// application status, operating environment and version, along with the state of each
+// dependency. The status is "available" if everything is healthy, and otherwise the
+// worst state of any dependency. If the API is unhealthy the response has a 503
+// status, so that load balancers take the instance out of rotation.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	dependencies := app.checkDependencies(r.Context())
+
+	status := "available"
+	code := http.StatusOK
+
+	for _, dep := range dependencies {
+		switch dep.Status {
+		case healthUnhealthy:
+			status = healthUnhealthy
+			code = http.StatusServiceUnavailable
+		case healthDegraded:
+			if status != healthUnhealthy {
+				status = healthDegraded
+			}
+		}
+	}
+
 	// Create a map which holds the information that we want to send in the response.
-	data := map[string]string{
-		"status":      "available",
-		"environment": app.config.env,
-		"version":     version,
+	data := map[string]any{
+		"status":       status,
+		"environment":  app.config.env,
+		"version":      version,
+		"dependencies": dependencies,
 	}
 
-	err := app.writeJSON(w, 200, envelope{"data": data}, nil)
+	err := app.writeJSON(w, code, envelope{"data": data}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The checkDependencies() method checks each dependency at the same time, so that the
+// healthcheck takes as long as the slowest check rather than all of them together.
+// Errors are logged rather than returned, since the healthcheck is public.
+func (app *application) checkDependencies(ctx context.Context) map[string]dependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	checks := map[string]func(ctx context.Context) (string, map[string]any){
+		"database":     app.checkDatabase,
+		"mailer":       app.checkMailer,
+		"background":   app.checkBackground,
+		"jobs":         app.checkJobs,
+		"rate_limiter": app.checkRateLimiter,
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]dependencyHealth, len(checks))
+	)
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			status, details := check(ctx)
+			latency := float64(time.Since(start).Microseconds()) / 1000
+
+			mu.Lock()
+			results[name] = dependencyHealth{Status: status, LatencyMS: latency, Details: details}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// The checkDatabase() method pings the database and reports on its connection pool.
+// The pool is degraded when every connection is in use and requests are waiting.
+func (app *application) checkDatabase(ctx context.Context) (string, map[string]any) {
+	stats := app.db.Stats()
+	details := map[string]any{
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+		"idle":             stats.Idle,
+		"max_open":         stats.MaxOpenConnections,
+		"wait_count":       stats.WaitCount,
+	}
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logger.Error("healthcheck: database ping failed", "error", err.Error())
+		return healthUnhealthy, details
+	}
+
+	if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		return healthDegraded, details
+	}
+
+	return healthOK, details
+}
+
+// The checkMailer() method checks that the SMTP server can be reached. Without it
+// emails are queued and retried, so the API still works.
+func (app *application) checkMailer(ctx context.Context) (string, map[string]any) {
+	err := app.mailer.Ping(ctx)
+	if err != nil {
+		app.logger.Error("healthcheck: SMTP server unreachable", "error", err.Error())
+		return healthDegraded, nil
+	}
+
+	return healthOK, nil
+}
+
+// The checkBackground() method reports the backlog of the in-process worker pool,
+// which is degraded once the queue is 90% full, since tasks start being rejected
+// soon after.
+func (app *application) checkBackground(ctx context.Context) (string, map[string]any) {
+	queued := app.workers.QueueDepth()
+	capacity := app.config.worker.queueSize
+
+	details := map[string]any{
+		"queued":   queued,
+		"running":  app.workers.Running(),
+		"capacity": capacity,
+	}
+
+	if capacity > 0 && queued*10 >= capacity*9 {
+		return healthDegraded, details
+	}
+
+	return healthOK, details
+}
+
+// The checkJobs() method reports how many persistent jobs are waiting to run.
+func (app *application) checkJobs(ctx context.Context) (string, map[string]any) {
+	counts, err := app.models.Jobs.CountByStatus()
+	if err != nil {
+		app.logger.Error("healthcheck: unable to count jobs", "error", err.Error())
+		return healthDegraded, nil
+	}
+
+	return healthOK, map[string]any{
+		"queued":  counts[data.JobQueued],
+		"running": counts[data.JobRunning],
+		"dead":    counts[data.JobDead],
+	}
+}
+
+// The checkRateLimiter() method reports how many clients the rate limiter is tracking,
+// which is how large its map has grown.
+func (app *application) checkRateLimiter(ctx context.Context) (string, map[string]any) {
+	return healthOK, map[string]any{
+		"enabled":         app.config.limiter.enabled,
+		"tracked_clients": app.limiterStats.clients.Load(),
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"greenlight/anaplo/internal/data"
 	"greenlight/anaplo/internal/validator"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -20,10 +24,16 @@ type envelope map[string]any
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper is like readIDParam(), for routes with more than one
+// ID parameter, like /v1/organizations/:id/members/:user_id.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -67,48 +77,54 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	err := dec.Decode(dst)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-		var maxBytesError *http.MaxBytesError
+		return jsonDecodeError(err)
+	}
 
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
 
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+	return nil
+}
 
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+// The jsonDecodeError() helper converts an error from a json.Decoder into a message
+// which is suitable for sending to the client.
+func jsonDecodeError(err error) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
 
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
 
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		}
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
 
-		default:
-			return err
-		}
-	}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
 
-	err = dec.Decode(&struct{}{})
-	if !errors.Is(err, io.EOF) {
-		return errors.New("body must only contain a single JSON value")
-	}
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
 
-	return nil
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	default:
+		return err
+	}
 }
 
 // The readString() helper returns a string value from the query string, or the provided
@@ -159,23 +175,135 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// The background() helper accepts an arbitrary function as a parameter.
-func (app *application) background(fn func()) { // Launch a background goroutine.
-	// Increment waitGroup counter by 1
-	// before launching go routine
-	app.wg.Add(1)
-
-	go func() {
-		// Use defer to decrement the WaitGroup counter before the goroutine returns.
-		defer app.wg.Done()
-
-		// Recover any panic.
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Error(fmt.Sprintf("%v", err))
-			}
-		}()
-		// Execute the arbitrary function that we passed as the parameter.
-		fn()
-	}()
+// The readIntRange() helper is like readInt(), but also records an error if the value
+// is outside the range from min to max inclusive, so that handlers don't each repeat
+// the same bounds check.
+func (app *application) readIntRange(qs url.Values, key string, defaultValue, min, max int, v *validator.Validator) int {
+	val := qs.Get(key)
+
+	if val == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	if i < min || i > max {
+		v.AddError(key, fmt.Sprintf("must be between %d and %d", min, max))
+		return defaultValue
+	}
+
+	return i
+}
+
+// The readFloat() helper reads a decimal number from the query string. If no matching
+// key could be found it returns the provided default value, and if the value couldn't
+// be parsed (or isn't a finite number) we record an error message in the provided
+// Validator instance.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	val := qs.Get(key)
+
+	if val == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+// The readBool() helper reads a boolean value from the query string. If no matching
+// key could be found it returns the provided default value, and if the value couldn't
+// be parsed we record an error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	val := qs.Get(key)
+
+	if val == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string. If no
+// matching key could be found it returns the zero time, and if the value couldn't be
+// parsed we record an error message in the provided Validator instance.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	val := qs.Get(key)
+
+	if val == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// The readDate() helper reads a calendar date like 2024-01-31 from the query string,
+// as midnight UTC. If no matching key could be found it returns the zero time, and if
+// the value couldn't be parsed we record an error message in the provided Validator
+// instance.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) time.Time {
+	val := qs.Get(key)
+
+	if val == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.DateOnly, val)
+	if err != nil {
+		v.AddError(key, "must be a date in the format YYYY-MM-DD")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// The readMoney() helper reads an amount of money like "1500000.00 USD" from the query
+// string. If no matching key could be found it returns nil, and if the value couldn't
+// be parsed we record an error message in the provided Validator instance.
+func (app *application) readMoney(qs url.Values, key string, v *validator.Validator) *data.Money {
+	val := qs.Get(key)
+
+	if val == "" {
+		return nil
+	}
+
+	m, err := data.ParseMoney(val)
+	if err != nil {
+		v.AddError(key, err.Error())
+		return nil
+	}
+
+	return &m
+}
+
+// The background() helper accepts an arbitrary function as a parameter and hands it
+// to the worker pool. The pool recovers any panics in the function, so we only need
+// to log the case where the task couldn't be queued at all. The name identifies the
+// task in log messages, and the function should return promptly when its context is
+// cancelled during shutdown.
+func (app *application) background(name string, fn func(ctx context.Context)) {
+	err := app.workers.Submit(name, fn)
+	if err != nil {
+		app.logger.Error("unable to queue background task", "task", name, "error", err.Error())
+	}
 }
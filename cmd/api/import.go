@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportBytes is the largest file which POST /v1/admin/movies/import accepts.
+const maxImportBytes = 64 << 20
+
+// importReadTimeout is how long a client has to upload an import file, which can take
+// longer than the server's read timeout allows.
+const importReadTimeout = 5 * time.Minute
+
+// importColumns are the columns which an import file can have. The id and created_at
+// columns of an export are also allowed, so that an export can be imported as it is,
+// but they're ignored since new movies get their own.
+var importColumns = map[string]bool{
+	"title": true, "year": true, "runtime": true, "genres": true, "tmdb_id": true, "imdb_id": true,
+	"budget": true, "box_office": true, "synopsis": true, "poster_url": true, "id": true, "created_at": true,
+}
+
+// importPayload is the payload for jobImportMovies jobs. The file itself is too large
+// for a job payload, so it's stored as a movie import until the job has finished.
+type importPayload struct {
+	ImportID       int64  `json:"import_id"`
+	OrganizationID int64  `json:"organization_id"`
+	Format         string `json:"format"`
+	BatchSize      int    `json:"batch_size"`
+}
+
+// The importMoviesHandler() adds the movies in a CSV or TSV file, sent as the request
+// body, to the organization's catalog. The file has a header row naming its columns,
+// in the same format as POST /v1/exports/movies, and title, year, runtime and genres
+// are required. The header is checked straight away, and the rows are imported by a
+// job, whose progress can be followed at the URL in the Location header.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "csv")
+	batchSize := app.readInt(qs, "batch_size", 500, v)
+
+	v.Check(validator.PermittedValues(format, "csv", "tsv"), "format", "must be csv or tsv")
+	v.Check(batchSize >= 1 && batchSize <= 5000, "batch_size", "must be between 1 and 5000")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check there's an organization to add the movies to before reading the file.
+	organizationID := app.contextGetOrganization(r)
+	if organizationID < 1 {
+		app.noOrganizationResponse(w, r)
+		return
+	}
+
+	// A large file can take longer to upload than the server's read timeout allows.
+	rc := http.NewResponseController(w)
+
+	err := rc.SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	file, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = readImportHeader(newImportReader(file, format))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	importID, err := app.models.MovieImports.Insert(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	job, err := app.enqueueUserJob(user.ID, jobImportMovies, importPayload{
+		ImportID:       importID,
+		OrganizationID: organizationID,
+		Format:         format,
+		BatchSize:      batchSize,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "import", "movie", 0, map[string]any{"format": format, "job_id": job.ID})
+
+	app.jobAcceptedResponse(w, r, job)
+}
+
+// The importMoviesJob() imports the rows of an uploaded file. Each row is validated
+// like a movie created through the API, and the valid rows are added with COPY in
+// batches, each in its own transaction. Invalid rows and failed batches are reported
+// in the job's errors rather than stopping the import. Progress is saved after each
+// batch, so if the job is retried it carries on from where it stopped rather than
+// importing rows twice.
+//
+// Imported movies go into the movie events stream, but aren't sent to webhooks or
+// checked for duplicates.
+func (app *application) importMoviesJob(ctx context.Context, job *data.Job) (err error) {
+	var p importPayload
+
+	err = json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	file, err := app.models.MovieImports.Get(p.ImportID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// The file is only kept until the import has finished, or has failed for the last
+	// time.
+	defer func() {
+		if err == nil || job.Attempts >= job.MaxAttempts {
+			deleteErr := app.models.MovieImports.Delete(p.ImportID)
+			if deleteErr != nil {
+				app.logger.Error("unable to delete movie import", "import_id", p.ImportID, "error", deleteErr.Error())
+			}
+		}
+	}()
+
+	total, err := countImportRows(newImportReader(file, p.Format))
+	if err != nil {
+		return err
+	}
+
+	cr := newImportReader(file, p.Format)
+
+	columns, err := readImportHeader(cr)
+	if err != nil {
+		return err
+	}
+
+	catalog, err := app.models.Genres.GetAll()
+	if err != nil {
+		return err
+	}
+
+	genres := map[string]bool{}
+	for _, genre := range catalog {
+		genres[genre.Slug] = true
+	}
+
+	movies := app.models.Movies.ForOrganization(p.OrganizationID)
+	if job.UserID != nil {
+		movies = movies.ByUser(*job.UserID)
+	}
+
+	var (
+		rowErrors []string
+		batch     []*data.Movie
+		batchRows []int
+		imported  int
+	)
+
+	done := min(job.Progress.Done, total)
+
+	flush := func(read int) {
+		if len(batch) > 0 {
+			first, last := batchRows[0], batchRows[len(batchRows)-1]
+
+			err := app.copyInMovies(ctx, movies, batch)
+			if err != nil {
+				app.logger.Info("import batch failed", "job_id", job.ID, "first_row", first, "last_row", last, "error", err.Error())
+				rowErrors = append(rowErrors, fmt.Sprintf("rows %d-%d: %s", first, last, importBatchMessage(err)))
+			} else {
+				imported += len(batch)
+			}
+		}
+
+		app.reportJobProgress(job, read, total, rowErrors...)
+		rowErrors, batch, batchRows = nil, nil, nil
+	}
+
+	for read := 0; ; read++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			flush(read)
+			break
+		}
+
+		// Rows which were dealt with by an earlier attempt are skipped.
+		if read < done {
+			continue
+		}
+
+		if err != nil {
+			// A malformed row is reported like an invalid one, and reading carries on
+			// from the next.
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+
+			rowErrors = append(rowErrors, fmt.Sprintf("row %d: %s", parseErr.StartLine, parseErr.Err.Error()))
+			continue
+		}
+
+		row, _ := cr.FieldPos(0)
+
+		movie, errs := parseImportRow(record, columns, genres)
+		if errs != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("row %d: %s", row, importRowMessage(errs)))
+			continue
+		}
+
+		batch = append(batch, movie)
+		batchRows = append(batchRows, row)
+
+		if len(batch) == p.BatchSize {
+			flush(read + 1)
+		}
+	}
+
+	app.logger.Info("movie import finished", "job_id", job.ID, "imported", imported, "errors", len(job.Errors))
+	return nil
+}
+
+// newImportReader returns a reader for the rows of an import file in the given
+// format.
+func newImportReader(file []byte, format string) *csv.Reader {
+	cr := csv.NewReader(bytes.NewReader(file))
+	cr.ReuseRecord = true
+	if format == "tsv" {
+		cr.Comma = '\t'
+		cr.LazyQuotes = true
+	}
+
+	return cr
+}
+
+// readImportHeader reads the header row of an import file, returning the index of
+// each column by name. It returns an error if a column is unknown or a required one
+// is missing.
+func readImportHeader(cr *csv.Reader) (map[string]int, error) {
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header row: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+
+		if !importColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing required column %q", name)
+		}
+	}
+
+	return columns, nil
+}
+
+// countImportRows returns the number of rows after the header in an import file,
+// including malformed ones.
+func countImportRows(cr *csv.Reader) (int, error) {
+	n := -1
+
+	for {
+		_, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return max(n, 0), nil
+		}
+
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return 0, err
+		}
+
+		n++
+	}
+}
+
+// The copyInMovies() method adds a batch of imported movies in a transaction of its
+// own.
+func (app *application) copyInMovies(ctx context.Context, movies data.MovieModel, batch []*data.Movie) error {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = movies.CopyIn(tx, batch)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// importBatchMessage describes why a batch failed, without giving away anything about
+// the database beyond what the client needs to fix the file.
+func importBatchMessage(err error) string {
+	switch {
+	case errors.Is(err, data.ErrDuplicateTMDBID):
+		return "a movie with one of these TMDB IDs is already in the catalog, or appears twice in the file"
+	default:
+		return "the rows could not be saved"
+	}
+}
+
+// importRowMessage describes why a row was rejected, listing the problems with each
+// column in order.
+func importRowMessage(errs map[string]string) string {
+	problems := []string{}
+	for key, message := range errs {
+		problems = append(problems, key+" "+message)
+	}
+	slices.Sort(problems)
+
+	return strings.Join(problems, "; ")
+}
+
+// parseImportRow turns a row of an import file into a movie, validating it in the same
+// way as a movie created through the API. If the row is invalid, it returns the
+// errors by column instead.
+func parseImportRow(record []string, columns map[string]int, genres map[string]bool) (*data.Movie, map[string]string) {
+	v := validator.New()
+
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	movie := &data.Movie{
+		Title:     field("title"),
+		Links:     data.MovieLinks{IMDbID: field("imdb_id")},
+		Synopsis:  field("synopsis"),
+		PosterURL: field("poster_url"),
+		Genres:    []string{},
+	}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		v.Check(err == nil, "year", "must be an integer")
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		runtime, err := strconv.ParseInt(strings.TrimSuffix(s, " mins"), 10, 32)
+		v.Check(err == nil, "runtime", "must be an integer number of minutes")
+		movie.Runtime = data.Runtime(runtime)
+	}
+
+	for _, genre := range strings.Split(field("genres"), ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			movie.Genres = append(movie.Genres, genre)
+		}
+	}
+
+	if s := field("tmdb_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id > 0, "tmdb_id", "must be a positive integer")
+		movie.TMDBID = &id
+	}
+
+	for name, dst := range map[string]**data.Money{"budget": &movie.Budget, "box_office": &movie.BoxOffice} {
+		if s := field(name); s != "" {
+			m, err := data.ParseMoney(s)
+			if err != nil {
+				v.AddError(name, err.Error())
+				continue
+			}
+			*dst = &m
+		}
+	}
+
+	unknown := []string{}
+	for _, genre := range movie.Genres {
+		if !genres[genre] {
+			unknown = append(unknown, genre)
+		}
+	}
+	if len(unknown) > 0 {
+		v.AddError("genres", "must only contain genres from GET /v1/genres; unknown: "+strings.Join(unknown, ", "))
+	}
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, v.Errors
+	}
+
+	return movie, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"time"
+)
+
+// The listInvitesHandler() lists the invites which can still be used. Their codes
+// aren't included, since only their hashes are stored.
+func (app *application) listInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := app.models.Invites.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invites": invites, "invite_only": app.config.registration.inviteOnly}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createInviteHandler() creates an invite, whose code is in the response for the
+// admin to pass on. It's the only time the code is shown.
+func (app *application) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Note string `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	invite := &data.Invite{
+		CreatedBy: &user.ID,
+		Note:      input.Note,
+		Expiry:    time.Now().Add(app.config.registration.inviteTTL),
+	}
+
+	v := validator.New()
+
+	if data.ValidateInvite(v, invite); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invites.Insert(invite)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "invite", invite.ID, map[string]string{"note": invite.Note})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/invites/%d", invite.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invite": invite}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Invites.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "invite", id, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invite successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Define constants for the kinds of job which can be queued.
+const (
+	jobSendEmail            = "send_email"
+	jobSendTokenEmail       = "send_token_email"
+	jobDeliverWebhook       = "deliver_webhook"
+	jobSendAnnouncement     = "send_announcement"
+	jobForwardSecurityEvent = "forward_security_event"
+	jobSendPush             = "send_push"
+	jobExportBackup         = "export_backup"
+	jobEnrichMovie          = "enrich_movie"
+	jobImportMovies         = "import_movies"
+	jobExportMovies         = "export_movies"
+)
+
+// A jobHandler executes a single job. The job's payload is the JSON value which was
+// passed to enqueueJob(), and long-running handlers can report their progress with
+// reportJobProgress(). Returning an error causes the job to be retried later.
+type jobHandler func(ctx context.Context, job *data.Job) error
+
+// The jobHandlers() method returns the handler for each kind of job.
+func (app *application) jobHandlers() map[string]jobHandler {
+	return map[string]jobHandler{
+		jobSendEmail:            app.sendEmailJob,
+		jobSendTokenEmail:       app.sendTokenEmailJob,
+		jobDeliverWebhook:       app.deliverWebhookJob,
+		jobSendAnnouncement:     app.sendAnnouncementJob,
+		jobForwardSecurityEvent: app.forwardSecurityEventJob,
+		jobSendPush:             app.sendPushJob,
+		jobExportBackup:         app.exportBackupJob,
+		jobEnrichMovie:          app.enrichMovieJob,
+		jobImportMovies:         app.importMoviesJob,
+		jobExportMovies:         app.exportMoviesJob,
+	}
+}
+
+// The enqueueJob() helper stores a job in the database for one of the job runners to
+// pick up. Unlike the tasks passed to background(), queued jobs survive a restart
+// or crash of the application.
+func (app *application) enqueueJob(kind string, payload any) (*data.Job, error) {
+	return app.models.Jobs.Enqueue(kind, payload, app.config.jobs.maxAttempts)
+}
+
+// The enqueueUserJob() helper queues a job on behalf of a user, who can follow its
+// progress at GET /v1/jobs/:id.
+func (app *application) enqueueUserJob(userID int64, kind string, payload any) (*data.Job, error) {
+	return app.models.Jobs.EnqueueForUser(userID, kind, payload, app.config.jobs.maxAttempts)
+}
+
+// The reportJobProgress() helper records the progress of a running job. Failing to
+// save the progress isn't a reason to fail the job itself, so errors are only logged.
+func (app *application) reportJobProgress(job *data.Job, done, total int, errs ...string) {
+	job.Progress.Done = done
+	job.Progress.Total = total
+	job.Errors = append(job.Errors, errs...)
+
+	err := app.models.Jobs.UpdateProgress(job)
+	if err != nil {
+		app.logger.Error("unable to update job progress", "job_id", job.ID, "error", err.Error())
+	}
+}
+
+// The jobAcceptedResponse() helper sends a 202 Accepted response for an operation which
+// will be carried out by a job, pointing the client to where it can check the job's
+// status.
+func (app *application) jobAcceptedResponse(w http.ResponseWriter, r *http.Request, job *data.Job) {
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+
+	err := app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// jobResource is a job together with its links.
+type jobResource struct {
+	*data.Job
+	Links links `json:"_links"`
+}
+
+// The showJobHandler() reports the status of a job started by the current user. Once
+// an export job has completed, its links include one to download the file.
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.jobForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	jobLinks := app.resourceLinks("/v1/jobs/:id", job.ID)
+	if !jobHasFile(job) {
+		delete(jobLinks, "download")
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"job": jobResource{job, jobLinks}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The downloadJobFileHandler() sends the file made by an export job started by the
+// current user. It's a 409 Conflict if the job hasn't completed yet.
+func (app *application) downloadJobFileHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.jobForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Kind != jobExportMovies {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !jobHasFile(job) {
+		app.errorResponse(w, r, http.StatusConflict, "the job has not completed")
+		return
+	}
+
+	file, err := app.models.MovieExports.Get(job.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies-%d.csv"`, job.ID))
+	w.Header().Set("Content-Length", strconv.Itoa(len(file)))
+
+	_, err = w.Write(file)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// jobHasFile reports whether a job has made a file which can be downloaded.
+func jobHasFile(job *data.Job) bool {
+	return job.Kind == jobExportMovies && job.Status == data.JobCompleted
+}
+
+// The jobForRequest() helper looks up the job in the :id parameter. Users can only
+// see their own jobs, so if there's no such job or it was started by someone else, it
+// sends a 404 Not Found response, so as not to reveal which job IDs exist, and
+// returns false.
+func (app *application) jobForRequest(w http.ResponseWriter, r *http.Request) (*data.Job, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	job, err := app.models.Jobs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+	if job.UserID == nil || *job.UserID != user.ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return job, true
+}
+
+// The startJobRunners() method launches the configured number of goroutines which
+// poll the jobs table and execute any due jobs. They stop claiming new jobs when
+// stopCtx is cancelled, and the returned WaitGroup can be used to wait for the job
+// they're currently working on to finish. The jobs themselves receive workCtx, which
+// is only cancelled once the shutdown grace period has run out.
+func (app *application) startJobRunners(stopCtx, workCtx context.Context) *sync.WaitGroup {
+	handlers := app.jobHandlers()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < app.config.jobs.concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(app.config.jobs.pollInterval)
+			defer ticker.Stop()
+
+			for {
+				// Keep working through jobs until there are none left, then wait for the
+				// next tick before checking again.
+				for stopCtx.Err() == nil && app.runNextJob(workCtx, handlers) {
+				}
+
+				select {
+				case <-stopCtx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	return &wg
+}
+
+// The runNextJob() method claims and executes a single job, returning false if there
+// was no job to run (or it wasn't possible to check).
+func (app *application) runNextJob(ctx context.Context, handlers map[string]jobHandler) bool {
+	job, err := app.models.Jobs.ClaimNext(app.config.jobs.lease)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.Error("unable to claim job", "error", err.Error())
+		}
+		return false
+	}
+
+	err = app.executeJob(ctx, handlers, job)
+	if err != nil {
+		// Back off exponentially between attempts, up to a maximum of one hour.
+		retryAfter := time.Duration(math.Min(
+			float64(app.config.jobs.retryBase)*math.Pow(2, float64(job.Attempts-1)),
+			float64(time.Hour),
+		))
+
+		failErr := app.models.Jobs.Fail(job, err, retryAfter)
+		if failErr != nil {
+			app.logger.Error("unable to record job failure", "job_id", job.ID, "error", failErr.Error())
+			return true
+		}
+
+		app.logger.Error("job failed", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "status", job.Status, "error", err.Error())
+		return true
+	}
+
+	err = app.models.Jobs.Complete(job)
+	if err != nil {
+		app.logger.Error("unable to mark job completed", "job_id", job.ID, "error", err.Error())
+	}
+
+	return true
+}
+
+// The executeJob() method runs the handler for a job, converting any panic into an
+// error so that the job is retried rather than crashing the runner.
+func (app *application) executeJob(ctx context.Context, handlers map[string]jobHandler, job *data.Job) (err error) {
+	handler, ok := handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler registered for job kind %q", job.Kind)
+	}
+
+	defer func() {
+		if pv := recover(); pv != nil {
+			err = fmt.Errorf("panic: %v", pv)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// emailPayload is the payload for jobSendEmail jobs.
+type emailPayload struct {
+	Recipient string            `json:"recipient"`
+	Template  string            `json:"template"`
+	Data      map[string]any    `json:"data"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// The enqueueEmail() helper queues an email to be sent by the job runners, which
+// means that it will be retried if the SMTP server is unavailable.
+func (app *application) enqueueEmail(recipient, templateFile string, data map[string]any) error {
+	return app.enqueueEmailWithHeaders(recipient, templateFile, data, nil)
+}
+
+// The enqueueEmailWithHeaders() helper is like enqueueEmail(), but adds extra headers
+// to the email.
+func (app *application) enqueueEmailWithHeaders(recipient, templateFile string, data map[string]any, headers map[string]string) error {
+	_, err := app.enqueueJob(jobSendEmail, emailPayload{
+		Recipient: recipient,
+		Template:  templateFile,
+		Data:      data,
+		Headers:   headers,
+	})
+	return err
+}
+
+func (app *application) sendEmailJob(ctx context.Context, job *data.Job) error {
+	var p emailPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.SendWithHeaders(p.Recipient, p.Template, p.Data, p.Headers)
+}
+
+// tokenEmailPayload is the payload for jobSendTokenEmail jobs. Everything else the
+// email needs, like who it's for, comes from the token which belongs to the job, so
+// neither the token nor any email address is stored in the jobs table.
+type tokenEmailPayload struct {
+	Template string `json:"template"`
+}
+
+// The enqueueTokenEmail() helper queues an email containing a token for a user, like
+// an activation token or a magic link. The token is stored along with the job, in one
+// transaction, but it can't be used until the job gives it a plaintext to send.
+func (app *application) enqueueTokenEmail(token *data.Token, template string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	job, err := app.models.Jobs.WithTx(tx).Enqueue(jobSendTokenEmail, tokenEmailPayload{Template: template}, app.config.jobs.maxAttempts)
+	if err != nil {
+		return err
+	}
+
+	tokens := app.models.Tokens.WithTx(tx)
+
+	err = tokens.InsertForJob(token, job.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (app *application) sendTokenEmailJob(ctx context.Context, job *data.Job) error {
+	var p tokenEmailPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	// Each attempt gets a new plaintext for the job's token, which stops the one from
+	// any earlier attempt working. If the token has gone, because it was used, it
+	// expired or a newer request replaced it, there's nothing to send.
+	token, err := app.models.Tokens.Reissue(job.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	recipient := user.Email
+	emailData := map[string]any{}
+
+	switch token.Scope {
+	case data.ScopeActivation:
+		if user.Activated {
+			return nil
+		}
+
+		emailData["activationToken"] = token.PlainText
+		emailData["activationURL"] = app.activationURL(token.PlainText)
+		emailData["userID"] = user.ID
+	case data.ScopeMagicLink:
+		emailData["loginURL"] = app.magicLinkURL(token.PlainText)
+		emailData["token"] = token.PlainText
+		emailData["minutes"] = int(magicLinkTTL.Minutes())
+	case data.ScopeEmailChange:
+		recipient = *token.Email
+		emailData["token"] = token.PlainText
+		emailData["hours"] = int(emailChangeTTL.Hours())
+	default:
+		return fmt.Errorf("unknown token scope %q", token.Scope)
+	}
+
+	return app.mailer.Send(recipient, p.Template, emailData)
+}
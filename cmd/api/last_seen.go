@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A lastSeenBuffer keeps when each user last made an authenticated request in memory
+// until it's flushed to the database, in the same way as an endpointUsageBuffer, so
+// that however many requests a user makes there's at most one write for them per
+// flush. Users who haven't made a request since this was added have no last_seen_at.
+type lastSeenBuffer struct {
+	mu   sync.Mutex
+	seen map[int64]time.Time
+}
+
+func newLastSeenBuffer() *lastSeenBuffer {
+	return &lastSeenBuffer{seen: map[int64]time.Time{}}
+}
+
+// add records that a user was seen at the given time, to the nearest minute.
+func (b *lastSeenBuffer) add(userID int64, t time.Time) {
+	t = t.UTC().Truncate(time.Minute)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t.After(b.seen[userID]) {
+		b.seen[userID] = t
+	}
+}
+
+// take empties the buffer, returning what was in it.
+func (b *lastSeenBuffer) take() map[int64]time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := b.seen
+	b.seen = map[int64]time.Time{}
+
+	return seen
+}
+
+// The flushLastSeen() method writes when users were last seen to the database, on the
+// same schedule as flushEndpointUsage(). If a flush fails the times are put back, to
+// be tried again next time.
+func (app *application) flushLastSeen(ctx context.Context) {
+	ticker := time.NewTicker(app.config.usageFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		seen := app.lastSeen.take()
+		if len(seen) == 0 {
+			return
+		}
+
+		err := app.models.Users.RecordLastSeen(seen)
+		if err != nil {
+			app.logger.Error("unable to record when users were last seen", "users", len(seen), "error", err.Error())
+
+			for id, t := range seen {
+				app.lastSeen.add(id, t)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
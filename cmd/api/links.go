@@ -0,0 +1,187 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/data"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A link describes a related URL in the _links object of a response, so that clients
+// can navigate the API without hardcoding URL templates.
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+type links map[string]link
+
+// movieResource is a movie together with its links.
+type movieResource struct {
+	*data.Movie
+	Links links `json:"_links"`
+}
+
+// collectionResource is a collection together with its links.
+type collectionResource struct {
+	*data.Collection
+	Links links `json:"_links"`
+}
+
+// seriesResource is a TV series together with its links.
+type seriesResource struct {
+	*data.Series
+	Links links `json:"_links"`
+}
+
+// The newLinkTemplates() function indexes the route table by path, for building the
+// links of resources. Routes on a resource's own path become the self, update, replace
+// and delete links, and GET routes on its sub-paths (like /v1/movies/:id/reviews) are
+// named after their last segment. This means that links appear as soon as the route
+// does. The hrefs are left as patterns, to be filled in by resourceLinks().
+func newLinkTemplates(table []route) map[string]links {
+	rels := map[string]string{
+		http.MethodGet:    "self",
+		http.MethodPatch:  "update",
+		http.MethodPut:    "replace",
+		http.MethodDelete: "delete",
+	}
+
+	templates := map[string]links{}
+	add := func(pattern, rel string, l link) {
+		if templates[pattern] == nil {
+			templates[pattern] = links{}
+		}
+		templates[pattern][rel] = l
+	}
+
+	for _, rt := range table {
+		if rel := rels[rt.method]; rel != "" {
+			add(rt.path, rel, link{Href: rt.path, Method: rt.method})
+		}
+
+		if dir, last := path.Split(rt.path); rt.method == http.MethodGet && dir != "/" && !strings.HasPrefix(last, ":") {
+			add(strings.TrimSuffix(dir, "/"), last, link{Href: rt.path, Method: rt.method})
+		}
+	}
+
+	return templates
+}
+
+// The resourceLinks() method builds the links for a single resource from the link
+// templates for its path.
+func (app *application) resourceLinks(pattern string, id int64) links {
+	result := links{}
+
+	for rel, l := range app.linkTemplates[pattern] {
+		l.Href = strings.Replace(l.Href, ":id", strconv.FormatInt(id, 10), 1)
+		result[rel] = l
+	}
+
+	return result
+}
+
+// The pageLinks() method builds the self, first, last, next and prev links for a page
+// of a list response, keeping the rest of the request's query string.
+func (app *application) pageLinks(r *http.Request, metadata data.Metadata) links {
+	pageURL := func(page int) link {
+		qs := r.URL.Query()
+		if page > 0 {
+			qs.Set("page", strconv.Itoa(page))
+		}
+
+		u := url.URL{Path: r.URL.Path, RawQuery: qs.Encode()}
+		return link{Href: u.String(), Method: http.MethodGet}
+	}
+
+	result := links{"self": pageURL(0)}
+
+	// A page fetched with a cursor has no page numbers, only a cursor for the next page.
+	if metadata.CurrentPage == 0 && metadata.NextCursor != "" {
+		qs := r.URL.Query()
+		qs.Set("cursor", metadata.NextCursor)
+		qs.Del("page")
+
+		u := url.URL{Path: r.URL.Path, RawQuery: qs.Encode()}
+		result["next"] = link{Href: u.String(), Method: http.MethodGet}
+		return result
+	}
+
+	// The metadata is empty if there were no records.
+	if metadata.TotalRecords == 0 {
+		return result
+	}
+
+	result["first"] = pageURL(metadata.FirstPage)
+	result["last"] = pageURL(metadata.LastPage)
+
+	if metadata.CurrentPage < metadata.LastPage {
+		result["next"] = pageURL(metadata.CurrentPage + 1)
+	}
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		result["prev"] = pageURL(metadata.CurrentPage - 1)
+	}
+
+	return result
+}
+
+func (app *application) movieResource(movie *data.Movie) movieResource {
+	return movieResource{Movie: movie, Links: app.resourceLinks("/v1/movies/:id", movie.ID)}
+}
+
+func (app *application) collectionResource(collection *data.Collection) collectionResource {
+	return collectionResource{Collection: collection, Links: app.resourceLinks("/v1/collections/:id", collection.ID)}
+}
+
+func (app *application) seriesResource(series *data.Series) seriesResource {
+	return seriesResource{Series: series, Links: app.resourceLinks("/v1/series/:id", series.ID)}
+}
+
+// The publicURL() method returns the absolute URL of a path on the API, with the given
+// query string, for links which are followed from outside it, like those in emails.
+func (app *application) publicURL(path string, qs url.Values) string {
+	base := app.config.publicURL
+	if base == "" {
+		base = "http://localhost:" + strconv.Itoa(app.config.port)
+	}
+
+	u := url.URL{Path: path, RawQuery: qs.Encode()}
+	return strings.TrimSuffix(base, "/") + u.String()
+}
+
+// The requestURL() method returns the absolute URL of the request, as it would be
+// reached through the public URL, for links to other pages of a list.
+func (app *application) requestURL(r *http.Request) *url.URL {
+	u, err := url.Parse(app.publicURL(r.URL.Path, r.URL.Query()))
+	if err != nil {
+		return nil
+	}
+
+	return u
+}
+
+// The activationURL() method returns the link in activation emails. If there's a web
+// frontend it handles activation itself, otherwise the link activates the account
+// directly on the API.
+func (app *application) activationURL(token string) string {
+	if app.config.frontendURL != "" {
+		u := url.URL{Path: "/activate", RawQuery: url.Values{"token": {token}}.Encode()}
+		return strings.TrimSuffix(app.config.frontendURL, "/") + u.String()
+	}
+
+	return app.publicURL("/v1/users/activate", url.Values{"token": {token}})
+}
+
+// The magicLinkURL() method returns the sign in link in magic link emails. Like
+// activation links, they point to the web frontend if there is one.
+func (app *application) magicLinkURL(token string) string {
+	if app.config.frontendURL != "" {
+		u := url.URL{Path: "/login/magic", RawQuery: url.Values{"token": {token}}.Encode()}
+		return strings.TrimSuffix(app.config.frontendURL, "/") + u.String()
+	}
+
+	return app.publicURL("/v1/tokens/magic-link", url.Values{"token": {token}})
+}
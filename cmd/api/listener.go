@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener wraps a listener so that at most a fixed number of its connections
+// are open at once. Accept() blocks until one of the open connections is closed, so
+// the extra clients wait in the kernel's backlog rather than being refused. Note that
+// each open event stream holds on to a connection for as long as it lasts.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+
+	return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+}
+
+// Close stops the listener, including unblocking an Accept() which is waiting for a
+// connection to close.
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitConn gives back its slot in the limitListener when it's closed.
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
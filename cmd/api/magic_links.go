@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"time"
+)
+
+// Magic links expire quickly, since anyone who gets hold of the email can use one.
+const magicLinkTTL = 15 * time.Minute
+
+// The createMagicLinkHandler() emails a single-use sign in link to a user, which lets
+// them sign in without a password. The response is the same whether or not there's an
+// account for the email address, so that it can't be used to find out who has one.
+func (app *application) createMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email          string `json:"email"`
+		OrganizationID *int64 `json:"organization_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+
+	if input.OrganizationID != nil {
+		v.Check(*input.OrganizationID > 0, "organization_id", "must be a positive integer")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		token := &data.Token{UserID: user.ID, Expiry: time.Now().Add(magicLinkTTL), Scope: data.ScopeMagicLink, OrganizationID: input.OrganizationID}
+
+		err = app.enqueueTokenEmail(token, "magic_link.tmpl")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "if there is an account for this email address, a sign in link will be sent to it"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showMagicLinkHandler() handles a magic link which was followed directly, with
+// the token in the query string.
+func (app *application) showMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	app.exchangeMagicLink(w, r, r.URL.Query().Get("token"))
+}
+
+// The exchangeMagicLinkHandler() exchanges the token from a magic link, sent in the
+// request body, for an authentication token.
+func (app *application) exchangeMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlainTextToken string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.exchangeMagicLink(w, r, input.PlainTextToken)
+}
+
+// The exchangeMagicLink() method uses up a magic link token and responds with a new
+// authentication token, just like signing in with a password does.
+func (app *application) exchangeMagicLink(w http.ResponseWriter, r *http.Request, plaintext string) {
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Like activation tokens, magic link tokens aren't tied to an email address in the
+	// request, so guessing them is throttled by IP address only.
+	if !app.checkAuthThrottle(w, r, data.AuthFailureMagicLink, "") {
+		return
+	}
+
+	magic, err := app.models.Tokens.Consume(data.ScopeMagicLink, plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAuthFailure(r, data.AuthFailureMagicLink, "")
+			v.AddError("token", "invalid or expired sign in link")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(magic.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired sign in link")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.newAuthenticationToken(user.ID, magic.OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotOrganizationMember):
+			v.AddError("organization_id", "you are not a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	details := map[string]string{"method": "magic_link"}
+
+	app.audit(r, "login", "user", user.ID, details)
+	app.securityEvent(r, user.ID, data.SecurityLoginSucceeded, data.SeverityInfo, details)
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,17 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"expvar"
 	"flag"
 	"fmt"
 	"greenlight/anaplo/internal/data"
 	"greenlight/anaplo/internal/mailer"
+	"greenlight/anaplo/internal/objectstore"
+	"greenlight/anaplo/internal/omdb"
+	"greenlight/anaplo/internal/push"
+	"greenlight/anaplo/internal/pwned"
+	"greenlight/anaplo/internal/storage"
+	"greenlight/anaplo/internal/tmdb"
 	"greenlight/anaplo/internal/vcs"
+	"greenlight/anaplo/internal/worker"
 	"log/slog"
+	"net/http"
 	"os"
 	"runtime"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	// Import the pq driver so that it can register itself with the database/sql
@@ -26,18 +35,36 @@ var version = vcs.Version()
 
 // Define a config struct to hold all the configuration settings for application.
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port          int
+	env           string
+	shutdownGrace time.Duration
+	// Settings for the HTTP server. A maxConns of zero means connections aren't
+	// limited.
+	server struct {
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		maxHeaderBytes    int
+		maxConns          int
+	}
+	// The URL clients reach the API at, for building links in emails. It defaults to
+	// http://localhost:<port>.
+	publicURL string
+	// The URL of the web frontend, if there is one. Activation links in emails point
+	// to it rather than to the API.
+	frontendURL string
+	db          struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps        float64
+		burst      int
+		enabled    bool
+		exemptions string
 	}
 	smtp struct {
 		host     string
@@ -49,17 +76,204 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	tmdb struct {
+		baseURL      string
+		imageBaseURL string
+		apiKey       string
+	}
+	// Newly created movies are enriched with details from OMDb if an API key is set.
+	omdb struct {
+		baseURL string
+		apiKey  string
+	}
+	// If enabled, new passwords are checked against the Pwned Passwords API, and ones
+	// which have appeared in data breaches are refused.
+	pwnedPasswords struct {
+		enabled bool
+		baseURL string
+		timeout time.Duration
+	}
+	// If url is set, security events are forwarded to it.
+	siem struct {
+		url string
+	}
+	// The S3-compatible bucket which backups are uploaded to. Backups are disabled if
+	// the endpoint is empty.
+	backup struct {
+		endpoint  string
+		region    string
+		bucket    string
+		accessKey string
+		secretKey string
+		prefix    string
+		retention time.Duration
+	}
+	// Where uploaded posters are stored: "disk", in dir, or "s3", in the bucket.
+	posters struct {
+		storage     string
+		dir         string
+		maxBytes    int64
+		s3Endpoint  string
+		s3Region    string
+		s3Bucket    string
+		s3AccessKey string
+		s3SecretKey string
+	}
+	// Credentials for sending push notifications. Each platform is only enabled if
+	// its credentials are set.
+	push struct {
+		fcmCredentialsFile string
+		apnsKeyFile        string
+		apnsKeyID          string
+		apnsTeamID         string
+		apnsTopic          string
+		apnsSandbox        bool
+	}
+	// The key used to encrypt users' names and email addresses. Encryption is
+	// disabled if it's empty.
+	pii struct {
+		key string
+	}
+	// The keys used to hash tokens, in the form accepted by data.ParseTokenKeys().
+	tokens struct {
+		keys     string
+		keyGrace time.Duration
+	}
+	// If inviteOnly is set, registering needs an invite code, which admins create with
+	// POST /v1/admin/invites. Invites expire after inviteTTL.
+	registration struct {
+		inviteOnly bool
+		inviteTTL  time.Duration
+	}
+	// Reviews are held for moderation before they're shown to other users. If
+	// autoApprove is set, reviews by trusted users are approved straight away: users
+	// who can moderate reviews, and users with at least trustedAfter approved reviews.
+	// Approved reviews go back to moderation once they've been reported
+	// reportThreshold times, unless it's zero.
+	reviews struct {
+		autoApprove     bool
+		trustedAfter    int
+		reportThreshold int
+	}
+	worker struct {
+		concurrency int
+		queueSize   int
+		block       bool
+	}
+	jobs struct {
+		concurrency  int
+		pollInterval time.Duration
+		lease        time.Duration
+		retryBase    time.Duration
+		maxAttempts  int
+	}
+	scheduler struct {
+		tokenCleanup      string
+		accountPurge      string
+		digest            string
+		eventsPrune       string
+		authFailuresPrune string
+		savedSearches     string
+		backup            string
+		recommendations   string
+		viewsPrune        string
+		metricsRollup     string
+		jobsPrune         string
+		lease             time.Duration
+		unactivatedTTL    time.Duration
+		deletedGrace      time.Duration
+		eventsTTL         time.Duration
+		jobsTTL           time.Duration
+	}
+	// Settings for slowing down and blocking repeated failed login and activation
+	// attempts.
+	authThrottle struct {
+		enabled      bool
+		window       time.Duration
+		freeAttempts int
+		blockAfter   int
+		maxDelay     time.Duration
+	}
+	// Request quotas for each user, or each application a user has authorized. Zero
+	// means unlimited.
+	quota struct {
+		daily   int64
+		monthly int64
+	}
+	// How often the per-endpoint usage and movie views which are added up in memory
+	// are written to the database.
+	usageFlushInterval time.Duration
+	// Limits on paging through lists.
+	pagination data.PageLimits
+	// The content ratings movies can be given, like PG-13.
+	certifications []string
+	// Whether every endpoint rejects unknown query parameters, rather than only those
+	// which opt in.
+	strictQuery bool
+	// Whether the fixture endpoints, which empty the database, are registered. Even
+	// then they're only available in development.
+	enableFixtures bool
+	// Secrets which are read from files or Vault instead of being passed directly on
+	// the command line, where they would be visible in process listings and shell
+	// history.
+	secrets struct {
+		dbDSNFile         string
+		dbDSNVault        string
+		smtpPasswordFile  string
+		smtpPasswordVault string
+		tmdbAPIKeyFile    string
+		tmdbAPIKeyVault   string
+		omdbAPIKeyFile    string
+		omdbAPIKeyVault   string
+		tokenKeysFile     string
+		tokenKeysVault    string
+		piiKeyFile        string
+		piiKeyVault       string
+		backupKeyFile     string
+		backupKeyVault    string
+		posterKeyFile     string
+		posterKeyVault    string
+		vaultAddr         string
+		vaultTokenFile    string
+	}
 }
 
 // Define an application struct to hold the dependencies for HTTP handlers, helpers,
 // and middleware.
 type application struct {
-	config config
-	logger *slog.Logger
-	db     *sql.DB
-	models *data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	db      *sql.DB
+	dsn     *dsnConnector
+	models  *data.Models
+	mailer  mailer.Mailer
+	workers *worker.Pool
+	// httpClient is used for outbound requests, like forwarding security events.
+	httpClient *http.Client
+	// webhookClient is used for webhook deliveries, which can't reach private and
+	// local addresses.
+	webhookClient *http.Client
+	events        *movieEventBroker
+	tmdb          *tmdb.Client
+	omdb          *omdb.Client
+	pwned         *pwned.Client
+	push          *push.Client
+	// backups is nil if backups aren't configured.
+	backups    *objectstore.Client
+	posters    storage.Store
+	tokenKeys  *data.TokenKeyring
+	exemptions *rateLimitExemptions
+	usage      *endpointUsageBuffer
+	views      *movieViewBuffer
+	lastSeen   *lastSeenBuffer
+	// linkTemplates is the route table indexed for building the _links of resources.
+	linkTemplates map[string]links
+	// limiterStats is kept up to date by the rateLimit() middleware, for reporting
+	// in GET /v1/admin/system.
+	limiterStats struct {
+		clients  atomic.Int64
+		rejected atomic.Int64
+	}
 }
 
 func main() {
@@ -71,6 +285,20 @@ func main() {
 	// corresponding flags are provided.
 	flag.IntVar(&cfg.port, "port", 4001, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.BoolVar(&cfg.enableFixtures, "enable-fixtures", false, "Register the endpoints which reset the database and load test fixtures (development only)")
+	flag.StringVar(&cfg.publicURL, "public-url", "", "URL the API is reachable at, for links in emails (defaults to http://localhost:<port>)")
+	flag.StringVar(&cfg.frontendURL, "frontend-url", "", "URL of the web frontend, which activation links in emails point to (defaults to the API itself)")
+	flag.DurationVar(&cfg.shutdownGrace, "shutdown-grace", 30*time.Second, "Time allowed for in-flight requests and background tasks to finish on shutdown")
+
+	// Read the HTTP server settings. A public API wants tight timeouts and limits, while
+	// an internal deployment may need to allow slow clients or large uploads.
+	flag.DurationVar(&cfg.server.readTimeout, "server-read-timeout", 5*time.Second, "Time allowed to read a whole request, including the body")
+	flag.DurationVar(&cfg.server.readHeaderTimeout, "server-read-header-timeout", 0, "Time allowed to read the request headers (0 to use server-read-timeout)")
+	flag.DurationVar(&cfg.server.writeTimeout, "server-write-timeout", 10*time.Second, "Time allowed to write a response")
+	flag.DurationVar(&cfg.server.idleTimeout, "server-idle-timeout", time.Minute, "How long idle keep-alive connections are kept open")
+	flag.IntVar(&cfg.server.maxHeaderBytes, "server-max-header-bytes", http.DefaultMaxHeaderBytes, "Largest request headers accepted, in bytes")
+	flag.IntVar(&cfg.server.maxConns, "server-max-conns", 0, "Most connections served at once, with the rest waiting to be accepted (0 for unlimited)")
+
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
@@ -78,6 +306,34 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "rate-limiter-rps", 2, "Rate limiter requests per second")
 	flag.IntVar(&cfg.limiter.burst, "rate-limiter-burst", 4, "Rate limiter allowed quick burst")
 	flag.BoolVar(&cfg.limiter.enabled, "rate-limiter-enabled", true, "Rate limiter enabled|disabled")
+	flag.StringVar(&cfg.limiter.exemptions, "rate-limiter-exemptions", "", "Comma-separated clients exempt from the rate limiter, as <user|client|cidr>:<value>[=<rps>/<burst>]")
+
+	// Read the settings for throttling failed login and activation attempts. These
+	// apply per email address and per IP address, on top of the general rate limiter.
+	flag.BoolVar(&cfg.authThrottle.enabled, "auth-throttle-enabled", true, "Throttle repeated failed login and activation attempts")
+	flag.DurationVar(&cfg.authThrottle.window, "auth-throttle-window", 15*time.Minute, "How long failed attempts count against an email or IP address")
+	flag.IntVar(&cfg.authThrottle.freeAttempts, "auth-throttle-free-attempts", 5, "Failed attempts allowed before delays start")
+	flag.IntVar(&cfg.authThrottle.blockAfter, "auth-throttle-block-after", 20, "Failed attempts after which an email address is blocked for the window")
+	flag.DurationVar(&cfg.authThrottle.maxDelay, "auth-throttle-max-delay", time.Minute, "Longest delay between attempts before the block threshold")
+
+	// Read the request quotas, which are counted per UTC day and month.
+	flag.Int64Var(&cfg.quota.daily, "quota-daily", 0, "Requests allowed per user or application per day (0 for unlimited)")
+	flag.Int64Var(&cfg.quota.monthly, "quota-monthly", 0, "Requests allowed per user or application per month (0 for unlimited)")
+	flag.DurationVar(&cfg.usageFlushInterval, "usage-flush-interval", time.Minute, "How often per-endpoint usage, movie views and when users were last seen are written to the database")
+
+	// Read the pagination limits. Deep offsets are expensive, so past max-page-offset
+	// clients have to narrow their query down instead of asking for later pages.
+	flag.IntVar(&cfg.pagination.MaxPageSize, "max-page-size", 100, "Largest page_size clients can ask for on list endpoints")
+	flag.IntVar(&cfg.pagination.MaxOffset, "max-page-offset", 10_000, "Number of records clients can page through on list endpoints before having to narrow the query")
+	flag.BoolVar(&cfg.strictQuery, "strict-query-params", false, "Reject requests with query parameters the endpoint doesn't accept on every endpoint, not just those which opt in")
+
+	// Read the certifications movies can be given. They default to the MPA ratings,
+	// but a catalog for another country can use its own.
+	cfg.certifications = []string{"G", "PG", "PG-13", "R", "NC-17"}
+	flag.Func("certifications", "Certifications movies can be given (space separated, default \"G PG PG-13 R NC-17\")", func(val string) error {
+		cfg.certifications = strings.Fields(val)
+		return nil
+	})
 
 	// Read the SMTP server configuration settings into the config struct, using the
 	// Mailtrap settings as the default values.
@@ -98,7 +354,125 @@ func main() {
 		return nil
 	})
 
+	// Read the settings for importing movies from TMDB. Importing is disabled if no API
+	// key is provided.
+	flag.StringVar(&cfg.tmdb.baseURL, "tmdb-base-url", "https://api.themoviedb.org/3", "TMDB API base URL")
+	flag.StringVar(&cfg.tmdb.imageBaseURL, "tmdb-image-base-url", "https://image.tmdb.org/t/p/original", "Base URL for TMDB poster images")
+	flag.StringVar(&cfg.tmdb.apiKey, "tmdb-api-key", "", "TMDB API key")
+
+	// Read the settings for enriching new movies with details from OMDb. Enrichment is
+	// disabled if no API key is provided.
+	flag.StringVar(&cfg.omdb.baseURL, "omdb-base-url", "https://www.omdbapi.com", "OMDb API base URL")
+	flag.StringVar(&cfg.omdb.apiKey, "omdb-api-key", "", "OMDb API key")
+
+	// Read the settings for checking new passwords against known data breaches.
+	flag.BoolVar(&cfg.pwnedPasswords.enabled, "pwned-passwords-enabled", false, "Refuse new passwords which have appeared in data breaches, using the Pwned Passwords API")
+	flag.StringVar(&cfg.pwnedPasswords.baseURL, "pwned-passwords-base-url", "https://api.pwnedpasswords.com", "Pwned Passwords API base URL")
+	flag.DurationVar(&cfg.pwnedPasswords.timeout, "pwned-passwords-timeout", 3*time.Second, "How long to wait for the Pwned Passwords API before accepting a password unchecked")
+
+	// Read the keys used to hash tokens. Without any keys, tokens are hashed with plain
+	// SHA-256 as they were originally.
+	flag.StringVar(&cfg.tokens.keys, "token-keys", "", "Keys for hashing tokens (comma separated <version>:<base64 secret>, highest version is current)")
+	flag.DurationVar(&cfg.tokens.keyGrace, "token-key-grace", 72*time.Hour, "How long tokens hashed with a replaced key are still accepted")
+
+	// Read the settings for invite-only registration.
+	flag.BoolVar(&cfg.registration.inviteOnly, "registration-invite-only", false, "Only let people register with an invite code from an admin")
+	flag.DurationVar(&cfg.registration.inviteTTL, "invite-ttl", 7*24*time.Hour, "How long invite codes can be used for")
+
+	// Read the settings for review moderation.
+	flag.BoolVar(&cfg.reviews.autoApprove, "reviews-auto-approve", true, "Approve reviews by trusted users without moderation")
+	flag.IntVar(&cfg.reviews.trustedAfter, "reviews-trusted-after", 3, "How many approved reviews a user needs to be trusted")
+	flag.IntVar(&cfg.reviews.reportThreshold, "reviews-report-threshold", 3, "How many reports hide a review until it's moderated again (0 to never hide reviews)")
+
+	flag.StringVar(&cfg.siem.url, "siem-webhook-url", "", "URL to forward security events to, for a SIEM")
+
+	flag.StringVar(&cfg.push.fcmCredentialsFile, "fcm-credentials-file", "", "Path to the Google service account key for sending push notifications with FCM")
+	flag.StringVar(&cfg.push.apnsKeyFile, "apns-key-file", "", "Path to the .p8 key for sending push notifications with APNs")
+	flag.StringVar(&cfg.push.apnsKeyID, "apns-key-id", "", "ID of the APNs key")
+	flag.StringVar(&cfg.push.apnsTeamID, "apns-team-id", "", "Apple developer team ID")
+	flag.StringVar(&cfg.push.apnsTopic, "apns-topic", "", "Bundle ID of the iOS app")
+	flag.BoolVar(&cfg.push.apnsSandbox, "apns-sandbox", false, "Send push notifications to the APNs development environment")
+
+	// Read the key for encrypting personal data. Unlike the other secrets, it isn't
+	// reloaded on SIGHUP, since data encrypted with the old key couldn't be read.
+	// Read the settings for backups to S3-compatible object storage.
+	flag.StringVar(&cfg.backup.endpoint, "backup-s3-endpoint", "", "URL of the S3-compatible storage to upload backups to, like https://s3.eu-west-1.amazonaws.com")
+	flag.StringVar(&cfg.backup.region, "backup-s3-region", "us-east-1", "Region of the backup bucket")
+	flag.StringVar(&cfg.backup.bucket, "backup-s3-bucket", "", "Name of the backup bucket")
+	flag.StringVar(&cfg.backup.accessKey, "backup-s3-access-key", "", "Access key ID for the backup bucket")
+	flag.StringVar(&cfg.backup.secretKey, "backup-s3-secret-key", "", "Secret access key for the backup bucket")
+	flag.StringVar(&cfg.backup.prefix, "backup-prefix", "greenlight", "Key prefix for backups in the bucket")
+	flag.DurationVar(&cfg.backup.retention, "backup-retention", 30*24*time.Hour, "How long backups are kept before being deleted")
+
+	// Read the settings for storing uploaded posters.
+	flag.StringVar(&cfg.posters.storage, "poster-storage", "disk", "Where uploaded posters are stored (disk|s3)")
+	flag.StringVar(&cfg.posters.dir, "poster-dir", "./uploads/posters", "Directory to store uploaded posters in, with poster-storage=disk")
+	flag.Int64Var(&cfg.posters.maxBytes, "poster-max-bytes", 5<<20, "Largest poster image which can be uploaded, in bytes")
+	flag.StringVar(&cfg.posters.s3Endpoint, "poster-s3-endpoint", "", "URL of the S3-compatible storage to store posters in, with poster-storage=s3")
+	flag.StringVar(&cfg.posters.s3Region, "poster-s3-region", "us-east-1", "Region of the poster bucket")
+	flag.StringVar(&cfg.posters.s3Bucket, "poster-s3-bucket", "", "Name of the poster bucket")
+	flag.StringVar(&cfg.posters.s3AccessKey, "poster-s3-access-key", "", "Access key ID for the poster bucket")
+	flag.StringVar(&cfg.posters.s3SecretKey, "poster-s3-secret-key", "", "Secret access key for the poster bucket")
+
+	flag.StringVar(&cfg.pii.key, "pii-key", "", "Base64-encoded 32-byte key for encrypting user names and email addresses")
+
+	// Read the settings for the background worker pool. By default a full queue
+	// rejects new tasks, rather than blocking the request which submitted them.
+	flag.IntVar(&cfg.worker.concurrency, "worker-concurrency", 4, "Number of background workers")
+	flag.IntVar(&cfg.worker.queueSize, "worker-queue-size", 100, "Maximum number of queued background tasks")
+	flag.BoolVar(&cfg.worker.block, "worker-queue-block", false, "Block instead of rejecting tasks when the background queue is full")
+
+	// Read the settings for the persistent job queue runners.
+	flag.IntVar(&cfg.jobs.concurrency, "jobs-concurrency", 2, "Number of persistent job runners")
+	flag.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle job runners check for new jobs")
+	flag.DurationVar(&cfg.jobs.lease, "jobs-lease", 5*time.Minute, "How long a running job can go before it's assumed lost and retried")
+	flag.DurationVar(&cfg.jobs.retryBase, "jobs-retry-base", 10*time.Second, "Delay before the first retry of a failed job (doubles each attempt)")
+	flag.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 5, "Attempts before a failed job is dead-lettered")
+
+	// Read the cron expressions for the scheduled jobs. An empty expression disables
+	// the job.
+	flag.StringVar(&cfg.scheduler.tokenCleanup, "schedule-token-cleanup", "@hourly", "Cron schedule for deleting expired tokens")
+	flag.StringVar(&cfg.scheduler.accountPurge, "schedule-account-purge", "@daily", "Cron schedule for purging unactivated and deleted accounts")
+	flag.StringVar(&cfg.scheduler.digest, "schedule-digest", "0 9 * * 1", "Cron schedule for the weekly new movies digest email")
+	flag.StringVar(&cfg.scheduler.eventsPrune, "schedule-events-prune", "@daily", "Cron schedule for pruning old movie change events")
+	flag.StringVar(&cfg.scheduler.authFailuresPrune, "schedule-auth-failures-prune", "@hourly", "Cron schedule for pruning old failed authentication attempts")
+	flag.StringVar(&cfg.scheduler.savedSearches, "schedule-saved-searches", "@hourly", "Cron schedule for notifying users of new movies matching their saved searches")
+	flag.StringVar(&cfg.scheduler.backup, "schedule-backup", "@daily", "Cron schedule for backing up the movie catalog (only runs if backup-s3-endpoint is set)")
+	flag.StringVar(&cfg.scheduler.recommendations, "schedule-recommendations", "@daily", "Cron schedule for refreshing users' movie recommendations")
+	flag.StringVar(&cfg.scheduler.viewsPrune, "schedule-views-prune", "@daily", "Cron schedule for pruning movie view counts older than trending movies look back")
+	flag.StringVar(&cfg.scheduler.metricsRollup, "schedule-metrics-rollup", "@hourly", "Cron schedule for rolling up API usage into daily metrics")
+	flag.StringVar(&cfg.scheduler.jobsPrune, "schedule-jobs-prune", "@daily", "Cron schedule for deleting finished persistent jobs")
+	flag.DurationVar(&cfg.scheduler.lease, "schedule-lease", 30*time.Minute, "Maximum time a scheduled job can hold its lock")
+	flag.DurationVar(&cfg.scheduler.unactivatedTTL, "unactivated-account-ttl", 30*24*time.Hour, "Age after which unactivated accounts are purged")
+	flag.DurationVar(&cfg.scheduler.deletedGrace, "deleted-account-grace", 30*24*time.Hour, "How long deleted accounts are kept before they're purged")
+	flag.DurationVar(&cfg.scheduler.eventsTTL, "movie-events-ttl", 7*24*time.Hour, "How long movie change events are kept for clients to resume from")
+	flag.DurationVar(&cfg.scheduler.jobsTTL, "jobs-ttl", 7*24*time.Hour, "How long completed and dead jobs, and their payloads, are kept")
+
+	// Read the locations of any secrets which should be loaded from files or Vault.
+	// When set, these take precedence over the -db-dsn, -smtp-password, -tmdb-api-key,
+	// -token-keys and -pii-key flags.
+	flag.StringVar(&cfg.secrets.dbDSNFile, "db-dsn-file", "", "Path to a file containing the PostgreSQL DSN")
+	flag.StringVar(&cfg.secrets.dbDSNVault, "db-dsn-vault", "", "Vault reference (<path>#<key>) for the PostgreSQL DSN")
+	flag.StringVar(&cfg.secrets.smtpPasswordFile, "smtp-password-file", "", "Path to a file containing the SMTP password")
+	flag.StringVar(&cfg.secrets.smtpPasswordVault, "smtp-password-vault", "", "Vault reference (<path>#<key>) for the SMTP password")
+	flag.StringVar(&cfg.secrets.tmdbAPIKeyFile, "tmdb-api-key-file", "", "Path to a file containing the TMDB API key")
+	flag.StringVar(&cfg.secrets.tmdbAPIKeyVault, "tmdb-api-key-vault", "", "Vault reference (<path>#<key>) for the TMDB API key")
+	flag.StringVar(&cfg.secrets.omdbAPIKeyFile, "omdb-api-key-file", "", "Path to a file containing the OMDb API key")
+	flag.StringVar(&cfg.secrets.omdbAPIKeyVault, "omdb-api-key-vault", "", "Vault reference (<path>#<key>) for the OMDb API key")
+	flag.StringVar(&cfg.secrets.tokenKeysFile, "token-keys-file", "", "Path to a file containing the token hashing keys")
+	flag.StringVar(&cfg.secrets.tokenKeysVault, "token-keys-vault", "", "Vault reference (<path>#<key>) for the token hashing keys")
+	flag.StringVar(&cfg.secrets.piiKeyFile, "pii-key-file", "", "Path to a file containing the PII encryption key")
+	flag.StringVar(&cfg.secrets.backupKeyFile, "backup-s3-secret-key-file", "", "Path to a file containing the backup bucket's secret access key")
+	flag.StringVar(&cfg.secrets.backupKeyVault, "backup-s3-secret-key-vault", "", "Vault reference (<path>#<key>) for the backup bucket's secret access key")
+	flag.StringVar(&cfg.secrets.posterKeyFile, "poster-s3-secret-key-file", "", "Path to a file containing the poster bucket's secret access key")
+	flag.StringVar(&cfg.secrets.posterKeyVault, "poster-s3-secret-key-vault", "", "Vault reference (<path>#<key>) for the poster bucket's secret access key")
+	flag.StringVar(&cfg.secrets.piiKeyVault, "pii-key-vault", "", "Vault reference (<path>#<key>) for the PII encryption key")
+	flag.StringVar(&cfg.secrets.vaultAddr, "vault-addr", "", "Vault server address")
+	flag.StringVar(&cfg.secrets.vaultTokenFile, "vault-token-file", "", "Path to a file containing the Vault token (defaults to $VAULT_TOKEN)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration, print it with secrets redacted and exit")
+	checkConfigDB := flag.Bool("check-config-db", false, "Also check that the database is reachable when using -check-config")
 
 	flag.Parse()
 
@@ -113,10 +487,65 @@ func main() {
 	// stream.
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	// Resolve any secrets which are stored in files or Vault.
+	err := loadSecrets(&cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// In check-config mode we validate the configuration and print it out, then exit
+	// with a non-zero status code if there were any problems. This is intended to be
+	// run in CI/CD pipelines before rolling out a new deployment.
+	if *checkConfig {
+		os.Exit(runConfigCheck(cfg, *checkConfigDB))
+	}
+
+	tokenKeyList, err := data.ParseTokenKeys(cfg.tokens.keys)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	tokenKeys := data.NewTokenKeyring(cfg.tokens.keyGrace)
+	tokenKeys.Set(tokenKeyList)
+
+	pii, err := data.NewPIICipher(cfg.pii.key)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	pushClient, err := openPush(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	backups, err := openBackups(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	posters, err := openPosters(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	workers, err := worker.New(cfg.worker.concurrency, cfg.worker.queueSize, cfg.worker.block, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	// Call the openDB() helper function to create the connection pool,
 	// passing in the config struct. If this returns an error, log it and exit the
 	// application immediately.
-	db, err := openDB(cfg)
+	dsn := &dsnConnector{dsn: cfg.db.dsn}
+
+	db, err := openDB(cfg, dsn)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -148,7 +577,8 @@ func main() {
 		config: cfg,
 		logger: logger,
 		db:     db,
-		models: data.NewModels(db),
+		dsn:    dsn,
+		models: data.NewModels(db, tokenKeys, pii),
 		mailer: mailer.New(
 			cfg.smtp.host,
 			cfg.smtp.port,
@@ -156,8 +586,41 @@ func main() {
 			cfg.smtp.password,
 			cfg.smtp.sender,
 		),
+		workers:       workers,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		webhookClient: newWebhookClient(10 * time.Second),
+		events:        newMovieEventBroker(),
+		push:          pushClient,
+		backups:       backups,
+		posters:       posters,
+		tmdb:          tmdb.New(cfg.tmdb.baseURL, cfg.tmdb.imageBaseURL, cfg.tmdb.apiKey, &http.Client{Timeout: 10 * time.Second}),
+		omdb:          omdb.New(cfg.omdb.baseURL, cfg.omdb.apiKey, &http.Client{Timeout: 10 * time.Second}),
+		pwned:         pwned.New(cfg.pwnedPasswords.baseURL, "Greenlight/"+version, &http.Client{Timeout: cfg.pwnedPasswords.timeout}),
+		tokenKeys:     tokenKeys,
+		exemptions:    newRateLimitExemptions(),
+		usage:         newEndpointUsageBuffer(),
+		views:         newMovieViewBuffer(),
+		lastSeen:      newLastSeenBuffer(),
 	}
 
+	app.linkTemplates = newLinkTemplates(app.routeTable())
+
+	err = app.loadRateLimitExemptions()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// If PII encryption is enabled, encrypt the details of any users which are still
+	// stored in plaintext.
+	if pii != nil {
+		app.background("encrypt user PII", app.encryptPlaintextUsers)
+	}
+
+	// Re-read secrets whenever the process receives a SIGHUP, so that credentials can
+	// be rotated without a restart.
+	go app.reloadSecretsOnSignal()
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
@@ -165,14 +628,80 @@ func main() {
 	}
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	// Use sql.Open() to create an empty connection pool, using the DSN from the config
-	// struct.
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// The openPush() function reads the push notification credentials from disk and
+// returns a client for the platforms they're set for.
+func openPush(cfg config) (*push.Client, error) {
+	var fcmCredentials []byte
+
+	if cfg.push.fcmCredentialsFile != "" {
+		b, err := os.ReadFile(cfg.push.fcmCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		fcmCredentials = b
+	}
+
+	var apns *push.APNsConfig
+
+	if cfg.push.apnsKeyFile != "" {
+		key, err := os.ReadFile(cfg.push.apnsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		apns = &push.APNsConfig{
+			Key:     key,
+			KeyID:   cfg.push.apnsKeyID,
+			TeamID:  cfg.push.apnsTeamID,
+			Topic:   cfg.push.apnsTopic,
+			Sandbox: cfg.push.apnsSandbox,
+		}
+	}
+
+	return push.New(fcmCredentials, apns, &http.Client{Timeout: 10 * time.Second})
+}
+
+// The openBackups() function returns a client for the backup bucket, or nil if
+// backups aren't configured. Uploads can be large, so they get a long timeout.
+func openBackups(cfg config) (*objectstore.Client, error) {
+	if cfg.backup.endpoint == "" {
+		return nil, nil
+	}
+
+	return objectstore.New(objectstore.Config{
+		Endpoint:  cfg.backup.endpoint,
+		Region:    cfg.backup.region,
+		Bucket:    cfg.backup.bucket,
+		AccessKey: cfg.backup.accessKey,
+		SecretKey: cfg.backup.secretKey,
+	}, &http.Client{Timeout: 30 * time.Minute})
+}
+
+// The openPosters() function returns the store for uploaded posters.
+func openPosters(cfg config) (storage.Store, error) {
+	if cfg.posters.storage == "disk" {
+		return storage.NewDiskStore(cfg.posters.dir)
+	}
+
+	client, err := objectstore.New(objectstore.Config{
+		Endpoint:  cfg.posters.s3Endpoint,
+		Region:    cfg.posters.s3Region,
+		Bucket:    cfg.posters.s3Bucket,
+		AccessKey: cfg.posters.s3AccessKey,
+		SecretKey: cfg.posters.s3SecretKey,
+	}, &http.Client{Timeout: time.Minute})
 	if err != nil {
 		return nil, err
 	}
 
+	return &storage.S3Store{Client: client, Prefix: "posters/"}, nil
+}
+
+func openDB(cfg config, connector driver.Connector) (*sql.DB, error) {
+	// Use sql.OpenDB() to create an empty connection pool. The connector opens new
+	// connections using the current DSN, which may change when secrets are rotated.
+	db := sql.OpenDB(connector)
+
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
@@ -186,7 +715,7 @@ func openDB(cfg config) (*sql.DB, error) {
 	// established successfully within the 5 second deadline,
 	// or there is any other, close the connection pool and
 	// return the error.
-	err = db.PingContext(ctx)
+	err := db.PingContext(ctx)
 	if err != nil {
 		db.Close()
 		return nil, err
@@ -54,8 +54,11 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	// Define a client struct to hold the rate limiter and last seen time for each
 	// client.
+	// The lookups limiter limits how often an access token is looked up for a client
+	// which is over its limit, to see if it's exempt.
 	type client struct {
 		limiter  *rate.Limiter
+		lookups  *rate.Limiter
 		lastSeen time.Time
 	}
 
@@ -82,6 +85,8 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				}
 			}
 
+			app.limiterStats.clients.Store(int64(len(clients)))
+
 			mu.Unlock()
 		}
 	}()
@@ -99,6 +104,19 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				return
 			}
 
+			// Trusted networks skip the per-IP limiter. If their exemption has a limit
+			// of its own, that applies instead.
+			if exemption := app.exemptions.forIP(ip); exemption != nil {
+				if !app.exemptions.allow(exemption) {
+					app.limiterStats.rejected.Add(1)
+					app.rateLimitExceededResponse(w, r)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Lock the mutex to prevent this code from being executed concurrently.
 			// we lock the whole map
 			mu.Lock()
@@ -108,25 +126,38 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			if _, ok := clients[ip]; !ok {
 				clients[ip] = &client{
 					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					lookups: rate.NewLimiter(1, 5),
 				}
+				app.limiterStats.clients.Store(int64(len(clients)))
 			}
 
 			clients[ip].lastSeen = time.Now()
 
-			// Call the Allow() method on the rate limiter for the current IP address. If
-			// the request isn't allowed, unlock the mutex and send a 429 Too Many Requests
-			// response, just like before.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+			// Call the Allow() method on the rate limiter for the current IP address.
+			allowed := clients[ip].limiter.Allow()
+			lookups := clients[ip].lookups
 
 			// Very importantly, unlock the mutex before calling the next handler in the
 			// chain. Notice that we DON'T use defer to unlock the mutex, as that would mean
 			// that the mutex isn't unlocked until all the handlers downstream of this
 			// middleware have also returned.
 			mu.Unlock()
+
+			// If the request isn't allowed, check whether it was made by an exempt user
+			// or application before sending a 429 Too Many Requests response.
+			if !allowed {
+				exemption, err := app.exemptionForToken(r, lookups)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+
+				if exemption == nil || !app.exemptions.allow(exemption) {
+					app.limiterStats.rejected.Add(1)
+					app.rateLimitExceededResponse(w, r)
+					return
+				}
+			}
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -159,6 +190,15 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// using the invalidAuthenticationTokenResponse() helper (which we will create
 		// in a moment).
 		headerParts := strings.Split(authorizationHeader, " ")
+
+		// Basic credentials are how OAuth clients authenticate at the token endpoint,
+		// rather than as a user, so the request carries on as anonymous.
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -180,7 +220,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// again calling the invalidAuthenticationTokenResponse() helper if no
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthorization, token)
+		// The token may also be one which was issued to a third-party application, in
+		// which case grant holds the permissions the user granted it.
+		user, organizationID, grant, err := app.models.Users.GetForAccessToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -191,9 +233,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Suspended users' tokens are left in place, so that they work again if the
+		// suspension is lifted, but they're refused in the meantime.
+		if user.Suspended {
+			app.suspendedAccountResponse(w, r)
+			return
+		}
+
+		app.lastSeen.add(user.ID, time.Now())
+
 		// Create new request with new context
 		// with user information
 		r = app.contextSetUser(r, user)
+		r = app.contextSetOrganization(r, organizationID)
+
+		if grant != nil {
+			r = app.contextSetGrant(r, grant)
+		}
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
@@ -245,9 +301,7 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 // we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	f := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -266,6 +320,64 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(f)
 }
 
+// The requireFirstParty() middleware rejects access tokens which were issued to
+// third-party applications. It guards the endpoints which aren't covered by a
+// permission that the user could grant to an application.
+func (app *application) requireFirstParty(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetGrant(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The rejectUnknownQuery() middleware sends a 422 Unprocessable Entity response if the
+// query string has any parameters which the endpoint doesn't accept, so that a typo
+// like ?tilte= is reported rather than silently ignored.
+func (app *application) rejectUnknownQuery(known []string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+
+		for key := range r.URL.Query() {
+			v.Check(validator.PermittedValues(key, known...), key, "is not a recognized query parameter")
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The userPermissions() helper returns the permission codes of the user making the
+// request. For a third-party application's access token, these are limited to the
+// permissions that the user granted the application.
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := app.contextGetGrant(r)
+	if grant == nil {
+		return permissions, nil
+	}
+
+	var granted data.Permissions
+	for _, code := range permissions {
+		if grant.Permissions.Include(code) {
+			granted = append(granted, code)
+		}
+	}
+
+	return granted, nil
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Notify client that responce may vary
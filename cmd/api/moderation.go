@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// The listModerationQueueHandler() lists the reviews of every movie in a moderation
+// state, pending by default, oldest first so that the longest waiting are seen to
+// first. Each comes with the reports made about it since it was last approved, and
+// reported=true lists only reviews which have been reported, including those which
+// were hidden for it.
+func (app *application) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		filter data.ReviewQueueFilter
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.filter.Status = app.readString(qs, "status", data.ReviewPending)
+	input.filter.Reported = app.readBool(qs, "reported", false, v)
+
+	v.Check(validator.PermittedValues(input.filter.Status, data.ReviewStatuses...), "status", "must be one of "+strings.Join(data.ReviewStatuses, ", "))
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "created_at")
+	input.Filters.SortSafelist = data.ReviewQueueSortSafelist
+	input.Filters.URL = app.requestURL(r)
+
+	if data.ValidateFilters(v, input.Filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetQueue(input.filter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "reviews": reviews, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showModeratedReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.moderatedReviewForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The moderateReviewHandler() approves or rejects a review, and lets its author know.
+// Like updateMeHandler(), if the version the moderator read is sent too, the review
+// isn't changed if it has been edited since then.
+func (app *application) moderateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.moderatedReviewForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status  string `json:"status"`
+		Version *int32 `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != review.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(validator.PermittedValues(input.Status, data.ReviewApproved, data.ReviewRejected), "status", "must be approved or rejected"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if review.Status != input.Status {
+		review.Status = input.Status
+
+		err = app.models.Reviews.Moderate(review)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		action := "approve"
+		if review.Status == data.ReviewRejected {
+			action = "reject"
+		}
+
+		app.audit(r, action, "review", review.ID, map[string]int64{"movie_id": review.MovieID, "user_id": review.UserID})
+
+		// The reviews of users who have deleted their account have no author to tell.
+		if review.UserID != 0 {
+			err = app.notifyInApp(review.UserID, "", &data.Notification{
+				Kind:  data.NotificationReviewModerated,
+				Title: fmt.Sprintf("Your review has been %s", review.Status),
+			}, map[string]any{"review_id": review.ID, "movie_id": review.MovieID, "status": review.Status})
+			if err != nil {
+				app.logger.Error("unable to create review moderation notification", "review_id", review.ID, "user_id", review.UserID, "error", err.Error())
+			}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The moderatedReviewForRequest() helper looks up the review in the :id parameter, of
+// any movie and in any moderation state. If there's no such review it sends a 404 Not
+// Found response and returns false.
+func (app *application) moderatedReviewForRequest(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return review, true
+}
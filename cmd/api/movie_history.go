@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The listMovieHistoryHandler() shows who changed a movie and what they changed, most
+// recent first.
+func (app *application) listMovieHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-version",
+		SortSafelist: []string{"-version"},
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.MovieHistory.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "history": revisions, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The rollbackMovieHandler() puts a movie's fields back to how they were at an earlier
+// version. The rollback is saved as a normal update, so it gets a new version and shows
+// up in the history itself.
+func (app *application) rollbackMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Version int32 `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Version > 0, "version", "must be provided")
+	v.Check(input.Version < movie.Version, "version", "must be earlier than the movie's current version")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	snapshot, err := app.models.MovieHistory.GetSnapshot(movie.ID, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("version", "must be a version which the movie's history can restore")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	snapshot.ApplyTo(movie)
+
+	// Genres may have been taken out of the catalog since, and certifications out of
+	// the configuration.
+	err = app.checkGenres(v, movie.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateCertification(v, movie.Certification, app.config.certifications)
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movies(r).Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "rollback", "movie", movie.ID, input)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.movieResource(movie)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/filter"
 	"greenlight/anaplo/internal/validator"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
+// movieIncludes are the related resources which can be embedded in movies with the
+// include query string parameter. With include=reviews each movie has its most recent
+// includedReviews reviews, and the rest are at GET /v1/movies/:id/reviews.
+var movieIncludes = []string{"reviews"}
+
+const includedReviews = 5
+
 // Add a createMovieHandler for the "POST /v1/movies" endpoint. For now we simply
 // return a plain-text placeholder response
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +31,17 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    int32        `json:"year"`    // Movie release year
 		Runtime data.Runtime `json:"runtime"` // Movie runtime (in minutes)
 		Genres  []string     `json:"genres"`  // Slice of genres for the movie (romance, comedy, etc.)
+		// Short plot summary, which title searches also look in
+		Synopsis string `json:"synopsis"`
+		// Money is written like "1500000.00 USD"
+		Budget    *data.Money     `json:"budget"`
+		BoxOffice *data.Money     `json:"box_office"`
+		Links     data.MovieLinks `json:"links"`
+		// Release dates by country, like {"US": "2010-07-16"}. If they're given the
+		// year can be left out, since it's the year of the earliest of them.
+		ReleaseDates     data.ReleaseDates `json:"release_dates"`
+		Certification    string            `json:"certification"`     // Like PG-13
+		OriginalLanguage string            `json:"original_language"` // Like fr
 	}
 	// var input data.MovieUserInput
 
@@ -36,15 +59,36 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy the values from the input struct to a new Movie struct.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:            input.Title,
+		Year:             input.Year,
+		Runtime:          input.Runtime,
+		Genres:           input.Genres,
+		Synopsis:         input.Synopsis,
+		Budget:           input.Budget,
+		BoxOffice:        input.BoxOffice,
+		Links:            input.Links,
+		ReleaseDates:     input.ReleaseDates,
+		Certification:    input.Certification,
+		OriginalLanguage: input.OriginalLanguage,
+	}
+
+	if movie.Year == 0 {
+		movie.Year = releaseYear(movie.ReleaseDates)
 	}
 
 	// Initialize a new Validator instance.
 	v := validator.New()
 
+	allowDuplicate := app.readBool(r.URL.Query(), "allow_duplicate", false, v)
+
+	err = app.checkGenres(v, movie.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateCertification(v, movie.Certification, app.config.certifications)
+
 	// if Errors map in Validator struct
 	// is not empty
 	if data.ValidateMovie(v, movie); !v.Valid() {
@@ -52,11 +96,36 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	// Unless the client has confirmed that it really is a new movie, refuse to create
+	// one which looks like a movie already in the catalog.
+	if !allowDuplicate {
+		candidates, err := app.movies(r).FindDuplicates(movie.Title, movie.Year)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(candidates) > 0 {
+			app.duplicateMovieResponse(w, r, candidates)
+			return
+		}
+	}
+
+	err = app.movies(r).Insert(movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
+	app.audit(r, "create", "movie", movie.ID, nil)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieCreated, movie)
+	app.enrichMovie(app.contextGetOrganization(r), movie)
+
 	// When sending a HTTP response, we want to include a Location header to let the
 	// client know which URL they can find the newly-created resource at. We make an
 	// empty http.Header map and then use the Set() method to add a new Location header,
@@ -66,7 +135,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": app.movieResource(movie)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -86,7 +155,22 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	movies, ok := app.moviesIncludingDeleted(w, r, v)
+	if !ok {
+		return
+	}
+
+	fields := app.readFields(r.URL.Query(), movieResource{}, v)
+	include := app.readInclude(r.URL.Query(), movieIncludes, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -98,7 +182,48 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// The watch providers and collection are looked up separately, so they're skipped
+	// if the client didn't ask for them.
+	if fields.has("watch_providers") {
+		movie.WatchProviders, err = app.models.WatchProviders.GetForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if fields.has("collection") {
+		collections, err := app.models.Collections.GetForMovies([]int64{movie.ID})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		movie.Collection = collections[movie.ID]
+	}
+
+	if slices.Contains(include, "reviews") {
+		reviews, err := app.models.Reviews.GetRecentForMovies([]int64{movie.ID}, includedReviews)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		movie.Reviews = reviews[movie.ID]
+	}
+
+	err = app.localizeTitles(w, r, []*data.Movie{movie})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Views of deleted movies, which only admins can see, don't count towards trending.
+	if movie.DeletedAt == nil {
+		app.views.add(movie.ID, time.Now(), 1)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": fields.apply(app.movieResource(movie))}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -111,7 +236,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.movies(r).Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -131,6 +256,17 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    *int32        `json:"year"`    // Movie release year
 		Runtime *data.Runtime `json:"runtime"` // Movie runtime (in minutes)
 		Genres  []string      `json:"genres"`  // Slice of genres for the movie (romance, comedy, etc.)
+		// Short plot summary, which title searches also look in
+		Synopsis *string `json:"synopsis"`
+		// Money is written like "1500000.00 USD"
+		Budget    *data.Money `json:"budget"`
+		BoxOffice *data.Money `json:"box_office"`
+		// The links and release dates are replaced as a whole, so any which are left
+		// out are removed.
+		Links            *data.MovieLinks  `json:"links"`
+		ReleaseDates     data.ReleaseDates `json:"release_dates"`
+		Certification    *string           `json:"certification"` // An empty string removes it
+		OriginalLanguage *string           `json:"original_language"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -162,14 +298,61 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Genres = input.Genres
 	}
 
+	if input.Synopsis != nil {
+		movie.Synopsis = *input.Synopsis
+	}
+
+	if input.Budget != nil {
+		movie.Budget = input.Budget
+	}
+
+	if input.BoxOffice != nil {
+		movie.BoxOffice = input.BoxOffice
+	}
+
+	if input.Links != nil {
+		movie.Links = *input.Links
+	}
+
+	if input.ReleaseDates != nil {
+		movie.ReleaseDates = input.ReleaseDates
+
+		if year := releaseYear(movie.ReleaseDates); input.Year == nil && year != 0 {
+			movie.Year = year
+		}
+	}
+
+	if input.Certification != nil {
+		movie.Certification = *input.Certification
+	}
+
+	if input.OriginalLanguage != nil {
+		movie.OriginalLanguage = *input.OriginalLanguage
+	}
+
 	v := validator.New()
+
+	if input.Genres != nil {
+		err = app.checkGenres(v, movie.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Like the genres, the certification is only checked if it's being changed, so
+	// that movies can still be updated after the list of certifications changes.
+	if input.Certification != nil {
+		data.ValidateCertification(v, movie.Certification, app.config.certifications)
+	}
+
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// fully replace an old record with new one for now
-	err = app.models.Movies.Update(movie)
+	err = app.movies(r).Update(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -180,7 +363,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	app.audit(r, "update", "movie", movie.ID, input)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.movieResource(movie)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -193,7 +379,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.movies(r).Delete(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -204,18 +390,190 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, "delete", "movie", id, nil)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieDeleted, map[string]int64{"id": id})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The restoreMovieHandler() undoes the deletion of a movie, putting it back in the
+// catalog along with its reviews, ratings and so on.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.movies(r).Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.movies(r).Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "restore", "movie", id, nil)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieRestored, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.movieResource(movie)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// releaseYear returns the year of the earliest of a movie's release dates, or 0 if it
+// doesn't have any, for filling in the year when only the release dates are given.
+func releaseYear(dates data.ReleaseDates) int32 {
+	earliest := dates.Earliest()
+	if earliest.IsZero() {
+		return 0
+	}
+
+	return int32(earliest.Year())
+}
+
+// The moviesIncludingDeleted() helper returns the movie model for the request, which
+// includes deleted movies if the client asked for them with ?include_deleted=true.
+// Only admins can see deleted movies, so for anyone else it sends a 403 Forbidden
+// response and returns false.
+func (app *application) moviesIncludingDeleted(w http.ResponseWriter, r *http.Request, v *validator.Validator) (data.MovieModel, bool) {
+	movies := app.movies(r)
+
+	if !app.readBool(r.URL.Query(), "include_deleted", false, v) {
+		return movies, true
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return movies, false
+	}
+
+	if !permissions.Include("admin:read") {
+		app.notPermittedResponse(w, r)
+		return movies, false
+	}
+
+	return movies.WithDeleted(), true
+}
+
+// maxBulkDelete is the most movies which DELETE /v1/movies will delete at once.
+const maxBulkDelete = 1000
+
+// The bulkDeleteMoviesHandler() deletes every movie matching the filters in the query
+// string, at least one of which is required. With ?dry_run=true it only reports what
+// would be deleted. If more than maxBulkDelete movies match, nothing is deleted and
+// the client has to narrow the filters down.
+func (app *application) bulkDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	selection := data.MovieSelection{
+		Genre:   app.readString(qs, "genre", ""),
+		YearMin: app.readInt(qs, "year_min", 0, v),
+		YearMax: app.readInt(qs, "year_max", 0, v),
+	}
+	dryRun := app.readBool(qs, "dry_run", false, v)
+
+	if expr := app.readString(qs, "filter", ""); expr != "" {
+		var err error
+
+		selection.Expression, err = filter.Parse(expr, data.MovieFilterFields)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+	}
+
+	if data.ValidateMovieSelection(v, selection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	movies := app.movies(r).WithTx(tx)
+
+	// Ask for one more than the limit, to tell whether there are too many.
+	ids, err := movies.SelectForUpdate(selection, maxBulkDelete+1)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(ids) > maxBulkDelete {
+		v.AddError("filter", fmt.Sprintf("matches more than %d movies; narrow it down and try again", maxBulkDelete))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !dryRun {
+		_, err = movies.DeleteAll(ids)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, id := range ids {
+			app.audit(r, "delete", "movie", id, map[string]bool{"bulk": true})
+			app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieDeleted, map[string]int64{"id": id})
+		}
+	}
+
+	env := envelope{"dry_run": dryRun, "affected": len(ids), "ids": ids}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	// To keep things consistent with our other handlers, we'll define an input struct
 	// to hold the expected values from the request query string.
 	var input struct {
 		Title  string
 		Genres []string
+		// On top of Genres, movies must have one of GenreQuery.Any and none of GenreQuery.Not.
+		GenreQuery data.GenreQuery
+		Highlight  bool
+		Fuzzy      bool
+		Provider   data.WatchProviderQuery
+		Release    data.ReleaseQuery
+		// Only movies with one of these certifications are listed.
+		Certifications []string
+		// And originally made in this language.
+		Language string
+		// And with a budget and box office in these ranges.
+		Budget    data.MoneyRange
+		BoxOffice data.MoneyRange
 		data.Filters
 	}
 
@@ -227,28 +585,163 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// parse query params
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenreQuery.Any = app.readCSV(qs, "genres_any", []string{})
+	input.GenreQuery.Not = app.readCSV(qs, "genres_not", []string{})
+	input.Highlight = app.readBool(qs, "highlight", false, v)
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.Provider.Provider = app.readString(qs, "provider", "")
+	input.Provider.Region = app.readString(qs, "region", "")
+	input.Provider.Type = app.readString(qs, "provider_type", "")
+	input.Release.Country = app.readString(qs, "released_in", "")
+	input.Release.After = app.readDate(qs, "released_after", v)
+	input.Release.Before = app.readDate(qs, "released_before", v)
+	input.Certifications = app.readCSV(qs, "certification", []string{})
+	input.Language = app.readString(qs, "language", "")
+	input.Budget.Min = app.readMoney(qs, "budget_min", v)
+	input.Budget.Max = app.readMoney(qs, "budget_max", v)
+	input.BoxOffice.Min = app.readMoney(qs, "box_office_min", v)
+	input.BoxOffice.Max = app.readMoney(qs, "box_office_max", v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.Filters.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.Filters.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.Filters.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
 	// Add the supported sort values for this endpoint to the sort safelist.
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = data.MovieSortSafelist
 
 	// Extract the sort query string value, falling back to "id" if it is not provided
-	// by the client (which will imply a ascending sort on movie ID).
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	// by the client (which will imply a ascending sort on movie ID). With a title search
+	// the best matches come first instead.
+	defaultSort := "id"
+	if input.Title != "" {
+		defaultSort = "-relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.URL = app.requestURL(r)
+
+	// A cursor from the next_cursor of a previous page carries on after its last movie,
+	// instead of paging by number, which gets slower the further it goes.
+	if c := app.readString(qs, "cursor", ""); c != "" {
+		cursor, err := data.ParseCursor(c)
+		if err != nil {
+			v.AddError("cursor", "must be a next_cursor from a previous response")
+		}
+		input.Filters.Cursor = &cursor
+	}
+
+	fields := app.readFields(qs, movieResource{}, v)
+	include := app.readInclude(qs, movieIncludes, v)
+
+	// Parse the optional filter expression, like year>=2000 AND genre:drama, against
+	// the fields which movies can be filtered on.
+	if expr := app.readString(qs, "filter", ""); expr != "" {
+		var err error
+
+		input.Filters.Expression, err = filter.Parse(expr, data.MovieFilterFields)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+	}
+
+	if strings.TrimPrefix(input.Filters.Sort, "-") == "relevance" {
+		v.Check(input.Title != "", "sort", "relevance can only be sorted on with a title search")
+	}
+
+	v.Check(input.Title != "" || !input.Fuzzy, "fuzzy", "can only be used with a title search")
+
+	data.ValidateGenreQuery(v, input.GenreQuery)
+	data.ValidateWatchProviderQuery(v, input.Provider)
+	data.ValidateReleaseQuery(v, input.Release)
+	data.ValidateMoneyRange(v, "budget", input.Budget)
+	data.ValidateMoneyRange(v, "box_office", input.BoxOffice)
+
+	if input.Language != "" {
+		v.Check(v.Matches(input.Language, data.OriginalLanguageRX), "language", "must be a two-letter ISO 639-1 language code, like fr")
+	}
+
+	movies, ok := app.moviesIncludingDeleted(w, r, v)
+	if !ok {
+		return
+	}
 
 	// check validation errors
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	if data.ValidateFilters(v, input.Filters, app.config.pagination); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies = movies.WithGenres(input.GenreQuery).WithWatchProvider(input.Provider).WithReleaseDates(input.Release).WithCertifications(input.Certifications).
+		WithOriginalLanguage(input.Language).WithMoneyRanges(input.Budget, input.BoxOffice)
+	if input.Highlight {
+		movies = movies.WithHighlights()
+	}
+	if input.Fuzzy {
+		movies = movies.WithFuzzySearch()
+	}
+
+	list, metadata, err := movies.GetAll(input.Title, input.Genres, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(list))
+	for i, movie := range list {
+		ids[i] = movie.ID
+	}
+
+	if fields.has("watch_providers") {
+		providers, err := app.models.WatchProviders.GetForMovies(ids)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, movie := range list {
+			movie.WatchProviders = providers[movie.ID]
+		}
+	}
+
+	if fields.has("collection") {
+		collections, err := app.models.Collections.GetForMovies(ids)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, movie := range list {
+			movie.Collection = collections[movie.ID]
+		}
+	}
+
+	// The reviews of the whole page are fetched in one query, rather than one per movie.
+	if slices.Contains(include, "reviews") {
+		reviews, err := app.models.Reviews.GetRecentForMovies(ids, includedReviews)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, movie := range list {
+			movie.Reviews = reviews[movie.ID]
+		}
+	}
+
+	err = app.localizeTitles(w, r, list)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	resources := make([]any, len(list))
+	for i, movie := range list {
+		resources[i] = fields.apply(app.movieResource(movie))
+	}
+
+	env := envelope{"metadata": metadata, "movies": resources, "_links": app.pageLinks(r, metadata)}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
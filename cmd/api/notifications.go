@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The notifyUser() method emails an optional notification to a user, unless they've
+// turned off that kind of notification or email notifications altogether. Emails
+// which the user can't opt out of, like activation tokens, are sent with
+// enqueueTokenEmail() or enqueueEmail() directly.
+//
+// Each email carries a link, in its body as unsubscribeURL and in the List-Unsubscribe
+// header, which turns that kind of email off without signing in. The link is left out
+// if no token keys are configured to sign it with.
+func (app *application) notifyUser(user *data.User, kind, templateFile string, emailData map[string]any) error {
+	prefs, err := app.models.NotificationPreferences.Get(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if !prefs.Allows(kind, data.ChannelEmail) {
+		return nil
+	}
+
+	token, err := data.NewUnsubscribeToken(app.tokenKeys, user.ID, kind)
+	if err != nil {
+		if errors.Is(err, data.ErrNoSigningKey) {
+			return app.enqueueEmail(user.Email, templateFile, emailData)
+		}
+		return err
+	}
+
+	unsubscribeURL := app.publicURL("/v1/unsubscribe", url.Values{"token": {token}})
+	emailData["unsubscribeURL"] = unsubscribeURL
+
+	// RFC 8058 one-click unsubscribe: mail clients POST to the link themselves.
+	headers := map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+
+	return app.enqueueEmailWithHeaders(user.Email, templateFile, emailData, headers)
+}
+
+// The unsubscribeHandler() turns off the kind of email an unsubscribe link was sent
+// with. It doesn't need the user to sign in, since the link's token is signed. Mail
+// clients which support one-click unsubscribe POST to the same URL.
+func (app *application) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, kind, err := data.ParseUnsubscribeToken(app.tokenKeys, r.URL.Query().Get("token"))
+	if err != nil {
+		v := validator.New()
+		v.AddError("token", "invalid or expired unsubscribe token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	prefs, err := app.models.NotificationPreferences.Get(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !prefs.Disable(kind) {
+		v := validator.New()
+		v.AddError("token", "invalid or expired unsubscribe token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.NotificationPreferences.Update(prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	message := fmt.Sprintf("you will no longer receive %s emails", strings.ReplaceAll(kind, "_", " "))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The notifyInApp() method creates an in-app notification for a user, unless they've
+// turned off that kind of notification or in-app notifications altogether. The kind
+// is one of the data.Notify* preference kinds; notifications which don't belong to
+// one pass an empty kind. The data, if any, is encoded as JSON.
+func (app *application) notifyInApp(userID int64, kind string, notification *data.Notification, payload any) error {
+	prefs, err := app.models.NotificationPreferences.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if !prefs.Allows(kind, data.ChannelInApp) {
+		return nil
+	}
+
+	if payload != nil {
+		notification.Data, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	notification.UserID = userID
+
+	return app.models.Notifications.Insert(notification)
+}
+
+func (app *application) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-created_at",
+		SortSafelist: data.NotificationSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	unread := app.readBool(qs, "unread", false, v)
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	notifications, metadata, err := app.models.Notifications.GetAllForUser(user.ID, unread, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	count, err := app.models.Notifications.CountUnread(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"metadata": metadata, "unread_count": count, "notifications": notifications, "_links": app.pageLinks(r, metadata)}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The unreadNotificationsHandler() returns just the number of unread notifications,
+// so that clients can cheaply poll it to show a badge.
+func (app *application) unreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := app.models.Notifications.CountUnread(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"unread_count": count}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Read *bool `json:"read"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Read != nil, "read", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	notification, err := app.models.Notifications.SetReadForUser(app.contextGetUser(r).ID, id, *input.Read)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notification": notification}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readAllNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := app.models.Notifications.MarkAllReadForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"marked_read": n}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Notifications.DeleteForUser(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "notification successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	prefs, err := app.models.NotificationPreferences.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notification_preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	prefs, err := app.models.NotificationPreferences.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Email              *bool `json:"email"`
+		InApp              *bool `json:"in_app"`
+		Push               *bool `json:"push"`
+		Digest             *bool `json:"digest"`
+		SecurityAlerts     *bool `json:"security_alerts"`
+		SavedSearchMatches *bool `json:"saved_search_matches"`
+		Announcements      *bool `json:"announcements"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Email != nil {
+		prefs.Email = *input.Email
+	}
+
+	if input.InApp != nil {
+		prefs.InApp = *input.InApp
+	}
+
+	if input.Push != nil {
+		prefs.Push = *input.Push
+	}
+
+	if input.Digest != nil {
+		prefs.Digest = *input.Digest
+	}
+
+	if input.SecurityAlerts != nil {
+		prefs.SecurityAlerts = *input.SecurityAlerts
+	}
+
+	if input.SavedSearchMatches != nil {
+		prefs.SavedSearchMatches = *input.SavedSearchMatches
+	}
+
+	if input.Announcements != nil {
+		prefs.Announcements = *input.Announcements
+	}
+
+	err = app.models.NotificationPreferences.Update(prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notification_preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
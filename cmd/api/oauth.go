@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	oauthCodeTTL  = 10 * time.Minute
+	oauthTokenTTL = 24 * time.Hour
+)
+
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	client := &data.OAuthClient{
+		UserID:       app.contextGetUser(r).ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.OAuth.InsertClient(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "oauth_client", client.ID, map[string]any{"name": client.Name, "scopes": client.Scopes})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/oauth/clients/%d", client.ID))
+
+	// As with webhooks, this is the only time the client secret is shown.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client, "client_secret": client.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.models.OAuth.GetClientsForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteOAuthClientHandler() removes a client, revoking every access token which
+// was issued to it.
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	client, err := app.models.OAuth.GetClient(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if client.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.OAuth.DeleteClient(client.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "oauth_client", client.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// An authorizationRequest holds the parameters of an OAuth 2.0 authorization request.
+type authorizationRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"` // Space-separated permission codes
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// The checkAuthorizationRequest() helper validates an authorization request for the
+// current user, returning the client and the permissions which would be granted. The
+// granted permissions are the requested scopes (or, if none were requested, all of
+// the client's scopes) which the user actually has.
+func (app *application) checkAuthorizationRequest(r *http.Request, v *validator.Validator, req authorizationRequest) (*data.OAuthClient, []string, error) {
+	v.Check(req.ClientID != "", "client_id", "must be provided")
+	v.Check(req.ResponseType == "code", "response_type", "must be code")
+	v.Check(len(req.State) <= 500, "state", "must not be more than 500 bytes long")
+
+	if req.CodeChallenge != "" || req.CodeChallengeMethod != "" {
+		v.Check(req.CodeChallengeMethod == "S256", "code_challenge_method", "must be S256")
+		v.Check(len(req.CodeChallenge) == 43, "code_challenge", "must be a base64url-encoded SHA-256 hash")
+	}
+
+	if !v.Valid() {
+		return nil, nil, nil
+	}
+
+	client, err := app.models.OAuth.GetClientByClientID(req.ClientID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("client_id", "must be a registered client")
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	// The redirect URI must match one of the registered ones exactly, or the code could
+	// be sent somewhere the client doesn't control.
+	v.Check(slices.Contains(client.RedirectURIs, req.RedirectURI), "redirect_uri", "must be one of the client's registered redirect URIs")
+
+	requested := strings.Fields(req.Scope)
+	if len(requested) == 0 {
+		requested = client.Scopes
+	}
+
+	for _, scope := range requested {
+		v.Check(slices.Contains(client.Scopes, scope), "scope", fmt.Sprintf("%q is not a scope this client may request", scope))
+	}
+
+	if !v.Valid() {
+		return nil, nil, nil
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	granted := []string{}
+	for _, scope := range requested {
+		if permissions.Include(scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	v.Check(len(granted) > 0, "scope", "you don't have any of the permissions this client requested")
+
+	return client, granted, nil
+}
+
+// The showAuthorizationHandler() describes an authorization request, for the
+// frontend to show on its consent screen. The request parameters are passed in the
+// query string, just as the client sent them.
+func (app *application) showAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	req := authorizationRequest{
+		ClientID:            qs.Get("client_id"),
+		RedirectURI:         qs.Get("redirect_uri"),
+		ResponseType:        qs.Get("response_type"),
+		Scope:               qs.Get("scope"),
+		State:               qs.Get("state"),
+		CodeChallenge:       qs.Get("code_challenge"),
+		CodeChallengeMethod: qs.Get("code_challenge_method"),
+	}
+
+	v := validator.New()
+
+	client, granted, err := app.checkAuthorizationRequest(r, v, req)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{
+		"client": map[string]string{"client_id": client.ClientID, "name": client.Name},
+		"scopes": granted,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The authorizeHandler() records the user's decision on an authorization request. It
+// responds with the URI to send the user back to the client with, carrying either an
+// authorization code or an access_denied error.
+func (app *application) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	client, granted, err := app.checkAuthorizationRequest(r, v, input.authorizationRequest)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	params := url.Values{}
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+
+	if input.Approve {
+		user := app.contextGetUser(r)
+
+		code := &data.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        user.ID,
+			RedirectURI:   input.RedirectURI,
+			Permissions:   granted,
+			CodeChallenge: input.CodeChallenge,
+			Expiry:        time.Now().Add(oauthCodeTTL),
+		}
+
+		err = app.models.OAuth.InsertCode(code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, "authorize", "oauth_client", client.ID, map[string]any{"permissions": granted})
+		app.securityEvent(r, user.ID, data.SecurityPermissionsGranted, data.SeverityWarning, map[string]any{
+			"client":      client.Name,
+			"permissions": granted,
+		})
+
+		params.Set("code", code.PlainText)
+	} else {
+		params.Set("error", "access_denied")
+	}
+
+	redirect, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	query := redirect.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	redirect.RawQuery = query.Encode()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_uri": redirect.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The oauthTokenHandler() implements the token endpoint, which exchanges an
+// authorization code for an access token. As the OAuth 2.0 specification requires, it
+// takes a form-encoded body and reports errors in the OAuth format rather than our
+// usual one.
+func (app *application) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the body must be form-encoded")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+
+	// Clients can authenticate with HTTP Basic authentication or in the body.
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.models.OAuth.GetClientByClientID(clientID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if client == nil || !client.MatchesSecret(clientSecret) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="greenlight"`)
+		app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	code, err := app.models.OAuth.ConsumeCode(r.PostForm.Get("code"))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or has expired")
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code was not issued to this client and redirect URI")
+		return
+	}
+
+	if code.CodeChallenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != code.CodeChallenge {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the code verifier doesn't match the code challenge")
+			return
+		}
+	}
+
+	token, err := app.models.Tokens.NewForClient(code.UserID, client.ID, code.Permissions, oauthTokenTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	env := envelope{
+		"access_token": token.PlainText,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauthTokenTTL.Seconds()),
+		"scope":        strings.Join(code.Permissions, " "),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The oauthErrorResponse() helper sends an error response in the format defined by
+// the OAuth 2.0 specification.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, status, envelope{"error": code, "error_description": description}, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
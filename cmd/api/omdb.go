@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/omdb"
+)
+
+// enrichMoviePayload is the payload for jobEnrichMovie jobs.
+type enrichMoviePayload struct {
+	OrganizationID int64 `json:"organization_id"`
+	MovieID        int64 `json:"movie_id"`
+}
+
+// The enrichMovie() method queues a job to fill in a newly created movie's details from
+// OMDb, if an OMDb API key is configured. The movie has already been created, so a
+// failure to queue the job is only logged.
+func (app *application) enrichMovie(organizationID int64, movie *data.Movie) {
+	if !app.omdb.Configured() {
+		return
+	}
+
+	_, err := app.enqueueJob(jobEnrichMovie, enrichMoviePayload{OrganizationID: organizationID, MovieID: movie.ID})
+	if err != nil {
+		app.logger.Error("unable to queue movie enrichment", "movie_id", movie.ID, "error", err.Error())
+	}
+}
+
+// The enrichMovieJob() looks a movie up on OMDb by its title and year, and stores its
+// IMDb ID and rating. The plot and poster are only used if the movie doesn't already
+// have a synopsis or poster, so that OMDb never overwrites what a user entered.
+func (app *application) enrichMovieJob(ctx context.Context, job *data.Job) error {
+	var p enrichMoviePayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	movies := app.models.Movies.ForOrganization(p.OrganizationID)
+
+	// The movie may have been deleted since the job was queued.
+	movie, err := movies.Get(p.MovieID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	details, err := app.omdb.GetMovie(ctx, movie.Title, movie.Year)
+	switch {
+	case errors.Is(err, omdb.ErrNotFound):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	// If the movie is edited between reading it and storing the details, read it
+	// again and have another go, rather than failing the whole job.
+	for attempt := 1; ; attempt++ {
+		if movie.Synopsis == "" {
+			movie.Synopsis = details.Plot
+		}
+		if movie.PosterURL == "" {
+			movie.PosterURL = details.PosterURL
+		}
+		// An IMDb ID given by a user wins over the one OMDb matched by title, and
+		// the rating is only taken if they agree.
+		if movie.Links.IMDbID == "" {
+			movie.Links.IMDbID = details.IMDbID
+		}
+		if movie.Links.IMDbID == details.IMDbID {
+			movie.IMDbRating = details.IMDbRating
+		}
+
+		err = movies.SetOMDbDetails(movie)
+		if !errors.Is(err, data.ErrEditConflict) || attempt == 3 {
+			break
+		}
+
+		movie, err = movies.Get(p.MovieID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	app.emitOrganizationEvent(p.OrganizationID, data.EventMovieUpdated, movie)
+
+	return nil
+}
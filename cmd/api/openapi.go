@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The openAPISpec() method generates an OpenAPI 3 document describing every route in
+// the route table.
+func (app *application) openAPISpec() map[string]any {
+	paths := map[string]map[string]any{}
+
+	for _, rt := range app.routeTable() {
+		// OpenAPI writes path parameters as {id} rather than httprouter's :id.
+		segments := strings.Split(rt.path, "/")
+		var params []any
+
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				name := strings.TrimPrefix(segment, ":")
+				segments[i] = "{" + name + "}"
+				params = append(params, map[string]any{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]any{"type": "string"},
+				})
+			}
+		}
+
+		for _, name := range rt.query {
+			params = append(params, map[string]any{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		// Group operations by resource, which is the first segment after the version.
+		tag := segments[1]
+		if tag == "v1" && len(segments) > 2 {
+			tag = segments[2]
+		}
+
+		operation := map[string]any{
+			"summary": rt.summary,
+			"tags":    []string{tag},
+			"responses": map[string]any{
+				"2XX":     map[string]any{"description": "Success"},
+				"default": map[string]any{"$ref": "#/components/responses/Error"},
+			},
+		}
+
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if rt.method == http.MethodPost || rt.method == http.MethodPut || rt.method == http.MethodPatch {
+			operation["requestBody"] = map[string]any{
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": map[string]any{"type": "object"}},
+				},
+			}
+		}
+
+		switch {
+		case rt.permission != "":
+			operation["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+			operation["description"] = "Requires an activated user with the " + rt.permission + " permission."
+		case rt.activated:
+			operation["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+			operation["description"] = "Requires an activated user."
+		case rt.authenticated:
+			operation["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+			operation["description"] = "Requires an authenticated user."
+		}
+
+		path := strings.Join(segments, "/")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(rt.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Error response",
+					"content": map[string]any{
+						"application/json": map[string]any{
+							"schema": map[string]any{
+								"type":       "object",
+								"properties": map[string]any{"error": map[string]any{}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, app.openAPISpec(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs, err := app.models.Organizations.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": orgs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createOrganizationHandler() creates an organization with the current user as its
+// owner. To work in it, the user signs in again with its organization_id.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Organizations.Insert(org, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "is already in use")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "organization", org.ID, org)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%d", org.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleMember)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+		Slug *string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		org.Name = *input.Name
+	}
+
+	if input.Slug != nil {
+		org.Slug = *input.Slug
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Update(org)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "is already in use")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "organization", org.ID, org)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteOrganizationHandler() deletes an organization along with its movies. Only
+// owners can do this, and the default organization can't be deleted.
+func (app *application) deleteOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleOwner)
+	if !ok {
+		return
+	}
+
+	if org.ID == data.DefaultOrganizationID {
+		app.errorResponse(w, r, http.StatusConflict, "the default organization can't be deleted")
+		return
+	}
+
+	err := app.models.Organizations.Delete(org.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "organization", org.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "organization successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleMember)
+	if !ok {
+		return
+	}
+
+	members, err := app.models.Users.GetOrganizationMembers(org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addOrganizationMemberHandler() adds an existing user to an organization by their
+// email address. Admins can add members and admins, but only owners can add owners.
+func (app *application) addOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = data.RoleMember
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !data.CanGrantRole(org.Role, input.Role) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no user with this email address exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member := &data.OrganizationMember{UserID: user.ID, Name: user.Name, Email: user.Email, Role: input.Role}
+
+	err = app.models.Organizations.AddMember(org.ID, member)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMember):
+			v.AddError("email", "is already a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "add_member", "organization", org.ID, map[string]any{"user_id": user.ID, "role": input.Role})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	role, ok := app.memberRoleForRequest(w, r, org, userID)
+	if !ok {
+		return
+	}
+
+	// Admins can't promote anyone to owner, or change an owner's role.
+	if !data.CanGrantRole(org.Role, input.Role) || !data.CanGrantRole(org.Role, role) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if role == data.RoleOwner && input.Role != data.RoleOwner && !app.hasAnotherOwner(w, r, org) {
+		return
+	}
+
+	err = app.models.Organizations.SetRole(org.ID, userID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update_member", "organization", org.ID, map[string]any{"user_id": userID, "role": input.Role})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": map[string]any{"user_id": userID, "role": input.Role}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeOrganizationMemberHandler() removes a user from an organization. Admins can
+// remove members and admins, owners can remove anyone, and any member can remove
+// themselves to leave. An organization always keeps at least one owner.
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.organizationForRequest(w, r, data.RoleMember)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role, ok := app.memberRoleForRequest(w, r, org, userID)
+	if !ok {
+		return
+	}
+
+	leaving := userID == app.contextGetUser(r).ID
+
+	if !leaving && (!data.RoleAtLeast(org.Role, data.RoleAdmin) || !data.CanGrantRole(org.Role, role)) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if role == data.RoleOwner && !app.hasAnotherOwner(w, r, org) {
+		return
+	}
+
+	err = app.models.Organizations.RemoveMember(org.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "remove_member", "organization", org.ID, map[string]int64{"user_id": userID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The organizationForRequest() helper fetches the organization identified by the :id
+// URL parameter, if the current user is a member of it with at least the given role.
+// Non-members get a 404 so that they can't discover which organizations exist. If the
+// user can't access it, an error response is sent and ok is false.
+func (app *application) organizationForRequest(w http.ResponseWriter, r *http.Request, role string) (*data.Organization, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	org, err := app.models.Organizations.GetForUser(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !data.RoleAtLeast(org.Role, role) {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return org, true
+}
+
+// The memberRoleForRequest() helper returns a member's role in the organization, or
+// sends a 404 and returns false if they aren't a member.
+func (app *application) memberRoleForRequest(w http.ResponseWriter, r *http.Request, org *data.Organization, userID int64) (string, bool) {
+	role, err := app.models.Organizations.GetRole(org.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return "", false
+	}
+
+	return role, true
+}
+
+// The hasAnotherOwner() helper checks that the organization has more than one owner
+// before one of them is removed or demoted. If it doesn't, an error response is sent
+// and it returns false.
+func (app *application) hasAnotherOwner(w http.ResponseWriter, r *http.Request, org *data.Organization) bool {
+	owners, err := app.models.Organizations.CountOwners(org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if owners < 2 {
+		app.errorResponse(w, r, http.StatusConflict, "an organization must have at least one owner")
+		return false
+	}
+
+	return true
+}
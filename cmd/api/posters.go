@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/storage"
+	"greenlight/anaplo/internal/validator"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// posterExtensions maps the image types which can be uploaded as posters to the
+// extension their files are stored with.
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// posterNameRX matches the names which uploaded posters are stored under: the movie
+// ID, a random part so that the URL changes with every upload, and the extension.
+var posterNameRX = regexp.MustCompile(`^[0-9]+-[0-9a-f]{32}\.(jpg|png)$`)
+
+// maxPosterDimension is the largest width or height of poster accepted, in pixels.
+const maxPosterDimension = 10000
+
+// The uploadPosterHandler() sets a movie's poster from an image uploaded as the
+// "poster" field of a multipart/form-data request. The image is stored under a new
+// name each time, so it can be cached forever, and the old one is deleted.
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Leave some room for the multipart headers and boundaries on top of the image.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.posters.maxBytes+64<<10)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var poster []byte
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if part.FormName() == "poster" {
+			poster, err = io.ReadAll(io.LimitReader(part, app.config.posters.maxBytes+1))
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+			break
+		}
+	}
+
+	v := validator.New()
+
+	contentType := validatePoster(v, poster, app.config.posters.maxBytes)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	random := make([]byte, 16)
+	_, err = rand.Read(random)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	name := fmt.Sprintf("%d-%s%s", movie.ID, hex.EncodeToString(random), posterExtensions[contentType])
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	err = app.posters.Put(ctx, name, poster, contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldURL := movie.PosterURL
+	movie.PosterURL = app.publicURL("/v1/posters/"+name, nil)
+
+	err = app.movies(r).SetPosterURL(movie)
+	if err != nil {
+		app.deletePoster(name)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if oldName, ok := uploadedPosterName(oldURL); ok {
+		app.deletePoster(oldName)
+	}
+
+	app.audit(r, "update_poster", "movie", movie.ID, map[string]any{"content_type": contentType, "size": len(poster)})
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.movieResource(movie)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showPosterHandler() serves an uploaded poster. Posters are public, like those
+// linked from TMDB; their names include a random part, so they can't be guessed.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if !posterNameRX.MatchString(name) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, contentType, err := app.posters.Get(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// validatePoster checks that an uploaded poster is a JPEG or PNG image of a sensible
+// size, going by its contents rather than what the client said it was. It returns
+// the image's content type.
+func validatePoster(v *validator.Validator, poster []byte, maxBytes int64) string {
+	switch {
+	case len(poster) == 0:
+		v.AddError("poster", "must be provided")
+		return ""
+	case int64(len(poster)) > maxBytes:
+		v.AddError("poster", fmt.Sprintf("must not be more than %d bytes", maxBytes))
+		return ""
+	}
+
+	contentType := http.DetectContentType(poster)
+
+	if _, ok := posterExtensions[contentType]; !ok {
+		v.AddError("poster", "must be a JPEG or PNG image")
+		return ""
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(poster))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		return ""
+	}
+
+	v.Check(cfg.Width <= maxPosterDimension && cfg.Height <= maxPosterDimension, "poster", fmt.Sprintf("must not be more than %d pixels wide or high", maxPosterDimension))
+
+	return contentType
+}
+
+// uploadedPosterName returns the name of the uploaded poster which a movie's poster
+// URL points to. It returns false if the URL is somewhere else, like TMDB.
+func uploadedPosterName(posterURL string) (string, bool) {
+	u, err := url.Parse(posterURL)
+	if err != nil || path.Dir(u.Path) != "/v1/posters" {
+		return "", false
+	}
+
+	name := path.Base(u.Path)
+	return name, posterNameRX.MatchString(name)
+}
+
+// The deletePoster() method deletes an uploaded poster in the background. A failure
+// only leaves an unused file behind, so it's logged rather than reported.
+func (app *application) deletePoster(name string) {
+	app.background("delete poster", func(ctx context.Context) {
+		err := app.posters.Delete(ctx, name)
+		if err != nil {
+			app.logger.Error("unable to delete poster", "name", name, "error", err.Error())
+		}
+	})
+}
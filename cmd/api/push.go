@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/push"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+func (app *application) listPushDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices, err := app.models.PushDevices.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"devices": devices}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The registerPushDeviceHandler() registers the current user's device for push
+// notifications, with the token the app got from FCM or APNs. Apps should register
+// each time they start, since the platforms change tokens from time to time.
+func (app *application) registerPushDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Platform string `json:"platform"`
+		Token    string `json:"token"`
+		Name     string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	device := &data.PushDevice{
+		UserID:   app.contextGetUser(r).ID,
+		Platform: input.Platform,
+		Token:    input.Token,
+		Name:     input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePushDevice(v, device); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.push.Configured(device.Platform) {
+		v.AddError("platform", "push notifications are not available for this platform")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.PushDevices.Insert(device)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"device": device}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePushDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.PushDevices.DeleteForUser(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "device successfully unregistered"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The notifyPush() method sends a push notification to each of a user's devices,
+// unless they've turned off that kind of notification or push notifications
+// altogether. Each device gets its own job, so that a failure to reach one doesn't
+// cause the others to be sent the notification again.
+func (app *application) notifyPush(userID int64, kind string, msg push.Message) error {
+	prefs, err := app.models.NotificationPreferences.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if !prefs.Allows(kind, data.ChannelPush) {
+		return nil
+	}
+
+	devices, err := app.models.PushDevices.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if !app.push.Configured(device.Platform) {
+			continue
+		}
+
+		_, err := app.enqueueJob(jobSendPush, pushPayload{DeviceID: device.ID, Message: msg})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushPayload is the payload for jobSendPush jobs.
+type pushPayload struct {
+	DeviceID int64        `json:"device_id"`
+	Message  push.Message `json:"message"`
+}
+
+// The sendPushJob() delivers a push notification to a device. If the platform says the
+// device's token is no longer valid the device is forgotten, rather than retrying.
+func (app *application) sendPushJob(ctx context.Context, job *data.Job) error {
+	var p pushPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	// The device may have been unregistered since the notification was queued.
+	device, err := app.models.PushDevices.Get(p.DeviceID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	err = app.push.Send(ctx, device.Platform, device.Token, p.Message)
+	switch {
+	case errors.Is(err, push.ErrUnregistered):
+		app.logger.Info("forgetting unregistered push device", "device_id", device.ID, "user_id", device.UserID)
+		return app.models.PushDevices.Delete(device.ID)
+	case errors.Is(err, push.ErrNotConfigured):
+		return nil
+	}
+
+	return err
+}
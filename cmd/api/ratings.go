@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The showRatingHandler() returns the current user's rating for a movie.
+func (app *application) showRatingHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rating, err := app.models.Ratings.Get(movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"rating": rating}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setRatingHandler() rates a movie for the current user, replacing their earlier
+// rating if they had one.
+func (app *application) setRatingHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Rating int `json:"rating"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rating := &data.Rating{
+		MovieID: movie.ID,
+		UserID:  app.contextGetUser(r).ID,
+		Rating:  input.Rating,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRating(v, rating); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Ratings.Set(rating)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"rating": rating}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteRatingHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Ratings.Delete(movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "rating successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The listRecommendationsHandler() suggests movies to the current user, based on the
+// genres of the movies they've rated and saved. The suggestions are refreshed
+// periodically, so new ratings take a while to be reflected.
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-score",
+		SortSafelist: []string{"-score"},
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recommendations, metadata, err := app.models.Recommendations.GetAllForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "recommendations": recommendations, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The refreshRecommendations() method works out users' recommendations again, one
+// organization at a time.
+func (app *application) refreshRecommendations(ctx context.Context) error {
+	organizationIDs, err := app.models.Organizations.GetAllIDs()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+
+	for _, organizationID := range organizationIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		n, err := app.models.Recommendations.RefreshForOrganization(ctx, organizationID)
+		if err != nil {
+			return err
+		}
+
+		total += n
+	}
+
+	app.logger.Info("refreshed recommendations", "organizations", len(organizationIDs), "count", total)
+	return nil
+}
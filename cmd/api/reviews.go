@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The createReviewHandler() reviews a movie. Reviews by trusted users are approved
+// straight away, and anyone else's are held for moderation; see newReviewStatus().
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review := &data.Review{
+		MovieID:  movie.ID,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review.Status, err = app.newReviewStatus(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("movie_id", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "review", review.ID, map[string]any{"movie_id": movie.ID, "status": review.Status})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews/%d", movie.ID, review.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafelist: data.ReviewSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "reviews": reviews, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateReviewHandler() lets the author of a review change it. Like movies,
+// reviews are versioned, so if someone else changed the review after it was read the
+// update fails with an edit conflict rather than overwriting their change. A changed
+// review is moderated again, like a new one.
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Body *string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Body != nil && *input.Body != review.Body {
+		review.Body = *input.Body
+
+		review.Status, err = app.newReviewStatus(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "review", review.ID, input)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteReviewHandler() deletes a review. Besides its author, users who can edit
+// the movie catalog or moderate reviews can delete them.
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include("movies:write") && !permissions.Include("reviews:moderate") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err := app.models.Reviews.Delete(review.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "review", review.ID, map[string]int64{"movie_id": review.MovieID, "user_id": review.UserID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The reportReviewHandler() reports a review which breaks the rules. Each user can
+// report a review once. Once an approved review has been reported
+// -reviews-report-threshold times, it's hidden until a moderator approves it again.
+func (app *application) reportReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.models.Reviews.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only approved reviews of movies in the organization the request is made on
+	// behalf of can be seen, so only they can be reported.
+	if review.Status != data.ReviewApproved {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.movies(r).Get(review.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	report := &data.ReviewReport{
+		ReviewID: review.ID,
+		UserID:   user.ID,
+		Reason:   input.Reason,
+		Details:  input.Details,
+	}
+
+	v := validator.New()
+
+	v.Check(review.UserID != user.ID, "review_id", "you cannot report your own review")
+
+	if data.ValidateReviewReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hidden, err := app.models.Reviews.Report(report, app.config.reviews.reportThreshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			v.AddError("review_id", "you have already reported this review")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "report", "review", review.ID, map[string]any{"movie_id": review.MovieID, "reason": report.Reason, "hidden": hidden})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The reviewForRequest() helper fetches the review identified by the :review_id URL
+// parameter, if it's of the movie in the :id parameter and that movie is in the
+// organization the request is made on behalf of. Reviews which haven't been approved
+// are only found for their author and for moderators. If it isn't found, a 404 Not
+// Found response is sent and ok is false.
+func (app *application) reviewForRequest(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := app.readNamedIDParam(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.GetForMovie(movie.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if review.Status != data.ReviewApproved && review.UserID != app.contextGetUser(r).ID {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
+		if !permissions.Include("reviews:moderate") {
+			app.notFoundResponse(w, r)
+			return nil, false
+		}
+	}
+
+	return review, true
+}
+
+// The newReviewStatus() helper returns the moderation state for a review the current
+// user has just written. With -reviews-auto-approve, reviews by trusted users are
+// approved straight away: users who can moderate reviews, and users with at least
+// -reviews-trusted-after approved reviews. Anyone else's are pending.
+func (app *application) newReviewStatus(r *http.Request) (string, error) {
+	if !app.config.reviews.autoApprove {
+		return data.ReviewPending, nil
+	}
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return "", err
+	}
+
+	if permissions.Include("reviews:moderate") {
+		return data.ReviewApproved, nil
+	}
+
+	approved, err := app.models.Reviews.CountApprovedForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return "", err
+	}
+
+	if approved >= app.config.reviews.trustedAfter {
+		return data.ReviewApproved, nil
+	}
+
+	return data.ReviewPending, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addUserRoleHandler() gives a user one of the roles from GET /v1/admin/roles.
+// Giving a user a role they already have does nothing. The user is alerted, since a
+// role can give them permissions they didn't have before.
+func (app *application) addUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Role != "", "role", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("role", "must be one of the roles from GET /v1/admin/roles")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "add_role", "user", user.ID, input)
+	app.securityEvent(r, user.ID, data.SecurityRoleAssigned, data.SeverityWarning, map[string]string{"role": input.Role})
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	err := app.models.Roles.RemoveForUser(user.ID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "remove_role", "user", user.ID, map[string]string{"role": role})
+	app.securityEvent(r, user.ID, data.SecurityRoleRemoved, data.SeverityInfo, map[string]string{"role": role})
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// The writeUserRoles() helper responds with a user's roles, after they've been changed.
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, userID int64) {
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
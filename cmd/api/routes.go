@@ -3,10 +3,224 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// A route describes a single endpoint. As well as being used to register the handler
+// with the router, the route table is used to generate the OpenAPI document which is
+// served at /v1/openapi.json, so the two can never drift apart.
+type route struct {
+	method  string
+	path    string
+	summary string
+	// The query string parameters accepted by the endpoint, if any. If strictQuery is
+	// set (or the -strict-query-params flag is), requests with any other parameters
+	// are rejected.
+	query       []string
+	strictQuery bool
+	// If permission is set, the user must be activated and have the permission code.
+	// Otherwise, if activated is set, the user must simply be activated, and if
+	// authenticated is set, they need only be logged in.
+	permission    string
+	activated     bool
+	authenticated bool
+	handler       http.HandlerFunc
+}
+
+// The routeTable() method returns the metadata and handlers for every endpoint.
+func (app *application) routeTable() []route {
+	routes := []route{
+		{method: http.MethodGet, path: "/v1/healthcheck", summary: "Show application status", handler: app.healthcheckHandler},
+		{method: http.MethodGet, path: "/v1/openapi.json", summary: "Show the OpenAPI specification", handler: app.openAPIHandler},
+		{method: http.MethodGet, path: "/debug/vars", summary: "Show application metrics", handler: expvar.Handler().ServeHTTP},
+
+		{method: http.MethodGet, path: "/v1/movies", summary: "List movies", query: []string{"title", "genres", "genres_any", "genres_not", "highlight", "fuzzy", "provider", "region", "provider_type", "released_in", "released_after", "released_before", "certification", "language", "budget_min", "budget_max", "box_office_min", "box_office_max", "year_min", "year_max", "runtime_min", "runtime_max", "filter", "include_deleted", "cursor", "page", "page_size", "sort", "fields", "include"}, strictQuery: true, permission: "movies:read", handler: app.listMoviesHandler},
+		{method: http.MethodDelete, path: "/v1/movies", summary: "Delete the movies matching a filter", query: []string{"genre", "year_min", "year_max", "filter", "dry_run"}, strictQuery: true, permission: "movies:write", handler: app.bulkDeleteMoviesHandler},
+		{method: http.MethodPost, path: "/v1/movies", summary: "Create a movie", query: []string{"allow_duplicate"}, permission: "movies:write", handler: app.createMovieHandler},
+		{method: http.MethodGet, path: "/v1/movies/duplicates", summary: "Find movies which a new movie would probably duplicate", query: []string{"title", "year"}, permission: "movies:read", handler: app.checkDuplicatesHandler},
+		{method: http.MethodGet, path: "/v1/movies/trending", summary: "List the most viewed movies", query: []string{"window", "page", "page_size"}, permission: "movies:read", handler: app.listTrendingMoviesHandler},
+		{method: http.MethodPost, path: "/v1/imports/tmdb/:id", summary: "Import a movie from TMDB", permission: "movies:write", handler: app.importTMDBMovieHandler},
+		{method: http.MethodPost, path: "/v1/exports/movies", summary: "Start exporting the movie list as CSV", query: []string{"format", "title", "genres", "filter", "sort"}, strictQuery: true, permission: "movies:read", handler: app.exportMoviesHandler},
+		{method: http.MethodGet, path: "/v1/movies/feed.atom", summary: "Atom feed of recently added movies", query: []string{"limit"}, handler: app.movieFeedHandler},
+		{method: http.MethodGet, path: "/v1/movies/events", summary: "Stream movie changes as server-sent events", query: []string{"last_event_id"}, permission: "movies:read", handler: app.movieEventsHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id", summary: "Show a movie", query: []string{"include_deleted", "fields", "include"}, permission: "movies:read", handler: app.showMovieHandler},
+		{method: http.MethodPatch, path: "/v1/movies/:id", summary: "Update a movie", permission: "movies:write", handler: app.updateMovieHandler},
+		{method: http.MethodDelete, path: "/v1/movies/:id", summary: "Delete a movie", permission: "movies:write", handler: app.deleteMovieHandler},
+		{method: http.MethodPost, path: "/v1/movies/:id/restore", summary: "Restore a deleted movie", permission: "movies:write", handler: app.restoreMovieHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/history", summary: "List a movie's revisions", query: []string{"page", "page_size"}, permission: "movies:read", handler: app.listMovieHistoryHandler},
+		{method: http.MethodPost, path: "/v1/movies/:id/rollback", summary: "Roll a movie back to an earlier version", permission: "movies:write", handler: app.rollbackMovieHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/watch-providers", summary: "Show where a movie can be watched", permission: "movies:read", handler: app.showWatchProvidersHandler},
+		{method: http.MethodPut, path: "/v1/movies/:id/watch-providers", summary: "Set where a movie can be watched", permission: "movies:write", handler: app.replaceWatchProvidersHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/translations", summary: "List a movie's titles in other languages", permission: "movies:read", handler: app.showTranslationsHandler},
+		{method: http.MethodPut, path: "/v1/movies/:id/translations", summary: "Replace a movie's titles in other languages", permission: "movies:write", handler: app.replaceTranslationsHandler},
+		{method: http.MethodGet, path: "/v1/collections", summary: "List movie collections", query: []string{"page", "page_size", "sort"}, strictQuery: true, permission: "movies:read", handler: app.listCollectionsHandler},
+		{method: http.MethodPost, path: "/v1/collections", summary: "Create a movie collection", permission: "movies:write", handler: app.createCollectionHandler},
+		{method: http.MethodGet, path: "/v1/collections/:id", summary: "Show a movie collection with its movies", permission: "movies:read", handler: app.showCollectionHandler},
+		{method: http.MethodPatch, path: "/v1/collections/:id", summary: "Update a movie collection", permission: "movies:write", handler: app.updateCollectionHandler},
+		{method: http.MethodDelete, path: "/v1/collections/:id", summary: "Delete a movie collection", permission: "movies:write", handler: app.deleteCollectionHandler},
+		{method: http.MethodGet, path: "/v1/collections/:id/movies", summary: "List the movies in a collection, in order", permission: "movies:read", handler: app.listCollectionMoviesHandler},
+		{method: http.MethodPost, path: "/v1/collections/:id/movies", summary: "Add a movie to a collection", permission: "movies:write", handler: app.addCollectionMovieHandler},
+		{method: http.MethodPut, path: "/v1/collections/:id/movies", summary: "Replace or reorder the movies in a collection", permission: "movies:write", handler: app.replaceCollectionMoviesHandler},
+		{method: http.MethodDelete, path: "/v1/collections/:id/movies/:movie_id", summary: "Remove a movie from a collection", permission: "movies:write", handler: app.removeCollectionMovieHandler},
+		{method: http.MethodGet, path: "/v1/series", summary: "List TV series", query: []string{"title", "genres", "page", "page_size", "sort"}, strictQuery: true, permission: "movies:read", handler: app.listSeriesHandler},
+		{method: http.MethodPost, path: "/v1/series", summary: "Create a TV series", permission: "movies:write", handler: app.createSeriesHandler},
+		{method: http.MethodGet, path: "/v1/series/:id", summary: "Show a TV series with its seasons and episodes", permission: "movies:read", handler: app.showSeriesHandler},
+		{method: http.MethodPatch, path: "/v1/series/:id", summary: "Update a TV series", permission: "movies:write", handler: app.updateSeriesHandler},
+		{method: http.MethodDelete, path: "/v1/series/:id", summary: "Delete a TV series", permission: "movies:write", handler: app.deleteSeriesHandler},
+		{method: http.MethodGet, path: "/v1/series/:id/seasons", summary: "List a TV series' seasons and episodes", permission: "movies:read", handler: app.listSeasonsHandler},
+		{method: http.MethodPut, path: "/v1/series/:id/seasons/:number", summary: "Add or replace a season of a TV series", permission: "movies:write", handler: app.replaceSeasonHandler},
+		{method: http.MethodDelete, path: "/v1/series/:id/seasons/:number", summary: "Delete a season of a TV series", permission: "movies:write", handler: app.deleteSeasonHandler},
+		{method: http.MethodGet, path: "/v1/genres", summary: "List the genre catalog", permission: "movies:read", handler: app.listGenresHandler},
+		{method: http.MethodGet, path: "/v1/genres/:slug/movies", summary: "List the movies with a genre", query: []string{"page", "page_size", "sort"}, permission: "movies:read", handler: app.listGenreMoviesHandler},
+		{method: http.MethodPost, path: "/v1/movies/:id/poster", summary: "Upload a movie's poster", permission: "movies:write", handler: app.uploadPosterHandler},
+		{method: http.MethodGet, path: "/v1/posters/:name", summary: "Show an uploaded poster", handler: app.showPosterHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/rating", summary: "Show your rating of a movie", permission: "movies:read", handler: app.showRatingHandler},
+		{method: http.MethodPut, path: "/v1/movies/:id/rating", summary: "Rate a movie from 1 to 10", permission: "movies:read", handler: app.setRatingHandler},
+		{method: http.MethodDelete, path: "/v1/movies/:id/rating", summary: "Delete your rating of a movie", permission: "movies:read", handler: app.deleteRatingHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/reviews", summary: "List a movie's reviews", query: []string{"page", "page_size", "sort"}, permission: "movies:read", handler: app.listReviewsHandler},
+		{method: http.MethodPost, path: "/v1/movies/:id/reviews", summary: "Review a movie", permission: "movies:read", handler: app.createReviewHandler},
+		{method: http.MethodGet, path: "/v1/movies/:id/reviews/:review_id", summary: "Show a review", permission: "movies:read", handler: app.showReviewHandler},
+		{method: http.MethodPatch, path: "/v1/movies/:id/reviews/:review_id", summary: "Edit your review", permission: "movies:read", handler: app.updateReviewHandler},
+		{method: http.MethodDelete, path: "/v1/movies/:id/reviews/:review_id", summary: "Delete a review", permission: "movies:read", handler: app.deleteReviewHandler},
+		{method: http.MethodPost, path: "/v1/reviews/:id/report", summary: "Report a review which breaks the rules", permission: "movies:read", handler: app.reportReviewHandler},
+
+		{method: http.MethodGet, path: "/v1/users", summary: "List user accounts", query: []string{"activated", "email", "seen_before", "page", "page_size", "sort"}, permission: "users:admin", handler: app.listUsersHandler},
+		{method: http.MethodPost, path: "/v1/users", summary: "Register a user", handler: app.registerUserHandler},
+		{method: http.MethodGet, path: "/v1/users/:id", summary: "Show a user account", permission: "users:admin", handler: app.showUserHandler},
+		{method: http.MethodPatch, path: "/v1/users/:id", summary: "Suspend a user, or lift their suspension", permission: "users:admin", handler: app.updateUserSuspendedHandler},
+		{method: http.MethodPost, path: "/v1/users/:id/permissions", summary: "Grant permission codes to a user", permission: "admin:write", handler: app.grantUserPermissionsHandler},
+		{method: http.MethodDelete, path: "/v1/users/:id/permissions", summary: "Revoke permission codes from a user", permission: "admin:write", handler: app.revokeUserPermissionsHandler},
+		{method: http.MethodPost, path: "/v1/users/:id/roles", summary: "Give a user a role", permission: "admin:write", handler: app.addUserRoleHandler},
+		{method: http.MethodDelete, path: "/v1/users/:id/roles/:role", summary: "Take a role away from a user", permission: "admin:write", handler: app.removeUserRoleHandler},
+		{method: http.MethodPost, path: "/v1/tokens/activation", summary: "Resend an activation token", handler: app.createActivationTokenHandler},
+		{method: http.MethodPut, path: "/v1/users/activated", summary: "Activate a user", handler: app.activateUserHandler},
+		{method: http.MethodPut, path: "/v1/users/email", summary: "Confirm a change of email address", handler: app.confirmEmailChangeHandler},
+		{method: http.MethodGet, path: "/v1/users/activate", summary: "Activate a user from the link in an activation email", query: []string{"token"}, handler: app.activateUserLinkHandler},
+		{method: http.MethodPost, path: "/v1/tokens/authentication", summary: "Create an authentication token", handler: app.createAuthenticationTokenHandler},
+		{method: http.MethodPost, path: "/v1/tokens/magic-link", summary: "Email a single-use sign in link", handler: app.createMagicLinkHandler},
+		{method: http.MethodGet, path: "/v1/tokens/magic-link", summary: "Sign in by following a magic link", query: []string{"token"}, handler: app.showMagicLinkHandler},
+		{method: http.MethodPost, path: "/v1/tokens/magic-link/exchange", summary: "Exchange a magic link token for an authentication token", handler: app.exchangeMagicLinkHandler},
+		{method: http.MethodGet, path: "/v1/me/watch-history", summary: "List the movies you've watched", query: []string{"page", "page_size", "sort"}, activated: true, handler: app.listWatchHistoryHandler},
+		{method: http.MethodPost, path: "/v1/me/watch-history", summary: "Record that you watched a movie", activated: true, handler: app.createWatchEntryHandler},
+		{method: http.MethodDelete, path: "/v1/me/watch-history/:id", summary: "Delete an entry from your watch history", activated: true, handler: app.deleteWatchEntryHandler},
+		{method: http.MethodGet, path: "/v1/me", summary: "Show your account and permissions", authenticated: true, handler: app.showMeHandler},
+		{method: http.MethodPatch, path: "/v1/me", summary: "Update your profile", authenticated: true, handler: app.updateMeHandler},
+		{method: http.MethodDelete, path: "/v1/me", summary: "Delete your account", authenticated: true, handler: app.deleteMeHandler},
+		{method: http.MethodPost, path: "/v1/me/email", summary: "Ask to change your email address", activated: true, handler: app.createEmailChangeHandler},
+		{method: http.MethodPut, path: "/v1/me/password", summary: "Change your password", activated: true, handler: app.updatePasswordHandler},
+		{method: http.MethodGet, path: "/v1/me/watchlist", summary: "List the movies you've saved to watch later", query: []string{"page", "page_size", "sort"}, activated: true, handler: app.listWatchlistHandler},
+		{method: http.MethodPost, path: "/v1/me/watchlist", summary: "Save a movie to watch later", activated: true, handler: app.addToWatchlistHandler},
+		{method: http.MethodDelete, path: "/v1/me/watchlist/:movie_id", summary: "Remove a movie from your watchlist", activated: true, handler: app.removeFromWatchlistHandler},
+		{method: http.MethodGet, path: "/v1/watchlists", summary: "List the shared watchlists you're a member of or invited to", activated: true, handler: app.listSharedWatchlistsHandler},
+		{method: http.MethodPost, path: "/v1/watchlists", summary: "Create a shared watchlist", activated: true, handler: app.createSharedWatchlistHandler},
+		{method: http.MethodGet, path: "/v1/watchlists/:id", summary: "Show a shared watchlist", activated: true, handler: app.showSharedWatchlistHandler},
+		{method: http.MethodPatch, path: "/v1/watchlists/:id", summary: "Rename a shared watchlist", activated: true, handler: app.updateSharedWatchlistHandler},
+		{method: http.MethodDelete, path: "/v1/watchlists/:id", summary: "Delete a shared watchlist", activated: true, handler: app.deleteSharedWatchlistHandler},
+		{method: http.MethodPost, path: "/v1/watchlists/:id/join", summary: "Accept an invitation to a shared watchlist", activated: true, handler: app.joinSharedWatchlistHandler},
+		{method: http.MethodGet, path: "/v1/watchlists/:id/movies", summary: "List the movies on a shared watchlist", query: []string{"page", "page_size", "sort"}, activated: true, handler: app.listSharedWatchlistMoviesHandler},
+		{method: http.MethodPost, path: "/v1/watchlists/:id/movies", summary: "Add a movie to a shared watchlist", activated: true, handler: app.addSharedWatchlistMovieHandler},
+		{method: http.MethodDelete, path: "/v1/watchlists/:id/movies/:movie_id", summary: "Remove a movie from a shared watchlist", activated: true, handler: app.removeSharedWatchlistMovieHandler},
+		{method: http.MethodGet, path: "/v1/watchlists/:id/members", summary: "List the members of a shared watchlist", activated: true, handler: app.listSharedWatchlistMembersHandler},
+		{method: http.MethodPost, path: "/v1/watchlists/:id/members", summary: "Invite a member of your organization to a shared watchlist by email", activated: true, handler: app.inviteSharedWatchlistMemberHandler},
+		{method: http.MethodPatch, path: "/v1/watchlists/:id/members/:user_id", summary: "Change a member's role on a shared watchlist", activated: true, handler: app.updateSharedWatchlistMemberHandler},
+		{method: http.MethodDelete, path: "/v1/watchlists/:id/members/:user_id", summary: "Remove a member from a shared watchlist, or leave it", activated: true, handler: app.removeSharedWatchlistMemberHandler},
+		{method: http.MethodGet, path: "/v1/me/favorites", summary: "List your favorite movies", query: []string{"page", "page_size", "sort"}, activated: true, handler: app.listFavoritesHandler},
+		{method: http.MethodPost, path: "/v1/me/favorites", summary: "Mark a movie as a favorite", activated: true, handler: app.addFavoriteHandler},
+		{method: http.MethodDelete, path: "/v1/me/favorites/:movie_id", summary: "Unmark a favorite movie", activated: true, handler: app.removeFavoriteHandler},
+		{method: http.MethodGet, path: "/v1/me/recommendations", summary: "List movies recommended for you", query: []string{"page", "page_size"}, activated: true, handler: app.listRecommendationsHandler},
+		{method: http.MethodGet, path: "/v1/me/ratings/export", summary: "Export your ratings and watch history as Letterboxd-compatible CSV", activated: true, handler: app.exportRatingsHandler},
+		{method: http.MethodGet, path: "/v1/me/logins", summary: "List the recent attempts to sign in to your account", query: []string{"page", "page_size"}, activated: true, handler: app.listLoginsHandler},
+		{method: http.MethodGet, path: "/v1/me/activity", summary: "List your recent activity", query: []string{"cursor", "page_size"}, activated: true, handler: app.listActivityHandler},
+		{method: http.MethodGet, path: "/v1/me/usage", summary: "Show your API usage and quotas", query: []string{"days"}, activated: true, handler: app.showUsageHandler},
+		{method: http.MethodGet, path: "/v1/me/usage/detail", summary: "Show your API usage by endpoint, with latencies", query: []string{"days"}, activated: true, handler: app.showUsageDetailHandler},
+		{method: http.MethodGet, path: "/v1/me/notifications", summary: "List your notifications", query: []string{"unread", "page", "page_size"}, authenticated: true, handler: app.listNotificationsHandler},
+		{method: http.MethodGet, path: "/v1/me/notifications/unread-count", summary: "Count your unread notifications", authenticated: true, handler: app.unreadNotificationsHandler},
+		{method: http.MethodPost, path: "/v1/me/notifications/read-all", summary: "Mark all your notifications as read", authenticated: true, handler: app.readAllNotificationsHandler},
+		{method: http.MethodPatch, path: "/v1/me/notifications/:id", summary: "Mark a notification as read or unread", authenticated: true, handler: app.updateNotificationHandler},
+		{method: http.MethodDelete, path: "/v1/me/notifications/:id", summary: "Delete a notification", authenticated: true, handler: app.deleteNotificationHandler},
+		{method: http.MethodGet, path: "/v1/unsubscribe", summary: "Turn off a kind of email from an unsubscribe link", query: []string{"token"}, handler: app.unsubscribeHandler},
+		{method: http.MethodPost, path: "/v1/unsubscribe", summary: "One-click unsubscribe from a kind of email", query: []string{"token"}, handler: app.unsubscribeHandler},
+		{method: http.MethodGet, path: "/v1/me/notification-preferences", summary: "Show which notifications you receive", activated: true, handler: app.showNotificationPreferencesHandler},
+		{method: http.MethodPatch, path: "/v1/me/notification-preferences", summary: "Change which notifications you receive", activated: true, handler: app.updateNotificationPreferencesHandler},
+		{method: http.MethodGet, path: "/v1/me/devices", summary: "List the devices you receive push notifications on", activated: true, handler: app.listPushDevicesHandler},
+		{method: http.MethodPost, path: "/v1/me/devices", summary: "Register a device for push notifications", activated: true, handler: app.registerPushDeviceHandler},
+		{method: http.MethodDelete, path: "/v1/me/devices/:id", summary: "Unregister a device from push notifications", activated: true, handler: app.deletePushDeviceHandler},
+		{method: http.MethodGet, path: "/v1/me/searches", summary: "List your saved searches", activated: true, handler: app.listSavedSearchesHandler},
+		{method: http.MethodPost, path: "/v1/me/searches", summary: "Save a movie search", activated: true, handler: app.createSavedSearchHandler},
+		{method: http.MethodGet, path: "/v1/me/searches/:id", summary: "Show a saved search", activated: true, handler: app.showSavedSearchHandler},
+		{method: http.MethodPatch, path: "/v1/me/searches/:id", summary: "Update a saved search", activated: true, handler: app.updateSavedSearchHandler},
+		{method: http.MethodDelete, path: "/v1/me/searches/:id", summary: "Delete a saved search", activated: true, handler: app.deleteSavedSearchHandler},
+		{method: http.MethodGet, path: "/v1/me/searches/:id/movies", summary: "Run a saved search", query: []string{"page", "page_size", "sort"}, activated: true, handler: app.runSavedSearchHandler},
+		{method: http.MethodGet, path: "/v1/me/security-events", summary: "List the security events for your account", query: []string{"page", "page_size"}, activated: true, handler: app.listSecurityEventsHandler},
+
+		{method: http.MethodPost, path: "/v1/batch", summary: "Apply a list of operations in a single transaction", activated: true, handler: app.batchHandler},
+		{method: http.MethodPost, path: "/v1/graphql", summary: "Execute a GraphQL query", handler: app.graphqlHandler},
+
+		{method: http.MethodGet, path: "/v1/oauth/clients", summary: "List your OAuth applications", activated: true, handler: app.listOAuthClientsHandler},
+		{method: http.MethodPost, path: "/v1/oauth/clients", summary: "Register an OAuth application", activated: true, handler: app.createOAuthClientHandler},
+		{method: http.MethodDelete, path: "/v1/oauth/clients/:id", summary: "Delete an OAuth application and revoke its tokens", activated: true, handler: app.deleteOAuthClientHandler},
+		{method: http.MethodGet, path: "/v1/oauth/authorize", summary: "Describe an OAuth authorization request for the consent screen", query: []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"}, activated: true, handler: app.showAuthorizationHandler},
+		{method: http.MethodPost, path: "/v1/oauth/authorize", summary: "Approve or deny an OAuth authorization request", activated: true, handler: app.authorizeHandler},
+		{method: http.MethodPost, path: "/v1/oauth/token", summary: "Exchange an OAuth authorization code for an access token", handler: app.oauthTokenHandler},
+
+		{method: http.MethodGet, path: "/v1/organizations", summary: "List your organizations", activated: true, handler: app.listOrganizationsHandler},
+		{method: http.MethodPost, path: "/v1/organizations", summary: "Create an organization", activated: true, handler: app.createOrganizationHandler},
+		{method: http.MethodGet, path: "/v1/organizations/:id", summary: "Show an organization", activated: true, handler: app.showOrganizationHandler},
+		{method: http.MethodPatch, path: "/v1/organizations/:id", summary: "Rename an organization", activated: true, handler: app.updateOrganizationHandler},
+		{method: http.MethodDelete, path: "/v1/organizations/:id", summary: "Delete an organization and its movies", activated: true, handler: app.deleteOrganizationHandler},
+		{method: http.MethodGet, path: "/v1/organizations/:id/members", summary: "List an organization's members", activated: true, handler: app.listOrganizationMembersHandler},
+		{method: http.MethodPost, path: "/v1/organizations/:id/members", summary: "Add a member to an organization", activated: true, handler: app.addOrganizationMemberHandler},
+		{method: http.MethodPatch, path: "/v1/organizations/:id/members/:user_id", summary: "Change a member's role", activated: true, handler: app.updateOrganizationMemberHandler},
+		{method: http.MethodDelete, path: "/v1/organizations/:id/members/:user_id", summary: "Remove a member from an organization", activated: true, handler: app.removeOrganizationMemberHandler},
+		{method: http.MethodGet, path: "/v1/webhooks", summary: "List your webhooks", permission: "webhooks:manage", handler: app.listWebhooksHandler},
+		{method: http.MethodPost, path: "/v1/webhooks", summary: "Create a webhook", permission: "webhooks:manage", handler: app.createWebhookHandler},
+		{method: http.MethodGet, path: "/v1/webhooks/:id", summary: "Show a webhook", permission: "webhooks:manage", handler: app.showWebhookHandler},
+		{method: http.MethodPatch, path: "/v1/webhooks/:id", summary: "Update a webhook", permission: "webhooks:manage", handler: app.updateWebhookHandler},
+		{method: http.MethodDelete, path: "/v1/webhooks/:id", summary: "Delete a webhook", permission: "webhooks:manage", handler: app.deleteWebhookHandler},
+		{method: http.MethodGet, path: "/v1/webhooks/:id/deliveries", summary: "List the delivery attempts for a webhook", query: []string{"page", "page_size"}, permission: "webhooks:manage", handler: app.listWebhookDeliveriesHandler},
+
+		{method: http.MethodPost, path: "/v1/admin/announcements", summary: "Email an announcement to users", permission: "admin:write", handler: app.createAnnouncementHandler},
+		{method: http.MethodPost, path: "/v1/admin/movies/import", summary: "Import movies from a CSV or TSV file", query: []string{"format", "batch_size"}, permission: "admin:write", handler: app.importMoviesHandler},
+		{method: http.MethodPost, path: "/v1/admin/backups", summary: "Back up the movie catalog to object storage now", permission: "admin:write", handler: app.createBackupHandler},
+		{method: http.MethodGet, path: "/v1/admin/audit-logs", summary: "Search the audit log", query: []string{"actor_id", "resource_type", "action", "from", "to", "page", "page_size", "sort"}, permission: "admin:read", handler: app.listAuditLogsHandler},
+		{method: http.MethodGet, path: "/v1/admin/roles", summary: "List the roles and the permissions they give", permission: "admin:read", handler: app.listRolesHandler},
+		{method: http.MethodGet, path: "/v1/admin/permissions", summary: "List permission codes with their user counts", permission: "admin:read", handler: app.listPermissionsHandler},
+		{method: http.MethodPost, path: "/v1/admin/permissions", summary: "Create a permission code", permission: "admin:write", handler: app.createPermissionHandler},
+		{method: http.MethodGet, path: "/v1/admin/permissions/:id", summary: "Show a permission code", permission: "admin:read", handler: app.showPermissionHandler},
+		{method: http.MethodPatch, path: "/v1/admin/permissions/:id", summary: "Update or deactivate a permission code", permission: "admin:write", handler: app.updatePermissionHandler},
+		{method: http.MethodGet, path: "/v1/admin/invites", summary: "List the invites which haven't been used yet", permission: "admin:read", handler: app.listInvitesHandler},
+		{method: http.MethodPost, path: "/v1/admin/invites", summary: "Create an invite code for registering", permission: "admin:write", handler: app.createInviteHandler},
+		{method: http.MethodDelete, path: "/v1/admin/invites/:id", summary: "Revoke an invite", permission: "admin:write", handler: app.deleteInviteHandler},
+		{method: http.MethodGet, path: "/v1/admin/rate-limit-exemptions", summary: "List the clients exempt from rate limiting", permission: "admin:read", handler: app.listRateLimitExemptionsHandler},
+		{method: http.MethodPost, path: "/v1/admin/rate-limit-exemptions", summary: "Exempt a user, application or network from rate limiting", permission: "admin:write", handler: app.createRateLimitExemptionHandler},
+		{method: http.MethodDelete, path: "/v1/admin/rate-limit-exemptions/:id", summary: "Delete a rate limit exemption", permission: "admin:write", handler: app.deleteRateLimitExemptionHandler},
+		{method: http.MethodGet, path: "/v1/admin/reviews", summary: "List the reviews waiting for moderation, or in another moderation state", query: []string{"status", "reported", "page", "page_size", "sort"}, permission: "reviews:moderate", handler: app.listModerationQueueHandler},
+		{method: http.MethodGet, path: "/v1/admin/reviews/:id", summary: "Show a review of any movie for moderation", permission: "reviews:moderate", handler: app.showModeratedReviewHandler},
+		{method: http.MethodPatch, path: "/v1/admin/reviews/:id", summary: "Approve or reject a review", permission: "reviews:moderate", handler: app.moderateReviewHandler},
+		{method: http.MethodGet, path: "/v1/admin/stats", summary: "Show aggregate statistics", query: []string{"days"}, permission: "admin:read", handler: app.adminStatsHandler},
+		{method: http.MethodGet, path: "/v1/admin/usage", summary: "List the heaviest users of the API", query: []string{"days", "limit"}, permission: "admin:read", handler: app.adminUsageHandler},
+		{method: http.MethodGet, path: "/v1/admin/system", summary: "Show runtime and database statistics", permission: "admin:read", handler: app.adminSystemHandler},
+
+		{method: http.MethodGet, path: "/v1/jobs/:id", summary: "Show the status of a job", activated: true, handler: app.showJobHandler},
+		{method: http.MethodGet, path: "/v1/jobs/:id/download", summary: "Download the file made by a completed export job", activated: true, handler: app.downloadJobFileHandler},
+	}
+
+	// The fixture endpoints wipe the database, so they only exist in development, where
+	// end-to-end tests and demos use them to set up known state, and only when they've
+	// been turned on with -enable-fixtures.
+	if app.config.enableFixtures && app.config.env == "development" {
+		routes = append(routes,
+			route{method: http.MethodPost, path: "/v1/testing/reset", summary: "Empty the database (development only)", handler: app.testingOnly(app.resetFixturesHandler)},
+			route{method: http.MethodPost, path: "/v1/testing/fixtures", summary: "Reset the database and load known users, tokens and movies (development only)", handler: app.testingOnly(app.loadFixturesHandler)},
+		)
+	}
+
+	return routes
+}
+
 // there will be one function routes
 // that will encapsulate all routing rules for future use
 func (app *application) routes() http.Handler {
@@ -21,26 +235,84 @@ func (app *application) routes() http.Handler {
 	// make the same for methodNotAllowedResponce()
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	// Register the relevant methods, URL patterns and handler functions for our
-	// endpoints using the HandlerFunc() method. Note that http.MethodGet and
-	// http.MethodPost are constants which equate to the strings "GET" and "POST"
-	// respectively.
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// Wrap the handler for each route in the table in the middleware which enforces
+	// the route's access requirements.
+	table := app.routeTable()
+	handlers := make([]http.HandlerFunc, len(table))
+
+	for i, rt := range table {
+		handler := rt.handler
+		if rt.strictQuery || app.config.strictQuery {
+			handler = app.rejectUnknownQuery(rt.query, handler)
+		}
+
+		handlers[i] = handler
+
+		switch {
+		case rt.permission != "":
+			handlers[i] = app.requirePermission(rt.permission, handler)
+		case rt.activated:
+			// Routes without a permission can't be granted to third-party applications.
+			handlers[i] = app.requireActivatedUser(app.requireFirstParty(handler))
+		case rt.authenticated:
+			handlers[i] = app.requireAuthenticatedUser(app.requireFirstParty(handler))
+		}
+
+		handlers[i] = app.recordEndpointUsage(rt.method, rt.path, handlers[i])
+	}
+
+	// httprouter doesn't allow a static path segment in the same position as a
+	// parameter, so a route like GET /v1/movies/events can't be registered alongside
+	// GET /v1/movies/:id. Instead, we set these static routes aside and have the
+	// parameterised route dispatch to them when the parameter matches.
+	params := map[string]string{}
+	for _, rt := range table {
+		dir, last := path.Split(rt.path)
+		if strings.HasPrefix(last, ":") {
+			params[rt.method+" "+dir] = strings.TrimPrefix(last, ":")
+		}
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	statics := map[string]map[string]http.HandlerFunc{}
+	for i, rt := range table {
+		dir, last := path.Split(rt.path)
+		key := rt.method + " " + dir
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+		if _, ok := params[key]; ok && !strings.HasPrefix(last, ":") {
+			if statics[key] == nil {
+				statics[key] = map[string]http.HandlerFunc{}
+			}
+			statics[key][last] = handlers[i]
+		}
+	}
+
+	for i, rt := range table {
+		dir, last := path.Split(rt.path)
+		key := rt.method + " " + dir
+
+		switch {
+		case statics[key] == nil:
+			router.HandlerFunc(rt.method, rt.path, handlers[i])
+		case strings.HasPrefix(last, ":"):
+			router.HandlerFunc(rt.method, rt.path, dispatchStatic(params[key], statics[key], handlers[i]))
+		}
+	}
 
 	// Return the httprouter instance.
 	// in order for middleware func to run for every handler
 	// router itself should be wrapped in middleware
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.meterUsage(router))))))
+}
+
+// The dispatchStatic() helper returns a handler which calls the handler for a static
+// path segment if the named parameter matches it, and otherwise calls next.
+func dispatchStatic(param string, statics map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := statics[httprouter.ParamsFromContext(r.Context()).ByName(param)]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/push"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+// savedSearchMatchLimit is the most new movies included in a single notification.
+// Any further matches are picked up by the next run.
+const savedSearchMatchLimit = 50
+
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string   `json:"name"`
+		Title         string   `json:"title"`
+		Genres        []string `json:"genres"`
+		YearMin       *int32   `json:"year_min"`
+		YearMax       *int32   `json:"year_max"`
+		NotifyEmail   bool     `json:"notify_email"`
+		NotifyWebhook bool     `json:"notify_webhook"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	search := &data.SavedSearch{
+		UserID:         app.contextGetUser(r).ID,
+		OrganizationID: app.contextGetOrganization(r),
+		Name:           input.Name,
+		Title:          input.Title,
+		Genres:         input.Genres,
+		YearMin:        input.YearMin,
+		YearMax:        input.YearMax,
+		NotifyEmail:    input.NotifyEmail,
+		NotifyWebhook:  input.NotifyWebhook,
+	}
+
+	if search.Genres == nil {
+		search.Genres = []string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Insert(search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/me/searches/%d", search.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := app.models.SavedSearches.GetAllForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name          *string  `json:"name"`
+		Title         *string  `json:"title"`
+		Genres        []string `json:"genres"`
+		YearMin       *int32   `json:"year_min"`
+		YearMax       *int32   `json:"year_max"`
+		NotifyEmail   *bool    `json:"notify_email"`
+		NotifyWebhook *bool    `json:"notify_webhook"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		search.Name = *input.Name
+	}
+
+	if input.Title != nil {
+		search.Title = *input.Title
+	}
+
+	if input.Genres != nil {
+		search.Genres = input.Genres
+	}
+
+	if input.YearMin != nil {
+		search.YearMin = input.YearMin
+	}
+
+	if input.YearMax != nil {
+		search.YearMax = input.YearMax
+	}
+
+	if input.NotifyEmail != nil {
+		search.NotifyEmail = *input.NotifyEmail
+	}
+
+	if input.NotifyWebhook != nil {
+		search.NotifyWebhook = *input.NotifyWebhook
+	}
+
+	v := validator.New()
+
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Update(search)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.SavedSearches.DeleteForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "search successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The runSavedSearchHandler() re-executes a saved search, returning the matching
+// movies in the same shape as GET /v1/movies.
+func (app *application) runSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := app.savedSearchForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.MovieSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var err error
+
+	filters.Expression, err = search.Expression(0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, metadata, err := app.movies(r).GetAll(search.Title, search.Genres, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resources := make([]movieResource, len(movies))
+	for i, movie := range movies {
+		resources[i] = app.movieResource(movie)
+	}
+
+	env := envelope{"metadata": metadata, "movies": resources, "_links": app.pageLinks(r, metadata)}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The savedSearchForRequest() helper fetches the saved search identified by the :id
+// URL parameter, if it belongs to the current user in the organization the request is
+// made on behalf of. If it doesn't, an error response
+// is sent and ok is false.
+func (app *application) savedSearchForRequest(w http.ResponseWriter, r *http.Request) (*data.SavedSearch, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	search, err := app.models.SavedSearches.GetForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return search, true
+}
+
+// The notifySavedSearches() method is run by the scheduler. For each saved search with
+// notifications turned on, it looks for movies added since the last run which match,
+// and tells the user about them by email and/or webhook.
+func (app *application) notifySavedSearches(ctx context.Context) error {
+	searches, err := app.models.SavedSearches.GetNotifiable()
+	if err != nil {
+		return err
+	}
+
+	// The newest movie in each organization's catalog, looked up as needed.
+	latestIDs := map[int64]int64{}
+
+	for _, search := range searches {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		latestID, ok := latestIDs[search.OrganizationID]
+		if !ok {
+			latestID, err = app.models.Movies.ForOrganization(search.OrganizationID).LatestID()
+			if err != nil {
+				return err
+			}
+			latestIDs[search.OrganizationID] = latestID
+		}
+
+		if search.LastMovieID >= latestID {
+			continue
+		}
+
+		err := app.notifySavedSearch(search, latestID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (app *application) notifySavedSearch(search *data.SavedSearch, latestID int64) error {
+	expr, err := search.Expression(search.LastMovieID)
+	if err != nil {
+		return err
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     savedSearchMatchLimit,
+		Sort:         "id",
+		SortSafelist: data.MovieSortSafelist,
+		Expression:   expr,
+	}
+
+	movies, _, err := app.models.Movies.ForOrganization(search.OrganizationID).GetAll(search.Title, search.Genres, filters)
+	if err != nil {
+		return err
+	}
+
+	// If there were more matches than fit in one notification, only move past the ones
+	// we've sent so the rest go out next time. Otherwise every movie up to latestID has
+	// been considered.
+	lastID := latestID
+	if len(movies) == savedSearchMatchLimit {
+		lastID = movies[len(movies)-1].ID
+	}
+
+	if len(movies) > 0 {
+		list := make([]map[string]any, len(movies))
+		for i, movie := range movies {
+			list[i] = map[string]any{"id": movie.ID, "title": movie.Title, "year": movie.Year}
+		}
+
+		if search.NotifyEmail {
+			user, err := app.models.Users.Get(search.UserID)
+			if err != nil {
+				return err
+			}
+
+			err = app.notifyUser(user, data.NotifySavedSearchMatches, "saved_search.tmpl", map[string]any{
+				"name":   user.Name,
+				"search": search.Name,
+				"movies": list,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Users who asked to be notified also see the matches in the app, unless
+		// they've turned in-app notifications off.
+		if search.NotifyEmail {
+			err := app.notifyInApp(search.UserID, data.NotifySavedSearchMatches, &data.Notification{
+				Kind:  data.NotificationSavedSearchMatch,
+				Title: fmt.Sprintf("%d new movies match %q", len(movies), search.Name),
+			}, map[string]any{"search_id": search.ID, "movies": list})
+			if err != nil {
+				return err
+			}
+
+			err = app.notifyPush(search.UserID, data.NotifySavedSearchMatches, push.Message{
+				Title: fmt.Sprintf("%d new movies match %q", len(movies), search.Name),
+				Data:  map[string]string{"search_id": strconv.FormatInt(search.ID, 10)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if search.NotifyWebhook {
+			app.emitUserEvent(search.UserID, data.EventSavedSearchMatched, map[string]any{
+				"search": search,
+				"movies": list,
+			})
+		}
+	}
+
+	return app.models.SavedSearches.SetLastMovieID(search.ID, lastID)
+}
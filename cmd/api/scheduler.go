@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"greenlight/anaplo/internal/cron"
+	"greenlight/anaplo/internal/data"
+	"os"
+	"sync"
+	"time"
+)
+
+// A scheduledJob is a task which runs periodically according to a cron expression.
+type scheduledJob struct {
+	name     string
+	schedule string
+	run      func(ctx context.Context) error
+}
+
+// The scheduledJobs() method returns the periodic jobs. A job with an empty schedule
+// is disabled.
+func (app *application) scheduledJobs() []scheduledJob {
+	return []scheduledJob{
+		{"token_cleanup", app.config.scheduler.tokenCleanup, app.cleanupExpiredTokens},
+		{"unactivated_account_purge", app.config.scheduler.accountPurge, app.purgeUnactivatedAccounts},
+		{"deleted_account_purge", app.config.scheduler.accountPurge, app.purgeDeletedAccounts},
+		{"movies_digest", app.config.scheduler.digest, app.sendMoviesDigest},
+		{"movie_events_prune", app.config.scheduler.eventsPrune, app.pruneMovieEvents},
+		{"auth_failures_prune", app.config.scheduler.authFailuresPrune, app.pruneAuthFailures},
+		{"saved_search_notify", app.config.scheduler.savedSearches, app.notifySavedSearches},
+		{"database_backup", app.config.scheduler.backup, app.exportBackup},
+		{"recommendations_refresh", app.config.scheduler.recommendations, app.refreshRecommendations},
+		{"movie_views_prune", app.config.scheduler.viewsPrune, app.pruneMovieViews},
+		{"metrics_rollup", app.config.scheduler.metricsRollup, app.rollupMetrics},
+		{"jobs_prune", app.config.scheduler.jobsPrune, app.pruneJobs},
+	}
+}
+
+// The startScheduler() method launches a goroutine for each enabled scheduled job,
+// which sleeps until the job's next run time and then runs it. The goroutines stop
+// when stopCtx is cancelled, and the returned WaitGroup can be used to wait for any
+// runs in progress to finish. Like the persistent jobs, the runs receive workCtx.
+func (app *application) startScheduler(stopCtx, workCtx context.Context) (*sync.WaitGroup, error) {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+
+	var wg sync.WaitGroup
+
+	for _, job := range app.scheduledJobs() {
+		if job.schedule == "" {
+			continue
+		}
+
+		schedule, err := cron.Parse(job.schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				next := schedule.Next(time.Now())
+				if next.IsZero() {
+					app.logger.Error("scheduled job will never run", "job", job.name, "schedule", job.schedule)
+					return
+				}
+
+				timer := time.NewTimer(time.Until(next))
+
+				select {
+				case <-stopCtx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				app.runScheduledJob(workCtx, job, next, owner)
+			}
+		}()
+	}
+
+	return &wg, nil
+}
+
+// The runScheduledJob() method runs a job for the given slot, if this instance of the
+// application manages to take the job's lock. Other instances will see that the slot
+// has been claimed and skip it.
+func (app *application) runScheduledJob(ctx context.Context, job scheduledJob, slot time.Time, owner string) {
+	locked, err := app.models.Scheduler.TryLock(job.name, slot, app.config.scheduler.lease, owner)
+	if err != nil {
+		app.logger.Error("unable to lock scheduled job", "job", job.name, "error", err.Error())
+		return
+	}
+
+	if !locked {
+		return
+	}
+
+	defer func() {
+		if pv := recover(); pv != nil {
+			app.logger.Error(fmt.Sprintf("%v", pv), "job", job.name)
+		}
+
+		err := app.models.Scheduler.Unlock(job.name, owner)
+		if err != nil {
+			app.logger.Error("unable to unlock scheduled job", "job", job.name, "error", err.Error())
+		}
+	}()
+
+	start := time.Now()
+
+	err = job.run(ctx)
+	if err != nil {
+		app.logger.Error("scheduled job failed", "job", job.name, "error", err.Error())
+		return
+	}
+
+	app.logger.Info("scheduled job completed", "job", job.name, "duration", time.Since(start).String())
+}
+
+func (app *application) cleanupExpiredTokens(ctx context.Context) error {
+	n, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("deleted expired tokens", "count", n)
+
+	// Tokens hashed with a key whose grace period has run out can't be used, so they
+	// might as well go too.
+	n, err = app.models.Tokens.DeleteForRetiredKeys()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("deleted tokens hashed with retired keys", "count", n)
+
+	n, err = app.models.OAuth.DeleteExpiredCodes()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("deleted expired OAuth authorization codes", "count", n)
+
+	n, err = app.models.Invites.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("deleted expired invites", "count", n)
+	return nil
+}
+
+func (app *application) purgeUnactivatedAccounts(ctx context.Context) error {
+	n, err := app.models.Users.DeleteUnactivated(time.Now().Add(-app.config.scheduler.unactivatedTTL))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("purged unactivated accounts", "count", n)
+	return nil
+}
+
+// The purgeDeletedAccounts() method removes the accounts which were deleted longer
+// ago than the grace period.
+func (app *application) purgeDeletedAccounts(ctx context.Context) error {
+	n, err := app.models.Users.PurgeDeleted(time.Now().Add(-app.config.scheduler.deletedGrace))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("purged deleted accounts", "count", n)
+	return nil
+}
+
+func (app *application) pruneMovieEvents(ctx context.Context) error {
+	n, err := app.models.MovieEvents.DeleteBefore(time.Now().Add(-app.config.scheduler.eventsTTL))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("pruned movie events", "count", n)
+	return nil
+}
+
+// The pruneJobs() method deletes the persistent jobs which finished long enough ago,
+// so that their payloads, which can hold email addresses, aren't kept forever.
+func (app *application) pruneJobs(ctx context.Context) error {
+	n, err := app.models.Jobs.DeleteFinishedBefore(time.Now().Add(-app.config.scheduler.jobsTTL))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("pruned finished jobs", "count", n)
+	return nil
+}
+
+func (app *application) pruneAuthFailures(ctx context.Context) error {
+	n, err := app.models.AuthFailures.DeleteBefore(time.Now().Add(-app.config.authThrottle.window))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("pruned authentication failures", "count", n)
+	return nil
+}
+
+// The sendMoviesDigest() method queues an email to every activated member of each
+// organization listing the movies added to its catalog in the past week. Nothing is
+// sent for organizations with no new movies.
+func (app *application) sendMoviesDigest(ctx context.Context) error {
+	organizationIDs, err := app.models.Organizations.GetAllIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, organizationID := range organizationIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := app.sendOrganizationMoviesDigest(ctx, organizationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (app *application) sendOrganizationMoviesDigest(ctx context.Context, organizationID int64) error {
+	movies, err := app.models.Movies.ForOrganization(organizationID).GetCreatedSince(time.Now().AddDate(0, 0, -7), 50)
+	if err != nil {
+		return err
+	}
+
+	if len(movies) == 0 {
+		return nil
+	}
+
+	// The email data goes through the job queue as JSON, so we copy just the fields
+	// the template needs into plain maps.
+	list := make([]map[string]any, len(movies))
+	for i, movie := range movies {
+		list[i] = map[string]any{"title": movie.Title, "year": movie.Year}
+	}
+
+	users, err := app.models.Users.GetAllActivatedInOrganization(organizationID)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := app.notifyUser(user, data.NotifyDigest, "movies_digest.tmpl", map[string]any{
+			"name":   user.Name,
+			"movies": list,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/secrets"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The loadSecrets() function resolves any secrets which were configured to come from
+// a file or from Vault rather than directly from a command-line flag, and stores the
+// values in the config struct. Values from a file take precedence over Vault, which
+// takes precedence over the plain flag value.
+func loadSecrets(cfg *config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var vault secrets.Fetcher
+
+	if cfg.secrets.vaultAddr != "" {
+		token := os.Getenv("VAULT_TOKEN")
+
+		if cfg.secrets.vaultTokenFile != "" {
+			t, err := secrets.FileFetcher{}.Fetch(ctx, cfg.secrets.vaultTokenFile)
+			if err != nil {
+				return err
+			}
+			token = t
+		}
+
+		vault = secrets.NewVaultFetcher(cfg.secrets.vaultAddr, token)
+	}
+
+	sources := []struct {
+		dst   *string
+		file  string
+		vault string
+	}{
+		{&cfg.db.dsn, cfg.secrets.dbDSNFile, cfg.secrets.dbDSNVault},
+		{&cfg.smtp.password, cfg.secrets.smtpPasswordFile, cfg.secrets.smtpPasswordVault},
+		{&cfg.tmdb.apiKey, cfg.secrets.tmdbAPIKeyFile, cfg.secrets.tmdbAPIKeyVault},
+		{&cfg.omdb.apiKey, cfg.secrets.omdbAPIKeyFile, cfg.secrets.omdbAPIKeyVault},
+		{&cfg.tokens.keys, cfg.secrets.tokenKeysFile, cfg.secrets.tokenKeysVault},
+		{&cfg.pii.key, cfg.secrets.piiKeyFile, cfg.secrets.piiKeyVault},
+		{&cfg.backup.secretKey, cfg.secrets.backupKeyFile, cfg.secrets.backupKeyVault},
+		{&cfg.posters.s3SecretKey, cfg.secrets.posterKeyFile, cfg.secrets.posterKeyVault},
+	}
+
+	for _, src := range sources {
+		switch {
+		case src.file != "":
+			val, err := secrets.FileFetcher{}.Fetch(ctx, src.file)
+			if err != nil {
+				return err
+			}
+			*src.dst = val
+
+		case src.vault != "":
+			if vault == nil {
+				return errors.New("a vault secret reference was provided but -vault-addr is not set")
+			}
+
+			val, err := vault.Fetch(ctx, src.vault)
+			if err != nil {
+				return err
+			}
+			*src.dst = val
+		}
+	}
+
+	return nil
+}
+
+// dsnConnector is a driver.Connector which opens every new PostgreSQL connection with
+// the most recently loaded DSN. Swapping the DSN means that rotated database
+// credentials are picked up by the connection pool without restarting the
+// application, while already established connections carry on working.
+type dsnConnector struct {
+	mu  sync.RWMutex
+	dsn string
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := pq.NewConnector(c.get())
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Connect(ctx)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func (c *dsnConnector) get() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.dsn
+}
+
+func (c *dsnConnector) set(dsn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dsn = dsn
+}
+
+// The reloadSecretsOnSignal() method listens for SIGHUP and re-reads the secrets from
+// their files or Vault when it arrives, so that credentials can be rotated without a
+// restart. A failed reload is logged and the previous values are kept.
+func (app *application) reloadSecretsOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		// Work on a copy of the config so that a partially failed reload doesn't leave
+		// us with a mix of old and new values.
+		cfg := app.config
+
+		err := loadSecrets(&cfg)
+		if err != nil {
+			app.logger.Error("unable to reload secrets", "error", err.Error())
+			continue
+		}
+
+		tokenKeys, err := data.ParseTokenKeys(cfg.tokens.keys)
+		if err != nil {
+			app.logger.Error("unable to reload secrets", "error", err.Error())
+			continue
+		}
+
+		app.dsn.set(cfg.db.dsn)
+		app.mailer.UpdatePassword(cfg.smtp.password)
+		app.tmdb.UpdateAPIKey(cfg.tmdb.apiKey)
+		app.omdb.UpdateAPIKey(cfg.omdb.apiKey)
+		app.tokenKeys.Set(tokenKeys)
+
+		app.logger.Info("secrets reloaded")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/push"
+	"greenlight/anaplo/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The securityEvent() helper records a security event for a user (or for no user, if
+// userID is zero), and queues it to be forwarded to the SIEM webhook if one is
+// configured. Like audit(), failures are logged rather than failing the request.
+func (app *application) securityEvent(r *http.Request, userID int64, event, severity string, details any) {
+	// The user agent is only there to help the user recognize the device, so an overly
+	// long one is cut short.
+	userAgent := r.UserAgent()
+	if len(userAgent) > 500 {
+		userAgent = strings.ToValidUTF8(userAgent[:500], "")
+	}
+
+	entry := &data.SecurityEvent{
+		Event:     event,
+		Severity:  severity,
+		IP:        clientIP(r),
+		UserAgent: userAgent,
+	}
+
+	if userID != 0 {
+		entry.UserID = &userID
+	}
+
+	if details != nil {
+		js, err := json.Marshal(details)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		entry.Details = js
+	}
+
+	err := app.models.SecurityEvents.Insert(entry)
+	if err != nil {
+		app.logger.Error("unable to record security event", "event", event, "user_id", userID, "error", err.Error())
+		return
+	}
+
+	if app.config.siem.url != "" {
+		_, err := app.enqueueJob(jobForwardSecurityEvent, siemPayload{entry, entry.UserID})
+		if err != nil {
+			app.logger.Error("unable to queue security event for the SIEM", "event_id", entry.ID, "error", err.Error())
+		}
+	}
+
+	// Email the user about anything unusual on their account. Failed logins are left
+	// out, since they're usually typos and repeated failures end in a lockout anyway.
+	if userID != 0 && (severity == data.SeverityCritical || severity == data.SeverityWarning && event != data.SecurityLoginFailed) {
+		app.background("security alert", func(ctx context.Context) {
+			user, err := app.models.Users.Get(userID)
+			if err != nil {
+				app.logger.Error("unable to find user for security alert", "user_id", userID, "error", err.Error())
+				return
+			}
+
+			err = app.notifyUser(user, data.NotifySecurityAlerts, "security_alert.tmpl", map[string]any{
+				"name":    user.Name,
+				"event":   event,
+				"ip":      entry.IP,
+				"time":    entry.CreatedAt.UTC().Format("2 January 2006 15:04 MST"),
+				"details": string(entry.Details),
+			})
+			if err != nil {
+				app.logger.Error("unable to queue security alert", "user_id", userID, "event", event, "error", err.Error())
+			}
+
+			err = app.notifyInApp(userID, data.NotifySecurityAlerts, &data.Notification{
+				Kind:  data.NotificationSecurityAlert,
+				Title: "Security alert: " + strings.ReplaceAll(event, "_", " "),
+				Body:  "If this wasn't you, change your password and review your account's security events.",
+			}, map[string]any{"event_id": entry.ID, "ip": entry.IP})
+			if err != nil {
+				app.logger.Error("unable to create security alert notification", "user_id", userID, "event", event, "error", err.Error())
+			}
+
+			err = app.notifyPush(userID, data.NotifySecurityAlerts, push.Message{
+				Title: "Security alert: " + strings.ReplaceAll(event, "_", " "),
+				Body:  "If this wasn't you, change your password and review your account's security events.",
+				Data:  map[string]string{"event_id": strconv.FormatInt(entry.ID, 10)},
+			})
+			if err != nil {
+				app.logger.Error("unable to queue security alert push notification", "user_id", userID, "event", event, "error", err.Error())
+			}
+		})
+	}
+}
+
+// siemPayload is the payload for jobForwardSecurityEvent jobs, and the body which is
+// posted to the SIEM webhook. Unlike in the API, the user ID is included.
+type siemPayload struct {
+	*data.SecurityEvent
+	UserID *int64 `json:"user_id"`
+}
+
+// The forwardSecurityEventJob() posts a security event to the SIEM webhook, retrying
+// with backoff (like any other job) if it can't be delivered.
+func (app *application) forwardSecurityEventJob(ctx context.Context, job *data.Job) error {
+	if app.config.siem.url == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.config.siem.url, bytes.NewReader(job.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight/"+version)
+
+	res, err := app.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("SIEM webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// The listSecurityEventsHandler() shows the current user the security events for their
+// account, most recent first.
+func (app *application) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listSecurityEvents(w, r, "security_events")
+}
+
+// The listLoginsHandler() shows the current user the recent attempts to sign in to
+// their account, successful or not, with where they came from, so that they can spot
+// access they don't recognize.
+func (app *application) listLoginsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listSecurityEvents(w, r, "logins", data.LoginEvents...)
+}
+
+// The listSecurityEvents() helper responds with a page of the current user's security
+// events under key, limited to the given kinds if there are any.
+func (app *application) listSecurityEvents(w http.ResponseWriter, r *http.Request, key string, kinds ...string) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-created_at",
+		SortSafelist: data.SecurityEventSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.models.SecurityEvents.GetAllForUser(app.contextGetUser(r).ID, filters, kinds...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, key: list, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) createSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title     string   `json:"title"`
+		StartYear int32    `json:"start_year"`
+		EndYear   *int32   `json:"end_year"`
+		Genres    []string `json:"genres"`
+		Synopsis  string   `json:"synopsis"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	series := &data.Series{
+		Title:     input.Title,
+		StartYear: input.StartYear,
+		EndYear:   input.EndYear,
+		Genres:    input.Genres,
+		Synopsis:  input.Synopsis,
+	}
+
+	v := validator.New()
+
+	err = app.checkGenres(v, series.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.series(r).Insert(series)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "create", "series", series.ID, nil)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/series/%d", series.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"series": app.seriesResource(series)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showSeriesHandler() shows a series along with all of its seasons and episodes.
+func (app *application) showSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.seriesForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+
+	series.Seasons, err = app.models.Series.GetSeasons(series.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": app.seriesResource(series)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.SeriesSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.series(r).GetAll(title, genres, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resources := make([]seriesResource, len(list))
+	for i, series := range list {
+		resources[i] = app.seriesResource(series)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "series": resources, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.seriesForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// A null end_year can't be told apart from a missing one, so a finished series
+	// which has been renewed is marked as running again with "running": true.
+	var input struct {
+		Title     *string  `json:"title"`
+		StartYear *int32   `json:"start_year"`
+		EndYear   *int32   `json:"end_year"`
+		Running   *bool    `json:"running"`
+		Genres    []string `json:"genres"`
+		Synopsis  *string  `json:"synopsis"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		series.Title = *input.Title
+	}
+
+	if input.StartYear != nil {
+		series.StartYear = *input.StartYear
+	}
+
+	if input.EndYear != nil {
+		series.EndYear = input.EndYear
+	}
+
+	if input.Genres != nil {
+		series.Genres = input.Genres
+	}
+
+	if input.Synopsis != nil {
+		series.Synopsis = *input.Synopsis
+	}
+
+	v := validator.New()
+
+	if input.Running != nil && *input.Running {
+		v.Check(input.EndYear == nil, "end_year", "must not be given for a running series")
+		series.EndYear = nil
+	}
+
+	if input.Genres != nil {
+		err = app.checkGenres(v, series.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if data.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.series(r).Update(series)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "series", series.ID, input)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": app.seriesResource(series)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.series(r).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "series", id, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "series successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSeasonsHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.seriesForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	seasons, err := app.models.Series.GetSeasons(series.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"seasons": seasons}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The replaceSeasonHandler() adds a season to a series, or replaces the season with
+// the same number. Like replaceTranslationsHandler(), the episodes in the request
+// replace the season's current episodes, so an empty list removes them all.
+func (app *application) replaceSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.seriesForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	number, ok := app.readSeasonNumber(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title    string          `json:"title"`
+		Year     int32           `json:"year"`
+		Episodes []*data.Episode `json:"episodes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	season := &data.Season{Number: number, Title: input.Title, Year: input.Year, Episodes: input.Episodes}
+
+	v := validator.New()
+
+	if data.ValidateSeason(v, season); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Series.ReplaceSeason(series.ID, season)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "update_season", "series", series.ID, season)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"season": season}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.seriesForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	number, ok := app.readSeasonNumber(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Series.DeleteSeason(series.ID, number)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete_season", "series", series.ID, map[string]int32{"number": number})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "season successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The seriesForRequest() helper looks up the series in the :id parameter, in the
+// organization the request is made on behalf of, like movieForRequest(). If there's
+// no such series it sends a 404 Not Found response and returns false.
+func (app *application) seriesForRequest(w http.ResponseWriter, r *http.Request) (*data.Series, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	series, err := app.series(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return series, true
+}
+
+// The readSeasonNumber() method reads the :number parameter of the URL. Unlike IDs it
+// can be 0, which is the season for specials.
+func (app *application) readSeasonNumber(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	number, err := strconv.ParseInt(params.ByName("number"), 10, 32)
+	if err != nil || number < 0 {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	return int32(number), true
+}
@@ -5,28 +5,83 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 )
 
 func (app *application) serve() error {
 	// Declare a HTTP server which listens on the port provided in the config struct,
-	// uses the servemux we created above as the handler, has some sensible timeout
-	// settings and writes any log messages to the structured logger at Error level.
+	// uses the servemux we created above as the handler, has the timeouts and limits
+	// from the config and writes any log messages to the structured logger at Error
+	// level.
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		MaxHeaderBytes:    app.config.server.maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	// Listen before starting any background work, so that a port which is already in
+	// use is reported straight away.
+	listener, err := app.listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	// The event streams would keep Shutdown() waiting until the grace period runs out,
+	// so we disconnect them as soon as it starts.
+	srv.RegisterOnShutdown(app.events.close)
+
 	shutdownError := make(chan error)
 
+	// Start the runners for the persistent job queue. Cancelling stopCtx tells them to
+	// stop claiming new jobs, while cancelling workCtx tells the jobs which are already
+	// running to give up.
+	stopCtx, stopJobs := context.WithCancel(context.Background())
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	jobRunners := app.startJobRunners(stopCtx, workCtx)
+
+	// Start the scheduler for periodic jobs, which uses the same contexts.
+	scheduler, err := app.startScheduler(stopCtx, workCtx)
+	if err != nil {
+		stopJobs()
+		cancelWork()
+		listener.Close()
+		return err
+	}
+
+	// Relay the database's movie change notifications to the event streams.
+	go app.listenForMovieEvents(stopCtx)
+
+	// Pick up rate limit exemptions created through the admin API on other instances.
+	go app.refreshRateLimitExemptions(stopCtx)
+
+	// Write the per-endpoint usage, movie views and when users were last seen to the
+	// database periodically. Their last flushes are waited for along with the job
+	// runners.
+	usageFlusher := &sync.WaitGroup{}
+	usageFlusher.Add(3)
+	go func() {
+		defer usageFlusher.Done()
+		app.flushEndpointUsage(stopCtx)
+	}()
+	go func() {
+		defer usageFlusher.Done()
+		app.flushMovieViews(stopCtx)
+	}()
+	go func() {
+		defer usageFlusher.Done()
+		app.flushLastSeen(stopCtx)
+	}()
+
 	// start a background go routine to listen for an
 	// interruption signals
 	go func() {
@@ -34,42 +89,75 @@ func (app *application) serve() error {
 		quit := make(chan os.Signal, 1)
 
 		// Use signal.Notify() to listen for incoming SIGINT and SIGTERM signals and
-		// relay them to the quit channel. Any other signals will not be caught by
-		// signal.Notify() and will retain their default behavior.
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		// relay them to the quit channel, along with SIGUSR2 which asks for an upgrade.
+		// Any other signals will not be caught by signal.Notify() and will retain their
+		// default behavior.
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
 
 		// Read the signal from the quit channel. This code will block until a signal is
-		// received.
-		s := <-quit
+		// received. On SIGUSR2 we hand the listener to a new process started from the
+		// executable on disk, and then shut down as usual once it's serving. If it
+		// can't be started we carry on serving instead.
+		var s os.Signal
+		for {
+			s = <-quit
+			if s != syscall.SIGUSR2 {
+				break
+			}
+
+			err := app.upgrade(listener)
+			if err == nil {
+				break
+			}
+
+			app.logger.Error("upgrade failed, continuing to serve", "error", err.Error())
+		}
 
 		// Log a message to say that the signal has been caught. Notice that we also
 		// call the String() method on the signal to get the signal name and include it
 		// in the log entry attributes.
 		app.logger.Info("shutting down server", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Everything below --- draining HTTP requests, job runs and background tasks ---
+		// shares a single grace period.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownGrace)
 		defer cancel()
 
 		// Call Shutdown() on our server, passing in the context we just made.
 		// Shutdown() will return nil if the graceful shutdown was successful, or an
 		// error (which may happen because of a problem closing the listeners, or
-		// because the shutdown didn't complete before the 30-second context deadline is
-		// hit). We relay this return value to the shutdownError channel if it's not nil
+		// because the shutdown didn't complete before the grace period deadline is
+		// hit). We relay this return value to the shutdownError channel once the
+		// background work below has been drained.
 		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
-		}
 
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
-		// Call Wait() to block until our WaitGroup counter is zero --- essentially
-		// blocking until the background goroutines have finished. Then we return nil on
-		// the shutdownError channel, to indicate that the shutdown completed without
-		// any issues.
-		app.wg.Wait()
-		shutdownError <- nil
+		// Stop the job runners and the scheduler, and wait for the jobs they're
+		// currently executing to finish. Anything still queued stays in the database
+		// for the next start. If the grace period runs out, cancel the running jobs;
+		// they'll be picked up again once their lease expires.
+		stopJobs()
+		if !waitWithContext(ctx, jobRunners, scheduler, usageFlusher) {
+			app.logger.Error("job runs did not complete before the shutdown deadline")
+			cancelWork()
+		}
+
+		// Call Shutdown() on the worker pool to block until every queued and in-flight
+		// background task has finished. Any tasks that couldn't finish within the grace
+		// period are cancelled, and we log them so that they don't vanish silently.
+		abandoned := app.workers.Shutdown(ctx)
+		for _, name := range abandoned {
+			app.logger.Error("background task did not complete before shutdown", "task", name)
+		}
+
+		cancelWork()
+
+		// Return the result of the server shutdown on the shutdownError channel. A nil
+		// value indicates that the shutdown completed without any issues.
+		shutdownError <- err
 
 		// Exit the application with a 0 (success) status code.
 		// os.Exit(0)
@@ -78,11 +166,13 @@ func (app *application) serve() error {
 	// Start the HTTP server.
 	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
 
-	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	// Calling Shutdown() on our server will cause Serve() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. So we check
 	// specifically for this, only returning the error if it is NOT http.ErrServerClosed.
-	err := srv.ListenAndServe()
+	app.signalReady()
+
+	err = srv.Serve(listener)
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -102,3 +192,46 @@ func (app *application) serve() error {
 
 	return nil
 }
+
+// The listen() method opens the server's listener, limiting the number of connections
+// open at once if the config asks for it. If this process was started by an upgrade,
+// the previous process's listener is used instead of opening a new one.
+func (app *application) listen(addr string) (net.Listener, error) {
+	listener, err := app.inheritedListener()
+	if err != nil {
+		return nil, err
+	}
+
+	if listener == nil {
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if app.config.server.maxConns > 0 {
+		return newLimitListener(listener, app.config.server.maxConns), nil
+	}
+
+	return listener, nil
+}
+
+// waitWithContext() waits for all of the WaitGroups, returning false if the context is
+// done first.
+func waitWithContext(ctx context.Context, wgs ...*sync.WaitGroup) bool {
+	done := make(chan struct{})
+
+	go func() {
+		for _, wg := range wgs {
+			wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The listSharedWatchlistsHandler() lists the shared watchlists the current user is a
+// member of, including those they've been invited to, which are marked as pending.
+func (app *application) listSharedWatchlistsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.sharedWatchlists(r).GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createSharedWatchlistHandler() creates a shared watchlist with the current user
+// as its owner.
+func (app *application) createSharedWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.SharedWatchlist{Name: input.Name}
+
+	v := validator.New()
+
+	if data.ValidateSharedWatchlist(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.sharedWatchlists(r).Insert(list)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "shared_watchlist", list.ID, list)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/watchlists/%d", list.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"watchlist": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSharedWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	list, err := app.sharedWatchlists(r).Get(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSharedWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lists := app.sharedWatchlists(r)
+
+	list, err := lists.Get(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateSharedWatchlist(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = lists.Update(list)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "update", "shared_watchlist", list.ID, list)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSharedWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.sharedWatchlists(r).Delete(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "delete", "shared_watchlist", id, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "watchlist successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSharedWatchlistMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-added_at"),
+		SortSafelist: data.WatchlistSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.sharedWatchlists(r).GetMovies(id, filters)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": entries, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addSharedWatchlistMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entry := &data.WatchlistEntry{MovieID: input.MovieID}
+
+	err = app.sharedWatchlists(r).AddMovie(id, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMovieNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateWatchlistEntry):
+			v.AddError("movie_id", "is already on this watchlist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.sharedWatchlistErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeSharedWatchlistMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.sharedWatchlists(r).RemoveMovie(id, movieID)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSharedWatchlistMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	members, err := app.sharedWatchlists(r).GetMembers(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The inviteSharedWatchlistMemberHandler() invites a user to a shared watchlist by
+// their email address. They must be a member of the list's organization, and don't
+// see the list until they've joined it with joinSharedWatchlistHandler(). Only owners
+// can invite members.
+func (app *application) inviteSharedWatchlistMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = data.WatchlistViewer
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateWatchlistRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lists := app.sharedWatchlists(r)
+
+	list, err := lists.Get(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	invitee, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no user with this email address exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member := &data.SharedWatchlistMember{UserID: invitee.ID, Name: invitee.Name, Role: input.Role}
+
+	err = lists.Invite(list.ID, member)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotInOrganization):
+			v.AddError("email", "must belong to a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateMember):
+			v.AddError("email", "is already a member of this watchlist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.sharedWatchlistErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "invite_member", "shared_watchlist", list.ID, map[string]any{"user_id": invitee.ID, "role": input.Role})
+
+	// The invitation is an optional email, so it's left out if the invitee has turned
+	// optional emails off. They can still see it in the app and in their watchlists.
+	inviter := app.contextGetUser(r)
+
+	err = app.notifyUser(invitee, data.ChannelEmail, "watchlist_invite.tmpl", map[string]any{
+		"name":        invitee.Name,
+		"inviter":     inviter.Name,
+		"watchlist":   list.Name,
+		"watchlistID": list.ID,
+		"role":        input.Role,
+		"userID":      invitee.ID,
+	})
+	if err != nil {
+		app.logger.Error("unable to queue watchlist invitation", "watchlist_id", list.ID, "user_id", invitee.ID, "error", err.Error())
+	}
+
+	err = app.notifyInApp(invitee.ID, "", &data.Notification{
+		Kind:  data.NotificationWatchlistInvite,
+		Title: fmt.Sprintf("%s invited you to the %q watchlist", inviter.Name, list.Name),
+	}, map[string]any{"watchlist_id": list.ID, "role": input.Role})
+	if err != nil {
+		app.logger.Error("unable to create watchlist invitation notification", "watchlist_id", list.ID, "user_id", invitee.ID, "error", err.Error())
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The joinSharedWatchlistHandler() accepts the current user's invitation to a shared
+// watchlist.
+func (app *application) joinSharedWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lists := app.sharedWatchlists(r)
+
+	err = lists.Join(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	list, err := lists.Get(id)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateSharedWatchlistMemberHandler() changes a member's role. Only owners can do
+// this, and a list always keeps at least one owner.
+func (app *application) updateSharedWatchlistMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateWatchlistRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.sharedWatchlists(r).SetRole(id, userID, input.Role)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "update_member", "shared_watchlist", id, map[string]any{"user_id": userID, "role": input.Role})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": map[string]any{"user_id": userID, "role": input.Role}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeSharedWatchlistMemberHandler() removes a member from a shared watchlist or
+// withdraws their invitation, which only owners can do. Any member can remove
+// themselves to leave the list, or to turn down an invitation.
+func (app *application) removeSharedWatchlistMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.sharedWatchlists(r).RemoveMember(id, userID)
+	if err != nil {
+		app.sharedWatchlistErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "remove_member", "shared_watchlist", id, map[string]int64{"user_id": userID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sharedWatchlistErrorResponse() helper sends the response for an error from the
+// shared watchlist model, which reports lists the user can't see as not found and
+// actions their role doesn't allow as ErrWatchlistRole.
+func (app *application) sharedWatchlistErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrWatchlistRole):
+		app.notPermittedResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrLastOwner):
+		app.errorResponse(w, r, http.StatusConflict, "a shared watchlist must have at least one owner")
+	case errors.Is(err, data.ErrNoOrganization):
+		app.noOrganizationResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
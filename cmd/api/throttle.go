@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Many users can share an IP address behind a NAT, so an IP address is allowed this
+// many times as many failures as a single email address before it's throttled.
+const ipThrottleFactor = 4
+
+// The authThrottleDelay() function returns how long a client must wait after its most
+// recent failure, given the number of failures in the window. The first few failures
+// are free; after that the delay doubles with each failure up to the maximum, and once
+// the block threshold is reached the client is locked out for the whole window.
+func (app *application) authThrottleDelay(failures int) time.Duration {
+	cfg := app.config.authThrottle
+
+	switch {
+	case failures >= cfg.blockAfter:
+		return cfg.window
+	case failures < cfg.freeAttempts:
+		return 0
+	}
+
+	shift := failures - cfg.freeAttempts
+	if shift > 30 {
+		return cfg.maxDelay
+	}
+
+	return min(time.Second<<shift, cfg.maxDelay)
+}
+
+// The checkAuthThrottle() helper looks up the recent failed attempts for the email
+// address (which can be empty) and the client's IP address. If the client has to wait
+// before trying again it sends a 429 Too Many Requests response, with a Retry-After
+// header, and returns false.
+func (app *application) checkAuthThrottle(w http.ResponseWriter, r *http.Request, kind, email string) bool {
+	if !app.config.authThrottle.enabled {
+		return true
+	}
+
+	counts, err := app.models.AuthFailures.Count(kind, email, clientIP(r), time.Now().Add(-app.config.authThrottle.window))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	failures := max(counts.ByEmail, counts.ByIP/ipThrottleFactor)
+
+	wait := time.Until(counts.Last.Add(app.authThrottleDelay(failures)))
+	if wait <= 0 {
+		return true
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("too many failed attempts, please try again in %d seconds", seconds)
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	return false
+}
+
+// The recordAuthFailure() helper records a failed attempt. When the attempt takes the
+// email or IP address over the block threshold, a security event is logged and written
+// to the audit log so that operators can see accounts which are under attack.
+func (app *application) recordAuthFailure(r *http.Request, kind, email string) {
+	if !app.config.authThrottle.enabled {
+		return
+	}
+
+	ip := clientIP(r)
+
+	err := app.models.AuthFailures.Insert(kind, email, ip)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	counts, err := app.models.AuthFailures.Count(kind, email, ip, time.Now().Add(-app.config.authThrottle.window))
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	blockAfter := app.config.authThrottle.blockAfter
+
+	if counts.ByEmail == blockAfter || counts.ByIP == blockAfter*ipThrottleFactor {
+		app.logger.Warn("security event: authentication attempts blocked",
+			"kind", kind,
+			"email", email,
+			"ip", ip,
+			"failures_by_email", counts.ByEmail,
+			"failures_by_ip", counts.ByIP,
+		)
+
+		app.audit(r, "auth_blocked", kind, 0, map[string]any{
+			"email":             email,
+			"failures_by_email": counts.ByEmail,
+			"failures_by_ip":    counts.ByIP,
+		})
+
+		// Let the owner of the account know that it has been locked, if there is one.
+		var userID int64
+		if email != "" {
+			if user, err := app.models.Users.GetByEmail(email); err == nil {
+				userID = user.ID
+			}
+		}
+
+		app.securityEvent(r, userID, data.SecurityLockout, data.SeverityCritical, map[string]any{
+			"kind":              kind,
+			"failures_by_email": counts.ByEmail,
+			"failures_by_ip":    counts.ByIP,
+		})
+	}
+}
+
+// The clearAuthFailures() helper forgets the failures for an email address after a
+// successful attempt, so that its owner isn't slowed down by earlier typos.
+func (app *application) clearAuthFailures(r *http.Request, kind, email string) {
+	if !app.config.authThrottle.enabled {
+		return
+	}
+
+	err := app.models.AuthFailures.DeleteForEmail(kind, email)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The clientIP() helper returns the IP address of the client which made the request.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
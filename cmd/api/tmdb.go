@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/tmdb"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+// The importTMDBMovieHandler() creates a movie from its record on TMDB, so that
+// clients don't have to copy the details across by hand. A movie can only be imported
+// once; importing it again responds with 409 Conflict and the existing record.
+func (app *application) importTMDBMovieHandler(w http.ResponseWriter, r *http.Request) {
+	tmdbID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.tmdb.Configured() {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "importing from TMDB is not configured on this server")
+		return
+	}
+
+	existing, err := app.movies(r).GetByTMDBID(tmdbID)
+	switch {
+	case err == nil:
+		app.tmdbConflictResponse(w, r, existing)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	record, err := app.tmdb.GetMovie(r.Context(), tmdbID)
+	if err != nil {
+		switch {
+		case errors.Is(err, tmdb.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.logError(r, err)
+			app.errorResponse(w, r, http.StatusBadGateway, "unable to fetch the movie from TMDB, please try again later")
+		}
+		return
+	}
+
+	movie := &data.Movie{
+		Title:            record.Title,
+		Year:             record.Year,
+		Runtime:          data.Runtime(record.Runtime),
+		Genres:           record.Genres,
+		TMDBID:           &record.ID,
+		Synopsis:         record.Synopsis,
+		PosterURL:        record.PosterURL,
+		OriginalLanguage: record.OriginalLanguage,
+	}
+
+	if record.Budget > 0 {
+		movie.Budget = &data.Money{Amount: record.Budget * 100, Currency: "USD"}
+	}
+
+	if record.Revenue > 0 {
+		movie.BoxOffice = &data.Money{Amount: record.Revenue * 100, Currency: "USD"}
+	}
+
+	// TMDB names its genres, like Science Fiction, so map them onto the catalog's slugs
+	// and leave out any the catalog doesn't have.
+	movie.Genres, err = app.models.Genres.SlugsForNames(record.Genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// TMDB lists as many genres as it likes, but we only allow 5.
+	if len(movie.Genres) > 5 {
+		movie.Genres = movie.Genres[:5]
+	}
+
+	// The TMDB record might be incomplete (for example, with no runtime for an
+	// unreleased movie), in which case it fails validation like any other movie.
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movies(r).Insert(movie)
+	if err != nil {
+		switch {
+		// Another request imported the same movie since we checked.
+		case errors.Is(err, data.ErrDuplicateTMDBID):
+			existing, err := app.movies(r).GetByTMDBID(tmdbID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.tmdbConflictResponse(w, r, existing)
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "import", "movie", movie.ID, map[string]int64{"tmdb_id": tmdbID})
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieCreated, movie)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": app.movieResource(movie)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) tmdbConflictResponse(w http.ResponseWriter, r *http.Request, existing *data.Movie) {
+	env := envelope{
+		"error": "this movie has already been imported",
+		"movie": app.movieResource(existing),
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
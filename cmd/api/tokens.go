@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+// Activation tokens last long enough for new users to get round to checking their
+// email.
+const activationTTL = 3 * 24 * time.Hour
+
 func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email string `json:"email"`
@@ -43,28 +47,18 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// Email the user with their additional activation token. Since email addresses
+	// MAY be case sensitive, notice that the job sends this email to the address
+	// stored in our database for the user --- not to the input.Email address provided
+	// by the client in this request.
+	token := &data.Token{UserID: user.ID, Expiry: time.Now().Add(activationTTL), Scope: data.ScopeActivation}
+
+	err = app.enqueueTokenEmail(token, "token_activation.tmpl")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Email the user with their additional activation tocken
-	app.background(func() {
-		// create data map to include into template
-		data := map[string]any{
-			"activationToken": token.PlainText,
-		}
-
-		// Since email addresses MAY be case sensitive, notice that we are sending this
-		// email using the address stored in our database for the user --- not to the
-		// input.Email address provided by the client in this request.
-		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-		}
-	})
-
 	// Send a 202 Accepted response and confirmation message to the client.
 	env := envelope{"message": "an email will be sent to you containing activation instructions"}
 
@@ -78,6 +72,9 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// The organization to sign in to. If it's omitted the token is scoped to the
+		// user's first organization.
+		OrganizationID *int64 `json:"organization_id"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -91,11 +88,21 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	data.ValidatePasswordPlaintext(v, input.Password)
 	data.ValidateEmail(v, input.Email)
 
+	if input.OrganizationID != nil {
+		v.Check(*input.OrganizationID > 0, "organization_id", "must be a positive integer")
+	}
+
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Refuse the attempt without checking the password if there have been too many
+	// recent failures for this email address or from this IP address.
+	if !app.checkAuthThrottle(w, r, data.AuthFailureLogin, input.Email) {
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
@@ -103,6 +110,8 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAuthFailure(r, data.AuthFailureLogin, input.Email)
+			app.securityEvent(r, 0, data.SecurityLoginFailed, data.SeverityWarning, map[string]string{"reason": "unknown email"})
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -120,20 +129,60 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
 	if !matches {
+		app.recordAuthFailure(r, data.AuthFailureLogin, input.Email)
+		app.securityEvent(r, user.ID, data.SecurityLoginFailed, data.SeverityWarning, map[string]string{"reason": "incorrect password"})
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	app.clearAuthFailures(r, data.AuthFailureLogin, input.Email)
+
+	// A suspended user's token would be refused anyway, so say why now.
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthorization)
+	token, err := app.newAuthenticationToken(user.ID, input.OrganizationID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, errNotOrganizationMember):
+			v.AddError("organization_id", "you are not a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	app.audit(r, "login", "user", user.ID, nil)
+	app.securityEvent(r, user.ID, data.SecurityLoginSucceeded, data.SeverityInfo, nil)
+
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+var errNotOrganizationMember = errors.New("not a member of the organization")
+
+// The newAuthenticationToken() method generates a 24-hour authentication token for a
+// user who has signed in. If they asked to sign in to an organization, they must be a
+// member of it, otherwise errNotOrganizationMember is returned.
+func (app *application) newAuthenticationToken(userID int64, organizationID *int64) (*data.Token, error) {
+	if organizationID == nil {
+		return app.models.Tokens.New(userID, 24*time.Hour, data.ScopeAuthorization)
+	}
+
+	_, err := app.models.Organizations.GetRole(*organizationID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, errNotOrganizationMember
+		}
+		return nil, err
+	}
+
+	return app.models.Tokens.NewForOrganization(userID, *organizationID, 24*time.Hour)
+}
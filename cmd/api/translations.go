@@ -0,0 +1,141 @@
+package main
+
+import (
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (app *application) showTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	translations, err := app.models.Translations.GetForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The replaceTranslationsHandler() sets a movie's titles in other languages. Like
+// replaceWatchProvidersHandler(), the list in the request replaces the movie's current
+// translations, so an empty list removes them all.
+func (app *application) replaceTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Translations []data.Translation `json:"translations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Translations != nil, "translations", "must be provided")
+
+	if data.ValidateTranslations(v, input.Translations); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Translations.Replace(movie.ID, input.Translations)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "update_translations", "movie", movie.ID, input)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+
+	translations, err := app.models.Translations.GetForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The localizeTitles() method replaces the titles of the movies with their titles in
+// the language the client prefers most, going by the Accept-Language header, where
+// there's a translation. Only the most preferred language is used (or a less specific
+// form of it, like pt for pt-BR), since we don't know which language the original
+// titles are in, and a lower preference is likely to be for the original.
+func (app *application) localizeTitles(w http.ResponseWriter, r *http.Request, movies []*data.Movie) error {
+	w.Header().Add("Vary", "Accept-Language")
+
+	language := preferredLanguage(r.Header.Get("Accept-Language"))
+	if language == "" || len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	translations, err := app.models.Translations.GetForMovies(ids, data.LanguageFallbacks(language))
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		t, ok := translations[movie.ID]
+		if !ok {
+			continue
+		}
+
+		movie.OriginalTitle = movie.Title
+		movie.Title = t.Title
+		movie.TitleLanguage = t.Language
+	}
+
+	return nil
+}
+
+// preferredLanguage returns the normalized language tag with the highest weight in an
+// Accept-Language header, or an empty string if there isn't one. Wildcards and tags
+// which aren't valid are skipped.
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if q > bestQ && len(tag) <= 35 && data.LanguageRX.MatchString(tag) {
+			best, bestQ = data.NormalizeLanguage(tag), q
+		}
+	}
+
+	return best
+}
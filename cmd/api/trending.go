@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// trendingWindows are how far back GET /v1/movies/trending can count views.
+var trendingWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// The listTrendingMoviesHandler() lists the movies whose details were viewed the most
+// over the last day, week or month. Recent views can take up to -usage-flush-interval
+// to be counted.
+func (app *application) listTrendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	window := app.readString(qs, "window", "week")
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-views",
+		SortSafelist: []string{"-views"},
+		URL:          app.requestURL(r),
+	}
+
+	v.Check(validator.PermittedValues(window, "day", "week", "month"), "window", "must be day, week or month")
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	list, metadata, err := app.movies(r).GetTrending(time.Now().Add(-trendingWindows[window]), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.localizeTitles(w, r, list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resources := make([]movieResource, len(list))
+	for i, movie := range list {
+		resources[i] = app.movieResource(movie)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "window": window, "movies": resources, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// movieViewKey identifies the views which are added up into one row of the
+// movie_views table.
+type movieViewKey struct {
+	movieID int64
+	hour    time.Time
+}
+
+// A movieViewBuffer counts movie views in memory until they're flushed to the
+// database, in the same way as an endpointUsageBuffer.
+type movieViewBuffer struct {
+	mu     sync.Mutex
+	counts map[movieViewKey]int64
+}
+
+func newMovieViewBuffer() *movieViewBuffer {
+	return &movieViewBuffer{counts: map[movieViewKey]int64{}}
+}
+
+// add counts n views of a movie in the hour of the given time.
+func (b *movieViewBuffer) add(movieID int64, t time.Time, n int64) {
+	key := movieViewKey{movieID: movieID, hour: t.UTC().Truncate(time.Hour)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.counts[key] += n
+}
+
+// take empties the buffer, returning what was in it.
+func (b *movieViewBuffer) take() []data.MovieViews {
+	b.mu.Lock()
+	counts := b.counts
+	b.counts = map[movieViewKey]int64{}
+	b.mu.Unlock()
+
+	views := make([]data.MovieViews, 0, len(counts))
+	for key, n := range counts {
+		views = append(views, data.MovieViews{MovieID: key.movieID, Hour: key.hour, Views: n})
+	}
+
+	return views
+}
+
+// The flushMovieViews() method writes the buffered movie views to the database, on the
+// same schedule as flushEndpointUsage(). If a flush fails the views are put back, to
+// be tried again next time.
+func (app *application) flushMovieViews(ctx context.Context) {
+	ticker := time.NewTicker(app.config.usageFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		views := app.views.take()
+		if len(views) == 0 {
+			return
+		}
+
+		err := app.models.MovieViews.Record(views)
+		if err != nil {
+			app.logger.Error("unable to record movie views", "rows", len(views), "error", err.Error())
+
+			for _, v := range views {
+				app.views.add(v.MovieID, v.Hour, v.Views)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (app *application) pruneMovieViews(ctx context.Context) error {
+	n, err := app.models.MovieViews.DeleteBefore(time.Now().Add(-data.MovieViewsTTL))
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("pruned movie views", "count", n)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When the server is upgraded in place, the new process is started with the old
+// one's listening socket and a pipe to report back on, at the file descriptor numbers
+// in these environment variables.
+const (
+	listenerFDEnv = "GREENLIGHT_LISTENER_FD"
+	readyFDEnv    = "GREENLIGHT_READY_FD"
+)
+
+// The inheritedListener() method returns the listening socket passed down by the
+// process which started this one for an upgrade, or nil if there isn't one.
+func (app *application) inheritedListener() (net.Listener, error) {
+	raw := os.Getenv(listenerFDEnv)
+	if raw == "" {
+		return nil, nil
+	}
+
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", listenerFDEnv, err)
+	}
+
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+
+	app.logger.Info("inherited listener from previous process", "addr", listener.Addr().String())
+
+	return listener, nil
+}
+
+// The signalReady() method tells the process which started this one for an upgrade
+// that we're serving requests, so it can stop. It does nothing if the process wasn't
+// started for an upgrade.
+func (app *application) signalReady() {
+	raw := os.Getenv(readyFDEnv)
+	if raw == "" {
+		return
+	}
+
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		app.logger.Error("invalid ready file descriptor", readyFDEnv, raw)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+
+	_, err = f.Write([]byte{1})
+	if err != nil {
+		app.logger.Error("unable to signal readiness to previous process", "error", err.Error())
+	}
+}
+
+// The upgrade() method starts a new copy of the server from the executable on disk,
+// with the same arguments, and hands it our listening socket. Both processes accept
+// connections from the socket until we shut down, so none are refused in between. It
+// waits until the new process says it's serving requests, and returns an error (having
+// stopped the new process) if it doesn't within the shutdown grace period, in which
+// case this process should keep running.
+func (app *application) upgrade(listener net.Listener) error {
+	if ll, ok := listener.(*limitListener); ok {
+		listener = ll.Listener
+	}
+
+	filer, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return errors.New("listener can't be handed to another process")
+	}
+
+	listenerFile, err := filer.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	// Files in ExtraFiles start at file descriptor 3 in the new process.
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenerFDEnv+"=") && !strings.HasPrefix(kv, readyFDEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, listenerFDEnv+"=3", readyFDEnv+"=4")
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW}
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("started new process for upgrade", "pid", cmd.Process.Pid)
+
+	// The read returns as soon as the new process signals, or with io.EOF if it exits
+	// without doing so.
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if errors.Is(err, io.EOF) {
+			err = errors.New("new process exited before it was ready")
+		}
+	case <-time.After(app.config.shutdownGrace):
+		err = errors.New("new process wasn't ready before the shutdown grace period ran out")
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	return cmd.Process.Release()
+}
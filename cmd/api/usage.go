@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The meterUsage() middleware counts each authenticated request against the user, or
+// against the application the user authorized if it was made with a third-party
+// token, and enforces the daily and monthly quotas. The quota headers tell clients
+// how much they have left.
+func (app *application) meterUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var clientID int64
+		if grant := app.contextGetGrant(r); grant != nil {
+			clientID = grant.ClientID
+		}
+
+		now := time.Now().UTC()
+
+		// If the count can't be updated we let the request through rather than
+		// failing every authenticated request while the database is struggling.
+		counts, err := app.models.Usage.Record(user.ID, clientID, now)
+		if err != nil {
+			app.logError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+		if limit := app.config.quota.daily; limit > 0 {
+			w.Header().Set("X-Quota-Daily-Limit", strconv.FormatInt(limit, 10))
+			w.Header().Set("X-Quota-Daily-Remaining", strconv.FormatInt(max(limit-counts.Day, 0), 10))
+			w.Header().Set("X-Quota-Daily-Reset", strconv.FormatInt(nextDay.Unix(), 10))
+
+			if counts.Day > limit {
+				app.quotaExceededResponse(w, r, "daily", nextDay)
+				return
+			}
+		}
+
+		if limit := app.config.quota.monthly; limit > 0 {
+			w.Header().Set("X-Quota-Monthly-Limit", strconv.FormatInt(limit, 10))
+			w.Header().Set("X-Quota-Monthly-Remaining", strconv.FormatInt(max(limit-counts.Month, 0), 10))
+			w.Header().Set("X-Quota-Monthly-Reset", strconv.FormatInt(nextMonth.Unix(), 10))
+
+			if counts.Month > limit {
+				app.quotaExceededResponse(w, r, "monthly", nextMonth)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, period string, reset time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+
+	message := fmt.Sprintf("%s request quota exceeded, it resets at %s", period, reset.Format(time.RFC3339))
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The showUsageHandler() reports the current user's request counts for each of the
+// last few days, broken down by application, along with the quotas and how much of
+// them has been used today and this month.
+func (app *application) showUsageHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	days := app.readInt(r.URL.Query(), "days", 30, v)
+	v.Check(days >= 1 && days <= 366, "days", "must be between 1 and 366")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, 0, 1-days)
+
+	// We need the whole of this month for the totals, even if fewer days were asked
+	// for.
+	from := since
+	if monthStart.Before(from) {
+		from = monthStart
+	}
+
+	rows, err := app.models.Usage.GetForUser(app.contextGetUser(r).ID, from)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type total struct {
+		ClientID   int64  `json:"client_id,omitempty"`
+		ClientName string `json:"client,omitempty"`
+		Today      int64  `json:"today"`
+		Month      int64  `json:"month"`
+	}
+
+	totals := []*total{}
+	byClient := map[int64]*total{}
+	usage := []*data.UsageDay{}
+
+	for _, row := range rows {
+		if !row.Day.Before(since) {
+			usage = append(usage, row)
+		}
+
+		if row.Day.Before(monthStart) {
+			continue
+		}
+
+		t := byClient[row.ClientID]
+		if t == nil {
+			t = &total{ClientID: row.ClientID, ClientName: row.ClientName}
+			byClient[row.ClientID] = t
+			totals = append(totals, t)
+		}
+
+		t.Month += row.Requests
+		if row.Day.Equal(today) {
+			t.Today += row.Requests
+		}
+	}
+
+	// A quota of zero means there's no limit, which we report as null.
+	var quota struct {
+		Daily   *int64 `json:"daily"`
+		Monthly *int64 `json:"monthly"`
+	}
+
+	if app.config.quota.daily > 0 {
+		quota.Daily = &app.config.quota.daily
+	}
+
+	if app.config.quota.monthly > 0 {
+		quota.Monthly = &app.config.quota.monthly
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"quota": quota, "totals": totals, "usage": usage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// endpointUsageKey identifies the requests which are added up into one row of the
+// api_usage_endpoints table.
+type endpointUsageKey struct {
+	userID   int64
+	clientID int64
+	day      time.Time
+	method   string
+	route    string
+}
+
+// An endpointUsageBuffer adds up per-endpoint usage in memory until it's flushed to
+// the database, so that requests don't wait on an extra write each.
+type endpointUsageBuffer struct {
+	mu     sync.Mutex
+	counts map[endpointUsageKey]*data.EndpointUsage
+}
+
+func newEndpointUsageBuffer() *endpointUsageBuffer {
+	return &endpointUsageBuffer{counts: map[endpointUsageKey]*data.EndpointUsage{}}
+}
+
+func (b *endpointUsageBuffer) add(u data.EndpointUsage) {
+	key := endpointUsageKey{userID: u.UserID, clientID: u.ClientID, day: u.Day, method: u.Method, route: u.Route}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.counts[key]
+	if c == nil {
+		c = &data.EndpointUsage{UserID: u.UserID, ClientID: u.ClientID, Day: u.Day, Method: u.Method, Route: u.Route}
+		b.counts[key] = c
+	}
+
+	c.Requests += u.Requests
+	c.Errors += u.Errors
+	c.TotalLatency += u.TotalLatency
+	c.MaxLatency = max(c.MaxLatency, u.MaxLatency)
+}
+
+// take empties the buffer, returning what was in it.
+func (b *endpointUsageBuffer) take() []data.EndpointUsage {
+	b.mu.Lock()
+	counts := b.counts
+	b.counts = map[endpointUsageKey]*data.EndpointUsage{}
+	b.mu.Unlock()
+
+	usage := make([]data.EndpointUsage, 0, len(counts))
+	for _, c := range counts {
+		usage = append(usage, *c)
+	}
+
+	return usage
+}
+
+// The recordEndpointUsage() middleware adds each authenticated request to the
+// per-endpoint usage, attributed like meterUsage() does. It's applied to each route
+// separately, so that requests are counted against the route pattern rather than
+// the path.
+func (app *application) recordEndpointUsage(method, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mw := newMetricsRwesponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			return
+		}
+
+		var clientID int64
+		if grant := app.contextGetGrant(r); grant != nil {
+			clientID = grant.ClientID
+		}
+
+		var failed int64
+		if mw.statusCode >= 400 {
+			failed = 1
+		}
+
+		latency := time.Since(start)
+		now := start.UTC()
+
+		app.usage.add(data.EndpointUsage{
+			UserID:       user.ID,
+			ClientID:     clientID,
+			Day:          time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+			Method:       method,
+			Route:        route,
+			Requests:     1,
+			Errors:       failed,
+			TotalLatency: latency,
+			MaxLatency:   latency,
+		})
+	}
+}
+
+// The flushEndpointUsage() method writes the buffered per-endpoint usage to the
+// database every -usage-flush-interval, until ctx is cancelled. It flushes once more
+// on the way out, which is after the HTTP server has drained during a shutdown. If a
+// flush fails the usage is put back, to be tried again next time.
+func (app *application) flushEndpointUsage(ctx context.Context) {
+	ticker := time.NewTicker(app.config.usageFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		usage := app.usage.take()
+		if len(usage) == 0 {
+			return
+		}
+
+		err := app.models.Usage.RecordEndpoints(usage)
+		if err != nil {
+			app.logger.Error("unable to record endpoint usage", "rows", len(usage), "error", err.Error())
+
+			for _, u := range usage {
+				app.usage.add(u)
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// The showUsageDetailHandler() reports the current user's requests to each endpoint
+// over the last few days, broken down by application, with their error counts and
+// latencies. Recent requests can take up to -usage-flush-interval to show up.
+func (app *application) showUsageDetailHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	days := app.readIntRange(r.URL.Query(), "days", 30, 1, 366, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	endpoints, err := app.models.Usage.GetEndpointsForUser(app.contextGetUser(r).ID, usageSince(days))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"days": days, "endpoints": endpoints}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The adminUsageHandler() lists the users who made the most requests over the last
+// few days, so that heavy consumers can be identified and billed or limited.
+func (app *application) adminUsageHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	days := app.readIntRange(qs, "days", 30, 1, 366, v)
+	limit := app.readIntRange(qs, "limit", 20, 1, 100, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, err := app.models.Usage.TopUsers(usageSince(days), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"days": days, "users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// usageSince returns the start of the period covering today and the days-1 days
+// before it, in UTC.
+func usageSince(days int) time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1-days, 0, 0, 0, 0, time.UTC)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"greenlight/anaplo/internal/data"
 	"greenlight/anaplo/internal/validator"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -13,6 +17,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Needed if registration is invite-only.
+		InviteCode string `json:"invite_code"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -40,12 +46,39 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// An invite code is used up even if registration is open, so that it can't be
+	// passed on.
+	inviteRequired := app.config.registration.inviteOnly || input.InviteCode != ""
+
+	if inviteRequired {
+		data.ValidateInviteCode(v, input.InviteCode)
+	}
+
+	if app.checkBreachedPassword(r, v, "password", input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if inviteRequired {
+		// Invite codes aren't tied to an email address, so guessing them is throttled by
+		// IP address only.
+		if !app.checkAuthThrottle(w, r, data.AuthFailureInvite, "") {
+			return
+		}
+
+		err = app.models.Users.InsertWithInvite(user, input.InviteCode)
+	} else {
+		err = app.models.Users.Insert(user)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAuthFailure(r, data.AuthFailureInvite, "")
+			v.AddError("invite_code", "invalid or expired invite code")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -53,51 +86,580 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.models.Roles.AddForUser(user.ID, data.DefaultRole)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// After the user record has been created in the database, generate a new activation
-	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	app.securityEvent(r, user.ID, data.SecurityRoleAssigned, data.SeverityInfo, map[string]string{"role": data.DefaultRole})
+
+	// Every new user joins the default organization, so they have a catalog to browse
+	// until they create or are added to another one.
+	err = app.models.Organizations.AddMember(data.DefaultOrganizationID, &data.OrganizationMember{UserID: user.ID, Role: data.RoleMember})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	// Launch a background goroutine to send the welcome email.
-	app.background(func() {
-		// As there are now multiple pieces of data that we want to pass to our email
-		// templates, we create a map to act as a 'holding structure' for the data. This
-		// contains the plaintext version of the activation token for the user, along
-		// with their ID.
-		data := map[string]any{
-			"activationToken": token.PlainText,
-			"userID":          user.ID,
+	// Queue the welcome email, which contains the user's activation token. It's sent by
+	// the job runners, which retry it if the SMTP server is unavailable and don't lose
+	// it if the application restarts.
+	token := &data.Token{UserID: user.ID, Expiry: time.Now().Add(activationTTL), Scope: data.ScopeActivation}
+
+	err = app.enqueueTokenEmail(token, "user_welcome.tmpl")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Remind the user to activate their account until they do. Failing to do so isn't
+	// worth failing the registration over.
+	err = app.notifyInApp(user.ID, "", &data.Notification{
+		Kind:  data.NotificationActivationReminder,
+		Title: "Activate your account",
+		Body:  "Check your email for the activation token we sent you, or request a new one.",
+	}, nil)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlainTextToken string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.PlainTextToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Activation tokens aren't tied to an email address in the request, so guessing
+	// them is throttled by IP address only.
+	if !app.checkAuthThrottle(w, r, data.AuthFailureActivation, "") {
+		return
+	}
+
+	user, err := app.activateUser(r, input.PlainTextToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidActivationToken):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The activateUserLinkHandler() activates an account from the link in an activation
+// email, for users who can't send the token to the API themselves. If there's a web
+// frontend it redirects there with the outcome, otherwise it shows a simple page.
+func (app *application) activateUserLinkHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	v := validator.New()
+
+	err := errInvalidActivationToken
+	if data.ValidateTokenPlaintext(v, token); v.Valid() {
+		if !app.checkAuthThrottle(w, r, data.AuthFailureActivation, "") {
+			return
+		}
+
+		_, err = app.activateUser(r, token)
+	}
+
+	switch {
+	case err == nil:
+		app.activationPageResponse(w, r, http.StatusOK, "activated",
+			"Your account has been activated. You can now sign in.")
+	case errors.Is(err, errInvalidActivationToken):
+		app.activationPageResponse(w, r, http.StatusUnprocessableEntity, "invalid_token",
+			"This activation link is invalid or has expired. You can ask for a new one from the sign in page.")
+	case errors.Is(err, data.ErrEditConflict):
+		app.activationPageResponse(w, r, http.StatusConflict, "conflict",
+			"Your account couldn't be activated just now. Please follow the link again.")
+	default:
+		app.logError(r, err)
+		app.activationPageResponse(w, r, http.StatusInternalServerError, "error",
+			"Something went wrong while activating your account. Please try again later.")
+	}
+}
+
+var errInvalidActivationToken = errors.New("invalid or expired activation token")
+
+// The activateUser() method activates the account an activation token belongs to and
+// deletes the user's activation tokens. It returns errInvalidActivationToken if the
+// token doesn't exist or has expired.
+func (app *application) activateUser(r *http.Request, plaintext string) (*data.User, error) {
+	user, err := app.models.Users.GetForToken(data.ScopeActivation, plaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.recordAuthFailure(r, data.AuthFailureActivation, "")
+			return nil, errInvalidActivationToken
+		}
+		return nil, err
+	}
+
+	user.Activated = true
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Notifications.DeleteKindForUser(user.ID, data.NotificationActivationReminder)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.audit(r, "activate", "user", user.ID, nil)
+	app.securityEvent(r, user.ID, data.SecurityAccountActivated, data.SeverityInfo, nil)
+	// Webhooks from every organization receive this event, so it only identifies the
+	// user rather than giving away their name and email address.
+	app.emitEvent(data.EventUserActivated, map[string]int64{"id": user.ID})
+
+	return user, nil
+}
+
+var activationPage = template.Must(template.New("activation").Parse(`<!doctype html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width" />
+<meta charset="utf-8" />
+<title>Greenlight account activation</title>
+</head>
+<body>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+// The activationPageResponse() method tells someone who followed an activation link
+// how it went. With a web frontend configured it redirects to the frontend's
+// activation page with the outcome in the status parameter, otherwise it renders the
+// message as HTML.
+func (app *application) activationPageResponse(w http.ResponseWriter, r *http.Request, status int, outcome, message string) {
+	if app.config.frontendURL != "" {
+		u := url.URL{Path: "/activate", RawQuery: url.Values{"status": {outcome}}.Encode()}
+		http.Redirect(w, r, strings.TrimSuffix(app.config.frontendURL, "/")+u.String(), http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := activationPage.Execute(w, message)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// A profile is the current user's own view of their account, along with the
+// permissions they have and the organization they're signed in to, so that clients can
+// hide what the user can't do. Unlike elsewhere, the user's version is included, so
+// that it can be sent back with updates.
+type profile struct {
+	*data.User
+	Version        int              `json:"version"`
+	Permissions    data.Permissions `json:"permissions"`
+	OrganizationID int64            `json:"organization_id,omitempty"`
+}
+
+// The profile() method builds the current user's profile.
+func (app *application) profile(r *http.Request) (*profile, error) {
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	user := app.contextGetUser(r)
+
+	return &profile{
+		User:           user,
+		Version:        user.Version,
+		Permissions:    permissions,
+		OrganizationID: app.contextGetOrganization(r),
+	}, nil
+}
+
+// The showMeHandler() returns the current user's profile. Unactivated users can see it
+// too, so that clients can tell them to activate their account.
+func (app *application) showMeHandler(w http.ResponseWriter, r *http.Request) {
+	me, err := app.profile(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": me}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateMeHandler() changes the current user's profile. Like updateMovieHandler(),
+// only the fields in the request are changed. If the version from GET /v1/me is sent
+// too, the update fails with an edit conflict if the account has changed since then,
+// and it always does if it changes while the request is being handled. The email
+// address and password have their own endpoints, since they need the password.
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    *string `json:"name"`
+		Version *int    `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.Version != nil && *input.Version != user.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "user", user.ID, input)
+
+	me, err := app.profile(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": me}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteMeHandler() deletes the current user's account. Like changing the password
+// it needs the current password, with wrong guesses counting towards the login
+// throttle. The account is signed out everywhere straight away, its reviews and
+// ratings are anonymized, and the rest of it is purged once the grace period is over.
+func (app *application) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.checkAuthThrottle(w, r, data.AuthFailureLogin, user.Email) {
+		return
+	}
+
+	matches, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !matches {
+		app.recordAuthFailure(r, data.AuthFailureLogin, user.Email)
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.clearAuthFailures(r, data.AuthFailureLogin, user.Email)
+
+	err = app.models.Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "user", user.ID, nil)
+	app.securityEvent(r, user.ID, data.SecurityAccountDeleted, data.SeverityInfo, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updatePasswordHandler() changes the current user's password. They have to give
+// their current password as well, so that someone with a stolen token can't lock them
+// out, and wrong guesses count towards the login throttle. Afterwards every session,
+// including this one, is signed out, so the user signs in again with the new password.
+func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.Password != input.CurrentPassword, "password", "must be different from the current password")
 
-		// Call the Send() method on our Mailer, passing in the user's email address,
-		// name of the template file, and the User struct containing the new user's data.
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.checkAuthThrottle(w, r, data.AuthFailureLogin, user.Email) {
+		return
+	}
+
+	matches, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !matches {
+		app.recordAuthFailure(r, data.AuthFailureLogin, user.Email)
+		app.securityEvent(r, user.ID, data.SecurityPasswordChangeFailed, data.SeverityWarning, map[string]string{"reason": "incorrect password"})
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.clearAuthFailures(r, data.AuthFailureLogin, user.Email)
+
+	if app.checkBreachedPassword(r, v, "password", input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sign in links are revoked too, since they'd sign the user in without the new
+	// password. Tokens issued to third-party applications are left alone, since the
+	// user granted those applications access separately.
+	for _, scope := range []string{data.ScopeAuthorization, data.ScopeMagicLink} {
+		err = app.models.Tokens.DeleteAllForUser(user.ID, scope)
 		if err != nil {
-			// Importantly, if there is an error sending the email then we use the
-			// app.logger.Error() helper to manage it, instead of the
-			// app.serverErrorResponse() helper like before.
-			app.logger.Error(err.Error())
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	app.audit(r, "change_password", "user", user.ID, nil)
+	app.securityEvent(r, user.ID, data.SecurityPasswordChanged, data.SeverityInfo, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "password successfully changed, please sign in again"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+// The checkBreachedPassword() method adds a validation error if a new password has
+// appeared in a data breach, when -pwned-passwords-enabled is set. If the Pwned
+// Passwords API can't be reached the password is accepted, so that an outage there
+// doesn't stop anyone registering or changing their password.
+func (app *application) checkBreachedPassword(r *http.Request, v *validator.Validator, key, password string) {
+	if !app.config.pwnedPasswords.enabled {
+		return
+	}
+
+	count, err := app.pwned.Count(r.Context(), password)
+	if err != nil {
+		app.logger.Warn("unable to check password against data breaches", "error", err.Error())
+		return
+	}
+
+	v.Check(count == 0, key, "has appeared in a data breach, please choose a different one")
+}
+
+// Email change tokens last long enough for the user to get to the new inbox, but not as
+// long as activation tokens, since the account is already in use.
+const emailChangeTTL = 24 * time.Hour
+
+// The createEmailChangeHandler() starts changing the current user's email address. Like
+// changing the password, it needs the current password. Nothing changes until the
+// token sent to the new address is used with confirmEmailChangeHandler(), which shows
+// that the user can receive email there.
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from the current email address")
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkAuthThrottle(w, r, data.AuthFailureLogin, user.Email) {
+		return
+	}
+
+	matches, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !matches {
+		app.recordAuthFailure(r, data.AuthFailureLogin, user.Email)
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.clearAuthFailures(r, data.AuthFailureLogin, user.Email)
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only the most recent request can be confirmed. Deleting the earlier requests'
+	// tokens also stops any of their emails which haven't been sent yet.
+	err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token := &data.Token{UserID: user.ID, Expiry: time.Now().Add(emailChangeTTL), Scope: data.ScopeEmailChange, Email: &input.Email}
+
+	err = app.enqueueTokenEmail(token, "email_change.tmpl")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// As a warning, this also alerts the current address, in case someone else is
+	// trying to take over the account.
+	app.securityEvent(r, user.ID, data.SecurityEmailChangeRequested, data.SeverityWarning, nil)
+
+	env := envelope{"message": "an email will be sent to the new address containing instructions to confirm the change"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmEmailChangeHandler() uses up an email change token and changes the user's
+// email address to the one it was sent to. Like activation, it doesn't need the user
+// to be signed in, since the link may be opened on another device. Using the token
+// shows that the user can receive email at the new address, so it also counts as
+// activating the account with that address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		PlainTextToken string `json:"token"`
 	}
+
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -111,11 +673,30 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.PlainTextToken)
+	// Like activation tokens, these tokens aren't tied to an email address in the
+	// request, so guessing them is throttled by IP address only.
+	if !app.checkAuthThrottle(w, r, data.AuthFailureEmailChange, "") {
+		return
+	}
+
+	token, err := app.models.Tokens.Consume(data.ScopeEmailChange, input.PlainTextToken)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			app.recordAuthFailure(r, data.AuthFailureEmailChange, "")
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -123,10 +704,15 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	user.Email = *token.Email
 	user.Activated = true
+
 	err = app.models.Users.Update(user)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -135,14 +721,194 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(user.ID, data.ScopeActivation)
+	app.audit(r, "change_email", "user", user.ID, nil)
+	app.securityEvent(r, user.ID, data.SecurityEmailChanged, data.SeverityInfo, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listUsersHandler() lists the user accounts for operators. All of the filters are
+// optional: activated is true or false, email is a whole email address, and
+// seen_before is an RFC 3339 timestamp, for finding inactive users.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var filter data.UserFilter
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	if qs.Has("activated") {
+		activated := app.readBool(qs, "activated", false, v)
+		filter.Activated = &activated
+	}
+
+	filter.Email = app.readString(qs, "email", "")
+	filter.SeenBefore = app.readTime(qs, "seen_before", v)
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: data.UserSortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "users": users, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showUserHandler() shows a user account for operators, along with the user's
+// roles and all of the permissions they hold.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	account := struct {
+		*data.User
+		Roles       []string         `json:"roles"`
+		Permissions data.Permissions `json:"permissions"`
+	}{user, roles, permissions}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": account}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserSuspendedHandler() suspends a user, or lifts their suspension. While
+// they're suspended, the authenticate middleware refuses their tokens. Operators
+// can't suspend themselves.
+func (app *application) updateUserSuspendedHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Suspended *bool `json:"suspended"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Suspended != nil, "suspended", "must be provided")
+	v.Check(user.ID != app.contextGetUser(r).ID, "suspended", "you cannot suspend your own account")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	if user.Suspended != *input.Suspended {
+		user.Suspended = *input.Suspended
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if user.Suspended {
+			app.audit(r, "suspend", "user", user.ID, nil)
+			app.securityEvent(r, user.ID, data.SecurityAccountSuspended, data.SeverityWarning, nil)
+		} else {
+			app.audit(r, "unsuspend", "user", user.ID, nil)
+			app.securityEvent(r, user.ID, data.SecurityAccountUnsuspended, data.SeverityInfo, nil)
+		}
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The userForRequest() helper looks up the user in the :id parameter. If there's no
+// such user it sends a 404 Not Found response and returns false.
+func (app *application) userForRequest(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// The encryptPlaintextUsers() method encrypts the personal details of users who
+// registered before PII encryption was enabled, in batches so that no single query
+// holds locks on the users table for long.
+func (app *application) encryptPlaintextUsers(ctx context.Context) {
+	total := 0
+
+	for ctx.Err() == nil {
+		n, err := app.models.Users.EncryptPlaintext(100)
+		if err != nil {
+			app.logger.Error("unable to encrypt user PII", "error", err.Error())
+			return
+		}
+
+		total += n
+		if n == 0 {
+			break
+		}
+	}
+
+	if total > 0 {
+		app.logger.Info("encrypted user PII", "count", total)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (app *application) createWatchEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64      `json:"movie_id"`
+		WatchedAt *time.Time `json:"watched_at"`
+		Rating    *int       `json:"rating"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &data.WatchEntry{
+		UserID:    app.contextGetUser(r).ID,
+		MovieID:   input.MovieID,
+		WatchedAt: time.Now(),
+		Rating:    input.Rating,
+	}
+
+	if input.WatchedAt != nil {
+		entry.WatchedAt = *input.WatchedAt
+	}
+
+	v := validator.New()
+
+	if data.ValidateWatchEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WatchHistory.Insert(app.contextGetOrganization(r), entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/me/watch-history/%d", entry.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWatchHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-watched_at"),
+		SortSafelist: data.WatchHistorySortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.WatchHistory.GetAllForUser(app.contextGetOrganization(r), app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "watch_history": entries, "_links": app.pageLinks(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWatchEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.WatchHistory.DeleteForUser(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "entry successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The exportRatingsHandler() streams the current user's watch history and ratings as
+// CSV, with the column names Letterboxd's importer understands, so that users can
+// take their data to other services.
+func (app *application) exportRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	cw := csv.NewWriter(w)
+
+	// The response is only started once the first row has been read, so that an error
+	// running the query can still be reported properly.
+	started := false
+	start := func() error {
+		started = true
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="greenlight-ratings.csv"`)
+
+		return cw.Write([]string{"tmdbID", "Title", "Year", "Rating", "WatchedDate", "Rewatch"})
+	}
+
+	err := app.models.WatchHistory.Export(app.contextGetOrganization(r), app.contextGetUser(r).ID, func(entry *data.WatchExport) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		record := []string{"", entry.Title, "", "", entry.WatchedAt.UTC().Format(time.DateOnly), strconv.FormatBool(entry.Rewatch)}
+
+		if entry.TMDBID != nil {
+			record[0] = strconv.FormatInt(*entry.TMDBID, 10)
+		}
+
+		if entry.Year != 0 {
+			record[2] = strconv.Itoa(int(entry.Year))
+		}
+
+		if entry.Rating != nil {
+			record[3] = strconv.Itoa(*entry.Rating)
+		}
+
+		return cw.Write(record)
+	})
+	if err != nil {
+		// Once rows have been sent it's too late for an error response, so the export
+		// is just cut short.
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+	}
+
+	// An empty history is exported as just the header row.
+	if !started {
+		err = start()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"net/http"
+)
+
+func (app *application) showWatchProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	providers, err := app.models.WatchProviders.GetForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watch_providers": providers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The replaceWatchProvidersHandler() sets where a movie can be watched. The list in
+// the request replaces the movie's current providers, so an empty list removes them
+// all.
+func (app *application) replaceWatchProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		WatchProviders []data.WatchProvider `json:"watch_providers"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.WatchProviders != nil, "watch_providers", "must be provided")
+
+	if data.ValidateWatchProviders(v, input.WatchProviders); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WatchProviders.Replace(movie.ID, input.WatchProviders)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie.WatchProviders = input.WatchProviders
+
+	app.audit(r, "update_watch_providers", "movie", movie.ID, input)
+	app.emitOrganizationEvent(app.contextGetOrganization(r), data.EventMovieUpdated, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watch_providers": input.WatchProviders}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The movieForRequest() helper looks up the movie in the :id parameter, in the
+// organization the request is made on behalf of. If there's no such movie it sends a
+// 404 Not Found response and returns false.
+func (app *application) movieForRequest(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.movies(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/data"
+	"greenlight/anaplo/internal/validator"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// webhookPayload is the payload for jobDeliverWebhook jobs. The body is rendered once
+// when the event is emitted, so that every attempt delivers exactly the same bytes.
+type webhookPayload struct {
+	WebhookID int64           `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Body      json.RawMessage `json:"body"`
+}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		UserID: app.contextGetUser(r).ID,
+		URL:    input.URL,
+		Events: input.Events,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhook.Secret, err = data.GenerateWebhookSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "webhook", webhook.ID, map[string]any{"url": webhook.URL, "events": webhook.Events})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	// The secret isn't included when the webhook is marshalled, so we send it
+	// alongside. This is the only time the client will be able to see it.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "update", "webhook", webhook.ID, input)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Webhooks.Delete(webhook.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "webhook", webhook.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhookDeliveriesHandler() shows the log of attempts to deliver events to a
+// webhook, which is the first place to look when a receiver isn't getting them.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: data.WebhookDeliverySortSafelist,
+		URL:          app.requestURL(r),
+	}
+
+	if data.ValidateFilters(v, filters, app.config.pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(webhook.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The webhookForRequest() helper fetches the webhook identified by the :id parameter,
+// sending a 404 response and returning false if it doesn't exist or belongs to another
+// user.
+func (app *application) webhookForRequest(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if webhook.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// The emitEvent() helper queues a delivery job for every active webhook which is
+// subscribed to the event. Looking up the subscriptions happens in the background so
+// that it doesn't slow down the request which triggered the event.
+func (app *application) emitEvent(event string, payload any) {
+	app.emit(event, payload, func() ([]*data.Webhook, error) {
+		return app.models.Webhooks.GetActiveForEvent(event)
+	})
+}
+
+// The emitUserEvent() helper is like emitEvent(), but only delivers the event to the
+// given user's webhooks. It's used for events which concern a single user.
+func (app *application) emitUserEvent(userID int64, event string, payload any) {
+	app.emit(event, payload, func() ([]*data.Webhook, error) {
+		return app.models.Webhooks.GetActiveForUserEvent(userID, event)
+	})
+}
+
+// The emitOrganizationEvent() helper is like emitEvent(), but only delivers the event
+// to the webhooks of the organization's members. It's used for changes to an
+// organization's movies, which other organizations mustn't see.
+func (app *application) emitOrganizationEvent(organizationID int64, event string, payload any) {
+	app.emit(event, payload, func() ([]*data.Webhook, error) {
+		return app.models.Webhooks.GetActiveForOrganizationEvent(organizationID, event)
+	})
+}
+
+func (app *application) emit(event string, payload any, subscribers func() ([]*data.Webhook, error)) {
+	body, err := json.Marshal(map[string]any{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       payload,
+	})
+	if err != nil {
+		app.logger.Error("unable to encode event", "event", event, "error", err.Error())
+		return
+	}
+
+	app.background("emit "+event, func(ctx context.Context) {
+		webhooks, err := subscribers()
+		if err != nil {
+			app.logger.Error("unable to find webhooks for event", "event", event, "error", err.Error())
+			return
+		}
+
+		for _, webhook := range webhooks {
+			_, err := app.enqueueJob(jobDeliverWebhook, webhookPayload{
+				WebhookID: webhook.ID,
+				Event:     event,
+				Body:      body,
+			})
+			if err != nil {
+				app.logger.Error("unable to queue webhook delivery", "webhook_id", webhook.ID, "event", event, "error", err.Error())
+			}
+		}
+	})
+}
+
+// The deliverWebhookJob() sends an event to a webhook and records the attempt in the
+// delivery log. A non-2xx response is returned as an error, so the job queue retries
+// the delivery with backoff.
+func (app *application) deliverWebhookJob(ctx context.Context, job *data.Job) error {
+	var p webhookPayload
+
+	err := json.Unmarshal(job.Payload, &p)
+	if err != nil {
+		return err
+	}
+
+	// If the webhook has been deleted or deactivated since the event was emitted there
+	// is nothing left to do.
+	webhook, err := app.models.Webhooks.Get(p.WebhookID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if !webhook.Active {
+		return nil
+	}
+
+	delivery := &data.WebhookDelivery{
+		WebhookID: webhook.ID,
+		Event:     p.Event,
+		Payload:   p.Body,
+		Attempt:   job.Attempts,
+	}
+
+	start := time.Now()
+	deliveryErr := app.postWebhook(ctx, webhook, p.Event, p.Body, delivery)
+	delivery.DurationMS = int(time.Since(start).Milliseconds())
+
+	if deliveryErr != nil {
+		delivery.Error = deliveryErr.Error()
+	} else {
+		delivery.Success = true
+	}
+
+	err = app.models.Webhooks.InsertDelivery(delivery)
+	if err != nil {
+		app.logger.Error("unable to record webhook delivery", "webhook_id", webhook.ID, "error", err.Error())
+	}
+
+	return deliveryErr
+}
+
+// The postWebhook() helper sends a signed event to the webhook's URL. The signature is
+// an HMAC-SHA256 of the timestamp and body, using the webhook's secret, so receivers can
+// verify that the request came from us and reject replays of old requests.
+func (app *application) postWebhook(ctx context.Context, webhook *data.Webhook, event string, body []byte, delivery *data.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+	req.Header.Set("X-Greenlight-Event", event)
+	req.Header.Set("X-Greenlight-Timestamp", timestamp)
+	req.Header.Set("X-Greenlight-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Drain (some of) the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	delivery.StatusCode = res.StatusCode
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// errWebhookAddress is returned when a webhook's host resolves to an address which
+// deliveries aren't allowed to go to.
+var errWebhookAddress = errors.New("webhook URL resolves to a private or local address")
+
+// The newWebhookClient() function returns the HTTP client used to deliver webhooks.
+// Anyone with the webhooks:manage permission chooses where deliveries go, and sees
+// the status they get back, so the client refuses to connect to private and local
+// addresses. The check is made on the address being dialled, after DNS resolution, so
+// a host name can't be pointed at the internal network instead. Redirects aren't
+// followed either, since they could lead anywhere, and proxies from the environment
+// aren't used, since the check would only see the proxy's address.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !data.WebhookAddressAllowed(ip) {
+				return errWebhookAddress
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
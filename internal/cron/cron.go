@@ -0,0 +1,172 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is stored as a bitset, where bit n
+// is set if the value n is permitted.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Record whether the day-of-month and day-of-week fields were restricted. When
+	// both are, a day matches if *either* field matches, as in standard cron.
+	domRestricted, dowRestricted bool
+}
+
+// Shorthands for common schedules.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a standard five-field cron expression ("minute hour day-of-month
+// month day-of-week") or one of the @hourly, @daily, @weekly, @monthly or @yearly
+// shorthands. Each field supports "*", single values, ranges ("1-5"), steps ("*/15"
+// or "0-30/10") and comma-separated lists of these.
+func Parse(expr string) (*Schedule, error) {
+	if d, ok := descriptors[strings.TrimSpace(expr)]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields in %q, got %d", expr, len(fields))
+	}
+
+	var (
+		s   Schedule
+		err error
+	)
+
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+
+	for i, b := range bounds {
+		*b.dst, err = parseField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", expr, err)
+		}
+	}
+
+	// Allow 7 as an alias for Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	return &s, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+
+			n, err := strconv.Atoi(loStr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", loStr)
+			}
+			lo, hi = n, n
+
+			if isRange {
+				n, err := strconv.Atoi(hiStr)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiStr)
+				}
+				hi = n
+			} else if hasStep {
+				// "5/15" means every 15 starting from 5.
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << i
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first time after t which matches the schedule, at minute
+// granularity and in t's location. It returns the zero time if there is no such time
+// in the next five years (for example, "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
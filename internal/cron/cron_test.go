@@ -0,0 +1,112 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"every minute", "* * * * *", "2024-06-03 10:07:30", "2024-06-03 10:08:00"},
+		{"step", "*/15 * * * *", "2024-06-03 10:07:00", "2024-06-03 10:15:00"},
+		{"step from a value", "5/20 * * * *", "2024-06-03 10:06:00", "2024-06-03 10:25:00"},
+		{"range with step", "0-30/10 * * * *", "2024-06-03 10:31:00", "2024-06-03 11:00:00"},
+		{"list", "0 8,12,18 * * *", "2024-06-03 12:00:00", "2024-06-03 18:00:00"},
+		{"strictly after", "@hourly", "2024-06-03 10:00:00", "2024-06-03 11:00:00"},
+		{"next day", "@daily", "2024-01-31 23:59:30", "2024-02-01 00:00:00"},
+		{"next year", "@yearly", "2024-12-31 12:00:00", "2025-01-01 00:00:00"},
+		{"weekly", "@weekly", "2024-06-03 00:00:00", "2024-06-09 00:00:00"},
+		{"day of week", "0 9 * * 1", "2024-06-02 10:00:00", "2024-06-03 09:00:00"},
+		{"sunday as 7", "0 0 * * 7", "2024-06-03 00:00:00", "2024-06-09 00:00:00"},
+		{"day of month or day of week", "0 0 1,15 * 5", "2024-06-02 00:00:00", "2024-06-07 00:00:00"},
+		{"day of month and month", "0 0 15 8 *", "2024-06-03 00:00:00", "2024-08-15 00:00:00"},
+		{"leap day", "30 4 29 2 *", "2024-03-01 00:00:00", "2028-02-29 04:30:00"},
+		{"never", "0 0 30 2 *", "2024-01-01 00:00:00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			from, err := time.Parse(time.DateTime, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := s.Next(from)
+
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("got %s; want the zero time", got)
+				}
+				return
+			}
+
+			if got.Format(time.DateTime) != tt.want {
+				t.Errorf("got %s; want %s", got.Format(time.DateTime), tt.want)
+			}
+		})
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	from := time.Date(2024, 6, 3, 10, 0, 0, 0, loc)
+
+	got := s.Next(from)
+	want := time.Date(2024, 6, 4, 9, 0, 0, 0, loc)
+
+	if !got.Equal(want) || got.Location() != loc {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"empty", "", "expected 5 fields"},
+		{"too few fields", "* * * *", "expected 5 fields"},
+		{"too many fields", "* * * * * *", "expected 5 fields"},
+		{"unknown shorthand", "@fortnightly", "expected 5 fields"},
+		{"not a number", "x * * * *", `invalid value "x"`},
+		{"open range", "1- * * * *", `invalid value ""`},
+		{"zero step", "*/0 * * * *", `invalid step "0"`},
+		{"bad step", "*/x * * * *", `invalid step "x"`},
+		{"minute out of range", "60 * * * *", `value "60" out of range 0-59`},
+		{"hour out of range", "0 24 * * *", `value "24" out of range 0-23`},
+		{"day of month out of range", "0 0 0 * *", `value "0" out of range 1-31`},
+		{"month out of range", "0 0 1 13 *", `value "13" out of range 1-12`},
+		{"day of week out of range", "0 0 * * 8", `value "8" out of range 0-7`},
+		{"reversed range", "0 5-1 * * *", `value "5-1" out of range 0-23`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %q; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Define constants for the kinds of activity shown in a user's feed.
+const (
+	ActivityMovieCreated  = "movie_created"
+	ActivityMovieImported = "movie_imported"
+	ActivityMovieUpdated  = "movie_updated"
+	ActivityMovieDeleted  = "movie_deleted"
+	ActivityWatched       = "watched"
+	ActivityRated         = "rated"
+	ActivityReviewPosted  = "review_posted"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// An Activity is something a user did, for showing on their profile. Changes to movies
+// come from the audit log, watches from the watch history and ratings and reviews
+// from their own tables, so the ID is prefixed with where the activity came from to
+// keep it unique.
+type Activity struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Movie      *ActivityMovie `json:"movie,omitempty"`
+	// The 1 to 10 score for a rating, or the 1 to 5 stars given with a watch.
+	Rating *int `json:"rating,omitempty"`
+}
+
+// ActivityMovie is the movie an activity was about. The title is empty if the movie
+// has since been deleted.
+type ActivityMovie struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+// An ActivityCursor marks the position of the last activity on a page, so that the
+// next page carries on from there even if new activity has happened in between.
+type ActivityCursor struct {
+	OccurredAt time.Time
+	Source     string
+	ID         int64
+}
+
+// String encodes the cursor as an opaque string for clients to send back.
+func (c ActivityCursor) String() string {
+	s := fmt.Sprintf("%d:%s:%d", c.OccurredAt.UnixMicro(), c.Source, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// ParseActivityCursor decodes a cursor made by ActivityCursor.String.
+func ParseActivityCursor(s string) (ActivityCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 3 || !validator.PermittedValues(parts[1], "audit", "watch", "rating", "review") {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return ActivityCursor{}, ErrInvalidCursor
+	}
+
+	return ActivityCursor{OccurredAt: time.UnixMicro(micros), Source: parts[1], ID: id}, nil
+}
+
+// ActivityMetadata is the pagination metadata for a page of activity. NextCursor is
+// empty on the last page.
+type ActivityMetadata struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type ActivityModel struct {
+	DB *sql.DB
+}
+
+// GetForUser returns up to limit of a user's activities in an organization, most
+// recent first, starting after the cursor if one is given. Changes to movies which
+// have since been deleted are still included, since there's no telling which
+// organization they were in.
+func (m ActivityModel) GetForUser(organizationID, userID int64, cursor *ActivityCursor, limit int) ([]*Activity, ActivityMetadata, error) {
+	args := []any{userID, organizationID}
+	after := "TRUE"
+
+	if cursor != nil {
+		args = append(args, cursor.OccurredAt, cursor.Source, cursor.ID)
+		after = "(occurred_at, source, id) < ($3, $4, $5)"
+	}
+
+	// Fetch one more than asked for, to find out whether there's another page.
+	args = append(args, limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT source, id, occurred_at, type, movie_id, title, rating
+		FROM (
+			SELECT 'audit' AS source, audit_logs.id, audit_logs.created_at AS occurred_at,
+				CASE audit_logs.action
+					WHEN 'create' THEN '%s'
+					WHEN 'import' THEN '%s'
+					WHEN 'update' THEN '%s'
+					ELSE '%s'
+				END AS type,
+				audit_logs.resource_id AS movie_id, movies.title, NULL::smallint AS rating
+			FROM audit_logs
+			LEFT JOIN movies ON movies.id = audit_logs.resource_id
+			WHERE audit_logs.actor_id = $1 AND audit_logs.resource_type = 'movie'
+			AND audit_logs.action IN ('create', 'import', 'update', 'delete')
+			AND (movies.id IS NULL OR movies.organization_id = $2)
+			UNION ALL
+			SELECT 'watch', watch_history.id, watch_history.watched_at, '%s',
+				watch_history.movie_id, movies.title, watch_history.rating
+			FROM watch_history
+			INNER JOIN movies ON movies.id = watch_history.movie_id
+			WHERE watch_history.user_id = $1 AND movies.organization_id = $2
+			UNION ALL
+			SELECT 'rating', ratings.movie_id, ratings.updated_at, '%s', ratings.movie_id, movies.title, ratings.rating
+			FROM ratings
+			INNER JOIN movies ON movies.id = ratings.movie_id
+			WHERE ratings.user_id = $1 AND movies.organization_id = $2
+			UNION ALL
+			SELECT 'review', reviews.id, reviews.created_at, '%s', reviews.movie_id, movies.title, NULL
+			FROM reviews
+			INNER JOIN movies ON movies.id = reviews.movie_id
+			WHERE reviews.user_id = $1 AND movies.organization_id = $2
+		) activity
+		WHERE %s
+		ORDER BY occurred_at DESC, source DESC, id DESC
+		LIMIT $%d`,
+		ActivityMovieCreated, ActivityMovieImported, ActivityMovieUpdated, ActivityMovieDeleted,
+		ActivityWatched, ActivityRated, ActivityReviewPosted, after, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, ActivityMetadata{}, err
+	}
+	defer rows.Close()
+
+	activities := []*Activity{}
+	var last ActivityCursor
+
+	for rows.Next() {
+		var activity Activity
+		var source string
+		var id int64
+		var movieID sql.NullInt64
+		var title sql.NullString
+		var rating sql.NullInt16
+
+		err := rows.Scan(&source, &id, &activity.OccurredAt, &activity.Type, &movieID, &title, &rating)
+		if err != nil {
+			return nil, ActivityMetadata{}, err
+		}
+
+		if len(activities) == limit {
+			return activities, ActivityMetadata{NextCursor: last.String()}, nil
+		}
+
+		activity.ID = fmt.Sprintf("%s-%d", source, id)
+
+		if movieID.Valid {
+			activity.Movie = &ActivityMovie{ID: movieID.Int64, Title: title.String}
+		}
+
+		if rating.Valid {
+			r := int(rating.Int16)
+			activity.Rating = &r
+		}
+
+		activities = append(activities, &activity)
+		last = ActivityCursor{OccurredAt: activity.OccurredAt, Source: source, ID: id}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ActivityMetadata{}, err
+	}
+
+	return activities, ActivityMetadata{}, nil
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An AuditLog records a change made through the API, and who made it.
+type AuditLog struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"` // Nil for anonymous requests, or if the user was deleted
+	Action       string          `json:"action"`   // Like "create", "update" or "delete"
+	ResourceType string          `json:"resource_type"`
+	ResourceID   *int64          `json:"resource_id,omitempty"`
+	IP           string          `json:"ip,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditLogFilter holds the optional filters for listing audit logs. Zero values mean
+// the filter isn't applied.
+type AuditLogFilter struct {
+	ActorID      int64
+	ResourceType string
+	Action       string
+	From         time.Time
+	To           time.Time
+}
+
+// AuditLogSortSafelist is what the audit log can be sorted on.
+var AuditLogSortSafelist = SortSafelist("created_at")
+
+type AuditLogModel struct {
+	DB *sql.DB
+}
+
+func (m AuditLogModel) Insert(entry *AuditLog) error {
+	query := `
+		INSERT INTO audit_logs (actor_id, action, resource_type, resource_id, ip, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	details := entry.Details
+	if len(details) == 0 {
+		details = json.RawMessage("{}")
+	}
+
+	args := []any{entry.ActorID, entry.Action, entry.ResourceType, entry.ResourceID, entry.IP, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns a page of audit logs matching the filter, sorted by filters.Sort.
+func (m AuditLogModel) GetAll(filter AuditLogFilter, filters Filters) ([]*AuditLog, Metadata, error) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != 0 {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.ResourceType != "" {
+		add("resource_type = $%d", filter.ResourceType)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id, ip, details
+		FROM audit_logs
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT $%d OFFSET $%d`,
+		where, filters.sortColumn(), filters.sortDirection(), filters.sortDirection(), len(args)+1, len(args)+2)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditLog{}
+
+	for rows.Next() {
+		var entry AuditLog
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&entry.IP,
+			&entry.Details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return entries, metadata, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The kinds of authentication attempt whose failures are tracked.
+const (
+	AuthFailureLogin       = "login"
+	AuthFailureActivation  = "activation"
+	AuthFailureMagicLink   = "magic_link"
+	AuthFailureEmailChange = "email_change"
+	AuthFailureInvite      = "invite"
+)
+
+// AuthFailureCounts summarizes the recent failed attempts for an email address and an
+// IP address.
+type AuthFailureCounts struct {
+	ByEmail int
+	ByIP    int
+	Last    time.Time // The most recent failure for either, or the zero time if none
+}
+
+// AuthFailureModel records failed login and activation attempts, so that repeated
+// guessing can be slowed down and then blocked. Unlike the in-memory rate limiter, the
+// records are shared by every instance of the application.
+type AuthFailureModel struct {
+	DB *sql.DB
+}
+
+// Insert records a failed attempt. The email can be empty for attempts which aren't
+// tied to an account, like activation.
+func (m AuthFailureModel) Insert(kind, email, ip string) error {
+	query := `INSERT INTO auth_failures (kind, email, ip) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, kind, email, ip)
+	return err
+}
+
+// Count returns the number of failures of the given kind since the given time. An
+// empty email isn't counted, so attempts without one are only limited by IP address.
+func (m AuthFailureModel) Count(kind, email, ip string, since time.Time) (AuthFailureCounts, error) {
+	query := `
+		SELECT
+			count(*) FILTER (WHERE email = $2 AND $2 <> ''),
+			count(*) FILTER (WHERE ip = $3),
+			max(created_at)
+		FROM auth_failures
+		WHERE kind = $1 AND created_at >= $4 AND ((email = $2 AND $2 <> '') OR ip = $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var counts AuthFailureCounts
+	var last sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, kind, email, ip, since).Scan(&counts.ByEmail, &counts.ByIP, &last)
+	if err != nil {
+		return AuthFailureCounts{}, err
+	}
+
+	counts.Last = last.Time
+
+	return counts, nil
+}
+
+// DeleteForEmail forgets the failures for an email address, which we do when its
+// owner logs in successfully.
+func (m AuthFailureModel) DeleteForEmail(kind, email string) error {
+	query := `DELETE FROM auth_failures WHERE kind = $1 AND email = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, kind, email)
+	return err
+}
+
+// DeleteBefore removes failures older than the cutoff, returning how many were deleted.
+func (m AuthFailureModel) DeleteBefore(cutoff time.Time) (int64, error) {
+	query := `DELETE FROM auth_failures WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
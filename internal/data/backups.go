@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"io"
+)
+
+// BackupTables are the tables which are included in backups: the movie catalog and
+// the data hanging off it. Users are left out, since their names and email addresses
+// are encrypted with a key which the backup wouldn't have.
+var BackupTables = []string{"organizations", "organization_members", "movies", "movie_watch_providers", "movie_translations", "collections", "collection_movies", "series", "seasons", "episodes", "watch_history", "reviews", "ratings", "watchlist", "favorites"}
+
+type BackupModel struct {
+	DB *sql.DB
+}
+
+// Export writes each of the BackupTables as newline-delimited JSON, one object per
+// row, to the writer which open returns for it. The writer is closed once the table
+// has been written. All the tables are read in one transaction, so that they're
+// consistent with each other. It returns the number of rows in each table.
+func (m BackupModel) Export(ctx context.Context, open func(table string) (io.WriteCloser, error)) (map[string]int64, error) {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	counts := make(map[string]int64, len(BackupTables))
+
+	for _, table := range BackupTables {
+		w, err := open(table)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := exportTable(ctx, tx, table, w)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		counts[table] = n
+	}
+
+	return counts, tx.Commit()
+}
+
+// exportTable writes the rows of a table as JSON lines. The table name comes from
+// BackupTables, so it's safe to interpolate.
+func exportTable(ctx context.Context, tx *sql.Tx, table string, w io.Writer) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT row_to_json(t)::text FROM `+table+` t`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int64
+
+	for rows.Next() {
+		var line []byte
+
+		err := rows.Scan(&line)
+		if err != nil {
+			return n, err
+		}
+
+		_, err = w.Write(append(line, '\n'))
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, rows.Err()
+}
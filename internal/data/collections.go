@@ -0,0 +1,459 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMovieInCollection = errors.New("movie already in a collection")
+
+// A Collection is an ordered group of movies which belong together, like a franchise
+// or "The Lord of the Rings Trilogy".
+type Collection struct {
+	ID          int64              `json:"id"`
+	CreatedAt   time.Time          `json:"-"`
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Version     int32              `json:"version"`
+	Movies      []*CollectionMovie `json:"movies,omitempty"` // Only filled in when showing a single collection
+}
+
+// A CollectionMovie is a movie in a collection, at its position in the collection's
+// order. Positions start at 1, but there can be gaps where movies have been deleted.
+type CollectionMovie struct {
+	MovieID  int64  `json:"movie_id"`
+	Title    string `json:"title"` // The movie's title and year, for convenience when listing
+	Year     int32  `json:"year,omitempty"`
+	Position int32  `json:"position"`
+}
+
+// MovieCollection is the collection a movie is in, as shown on the movie.
+type MovieCollection struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Position int32  `json:"position"`
+}
+
+// CollectionSortSafelist is what a list of collections can be sorted on.
+var CollectionSortSafelist = SortSafelist("id", "name")
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(collection.Description) <= 10000, "description", "must not be more than 10000 bytes long")
+}
+
+// The CollectionModel only reads and writes the collections of one organization, and
+// only puts the organization's movies in them, like the MovieModel. Use
+// ForOrganization() to get a model for the organization a request is made on behalf of.
+type CollectionModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the model which is scoped to the
+// given organization.
+func (m CollectionModel) ForOrganization(id int64) CollectionModel {
+	m.OrganizationID = id
+	return m
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	query := `
+		INSERT INTO collections (organization_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, m.OrganizationID, collection.Name, collection.Description).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+}
+
+// Get returns a collection without its movies. Use GetMovies() for those.
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, description, version
+		FROM collections
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Name,
+		&collection.Description,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) GetAll(filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, description, version
+		FROM collections
+		WHERE organization_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, m.OrganizationID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(&totalRecords, &collection.ID, &collection.CreatedAt, &collection.Name, &collection.Description, &collection.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return collections, calculateMetadata(totalRecords, filters), nil
+}
+
+// Update saves a collection's name and description, as long as its version hasn't
+// changed since it was read. Otherwise it returns ErrEditConflict.
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET name = $1, description = $2, version = version + 1
+		WHERE id = $3 AND organization_id = $4 AND version = $5
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{collection.Name, collection.Description, collection.ID, m.OrganizationID, collection.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a collection. Its movies are left in the catalog.
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM collections WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetMovies returns the movies in a collection in order, leaving out deleted ones. It
+// doesn't check that the collection exists, so callers should look it up first.
+func (m CollectionModel) GetMovies(collectionID int64) ([]*CollectionMovie, error) {
+	query := `
+		SELECT collection_movies.movie_id, movies.title, movies.year, collection_movies.position
+		FROM collection_movies
+		INNER JOIN movies ON movies.id = collection_movies.movie_id
+		WHERE collection_movies.collection_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY collection_movies.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*CollectionMovie{}
+
+	for rows.Next() {
+		var movie CollectionMovie
+
+		err := rows.Scan(&movie.MovieID, &movie.Title, &movie.Year, &movie.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GetForMovies returns the collection each of the given movies is in, for adding to
+// a page of movies with a single query. Movies which aren't in one are left out.
+func (m CollectionModel) GetForMovies(movieIDs []int64) (map[int64]*MovieCollection, error) {
+	query := `
+		SELECT collection_movies.movie_id, collections.id, collections.name, collection_movies.position
+		FROM collection_movies
+		INNER JOIN collections ON collections.id = collection_movies.collection_id
+		WHERE collection_movies.movie_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := map[int64]*MovieCollection{}
+
+	for rows.Next() {
+		var movieID int64
+		var c MovieCollection
+
+		err := rows.Scan(&movieID, &c.ID, &c.Name, &c.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		collections[movieID] = &c
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// AddMovie puts a movie into a collection at the given position, moving the movies
+// from that position on down by one. If the position is 0, or past the end, the movie
+// goes at the end. The movie's title, year and actual position are filled in. It
+// returns ErrRecordNotFound if the movie isn't in the organization's catalog, and
+// ErrMovieInCollection if it's already in a collection.
+func (m CollectionModel) AddMovie(collectionID int64, movie *CollectionMovie) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var end int32
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(max(position), 0) + 1 FROM collection_movies WHERE collection_id = $1`, collectionID).Scan(&end)
+	if err != nil {
+		return err
+	}
+
+	if movie.Position < 1 || movie.Position > end {
+		movie.Position = end
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE collection_movies SET position = position + 1
+		WHERE collection_id = $1 AND position >= $2`, collectionID, movie.Position)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO collection_movies (collection_id, movie_id, position)
+		SELECT $1, movies.id, $2 FROM movies WHERE movies.id = $3 AND movies.organization_id = $4 AND movies.deleted_at IS NULL
+		RETURNING (SELECT title FROM movies WHERE id = $3), (SELECT year FROM movies WHERE id = $3)`,
+		collectionID, movie.Position, movie.MovieID, m.OrganizationID).Scan(&movie.Title, &movie.Year)
+	if err != nil {
+		return collectionMovieError(err)
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceMovies sets the movies in a collection, in the given order, replacing
+// whatever was there before. It returns ErrRecordNotFound if any of the movies aren't
+// in the organization's catalog, and ErrMovieInCollection if any are already in
+// another collection.
+func (m CollectionModel) ReplaceMovies(collectionID int64, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collection_movies WHERE collection_id = $1`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO collection_movies (collection_id, movie_id, position)
+		SELECT $1, movies.id, ids.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS ids(id, position)
+		INNER JOIN movies ON movies.id = ids.id
+		WHERE movies.organization_id = $3 AND movies.deleted_at IS NULL`,
+		collectionID, pq.Array(movieIDs), m.OrganizationID)
+	if err != nil {
+		return collectionMovieError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(movieIDs)) {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMovie takes a movie out of a collection, moving the movies after it up by one.
+// It returns ErrRecordNotFound if the movie wasn't in the collection.
+func (m CollectionModel) RemoveMovie(collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var position int32
+
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM collection_movies WHERE collection_id = $1 AND movie_id = $2
+		RETURNING position`, collectionID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE collection_movies SET position = position - 1
+		WHERE collection_id = $1 AND position > $2`, collectionID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockCollection locks a collection's row until the end of the transaction, so that
+// changes to its movies made at the same time don't give two movies the same position.
+// It returns ErrRecordNotFound if there's no such collection.
+func lockCollection(ctx context.Context, tx *sql.Tx, collectionID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR UPDATE`, collectionID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectionMovieError maps the errors from adding movies to a collection to the
+// model's errors.
+func collectionMovieError(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case err.Error() == `pq: duplicate key value violates unique constraint "collection_movies_movie_id_key"`,
+		err.Error() == `pq: duplicate key value violates unique constraint "collection_movies_pkey"`:
+		return ErrMovieInCollection
+	default:
+		return err
+	}
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrDuplicateFavorite = errors.New("duplicate favorite")
+
+// A Favorite is a movie which a user has marked as one they like. Favorites are kept
+// apart from the watchlist, and each movie's favorite count is kept up to date by a
+// database trigger for sorting by popularity.
+type Favorite struct {
+	MovieID int64     `json:"movie_id"`
+	Title   string    `json:"title"` // The movie's title and year, for convenience when listing
+	Year    int32     `json:"year,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// FavoriteSortSafelist is what a user's favorites can be sorted on.
+var FavoriteSortSafelist = SortSafelist("added_at")
+
+type FavoriteModel struct {
+	DB *sql.DB
+}
+
+// Insert marks a movie as one of a user's favorites. It returns ErrRecordNotFound if
+// the movie doesn't exist in the given organization's catalog, and
+// ErrDuplicateFavorite if it's already a favorite.
+func (m FavoriteModel) Insert(organizationID, userID int64, entry *Favorite) error {
+	query := `
+		INSERT INTO favorites (user_id, movie_id)
+		SELECT $1, movies.id FROM movies WHERE movies.id = $2 AND movies.organization_id = $3 AND movies.deleted_at IS NULL
+		RETURNING added_at, (SELECT title FROM movies WHERE id = $2), (SELECT year FROM movies WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, entry.MovieID, organizationID).Scan(&entry.AddedAt, &entry.Title, &entry.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "favorites_pkey"`:
+			return ErrDuplicateFavorite
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser returns a page of a user's favorite movies which are in the given
+// organization's catalog.
+func (m FavoriteModel) GetAllForUser(organizationID, userID int64, filters Filters) ([]*Favorite, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), favorites.movie_id, movies.title, movies.year, favorites.added_at
+		FROM favorites
+		INNER JOIN movies ON movies.id = favorites.movie_id
+		WHERE favorites.user_id = $1 AND movies.organization_id = $4 AND movies.deleted_at IS NULL
+		ORDER BY favorites.%s %s, favorites.movie_id %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*Favorite{}
+
+	for rows.Next() {
+		var entry Favorite
+
+		err := rows.Scan(&totalRecords, &entry.MovieID, &entry.Title, &entry.Year, &entry.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters), nil
+}
+
+// DeleteForUser unmarks one of a user's favorite movies. It returns ErrRecordNotFound
+// if the movie wasn't a favorite.
+func (m FavoriteModel) DeleteForUser(userID, movieID int64) error {
+	query := `DELETE FROM favorites WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -1,7 +1,14 @@
 package data
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"greenlight/anaplo/internal/filter"
 	"greenlight/anaplo/internal/validator"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -13,14 +20,66 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// An optional expression from the filter query string parameter, which has been
+	// parsed against the endpoint's allowlist of fields.
+	Expression *filter.Expr
+	// Optional bounds on a movie's year and runtime (in minutes), from the year_min,
+	// year_max, runtime_min and runtime_max query string parameters. Zero means there's
+	// no bound.
+	YearMin    int
+	YearMax    int
+	RuntimeMin int
+	RuntimeMax int
+	// The position to carry on from, if the list is paginated with a cursor from a
+	// previous page rather than by page number.
+	Cursor *Cursor
+	// The absolute URL of the list request, if there is one. It's used to link to the
+	// next and previous pages, with the same query string apart from the page.
+	URL *url.URL
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextPageURL  string `json:"next_page_url,omitempty"`
+	PrevPageURL  string `json:"prev_page_url,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// A Cursor marks the position of the last record on a page, by its value of the sort
+// column (nil if it's NULL) and its ID, so that the next page can start straight after
+// it. Unlike an offset, the database doesn't have to read through all the records
+// before it, and records added in between don't shift the pages.
+type Cursor struct {
+	Sort  string  `json:"sort"`
+	Value *string `json:"value"`
+	ID    int64   `json:"id"`
+}
+
+// String encodes the cursor as an opaque string for clients to send back.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor made by Cursor.String.
+func ParseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.Sort == "" || c.ID < 1 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -30,28 +89,116 @@ type Metadata struct {
 // the modulus (or remainder) dropped. So, for example, if there were 12 records in total
 // and a page size of 5, the last page value would be (12+5-1)/5 = 3.2, which is then
 // truncated to 3 by Go.
-func calculateMetadata(totalRecords, pageSize, page int) Metadata {
+//
+// If the filters have the request's URL, the metadata also includes the URLs of the next
+// and previous pages (when there are such pages), keeping all the other query parameters.
+func calculateMetadata(totalRecords int, filters Filters) Metadata {
 	if totalRecords == 0 {
 		return Metadata{}
 	}
 
-	return Metadata{
-		CurrentPage:  page,
-		PageSize:     pageSize,
+	// With a cursor the records before it aren't counted, so there are no page numbers.
+	if filters.Cursor != nil {
+		return Metadata{PageSize: filters.PageSize}
+	}
+
+	metadata := Metadata{
+		CurrentPage:  filters.Page,
+		PageSize:     filters.PageSize,
 		FirstPage:    1,
-		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		LastPage:     (totalRecords + filters.PageSize - 1) / filters.PageSize,
 		TotalRecords: totalRecords,
 	}
+
+	if filters.URL != nil {
+		if metadata.CurrentPage < metadata.LastPage {
+			metadata.NextPageURL = filters.pageURL(metadata.CurrentPage + 1)
+		}
+
+		// A page past the end links back to the last page, rather than the one before it.
+		if metadata.CurrentPage > metadata.FirstPage {
+			metadata.PrevPageURL = filters.pageURL(min(metadata.CurrentPage-1, metadata.LastPage))
+		}
+	}
+
+	return metadata
+}
+
+// The pageURL() method returns the request's URL with the page parameter set to page.
+func (f Filters) pageURL(page int) string {
+	u := *f.URL
+
+	qs := u.Query()
+	qs.Set("page", strconv.Itoa(page))
+	u.RawQuery = qs.Encode()
+
+	return u.String()
 }
 
-func ValidateFilters(v *validator.Validator, f Filters) {
+// PageLimits bounds how far clients can page through a list. Postgres has to read and
+// throw away every row before the offset, so deep pages get slower and slower.
+type PageLimits struct {
+	MaxPageSize int
+	MaxOffset   int
+}
+
+func ValidateFilters(v *validator.Validator, f Filters, limits PageLimits) {
 	// Check that the page and page_size parameters contain sensible values.
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.PermittedValues(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.PageSize <= limits.MaxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", limits.MaxPageSize))
+
+	// Only check the offset when the page and page size are sensible, so the offset
+	// can't overflow.
+	if f.Page > 0 && f.Page <= 10_000_000 && f.PageSize > 0 && f.PageSize <= limits.MaxPageSize {
+		v.Check(f.offset() < limits.MaxOffset, "page", fmt.Sprintf("is too deep, only the first %d records can be paged through; continue with a filter on the sort column instead, like id>1234 with sort=id", limits.MaxOffset))
+	}
+
+	// Check that the sort parameter matches a value in the safelist, and tell the
+	// client which values they can use if it doesn't.
+	v.Check(validator.PermittedValues(f.Sort, f.SortSafelist...), "sort", "must be one of "+strings.Join(f.SortSafelist, ", "))
+
+	// A cursor carries on from a page with the same sort, so it can't be used to skip
+	// pages as well.
+	if f.Cursor != nil {
+		v.Check(f.Page == 1, "page", "must not be given with a cursor")
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "must be from a response with the same sort")
+	}
+
+	// Check the year and runtime bounds, if there are any.
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888, "year_min", "must be greater than 1888")
+	}
+
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888, "year_max", "must be greater than 1888")
+	}
+
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must not be negative")
+
+	if f.RuntimeMin != 0 && f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+}
+
+// The SortSafelist() function returns the sort values for an endpoint which can be
+// sorted on the given columns, in ascending order and (with a leading hyphen)
+// descending order. Each model declares its endpoints' safelists next to the model,
+// so that making another column sortable only means adding it to the list.
+func SortSafelist(columns ...string) []string {
+	safelist := make([]string, 0, len(columns)*2)
+
+	for _, column := range columns {
+		safelist = append(safelist, column, "-"+column)
+	}
+
+	return safelist
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -75,6 +222,75 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// The where() method returns the SQL condition for the filter expression and the year
+// and runtime bounds, with its placeholders numbered from firstArg, or "TRUE" if there
+// aren't any.
+func (f Filters) where(firstArg int) (string, []any) {
+	where, args := "TRUE", []any{}
+
+	if f.Expression != nil {
+		where, args = f.Expression.SQL(firstArg)
+	}
+
+	bounds := []struct {
+		condition string
+		value     int
+	}{
+		{"year >= $%d", f.YearMin},
+		{"year <= $%d", f.YearMax},
+		{"runtime >= $%d", f.RuntimeMin},
+		{"runtime <= $%d", f.RuntimeMax},
+	}
+
+	for _, bound := range bounds {
+		if bound.value != 0 {
+			where += " AND " + fmt.Sprintf(bound.condition, firstArg+len(args))
+			args = append(args, bound.value)
+		}
+	}
+
+	return where, args
+}
+
+// The after() method returns the SQL condition for the records after the cursor, in
+// the order of the sort column (given as the SQL expression for it, with NULLS LAST)
+// and then ID, with its placeholders numbered from firstArg, or "TRUE" if there isn't
+// a cursor.
+func (f Filters) after(sortExpression string, firstArg int) (string, []any) {
+	if f.Cursor == nil {
+		return "TRUE", nil
+	}
+
+	if f.Cursor.Value == nil {
+		return fmt.Sprintf("(%s IS NULL AND id > $%d)", sortExpression, firstArg), []any{f.Cursor.ID}
+	}
+
+	op := ">"
+	if f.sortDirection() == "DESC" {
+		op = "<"
+	}
+
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d) OR %[1]s IS NULL)",
+		sortExpression, op, firstArg, firstArg+1)
+
+	return condition, []any{*f.Cursor.Value, f.Cursor.ID}
+}
+
+// The nextCursor() method returns the cursor for the page after one whose last record
+// has the given sort value and ID, or an empty string if it's the last page.
+func (f Filters) nextCursor(totalRecords, count int, value sql.NullString, id int64) string {
+	if f.offset()+count >= totalRecords {
+		return ""
+	}
+
+	c := Cursor{Sort: f.Sort, ID: id}
+	if value.Valid {
+		c.Value = &value.String
+	}
+
+	return c.String()
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
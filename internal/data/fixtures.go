@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// fixtureTables are the tables which are emptied when the database is reset for a test
+// run. The permission catalog and the roles are left alone, since they're seeded by the
+// migrations.
+var fixtureTables = []string{
+	"users", "tokens", "invites", "users_permissions", "users_roles", "jobs", "scheduled_jobs", "webhooks", "webhook_deliveries",
+	"movie_events", "token_issuance", "audit_logs", "auth_failures", "security_events", "oauth_clients",
+	"oauth_codes", "watch_history", "saved_searches", "notification_preferences", "notifications",
+	"rate_limit_exemptions", "api_usage", "api_usage_endpoints", "organizations", "organization_members",
+	"movies", "movie_imports", "movie_exports", "movies_history", "movie_watch_providers", "movie_translations", "push_devices", "reviews",
+	"review_reports", "ratings", "movie_stats", "watchlist", "favorites", "recommendations", "movie_views",
+	"metrics_daily", "shared_watchlists", "shared_watchlist_members", "shared_watchlist_movies",
+	"series", "seasons", "episodes", "collections", "collection_movies",
+}
+
+// FixtureModel sets up known state for end-to-end tests and demos. It must never be
+// reachable in production.
+type FixtureModel struct {
+	DB *sql.DB
+}
+
+// Reset empties every table apart from the permission catalog and restarts their ID
+// sequences, so that the IDs of anything created afterwards are predictable. The
+// default organization is put back, as the migrations created it.
+func (m FixtureModel) Reset() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE `+strings.Join(fixtureTables, ", ")+` RESTART IDENTITY CASCADE`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organizations (id, name, slug) VALUES ($1, 'Default', 'default');
+		SELECT setval('organizations_id_seq', $1)`, DefaultOrganizationID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A Genre is one of the genres in the catalog which movies are tagged with. Movies
+// refer to genres by slug, like sci-fi, and the name is for display, like Science
+// Fiction.
+type Genre struct {
+	ID   int64  `json:"-"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// GenreQuery narrows a movie list down by genre, on top of the genres parameter, which
+// only matches movies with all of the given genres. Movies must have at least one of
+// the Any genres, and none of the Not genres.
+type GenreQuery struct {
+	Any []string
+	Not []string
+}
+
+func ValidateGenreQuery(v *validator.Validator, q GenreQuery) {
+	for key, genres := range map[string][]string{"genres_any": q.Any, "genres_not": q.Not} {
+		for _, genre := range genres {
+			if !v.Matches(genre, SlugRX) {
+				v.AddError(key, "must only contain genre slugs, like sci-fi")
+				break
+			}
+		}
+	}
+
+	for _, genre := range q.Any {
+		if slices.Contains(q.Not, genre) {
+			v.AddError("genres_not", "must not contain genres which are in genres_any")
+			break
+		}
+	}
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns the whole genre catalog, in alphabetical order of name.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT id, slug, name
+		FROM genres
+		ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.Slug, &genre.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) GetBySlug(slug string) (*Genre, error) {
+	query := `
+		SELECT id, slug, name
+		FROM genres
+		WHERE slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var genre Genre
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&genre.ID, &genre.Slug, &genre.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// Unknown returns the slugs which aren't in the catalog, in the order given, so that
+// a movie's genres can be checked before it's saved.
+func (m GenreModel) Unknown(slugs []string) ([]string, error) {
+	query := `
+		SELECT slug
+		FROM unnest($1::text[]) WITH ORDINALITY AS s(slug, position)
+		WHERE s.slug NOT IN (SELECT genres.slug FROM genres)
+		ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(slugs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unknown := []string{}
+
+	for rows.Next() {
+		var slug string
+
+		err := rows.Scan(&slug)
+		if err != nil {
+			return nil, err
+		}
+
+		unknown = append(unknown, slug)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return unknown, nil
+}
+
+// SlugsForNames looks up genres by name or slug, ignoring case, for taking genres from
+// somewhere else like TMDB. Names which aren't in the catalog are left out.
+func (m GenreModel) SlugsForNames(names []string) ([]string, error) {
+	genres, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := []string{}
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		for _, genre := range genres {
+			if strings.EqualFold(name, genre.Name) || strings.EqualFold(name, genre.Slug) {
+				if !seen[genre.Slug] {
+					slugs = append(slugs, genre.Slug)
+					seen[genre.Slug] = true
+				}
+				break
+			}
+		}
+	}
+
+	return slugs, nil
+}
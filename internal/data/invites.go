@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// An Invite lets someone register when registration is invite-only. Like an activation
+// token it can only be used once, and only before it expires, and just its hash is
+// stored, so the code itself is only available when the invite is created.
+type Invite struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	PlainText string    `json:"code,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+func ValidateInvite(v *validator.Validator, invite *Invite) {
+	v.Check(len(invite.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+// Check that an invite code has been provided and is exactly 26 bytes long, like a
+// token.
+func ValidateInviteCode(v *validator.Validator, code string) {
+	v.Check(code != "", "invite_code", "must be provided")
+	v.Check(len(code) == 26, "invite_code", "must be 26 bytes long")
+}
+
+type InviteModel struct {
+	DB   *sql.DB
+	Keys *TokenKeyring
+}
+
+// Insert generates the invite's code, which is stored hashed like a token.
+func (m InviteModel) Insert(invite *Invite) error {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	invite.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	version, hash := m.Keys.Hash(invite.PlainText)
+
+	query := `
+		INSERT INTO invites (created_by, hash, key_version, note, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, invite.CreatedBy, hash, version, invite.Note, invite.Expiry).Scan(&invite.ID, &invite.CreatedAt)
+}
+
+// GetAll returns the invites which haven't been used or expired yet, newest first.
+func (m InviteModel) GetAll() ([]*Invite, error) {
+	query := `
+		SELECT id, created_at, created_by, note, expiry
+		FROM invites
+		WHERE expiry > NOW()
+		ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+
+	for rows.Next() {
+		var invite Invite
+
+		err := rows.Scan(&invite.ID, &invite.CreatedAt, &invite.CreatedBy, &invite.Note, &invite.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, &invite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Delete revokes an invite, so that it can't be used.
+func (m InviteModel) Delete(id int64) error {
+	query := `DELETE FROM invites WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes invites which were never used.
+func (m InviteModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM invites WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
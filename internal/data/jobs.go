@@ -0,0 +1,285 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define constants for the states a job moves through. A job starts out queued, is
+// marked as running when a worker claims it, and ends up either completed or dead
+// (when it has failed more times than its max_attempts allows).
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobDead      = "dead"
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	UserID      *int64          `json:"-"` // The user who started the job, if any
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	Progress    JobProgress     `json:"progress"`
+	Errors      []string        `json:"errors,omitempty"` // Non-fatal errors, like rows rejected by an import
+}
+
+// JobProgress reports how far through its work a long-running job is.
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// The columns selected for every query which returns a full Job.
+const jobColumns = `id, created_at, updated_at, user_id, kind, payload, status, attempts, max_attempts,
+		run_at, last_error, progress_done, progress_total, errors`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.UserID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.Progress.Done,
+		&job.Progress.Total,
+		pq.Array(&job.Errors),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+type JobModel struct {
+	DB DBTX
+}
+
+// The WithTx() method returns a copy of the model which runs its queries inside the
+// given transaction, so that a job is only queued if the rest of the transaction is
+// committed.
+func (m JobModel) WithTx(tx *sql.Tx) JobModel {
+	m.DB = tx
+	return m
+}
+
+// Enqueue stores a new job of the given kind. The payload is encoded to JSON and
+// handed back to the job handler when the job runs.
+func (m JobModel) Enqueue(kind string, payload any, maxAttempts int) (*Job, error) {
+	return m.enqueue(nil, kind, payload, maxAttempts)
+}
+
+// EnqueueForUser stores a new job on behalf of a user, who can then follow its
+// progress through the jobs API.
+func (m JobModel) EnqueueForUser(userID int64, kind string, payload any, maxAttempts int) (*Job, error) {
+	return m.enqueue(&userID, kind, payload, maxAttempts)
+}
+
+func (m JobModel) enqueue(userID *int64, kind string, payload any, maxAttempts int) (*Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO jobs (user_id, kind, payload, max_attempts)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, status, run_at`
+
+	job := &Job{
+		UserID:      userID,
+		Kind:        kind,
+		Payload:     js,
+		MaxAttempts: maxAttempts,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, kind, js, maxAttempts).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Status,
+		&job.RunAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ClaimNext marks the next due job as running and returns it, or returns
+// ErrRecordNotFound if there's nothing to do. Jobs which have been running for longer
+// than the lease are assumed to belong to a worker which crashed, and are claimed
+// again --- this is what gives us at-least-once delivery. FOR UPDATE SKIP LOCKED
+// lets several workers (in one process or many) claim jobs concurrently without ever
+// picking the same one.
+func (m JobModel) ClaimNext(lease time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_at <= NOW())
+			OR (status = 'running' AND locked_at < NOW() - make_interval(secs => $1))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanJob(m.DB.QueryRowContext(ctx, query, lease.Seconds()))
+}
+
+// Get returns a specific job.
+func (m JobModel) Get(id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanJob(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// UpdateProgress records how far through its work a running job is, along with any
+// non-fatal errors it has come across so far.
+func (m JobModel) UpdateProgress(job *Job) error {
+	query := `
+		UPDATE jobs
+		SET progress_done = $1, progress_total = $2, errors = $3, updated_at = NOW()
+		WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, job.Progress.Done, job.Progress.Total, pq.Array(job.Errors), job.ID)
+	return err
+}
+
+// Complete marks a job as successfully finished.
+func (m JobModel) Complete(job *Job) error {
+	query := `
+		UPDATE jobs
+		SET status = 'completed', locked_at = NULL, updated_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, job.ID)
+	if err != nil {
+		return err
+	}
+
+	job.Status = JobCompleted
+	return nil
+}
+
+// Fail records a failed attempt. If the job has attempts left it is queued again to
+// run after the retry delay; otherwise it is moved to the dead state, where it stays
+// for someone to inspect.
+func (m JobModel) Fail(job *Job, jobErr error, retryAfter time.Duration) error {
+	job.Status = JobQueued
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = JobDead
+	}
+	job.RunAt = time.Now().Add(retryAfter)
+	job.LastError = jobErr.Error()
+
+	query := `
+		UPDATE jobs
+		SET status = $1, run_at = $2, last_error = $3, locked_at = NULL, updated_at = NOW()
+		WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, job.Status, job.RunAt, job.LastError, job.ID)
+	return err
+}
+
+// DeleteFinishedBefore removes completed and dead jobs which finished before the given
+// time, along with their payloads, returning the number of jobs deleted.
+func (m JobModel) DeleteFinishedBefore(before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status IN ('completed', 'dead') AND updated_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// CountByStatus returns the number of jobs in each status, which tells us how far
+// behind the job runners are.
+func (m JobModel) CountByStatus() (map[string]int64, error) {
+	query := `SELECT status, count(*) FROM jobs GROUP BY status`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{JobQueued: 0, JobRunning: 0, JobCompleted: 0, JobDead: 0}
+
+	for rows.Next() {
+		var status string
+		var count int64
+
+		err := rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -1,35 +1,202 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
 
+// DBTX is the set of methods shared by *sql.DB and *sql.Tx. Models which hold a DBTX
+// rather than a *sql.DB can be used inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Movies      MovieModel
-	Users       UsersModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies                  MovieModel
+	MovieImports            MovieImportModel
+	MovieExports            MovieExportModel
+	Users                   UsersModel
+	Tokens                  TokenModel
+	Invites                 InviteModel
+	Permissions             PermissionModel
+	Roles                   RoleModel
+	Jobs                    JobModel
+	Scheduler               SchedulerModel
+	Webhooks                WebhookModel
+	MovieEvents             MovieEventModel
+	Stats                   StatsModel
+	AuditLogs               AuditLogModel
+	AuthFailures            AuthFailureModel
+	SecurityEvents          SecurityEventModel
+	OAuth                   OAuthModel
+	WatchHistory            WatchHistoryModel
+	SavedSearches           SavedSearchModel
+	NotificationPreferences NotificationPreferenceModel
+	Notifications           NotificationModel
+	RateLimitExemptions     RateLimitExemptionModel
+	Usage                   UsageModel
+	Organizations           OrganizationModel
+	PushDevices             PushDeviceModel
+	WatchProviders          WatchProviderModel
+	Backups                 BackupModel
+	Fixtures                FixtureModel
+	Activity                ActivityModel
+	Reviews                 ReviewModel
+	Ratings                 RatingModel
+	Watchlist               WatchlistModel
+	SharedWatchlists        SharedWatchlistModel
+	Favorites               FavoriteModel
+	Genres                  GenreModel
+	MovieHistory            MovieHistoryModel
+	Recommendations         RecommendationModel
+	MovieViews              MovieViewModel
+	Translations            TranslationModel
+	Series                  SeriesModel
+	Collections             CollectionModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
-// the initialized MovieModel.
-func NewModels(db *sql.DB) *Models {
+// the initialized MovieModel. The keyring is used to hash tokens, and pii (which can be
+// nil) to encrypt users' personal details.
+func NewModels(db *sql.DB, tokenKeys *TokenKeyring, pii *PIICipher) *Models {
 	return &Models{
 		Movies: MovieModel{
 			DB: db,
 		},
-		Users: UsersModel{
+		MovieImports: MovieImportModel{
 			DB: db,
 		},
-		Tokens: TokenModel{
+		MovieExports: MovieExportModel{
 			DB: db,
 		},
+		Users: UsersModel{
+			DB:   db,
+			Keys: tokenKeys,
+			PII:  pii,
+		},
+		Tokens: TokenModel{
+			DB:   db,
+			Keys: tokenKeys,
+			PII:  pii,
+		},
+		Invites: InviteModel{
+			DB:   db,
+			Keys: tokenKeys,
+		},
 		Permissions: PermissionModel{
 			DB: db,
 		},
+		Roles: RoleModel{
+			DB: db,
+		},
+		Jobs: JobModel{
+			DB: db,
+		},
+		Scheduler: SchedulerModel{
+			DB: db,
+		},
+		Webhooks: WebhookModel{
+			DB: db,
+		},
+		MovieEvents: MovieEventModel{
+			DB: db,
+		},
+		Stats: StatsModel{
+			DB: db,
+		},
+		AuditLogs: AuditLogModel{
+			DB: db,
+		},
+		AuthFailures: AuthFailureModel{
+			DB: db,
+		},
+		SecurityEvents: SecurityEventModel{
+			DB: db,
+		},
+		OAuth: OAuthModel{
+			DB:   db,
+			Keys: tokenKeys,
+		},
+		WatchHistory: WatchHistoryModel{
+			DB: db,
+		},
+		SavedSearches: SavedSearchModel{
+			DB: db,
+		},
+		NotificationPreferences: NotificationPreferenceModel{
+			DB: db,
+		},
+		Notifications: NotificationModel{
+			DB: db,
+		},
+		RateLimitExemptions: RateLimitExemptionModel{
+			DB: db,
+		},
+		Usage: UsageModel{
+			DB: db,
+		},
+		Organizations: OrganizationModel{
+			DB: db,
+		},
+		PushDevices: PushDeviceModel{
+			DB: db,
+		},
+		WatchProviders: WatchProviderModel{
+			DB: db,
+		},
+		Backups: BackupModel{
+			DB: db,
+		},
+		Fixtures: FixtureModel{
+			DB: db,
+		},
+		Activity: ActivityModel{
+			DB: db,
+		},
+		Reviews: ReviewModel{
+			DB:  db,
+			PII: pii,
+		},
+		Ratings: RatingModel{
+			DB: db,
+		},
+		Watchlist: WatchlistModel{
+			DB: db,
+		},
+		SharedWatchlists: SharedWatchlistModel{
+			DB:  db,
+			PII: pii,
+		},
+		Favorites: FavoriteModel{
+			DB: db,
+		},
+		Genres: GenreModel{
+			DB: db,
+		},
+		MovieHistory: MovieHistoryModel{
+			DB: db,
+		},
+		Recommendations: RecommendationModel{
+			DB: db,
+		},
+		MovieViews: MovieViewModel{
+			DB: db,
+		},
+		Translations: TranslationModel{
+			DB: db,
+		},
+		Series: SeriesModel{
+			DB: db,
+		},
+		Collections: CollectionModel{
+			DB: db,
+		},
 	}
 }
 
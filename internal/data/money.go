@@ -0,0 +1,210 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMoneyFormat = errors.New(`invalid money format, it must be like "1500000.00 USD"`)
+	ErrUnknownCurrency    = errors.New("unknown currency, it must be an ISO 4217 code like USD")
+)
+
+// Money is an amount in a currency, like a movie's budget. The amount is stored in the
+// currency's minor units (cents for USD, yen for JPY), so that it's exact. In JSON it's
+// a string like "1500000.00 USD", with as many decimal places as the currency has.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// currencyExponents maps the active ISO 4217 currency codes to the number of decimal
+// places in their minor unit. Most currencies have two, so only the others are listed
+// separately below.
+var currencyExponents = func() map[string]int {
+	exponents := map[string]int{}
+
+	for _, code := range strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB BOV BRL BSD BTN
+		BWP BYN BZD CAD CDF CHE CHF CHW CNY COP COU CRC CUP CVE CZK DKK DOP DZD EGP ERN ETB
+		EUR FJD FKP GBP GEL GHS GIP GMD GTQ GYD HKD HNL HTG HUF IDR ILS INR IRR JMD KES KGS
+		KHR KPW KYD KZT LAK LBP LKR LRD LSL MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN
+		MXV MYR MZN NAD NGN NIO NOK NPR NZD PAB PEN PGK PHP PKR PLN QAR RON RSD RUB SAR SBD
+		SCR SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TOP TRY TTD TWD TZS
+		UAH USD USN UYU UZS VED VES WST XCD YER ZAR ZMW ZWG`) {
+		exponents[code] = 2
+	}
+
+	for _, code := range strings.Fields(`BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX UYI VND VUV XAF XOF XPF`) {
+		exponents[code] = 0
+	}
+
+	for _, code := range strings.Fields(`BHD IQD JOD KWD LYD OMR TND`) {
+		exponents[code] = 3
+	}
+
+	for _, code := range strings.Fields(`CLF UYW`) {
+		exponents[code] = 4
+	}
+
+	return exponents
+}()
+
+// ValidCurrency reports whether code is an active ISO 4217 currency code.
+func ValidCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+func ValidateMoney(v *validator.Validator, key string, m *Money) {
+	if m == nil {
+		return
+	}
+
+	v.Check(ValidCurrency(m.Currency), key, "must be in an ISO 4217 currency, like USD")
+	v.Check(m.Amount >= 0, key, "must not be negative")
+	v.Check(m.Amount <= 1e15, key, "must not be more than 1e15 in minor units")
+}
+
+// String formats the amount in major units, followed by the currency code.
+func (m Money) String() string {
+	exp := currencyExponents[m.Currency]
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	s := strconv.FormatInt(amount, 10)
+	if exp > 0 {
+		s = fmt.Sprintf("%0*d", exp+1, amount)
+		s = s[:len(s)-exp] + "." + s[len(s)-exp:]
+	}
+
+	return sign + s + " " + m.Currency
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON parses a string like "1500000.00 USD", in the same way as ParseMoney().
+func (m *Money) UnmarshalJSON(jsonValue []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidMoneyFormat
+	}
+
+	parsed, err := ParseMoney(unquoted)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// ParseMoney parses a string like "1500000.00 USD". The amount can have fewer decimal
+// places than the currency, but not more.
+func ParseMoney(s string) (Money, error) {
+	amount, currency, ok := strings.Cut(s, " ")
+	if !ok || amount == "" {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	whole, frac, _ := strings.Cut(amount, ".")
+	if len(frac) > exp || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+
+	minor, err := strconv.ParseInt(whole+frac+strings.Repeat("0", exp-len(frac)), 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidMoneyFormat
+	}
+
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// nullMoney scans the pair of amount and currency columns which hold a Money value
+// that may be NULL.
+type nullMoney struct {
+	amount   sql.NullInt64
+	currency sql.NullString
+}
+
+func (n *nullMoney) money() *Money {
+	if !n.amount.Valid || !n.currency.Valid {
+		return nil
+	}
+
+	return &Money{Amount: n.amount.Int64, Currency: n.currency.String}
+}
+
+// moneyArgs returns the query arguments for storing m in a pair of amount and currency
+// columns.
+func moneyArgs(m *Money) (any, any) {
+	if m == nil {
+		return nil, nil
+	}
+
+	return m.Amount, m.Currency
+}
+
+// A MoneyRange narrows a movie list down to the movies whose amount in a pair of money
+// columns is between Min and Max, inclusive. Either can be nil. Amounts can only be
+// compared in the same currency, so movies in other currencies are left out.
+type MoneyRange struct {
+	Min *Money
+	Max *Money
+}
+
+func (r MoneyRange) empty() bool {
+	return r.Min == nil && r.Max == nil
+}
+
+func (r MoneyRange) currency() string {
+	if r.Min != nil {
+		return r.Min.Currency
+	}
+
+	return r.Max.Currency
+}
+
+// where returns the SQL condition for the range on the <prefix>_amount and
+// <prefix>_currency columns, with its placeholders numbered from firstArg.
+func (r MoneyRange) where(prefix string, firstArg int) (string, []any) {
+	var minAmount, maxAmount any
+	if r.Min != nil {
+		minAmount = r.Min.Amount
+	}
+	if r.Max != nil {
+		maxAmount = r.Max.Amount
+	}
+
+	condition := fmt.Sprintf(`%[1]s_currency = $%[2]d AND %[1]s_amount >= COALESCE($%[3]d::bigint, %[1]s_amount)
+		AND %[1]s_amount <= COALESCE($%[4]d::bigint, %[1]s_amount)`, prefix, firstArg, firstArg+1, firstArg+2)
+
+	return condition, []any{r.currency(), minAmount, maxAmount}
+}
+
+// ValidateMoneyRange checks a range read from the <key>_min and <key>_max query
+// parameters.
+func ValidateMoneyRange(v *validator.Validator, key string, r MoneyRange) {
+	if r.Min == nil || r.Max == nil {
+		return
+	}
+
+	v.Check(r.Min.Currency == r.Max.Currency, key+"_max", "must be in the same currency as "+key+"_min")
+	v.Check(r.Min.Currency != r.Max.Currency || r.Min.Amount <= r.Max.Amount, key+"_max", "must not be less than "+key+"_min")
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// A MovieEvent records a change to the movies table. Events are written by a trigger
+// in the database, which also sends the event ID as a notification on the
+// MovieEventsChannel, so they can't be missed even if the change is made outside the
+// application.
+type MovieEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Event     string          `json:"event"`
+	MovieID   int64           `json:"movie_id"`
+	Movie     json.RawMessage `json:"movie"` // The movie row after the change (or before, for a delete)
+	// The organization the movie belongs to. Events are only streamed to members of
+	// that organization.
+	OrganizationID int64 `json:"-"`
+}
+
+// MovieEventsChannel is the PostgreSQL notification channel for new movie events.
+const MovieEventsChannel = "movie_events"
+
+type MovieEventModel struct {
+	DB *sql.DB
+}
+
+// LatestID returns the ID of the most recent event, or 0 if there are none.
+func (m MovieEventModel) LatestID() (int64, error) {
+	query := `SELECT COALESCE(MAX(id), 0) FROM movie_events`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+// GetSince returns up to limit events with an ID greater than id, oldest first. It's
+// used to replay the events that a client missed while it was disconnected.
+func (m MovieEventModel) GetSince(id int64, limit int) ([]*MovieEvent, error) {
+	query := `
+		SELECT id, created_at, event, movie_id, movie, organization_id
+		FROM movie_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`
+
+	return m.query(query, id, limit)
+}
+
+// GetSinceForOrganization is like GetSince, but only returns the events for one
+// organization's movies.
+func (m MovieEventModel) GetSinceForOrganization(organizationID, id int64, limit int) ([]*MovieEvent, error) {
+	query := `
+		SELECT id, created_at, event, movie_id, movie, organization_id
+		FROM movie_events
+		WHERE id > $1 AND organization_id = $3
+		ORDER BY id
+		LIMIT $2`
+
+	return m.query(query, id, limit, organizationID)
+}
+
+func (m MovieEventModel) query(query string, args ...any) ([]*MovieEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*MovieEvent{}
+
+	for rows.Next() {
+		var event MovieEvent
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Event, &event.MovieID, &event.Movie, &event.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteBefore removes events older than the given time, returning the number of
+// events deleted.
+func (m MovieEventModel) DeleteBefore(before time.Time) (int64, error) {
+	query := `DELETE FROM movie_events WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MovieExportModel stores the files made by movie export jobs, for the user who
+// started the job to download. They're deleted along with the job.
+type MovieExportModel struct {
+	DB *sql.DB
+}
+
+// Insert stores the file made by an export job, replacing the file from any earlier
+// attempt of the job.
+func (m MovieExportModel) Insert(jobID int64, file []byte) error {
+	query := `
+		INSERT INTO movie_exports (job_id, file)
+		VALUES ($1, $2)
+		ON CONFLICT (job_id) DO UPDATE SET file = EXCLUDED.file, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, jobID, file)
+	return err
+}
+
+// Get returns the file made by an export job.
+func (m MovieExportModel) Get(jobID int64) ([]byte, error) {
+	query := `SELECT file FROM movie_exports WHERE job_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var file []byte
+
+	err := m.DB.QueryRowContext(ctx, query, jobID).Scan(&file)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
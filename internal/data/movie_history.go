@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A MovieRevision is one update of a movie: the version it produced, who made it and
+// when, and the fields which changed.
+type MovieRevision struct {
+	Version   int32                     `json:"version"`
+	ChangedAt time.Time                 `json:"changed_at"`
+	ChangedBy *int64                    `json:"changed_by,omitempty"` // Nil if the user has since been deleted
+	Changes   map[string]RevisionChange `json:"changes"`
+}
+
+// A RevisionChange is a field's value before and after a revision, in the same form as
+// the field is shown on the movie.
+type RevisionChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// MovieSnapshot holds the fields of a movie which its history records, as they were
+// at some version. They're stored as JSON in movies_history.
+type MovieSnapshot struct {
+	Title             string   `json:"title"`
+	Year              int32    `json:"year"`
+	Runtime           int32    `json:"runtime"`
+	Genres            []string `json:"genres"`
+	BudgetAmount      *int64   `json:"budget_amount"`
+	BudgetCurrency    *string  `json:"budget_currency"`
+	BoxOfficeAmount   *int64   `json:"box_office_amount"`
+	BoxOfficeCurrency *string  `json:"box_office_currency"`
+	// The links weren't recorded at first, so they're nil in older snapshots.
+	TrailerURL  *string `json:"trailer_url"`
+	HomepageURL *string `json:"homepage_url"`
+	IMDbID      *string `json:"imdb_id"`
+	// Nor were the release dates, certification, original language or synopsis.
+	ReleaseDates     *ReleaseDates `json:"release_dates"`
+	Certification    *string       `json:"certification"`
+	OriginalLanguage *string       `json:"original_language"`
+	Synopsis         *string       `json:"synopsis"`
+}
+
+// revisionSnapshot returns the SQL expression which builds a MovieSnapshot from the
+// movie columns of the given table or alias.
+func revisionSnapshot(alias string) string {
+	return fmt.Sprintf(`jsonb_build_object(
+		'title', %[1]s.title, 'year', %[1]s.year, 'runtime', %[1]s.runtime, 'genres', %[1]s.genres,
+		'budget_amount', %[1]s.budget_amount, 'budget_currency', %[1]s.budget_currency,
+		'box_office_amount', %[1]s.box_office_amount, 'box_office_currency', %[1]s.box_office_currency,
+		'trailer_url', %[1]s.trailer_url, 'homepage_url', %[1]s.homepage_url, 'imdb_id', %[1]s.imdb_id,
+		'release_dates', %[1]s.release_dates, 'certification', %[1]s.certification,
+		'original_language', %[1]s.original_language, 'synopsis', %[1]s.synopsis)`, alias)
+}
+
+// ApplyTo sets the movie's fields to the ones in the snapshot. The fields which were
+// added to the history later are left alone if the snapshot doesn't have them.
+func (s MovieSnapshot) ApplyTo(movie *Movie) {
+	movie.Title = s.Title
+	movie.Year = s.Year
+	movie.Runtime = Runtime(s.Runtime)
+	movie.Genres = s.Genres
+	movie.Budget = snapshotMoney(s.BudgetAmount, s.BudgetCurrency)
+	movie.BoxOffice = snapshotMoney(s.BoxOfficeAmount, s.BoxOfficeCurrency)
+
+	if s.TrailerURL != nil && s.HomepageURL != nil && s.IMDbID != nil {
+		movie.Links = MovieLinks{Trailer: *s.TrailerURL, Homepage: *s.HomepageURL, IMDbID: *s.IMDbID}
+	}
+
+	if s.ReleaseDates != nil {
+		movie.ReleaseDates = *s.ReleaseDates
+	}
+
+	if s.Certification != nil {
+		movie.Certification = *s.Certification
+	}
+
+	if s.OriginalLanguage != nil {
+		movie.OriginalLanguage = *s.OriginalLanguage
+	}
+
+	if s.Synopsis != nil {
+		movie.Synopsis = *s.Synopsis
+	}
+}
+
+// fields returns the snapshot's values keyed by their names on the movie.
+func (s MovieSnapshot) fields() map[string]any {
+	var movie Movie
+	s.ApplyTo(&movie)
+
+	// Snapshots from before release dates were recorded don't have them at all, which
+	// is no different from having none.
+	if len(movie.ReleaseDates) == 0 {
+		movie.ReleaseDates = nil
+	}
+
+	return map[string]any{
+		"title":             movie.Title,
+		"year":              movie.Year,
+		"runtime":           movie.Runtime,
+		"genres":            movie.Genres,
+		"budget":            movie.Budget,
+		"box_office":        movie.BoxOffice,
+		"links":             movie.Links,
+		"release_dates":     movie.ReleaseDates,
+		"certification":     movie.Certification,
+		"original_language": movie.OriginalLanguage,
+		"synopsis":          movie.Synopsis,
+	}
+}
+
+func snapshotMoney(amount *int64, currency *string) *Money {
+	if amount == nil || currency == nil {
+		return nil
+	}
+
+	return &Money{Amount: *amount, Currency: *currency}
+}
+
+// revisionChanges returns the fields which differ between two snapshots.
+func revisionChanges(previous, current MovieSnapshot) map[string]RevisionChange {
+	from, to := previous.fields(), current.fields()
+	changes := map[string]RevisionChange{}
+
+	for field := range from {
+		if !reflect.DeepEqual(from[field], to[field]) {
+			changes[field] = RevisionChange{From: from[field], To: to[field]}
+		}
+	}
+
+	return changes
+}
+
+type MovieHistoryModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns a page of a movie's revisions, most recent first. It doesn't
+// check that the movie exists, so callers should look it up first.
+func (m MovieHistoryModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), version, changed_at, changed_by, previous, current
+		FROM movies_history
+		WHERE movie_id = $1
+		ORDER BY version DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+		var previous, current []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.Version,
+			&revision.ChangedAt,
+			&revision.ChangedBy,
+			&previous,
+			&current,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		var before, after MovieSnapshot
+
+		if err := json.Unmarshal(previous, &before); err != nil {
+			return nil, Metadata{}, err
+		}
+		if err := json.Unmarshal(current, &after); err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revision.Changes = revisionChanges(before, after)
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return revisions, metadata, nil
+}
+
+// GetSnapshot returns a movie's recorded fields as they were at the given version.
+// Only updates change those fields (apart from the IMDb ID, which OMDb can fill in), so
+// they're the values which the first later update replaced. That's only certain if the history goes back to the version, since
+// movies which existed before the history was kept may have had earlier updates. If
+// there's no such update it returns ErrRecordNotFound.
+func (m MovieHistoryModel) GetSnapshot(movieID int64, version int32) (*MovieSnapshot, error) {
+	query := `
+		SELECT previous
+		FROM movies_history
+		WHERE movie_id = $1 AND version > $2
+		AND (version = $2 + 1 OR EXISTS (
+			SELECT 1 FROM movies_history WHERE movie_id = $1 AND version <= $2
+		))
+		ORDER BY version
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var previous []byte
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var snapshot MovieSnapshot
+
+	err = json.Unmarshal(previous, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MovieImportModel stores the files uploaded to be imported into the movie catalog,
+// for the job which imports them to read.
+type MovieImportModel struct {
+	DB *sql.DB
+}
+
+// Insert stores an import file, returning its ID.
+func (m MovieImportModel) Insert(file []byte) (int64, error) {
+	query := `INSERT INTO movie_imports (file) VALUES ($1) RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query, file).Scan(&id)
+	return id, err
+}
+
+// Get returns an import file.
+func (m MovieImportModel) Get(id int64) ([]byte, error) {
+	query := `SELECT file FROM movie_imports WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var file []byte
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&file)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// Delete removes an import file once it's no longer needed.
+func (m MovieImportModel) Delete(id int64) error {
+	query := `DELETE FROM movie_imports WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
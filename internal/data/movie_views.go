@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MovieViewsTTL is how long view counts are kept, which is as far back as trending
+// movies can look.
+const MovieViewsTTL = 31 * 24 * time.Hour
+
+// MovieViews is the number of times a movie's details were viewed in one hour.
+type MovieViews struct {
+	MovieID int64
+	Hour    time.Time
+	Views   int64
+}
+
+type MovieViewModel struct {
+	DB *sql.DB
+}
+
+// Record adds the given view counts to the stored ones, in a single transaction.
+// Movies which have been deleted in the meantime are skipped.
+func (m MovieViewModel) Record(views []MovieViews) error {
+	query := `
+		INSERT INTO movie_views (movie_id, hour, views)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $1)
+		ON CONFLICT (movie_id, hour) DO UPDATE
+		SET views = movie_views.views + EXCLUDED.views`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, v := range views {
+		_, err = stmt.ExecContext(ctx, v.MovieID, v.Hour, v.Views)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBefore removes the view counts for hours before the given time, returning the
+// number of rows deleted.
+func (m MovieViewModel) DeleteBefore(before time.Time) (int64, error) {
+	query := `DELETE FROM movie_views WHERE hour < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -8,7 +8,11 @@ import (
 
 	// "greenlight/anaplo/internal/data"
 
+	"greenlight/anaplo/internal/filter"
 	"greenlight/anaplo/internal/validator"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -21,38 +25,389 @@ import (
 // 	Genres  []string `json:"genres"`  // Slice of genres for the movie (romance, comedy, etc.)
 // }
 
+var (
+	ErrDuplicateTMDBID = errors.New("duplicate tmdb id")
+	ErrNoOrganization  = errors.New("no organization")
+)
+
+// The MovieModel only reads and writes the movies of one organization, so that one
+// organization can never see or change another's catalog. Use ForOrganization() to
+// get a model for the organization a request is made on behalf of.
 type MovieModel struct {
-	DB *sql.DB
+	DB             DBTX
+	OrganizationID int64
+	// If highlight is set, GetAll() marks the words in each title which match the
+	// title search.
+	highlight bool
+	// If fuzzy is set, GetAll() matches titles by trigram similarity instead of by
+	// their words, so that a search with typos still finds them.
+	fuzzy bool
+	// If provider.Provider is set, GetAll() only returns movies available on it.
+	provider WatchProviderQuery
+	// If release.Country is set, GetAll() only returns movies released there between
+	// the dates.
+	release ReleaseQuery
+	// If genres.Any or genres.Not is set, GetAll() only returns movies with one of the
+	// genres in Any and none of those in Not.
+	genres GenreQuery
+	// If certifications is set, GetAll() only returns movies with one of them.
+	certifications []string
+	// If originalLanguage is set, GetAll() only returns movies originally made in it.
+	originalLanguage string
+	// If these are set, GetAll() only returns movies whose budget or box office is in
+	// the range.
+	budget, boxOffice MoneyRange
+	// If withDeleted is set, Get(), GetAll() and Each() include deleted movies.
+	withDeleted bool
+	// The user making changes, who Update() records in the movie's history. It's 0 if
+	// the change isn't made by a user.
+	userID int64
+}
+
+// The WithTx() method returns a copy of the model which runs its queries inside the
+// given transaction.
+func (m MovieModel) WithTx(tx *sql.Tx) MovieModel {
+	m.DB = tx
+	return m
+}
+
+// The ForOrganization() method returns a copy of the model which is scoped to the
+// given organization.
+func (m MovieModel) ForOrganization(id int64) MovieModel {
+	m.OrganizationID = id
+	return m
+}
+
+// The WithWatchProvider() method returns a copy of the model whose GetAll() only
+// returns movies which can be watched on the given provider.
+func (m MovieModel) WithWatchProvider(q WatchProviderQuery) MovieModel {
+	m.provider = q
+	return m
+}
+
+// The ByUser() method returns a copy of the model whose Update() records the given
+// user as the one who made the change.
+func (m MovieModel) ByUser(id int64) MovieModel {
+	m.userID = id
+	return m
+}
+
+// The WithReleaseDates() method returns a copy of the model whose GetAll() only
+// returns movies which were released in the given country between the given dates.
+func (m MovieModel) WithReleaseDates(q ReleaseQuery) MovieModel {
+	m.release = q
+	return m
+}
+
+// The WithGenres() method returns a copy of the model whose GetAll() only returns
+// movies with at least one of the genres in q.Any and none of those in q.Not.
+func (m MovieModel) WithGenres(q GenreQuery) MovieModel {
+	m.genres = q
+	return m
+}
+
+// The WithCertifications() method returns a copy of the model whose GetAll() only
+// returns movies with one of the given certifications.
+func (m MovieModel) WithCertifications(certifications []string) MovieModel {
+	m.certifications = certifications
+	return m
+}
+
+// The WithOriginalLanguage() method returns a copy of the model whose GetAll() only
+// returns movies originally made in the given language.
+func (m MovieModel) WithOriginalLanguage(language string) MovieModel {
+	m.originalLanguage = language
+	return m
+}
+
+// The WithMoneyRanges() method returns a copy of the model whose GetAll() only
+// returns movies whose budget and box office are in the given ranges.
+func (m MovieModel) WithMoneyRanges(budget, boxOffice MoneyRange) MovieModel {
+	m.budget = budget
+	m.boxOffice = boxOffice
+	return m
+}
+
+// The WithFuzzySearch() method returns a copy of the model whose GetAll() matches
+// titles which are similar to the title search, rather than only those containing its
+// words.
+func (m MovieModel) WithFuzzySearch() MovieModel {
+	m.fuzzy = true
+	return m
+}
+
+// The WithDeleted() method returns a copy of the model whose Get(), GetAll() and
+// Each() include movies which have been deleted.
+func (m MovieModel) WithDeleted() MovieModel {
+	m.withDeleted = true
+	return m
+}
+
+// notDeleted returns the SQL condition which leaves out deleted movies, unless the
+// model was made with WithDeleted().
+func (m MovieModel) notDeleted() string {
+	if m.withDeleted {
+		return "TRUE"
+	}
+	return "movies.deleted_at IS NULL"
+}
+
+// The WithHighlights() method returns a copy of the model whose GetAll() results
+// include highlighted titles.
+func (m MovieModel) WithHighlights() MovieModel {
+	m.highlight = true
+	return m
 }
 
 type Movie struct {
-	ID        int64     `json:"id"`                // Unique integer ID for the movie
-	CreatedAt time.Time `json:"created_at"`        // Timestamp for when the movie is added to our database
-	Title     string    `json:"title"`             // Movie title
-	Year      int32     `json:"year,omitempty"`    // Movie release year
-	Runtime   Runtime   `json:"runtime,omitempty"` // Movie runtime (in minutes)
-	Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.)
-	Version   int32     `json:"version"`           // The version number starts at 1 and will be incremented each
+	ID        int64     `json:"id"`                   // Unique integer ID for the movie
+	CreatedAt time.Time `json:"created_at"`           // Timestamp for when the movie is added to our database
+	Title     string    `json:"title"`                // Movie title
+	Year      int32     `json:"year,omitempty"`       // Movie release year, the year of the earliest release date if there are any
+	Runtime   Runtime   `json:"runtime,omitempty"`    // Movie runtime (in minutes)
+	Genres    []string  `json:"genres,omitempty"`     // Slugs of the movie's genres from the catalog (romance, sci-fi, etc.)
+	Version   int32     `json:"version"`              // The version number starts at 1 and will be incremented each
+	TMDBID    *int64    `json:"tmdb_id,omitempty"`    // The movie's ID on TMDB, if it was imported from there
+	Synopsis  string    `json:"synopsis,omitempty"`   // Short plot summary
+	PosterURL string    `json:"poster_url,omitempty"` // URL of the movie's poster image
+	Budget    *Money    `json:"budget,omitempty"`     // What the movie cost to make
+	BoxOffice *Money    `json:"box_office,omitempty"` // What the movie took at the box office
+	// Where to find out more about the movie. The IMDb ID can also be filled in from
+	// OMDb, along with the movie's IMDb rating from 0 to 10.
+	Links      MovieLinks `json:"links"`
+	IMDbRating *float64   `json:"imdb_rating,omitempty"`
+	// When the movie was released in each country, for clients which need more than
+	// the year.
+	ReleaseDates ReleaseDates `json:"release_dates,omitempty"`
+	// The movie's content rating, like PG-13, from the list of certifications the API
+	// is configured with.
+	Certification string `json:"certification,omitempty"`
+	// The ISO 639-1 code of the language the movie was originally made in, like fr.
+	OriginalLanguage string `json:"original_language,omitempty"`
+	// When the movie was deleted. Deleted movies are only shown to admins who ask for
+	// them, and can be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// The mean of users' ratings from 1 to 10, rounded to two decimal places, and how
+	// many ratings there are. The average is nil if nobody has rated the movie.
+	AverageRating *float64 `json:"average_rating,omitempty"`
+	RatingCount   int      `json:"rating_count"`
+	// How many users have marked the movie as a favorite.
+	FavoriteCount int `json:"favorite_count"`
+	// When listing movies with a title search, how well the title and synopsis match
+	// the search and, if asked for, the title as HTML with the matching words in <mark> elements.
+	Relevance *float64 `json:"relevance,omitempty"`
+	Highlight string   `json:"highlight,omitempty"`
+	// When listing movies, the box office divided by the budget, if both are known and
+	// in the same currency.
+	Profitability *float64 `json:"profitability,omitempty"`
+	// Where the movie can be watched. This is only filled in when showing or listing
+	// movies.
+	WatchProviders []WatchProvider `json:"watch_providers,omitempty"`
+	// The collection the movie is in, like a franchise, and its position there. This is
+	// also only filled in when showing or listing movies.
+	Collection *MovieCollection `json:"collection,omitempty"`
+	// The most recent of the movie's reviews, when the client asks for them with
+	// include=reviews.
+	Reviews []*Review `json:"reviews,omitempty"`
+	// When listing trending movies, how many times the movie was viewed in the window.
+	Views *int64 `json:"views,omitempty"`
+	// If the title has been translated into the language the client asked for, the
+	// language of the title and the original title it replaced.
+	TitleLanguage string `json:"title_language,omitempty"`
+	OriginalTitle string `json:"original_title,omitempty"`
+}
+
+// MovieLinks are where to find out more about a movie elsewhere.
+type MovieLinks struct {
+	Trailer  string `json:"trailer,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+	IMDbID   string `json:"imdb_id,omitempty"`
+}
+
+// OriginalLanguageRX matches ISO 639-1 language codes, like en or fr.
+var OriginalLanguageRX = regexp.MustCompile(`^[a-z]{2}$`)
+
+// IMDbIDRX matches IMDb title IDs, like tt0111161.
+var IMDbIDRX = regexp.MustCompile(`^tt[0-9]{7,10}$`)
+
+func ValidateMovieLinks(v *validator.Validator, links MovieLinks) {
+	for key, raw := range map[string]string{"links.trailer": links.Trailer, "links.homepage": links.Homepage} {
+		if raw == "" {
+			continue
+		}
+
+		u, err := url.Parse(raw)
+		v.Check(len(raw) <= 2048, key, "must not be more than 2048 bytes long")
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", key, "must be an absolute http or https URL")
+	}
+
+	if links.IMDbID != "" {
+		v.Check(v.Matches(links.IMDbID, IMDbIDRX), "links.imdb_id", "must be an IMDb title ID, like tt0111161")
+	}
 }
 
+// movieStatsColumns are selected after the movie's own columns, from the movie_stats
+// table joined onto movies. Movies which haven't been rated or favorited don't have a
+// row there yet. The favorite count is named so that movie lists can be sorted by it.
+const movieStatsColumns = `COALESCE(movie_stats.rating_count, 0),
+			round(movie_stats.rating_sum::numeric / NULLIF(movie_stats.rating_count, 0), 2)::float8,
+			COALESCE(movie_stats.favorite_count, 0) AS favorite_count`
+
+// movieSearchMatch is the condition for a movie's search vector (its title and
+// synopsis) or one of its translated titles to contain the words searched for in $1,
+// and movieSearchRank is how well the best of them matches. Words in a title, original
+// or translated, have weight A and words in the synopsis weight B, so a match in the
+// title ranks higher.
+const (
+	movieSearchMatch = `(search @@ plainto_tsquery('simple', $1) OR EXISTS (
+				SELECT 1 FROM movie_translations
+				WHERE movie_translations.movie_id = movies.id
+				AND to_tsvector('simple', movie_translations.title) @@ plainto_tsquery('simple', $1)))`
+	movieSearchRank = `GREATEST(ts_rank(search, plainto_tsquery('simple', $1)), (
+				SELECT max(ts_rank(setweight(to_tsvector('simple', movie_translations.title), 'A'), plainto_tsquery('simple', $1)))
+				FROM movie_translations WHERE movie_translations.movie_id = movies.id))`
+)
+
+// movieFuzzyTitleMatch is the condition for a movie's title to be similar to the one
+// searched for in $1, with the similarity it must reach in the placeholder numbered by
+// %d, and movieFuzzyTitleRank is how similar it is. Titles are normalized like they are
+// for finding duplicates, so that the trigram index on them is used. Translated titles
+// aren't searched.
+const (
+	movieFuzzyTitleMatch = `(normalize_title($1) <%% normalize_title(title)
+				AND word_similarity(normalize_title($1), normalize_title(title)) >= $%d)`
+	movieFuzzyTitleRank = `word_similarity(normalize_title($1), normalize_title(title))`
+)
+
+// FuzzySearchSimilarity is how similar a title must be to a fuzzy title search, by
+// trigram word similarity, to match it. The <% operator which uses the index has its
+// own threshold of 0.6 by default, so this can't usefully be set any lower.
+const FuzzySearchSimilarity = 0.6
+
+// movieProfitability is how many times over a movie made back its budget at the box
+// office. It's NULL unless both are known and in the same currency.
+const movieProfitability = `CASE WHEN budget_currency = box_office_currency AND budget_amount > 0
+				THEN round(box_office_amount::numeric / budget_amount, 2)::float8 END`
+
 func (m MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4)
+	// A user who doesn't belong to any organization has nowhere to add the movie.
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	query := `INSERT INTO movies (title, year, runtime, genres, tmdb_id, synopsis, poster_url, organization_id,
+				budget_amount, budget_currency, box_office_amount, box_office_currency, trailer_url, homepage_url, imdb_id, release_dates, certification,
+				original_language)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 				RETURNING id, created_at, version`
 
+	budgetAmount, budgetCurrency := moneyArgs(movie.Budget)
+	boxOfficeAmount, boxOfficeCurrency := moneyArgs(movie.BoxOffice)
+
 	//create arguments slice
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.TMDBID, movie.Synopsis, movie.PosterURL, m.OrganizationID,
+		budgetAmount, budgetCurrency, boxOfficeAmount, boxOfficeCurrency, movie.Links.Trailer, movie.Links.Homepage, movie.Links.IMDbID,
+		movie.ReleaseDates, movie.Certification, movie.OriginalLanguage}
 
-	return m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movies_organization_id_tmdb_id_key"`:
+			return ErrDuplicateTMDBID
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CopyIn adds a batch of movies in one go with COPY, which is much faster than
+// inserting them one at a time. It needs its own transaction, since COPY can't be
+// mixed with other queries, and the movies' IDs aren't filled in.
+func (m MovieModel) CopyIn(tx *sql.Tx, movies []*Movie) error {
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies", "title", "year", "runtime", "genres", "tmdb_id", "imdb_id", "synopsis", "poster_url",
+		"organization_id", "budget_amount", "budget_currency", "box_office_amount", "box_office_currency"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		budgetAmount, budgetCurrency := moneyArgs(movie.Budget)
+		boxOfficeAmount, boxOfficeCurrency := moneyArgs(movie.BoxOffice)
+
+		_, err = stmt.ExecContext(ctx, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.TMDBID, movie.Links.IMDbID,
+			movie.Synopsis, movie.PosterURL, m.OrganizationID, budgetAmount, budgetCurrency, boxOfficeAmount, boxOfficeCurrency)
+		if err != nil {
+			return err
+		}
+	}
+
+	// An Exec() with no arguments sends the buffered rows and ends the COPY.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `"movies_organization_id_tmdb_id_key"`):
+			return ErrDuplicateTMDBID
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
+// Update saves the movie's changes and records them in movies_history, along with the
+// user making them, in the same statement. The old row is locked while it's read so
+// that the history always has the values which were actually replaced.
 func (m MovieModel) Update(movie *Movie) error {
 	// update only if version matches the expected one
 	// to avoid race conditions
-	query := `UPDATE movies
-				SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 
-				WHERE id = $5 AND version = $6
-				RETURNING version`
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version}
+	query := fmt.Sprintf(`
+		WITH old AS (
+			SELECT id, title, year, runtime, genres, budget_amount, budget_currency, box_office_amount, box_office_currency,
+				trailer_url, homepage_url, imdb_id, release_dates, certification, original_language, synopsis
+			FROM movies
+			WHERE id = $5 AND version = $6 AND organization_id = $7 AND deleted_at IS NULL
+			FOR UPDATE
+		), updated AS (
+			UPDATE movies
+			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1,
+			budget_amount = $8, budget_currency = $9, box_office_amount = $10, box_office_currency = $11,
+			trailer_url = $13, homepage_url = $14, imdb_id = $15, release_dates = $16,
+			certification = $17, original_language = $18, synopsis = $19
+			FROM old
+			WHERE movies.id = old.id
+			RETURNING movies.id, movies.version, movies.title, movies.year, movies.runtime, movies.genres,
+				movies.budget_amount, movies.budget_currency, movies.box_office_amount, movies.box_office_currency,
+				movies.trailer_url, movies.homepage_url, movies.imdb_id, movies.release_dates, movies.certification,
+				movies.original_language, movies.synopsis
+		), history AS (
+			INSERT INTO movies_history (movie_id, version, changed_by, previous, current)
+			SELECT updated.id, updated.version, $12::bigint, %s, %s
+			FROM old, updated
+		)
+		SELECT version FROM updated`, revisionSnapshot("old"), revisionSnapshot("updated"))
+
+	budgetAmount, budgetCurrency := moneyArgs(movie.Budget)
+	boxOfficeAmount, boxOfficeCurrency := moneyArgs(movie.BoxOffice)
+
+	var changedBy *int64
+	if m.userID != 0 {
+		changedBy = &m.userID
+	}
+
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version, m.OrganizationID,
+		budgetAmount, budgetCurrency, boxOfficeAmount, boxOfficeCurrency, changedBy, movie.Links.Trailer, movie.Links.Homepage, movie.Links.IMDbID,
+		movie.ReleaseDates, movie.Certification, movie.OriginalLanguage, movie.Synopsis}
 
 	// Use the QueryRow() method to execute the query, passing in the args slice as a
 	// variadic parameter and scanning the new version value into the movie struct.
@@ -72,32 +427,114 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
+// SetPosterURL changes just the movie's poster URL, using the version field in the
+// same way as Update().
+func (m MovieModel) SetPosterURL(movie *Movie) error {
+	query := `UPDATE movies
+				SET poster_url = $1, version = version + 1
+				WHERE id = $2 AND version = $3 AND organization_id = $4 AND deleted_at IS NULL
+				RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movie.PosterURL, movie.ID, movie.Version, m.OrganizationID).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetOMDbDetails stores the details fetched from OMDb for a movie, using the version
+// field in the same way as Update(). Only the synopsis, poster URL and IMDb fields are
+// changed, so that edits made while the details were being fetched aren't lost.
+func (m MovieModel) SetOMDbDetails(movie *Movie) error {
+	query := `UPDATE movies
+				SET synopsis = $1, poster_url = $2, imdb_id = $3, imdb_rating = $4, version = version + 1
+				WHERE id = $5 AND version = $6 AND organization_id = $7 AND deleted_at IS NULL
+				RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{movie.Synopsis, movie.PosterURL, movie.Links.IMDbID, movie.IMDbRating, movie.ID, movie.Version, m.OrganizationID}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete soft-deletes a movie by setting its deleted_at time, so that it can be
+// restored later. Its reviews, ratings and so on are kept.
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := "DELETE FROM movies WHERE id=$1"
+	query := `UPDATE movies SET deleted_at = NOW(), version = version + 1
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, id)
+	res, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
 	if err != nil {
 		return err
 	}
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		switch {
-		// If no rows were affected, we know that the movies table didn't contain a record
-		// with the provided ID at the moment we tried to delete it. In that case we
-		// return an ErrRecordNotFound error.
-		case rowsAffected == 0:
-			return ErrRecordNotFound
-		default:
-			return err
-		}
+		return err
+	}
+
+	// If no rows were affected, there's no movie with that ID which hasn't already
+	// been deleted.
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Restore undoes the deletion of a movie. It returns ErrRecordNotFound if there's no
+// deleted movie with that ID.
+func (m MovieModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `UPDATE movies SET deleted_at = NULL, version = version + 1
+				WHERE id = $1 AND organization_id = $2 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 
 	return nil
@@ -110,11 +547,13 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, title, year, runtime, genres, version FROM movies
-				WHERE id = $1`
+	query := `SELECT id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, ` + movieStatsColumns + ` FROM movies
+				LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+				WHERE id = $1 AND organization_id = $2 AND ` + m.notDeleted()
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
+	var budget, boxOffice nullMoney
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Importantly, use defer to make sure that we cancel the context before the Get()
@@ -125,7 +564,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// context (which in this specific example is context.Background()) is canceled.
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -133,6 +572,24 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.TMDBID,
+		&movie.Synopsis,
+		&movie.PosterURL,
+		&movie.Links.IMDbID,
+		&movie.IMDbRating,
+		&movie.Links.Trailer,
+		&movie.Links.Homepage,
+		&movie.ReleaseDates,
+		&movie.Certification,
+		&movie.OriginalLanguage,
+		&movie.DeletedAt,
+		&budget.amount,
+		&budget.currency,
+		&boxOffice.amount,
+		&boxOffice.currency,
+		&movie.RatingCount,
+		&movie.AverageRating,
+		&movie.FavoriteCount,
 	)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
@@ -145,6 +602,65 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 			return nil, err
 		}
 	}
+	movie.Budget = budget.money()
+	movie.BoxOffice = boxOffice.money()
+
+	return &movie, nil
+}
+
+// GetByTMDBID returns the movie which was imported from TMDB with the given ID. A
+// deleted movie is returned too, since its TMDB ID stays taken while it can still be
+// restored.
+func (m MovieModel) GetByTMDBID(tmdbID int64) (*Movie, error) {
+	query := `SELECT id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, ` + movieStatsColumns + ` FROM movies
+				LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+				WHERE tmdb_id = $1 AND organization_id = $2`
+
+	var movie Movie
+	var budget, boxOffice nullMoney
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tmdbID, m.OrganizationID).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.TMDBID,
+		&movie.Synopsis,
+		&movie.PosterURL,
+		&movie.Links.IMDbID,
+		&movie.IMDbRating,
+		&movie.Links.Trailer,
+		&movie.Links.Homepage,
+		&movie.ReleaseDates,
+		&movie.Certification,
+		&movie.OriginalLanguage,
+		&movie.DeletedAt,
+		&budget.amount,
+		&budget.currency,
+		&boxOffice.amount,
+		&boxOffice.currency,
+		&movie.RatingCount,
+		&movie.AverageRating,
+		&movie.FavoriteCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	movie.Budget = budget.money()
+	movie.BoxOffice = boxOffice.money()
+
 	return &movie, nil
 }
 
@@ -153,17 +669,108 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 // arguments.
 // Add order by id as a secondary order clause
 // to ensure the same order on every query
-func (m *MovieModel) GetAll(title string, genres []string, filter Filters) ([]*Movie, Metadata, error) {
+// The id is also what makes cursors work: when there's a next page, the metadata has
+// a cursor for it, and with filter.Cursor set the page starts after that movie.
+func (m MovieModel) GetAll(title string, genres []string, filter Filters) ([]*Movie, Metadata, error) {
+	where, whereArgs := filter.where(6)
+
+	if m.provider.Provider != "" {
+		n := 6 + len(whereArgs)
+		where += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM movie_watch_providers
+			WHERE movie_watch_providers.movie_id = movies.id AND movie_watch_providers.provider = $%d
+			AND ($%d = '' OR movie_watch_providers.region = $%d) AND ($%d = '' OR movie_watch_providers.type = $%d))`,
+			n, n+1, n+1, n+2, n+2)
+		whereArgs = append(whereArgs, m.provider.Provider, m.provider.Region, m.provider.Type)
+	}
+
+	if m.release.Country != "" {
+		n := 6 + len(whereArgs)
+		where += fmt.Sprintf(` AND (release_dates->>$%d)::date > COALESCE($%d::date, '-infinity')
+			AND (release_dates->>$%d)::date < COALESCE($%d::date, 'infinity')`, n, n+1, n, n+2)
+		whereArgs = append(whereArgs, m.release.Country, dateArg(m.release.After), dateArg(m.release.Before))
+	}
+
+	// Like the genres parameter, overlap can use the movies_genres_idx index. The
+	// exclusion can't, so it's only a filter on the movies the rest of the query finds.
+	if len(m.genres.Any) > 0 {
+		where += fmt.Sprintf(` AND genres && $%d`, 6+len(whereArgs))
+		whereArgs = append(whereArgs, pq.Array(m.genres.Any))
+	}
+
+	if len(m.genres.Not) > 0 {
+		where += fmt.Sprintf(` AND NOT genres && $%d`, 6+len(whereArgs))
+		whereArgs = append(whereArgs, pq.Array(m.genres.Not))
+	}
+
+	if len(m.certifications) > 0 {
+		where += fmt.Sprintf(` AND certification = ANY($%d)`, 6+len(whereArgs))
+		whereArgs = append(whereArgs, pq.Array(m.certifications))
+	}
+
+	if m.originalLanguage != "" {
+		where += fmt.Sprintf(` AND original_language = $%d`, 6+len(whereArgs))
+		whereArgs = append(whereArgs, m.originalLanguage)
+	}
+
+	for prefix, r := range map[string]MoneyRange{"budget": m.budget, "box_office": m.boxOffice} {
+		if !r.empty() {
+			condition, args := r.where(prefix, 6+len(whereArgs))
+			where += " AND " + condition
+			whereArgs = append(whereArgs, args...)
+		}
+	}
+
+	titleMatch, titleRank := movieSearchMatch, movieSearchRank
+	if m.fuzzy {
+		titleMatch = fmt.Sprintf(movieFuzzyTitleMatch, 6+len(whereArgs))
+		titleRank = movieFuzzyTitleRank
+		whereArgs = append(whereArgs, FuzzySearchSimilarity)
+	}
+
+	// The sort columns which are worked out by the query are compared with the cursor
+	// by their expressions, since the WHERE clause can't refer to them by name.
+	sortExpression := filter.sortColumn()
+	switch sortExpression {
+	case "relevance":
+		sortExpression = fmt.Sprintf(`CASE WHEN $1 = '' THEN NULL ELSE %s END`, titleRank)
+	case "profitability":
+		sortExpression = movieProfitability
+	case "favorite_count":
+		sortExpression = `COALESCE(movie_stats.favorite_count, 0)`
+	}
+
+	after, afterArgs := filter.after(sortExpression, 6+len(whereArgs))
+	where += " AND " + after
+	whereArgs = append(whereArgs, afterArgs...)
+
+	// The title is escaped before it's highlighted, so that the result is safe to
+	// display as HTML. The text search parser skips the entities this produces.
+	highlight := `''`
+	if m.highlight {
+		highlight = `CASE WHEN $1 = '' THEN '' ELSE ts_headline('simple',
+				replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+				plainto_tsquery('simple', $1), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') END`
+	}
+
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version FROM movies
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND (genres @> $2 OR $2 = '{}')
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filter.sortColumn(), filter.sortDirection())
+			SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, %s,
+			CASE WHEN $1 = '' THEN NULL ELSE %s END AS relevance,
+			%s AS profitability,
+			%s,
+			(%s)::text AS sort_value
+			FROM movies
+			LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+			WHERE (%s OR $1 = '') AND (genres @> $2 OR $2 = '{}')
+			AND organization_id = $5 AND %s AND %s
+			ORDER BY %s %s NULLS LAST, id ASC
+			LIMIT $3 OFFSET $4`, movieStatsColumns, titleRank, movieProfitability, highlight, sortExpression, titleMatch, m.notDeleted(), where, filter.sortColumn(), filter.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filter.limit(), filter.offset()}
+	args := []any{title, pq.Array(genres), filter.limit(), filter.offset(), m.OrganizationID}
+	args = append(args, whereArgs...)
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -179,10 +786,13 @@ func (m *MovieModel) GetAll(title string, genres []string, filter Filters) ([]*M
 	// create separate variable to store
 	// total records count
 	totalRecords := 0
+	// and the last movie's value of the sort column, for the next page's cursor
+	var sortValue sql.NullString
 
 	// go through every row in result set
 	for rows.Next() {
 		var movie Movie
+		var budget, boxOffice nullMoney
 
 		err := rows.Scan(
 			&totalRecords,
@@ -191,13 +801,38 @@ func (m *MovieModel) GetAll(title string, genres []string, filter Filters) ([]*M
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
-			pq.Array(movie.Genres),
+			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.TMDBID,
+			&movie.Synopsis,
+			&movie.PosterURL,
+			&movie.Links.IMDbID,
+			&movie.IMDbRating,
+			&movie.Links.Trailer,
+			&movie.Links.Homepage,
+			&movie.ReleaseDates,
+			&movie.Certification,
+			&movie.OriginalLanguage,
+			&movie.DeletedAt,
+			&budget.amount,
+			&budget.currency,
+			&boxOffice.amount,
+			&boxOffice.currency,
+			&movie.RatingCount,
+			&movie.AverageRating,
+			&movie.FavoriteCount,
+			&movie.Relevance,
+			&movie.Profitability,
+			&movie.Highlight,
+			&sortValue,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		movie.Budget = budget.money()
+		movie.BoxOffice = boxOffice.money()
+
 		movies = append(movies, &movie)
 	}
 
@@ -205,11 +840,464 @@ func (m *MovieModel) GetAll(title string, genres []string, filter Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filter.PageSize, filter.Page)
+	metadata := calculateMetadata(totalRecords, filter)
+
+	if len(movies) > 0 {
+		metadata.NextCursor = filter.nextCursor(totalRecords, len(movies), sortValue, movies[len(movies)-1].ID)
+	}
 
 	return movies, metadata, nil
 }
 
+// Each calls fn with each movie matching the same title search, genres and filter
+// expression as GetAll(), in the same order, but without paging. The movies are read
+// one at a time, so that a whole catalog can be exported without holding it in
+// memory. If fn returns an error, Each stops and returns it.
+func (m MovieModel) Each(title string, genres []string, filter Filters, fn func(*Movie) error) error {
+	where, whereArgs := filter.where(4)
+
+	query := fmt.Sprintf(`
+			SELECT id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, %s,
+			CASE WHEN $1 = '' THEN NULL ELSE %s END AS relevance,
+			%s AS profitability
+			FROM movies
+			LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+			WHERE (%s OR $1 = '') AND (genres @> $2 OR $2 = '{}')
+			AND organization_id = $3 AND %s AND %s
+			ORDER BY %s %s NULLS LAST, id ASC`, movieStatsColumns, movieSearchRank, movieProfitability, movieSearchMatch, m.notDeleted(), where, filter.sortColumn(), filter.sortDirection())
+
+	// Exports can take a while, so allow much longer than for a single page.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	args := []any{title, pq.Array(genres), m.OrganizationID}
+	args = append(args, whereArgs...)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+		var budget, boxOffice nullMoney
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.TMDBID,
+			&movie.Synopsis,
+			&movie.PosterURL,
+			&movie.Links.IMDbID,
+			&movie.IMDbRating,
+			&movie.Links.Trailer,
+			&movie.Links.Homepage,
+			&movie.ReleaseDates,
+			&movie.Certification,
+			&movie.OriginalLanguage,
+			&movie.DeletedAt,
+			&budget.amount,
+			&budget.currency,
+			&boxOffice.amount,
+			&boxOffice.currency,
+			&movie.RatingCount,
+			&movie.AverageRating,
+			&movie.FavoriteCount,
+			&movie.Relevance,
+			&movie.Profitability,
+		)
+		if err != nil {
+			return err
+		}
+
+		movie.Budget = budget.money()
+		movie.BoxOffice = boxOffice.money()
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Count returns how many movies Each() would call fn with, so that an export can
+// report its progress.
+func (m MovieModel) Count(title string, genres []string, filter Filters) (int, error) {
+	where, whereArgs := filter.where(4)
+
+	query := fmt.Sprintf(`
+		SELECT count(*)
+		FROM movies
+		LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+		WHERE (%s OR $1 = '') AND (genres @> $2 OR $2 = '{}')
+		AND organization_id = $3 AND %s AND %s`, movieSearchMatch, m.notDeleted(), where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := []any{title, pq.Array(genres), m.OrganizationID}
+	args = append(args, whereArgs...)
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetTrending returns a page of the movies whose details were viewed the most since
+// the given time, most viewed first. Movies which weren't viewed at all are left out.
+func (m MovieModel) GetTrending(since time.Time, filter Filters) ([]*Movie, Metadata, error) {
+	query := `
+			SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, ` + movieStatsColumns + `, views.views
+			FROM movies
+			INNER JOIN (
+				SELECT movie_id, sum(views)::bigint AS views
+				FROM movie_views
+				WHERE hour >= $1
+				GROUP BY movie_id
+			) AS views ON views.movie_id = movies.id
+			LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+			WHERE organization_id = $2 AND deleted_at IS NULL
+			ORDER BY views.views DESC, id ASC
+			LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, m.OrganizationID, filter.limit(), filter.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var movie Movie
+		var budget, boxOffice nullMoney
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.TMDBID,
+			&movie.Synopsis,
+			&movie.PosterURL,
+			&movie.Links.IMDbID,
+			&movie.IMDbRating,
+			&movie.Links.Trailer,
+			&movie.Links.Homepage,
+			&movie.ReleaseDates,
+			&movie.Certification,
+			&movie.OriginalLanguage,
+			&movie.DeletedAt,
+			&budget.amount,
+			&budget.currency,
+			&boxOffice.amount,
+			&boxOffice.currency,
+			&movie.RatingCount,
+			&movie.AverageRating,
+			&movie.FavoriteCount,
+			&movie.Views,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.Budget = budget.money()
+		movie.BoxOffice = boxOffice.money()
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filter), nil
+}
+
+// GetCreatedSince returns up to limit movies added to the catalog after the given
+// time, newest first.
+func (m MovieModel) GetCreatedSince(since time.Time, limit int) ([]*Movie, error) {
+	query := `
+			SELECT id, created_at, title, year, runtime, genres, version, tmdb_id, synopsis, poster_url, imdb_id, imdb_rating, trailer_url, homepage_url, release_dates, certification, original_language, deleted_at, budget_amount, budget_currency, box_office_amount, box_office_currency, ` + movieStatsColumns + ` FROM movies
+			LEFT JOIN movie_stats ON movie_stats.movie_id = movies.id
+			WHERE created_at > $1 AND organization_id = $3 AND deleted_at IS NULL
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, limit, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		var budget, boxOffice nullMoney
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.TMDBID,
+			&movie.Synopsis,
+			&movie.PosterURL,
+			&movie.Links.IMDbID,
+			&movie.IMDbRating,
+			&movie.Links.Trailer,
+			&movie.Links.Homepage,
+			&movie.ReleaseDates,
+			&movie.Certification,
+			&movie.OriginalLanguage,
+			&movie.DeletedAt,
+			&budget.amount,
+			&budget.currency,
+			&boxOffice.amount,
+			&boxOffice.currency,
+			&movie.RatingCount,
+			&movie.AverageRating,
+			&movie.FavoriteCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.Budget = budget.money()
+		movie.BoxOffice = boxOffice.money()
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// A MovieSelection picks out movies to act on in bulk, by genre, a range of years
+// and a filter expression. Empty criteria match every movie, so callers must make sure
+// that at least one is set.
+type MovieSelection struct {
+	Genre      string
+	YearMin    int
+	YearMax    int
+	Expression *filter.Expr
+}
+
+// Empty reports whether the selection has no criteria, and so would match every movie.
+func (s MovieSelection) Empty() bool {
+	return s.Genre == "" && s.YearMin == 0 && s.YearMax == 0 && s.Expression == nil
+}
+
+func ValidateMovieSelection(v *validator.Validator, s MovieSelection) {
+	v.Check(!s.Empty(), "filter", "at least one of genre, year_min, year_max or filter must be provided")
+
+	if s.Genre != "" {
+		v.Check(v.Matches(s.Genre, SlugRX), "genre", "must be a genre slug, like sci-fi")
+	}
+
+	if s.YearMin != 0 && s.YearMax != 0 {
+		v.Check(s.YearMin <= s.YearMax, "year_min", "must not be greater than year_max")
+	}
+}
+
+// SelectForUpdate returns the IDs of the selected movies, locking them until the
+// end of the transaction, so it should be used with WithTx(). At most limit IDs are
+// returned, so a result with exactly limit IDs may mean that more movies matched.
+func (m MovieModel) SelectForUpdate(s MovieSelection, limit int) ([]int64, error) {
+	where, whereArgs := Filters{Expression: s.Expression}.where(6)
+
+	query := fmt.Sprintf(`
+		SELECT id
+		FROM movies
+		WHERE organization_id = $1 AND deleted_at IS NULL AND ($2 = '' OR genres @> ARRAY[$2])
+		AND ($3 = 0 OR year >= $3) AND ($4 = 0 OR year <= $4) AND %s
+		ORDER BY id
+		LIMIT $5
+		FOR UPDATE`, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{m.OrganizationID, s.Genre, s.YearMin, s.YearMax, limit}
+	args = append(args, whereArgs...)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// DeleteAll soft-deletes the movies with the given IDs, like Delete(), returning how
+// many there were.
+func (m MovieModel) DeleteAll(ids []int64) (int64, error) {
+	query := `UPDATE movies SET deleted_at = NOW(), version = version + 1
+				WHERE id = ANY($1) AND organization_id = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, pq.Array(ids), m.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// LatestID returns the ID of the most recently added movie, or zero if there are none.
+func (m MovieModel) LatestID() (int64, error) {
+	query := `SELECT COALESCE(max(id), 0) FROM movies WHERE organization_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, m.OrganizationID).Scan(&id)
+	return id, err
+}
+
+// A DuplicateCandidate is an existing movie which is probably the same as one about
+// to be created, with how similar its title is from 0 to 1.
+type DuplicateCandidate struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Year       int32   `json:"year"`
+	Similarity float64 `json:"similarity"`
+}
+
+// DuplicateSimilarity is how similar two normalized titles must be, by trigram
+// similarity, for movies in the same year to be considered probable duplicates.
+const DuplicateSimilarity = 0.6
+
+// FindDuplicates returns the movies from the same year whose title is similar to the
+// given one, ignoring case, punctuation and a leading article. The closest matches
+// come first.
+func (m MovieModel) FindDuplicates(title string, year int32) ([]*DuplicateCandidate, error) {
+	query := `
+		SELECT id, title, year, similarity(normalize_title(title), normalize_title($1)) AS similarity
+		FROM movies
+		WHERE organization_id = $2 AND year = $3 AND deleted_at IS NULL
+		AND normalize_title(title) % normalize_title($1)
+		AND similarity(normalize_title(title), normalize_title($1)) >= $4
+		ORDER BY similarity DESC, id
+		LIMIT 5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, m.OrganizationID, year, DuplicateSimilarity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*DuplicateCandidate{}
+
+	for rows.Next() {
+		var c DuplicateCandidate
+
+		err := rows.Scan(&c.ID, &c.Title, &c.Year, &c.Similarity)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// MovieSortSafelist is what movie lists can be sorted on. Sorting by relevance is only
+// meaningful with a title search, and sorting by favorite_count orders by popularity.
+// Movies without a profitability come last when sorting by it either way.
+var MovieSortSafelist = SortSafelist("id", "title", "year", "runtime", "relevance", "favorite_count", "profitability")
+
+// MovieFilterFields are the fields which can be used in a filter expression when
+// listing movies.
+var MovieFilterFields = map[string]filter.Field{
+	"id":                {Column: "id", Type: filter.Int},
+	"title":             {Column: "title", Type: filter.Text},
+	"year":              {Column: "year", Type: filter.Int},
+	"runtime":           {Column: "runtime", Type: filter.Int},
+	"genre":             {Column: "genres", Type: filter.Array},
+	"has_trailer":       {Column: "(trailer_url <> '')", Type: filter.Bool},
+	"certification":     {Column: "certification", Type: filter.Text},
+	"original_language": {Column: "original_language", Type: filter.Text},
+	// The languages the movie's title has been translated into.
+	"language": {Column: "ARRAY(SELECT language FROM movie_translations WHERE movie_translations.movie_id = movies.id)", Type: filter.Array},
+	// Money is compared in the currency's minor units, so budget>=100000000 AND
+	// budget_currency=USD finds movies which cost at least a million dollars.
+	"budget":              {Column: "budget_amount", Type: filter.Int},
+	"budget_currency":     {Column: "budget_currency", Type: filter.Text},
+	"box_office":          {Column: "box_office_amount", Type: filter.Int},
+	"box_office_currency": {Column: "box_office_currency", Type: filter.Text},
+}
+
+// ValidateCertification checks a movie's certification against the ones which are
+// permitted. A movie doesn't have to have one.
+func ValidateCertification(v *validator.Validator, certification string, permitted []string) {
+	if certification == "" {
+		return
+	}
+
+	v.Check(validator.PermittedValues(certification, permitted...), "certification", "must be one of "+strings.Join(permitted, ", "))
+}
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
@@ -222,4 +1310,17 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	ValidateMoney(v, "budget", movie.Budget)
+	ValidateMoney(v, "box_office", movie.BoxOffice)
+	v.Check(len(movie.Synopsis) <= 10000, "synopsis", "must not be more than 10000 bytes long")
+	ValidateMovieLinks(v, movie.Links)
+	ValidateReleaseDates(v, movie.ReleaseDates)
+
+	if movie.OriginalLanguage != "" {
+		v.Check(v.Matches(movie.OriginalLanguage, OriginalLanguageRX), "original_language", "must be a two-letter ISO 639-1 language code, like fr")
+	}
+
+	if earliest := movie.ReleaseDates.Earliest(); !earliest.IsZero() {
+		v.Check(movie.Year == int32(earliest.Year()), "year", "must be the year of the earliest release date")
+	}
 }
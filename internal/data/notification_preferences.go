@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define constants for the kinds of notification which users can opt out of.
+const (
+	NotifyDigest             = "digest"
+	NotifySecurityAlerts     = "security_alerts"
+	NotifySavedSearchMatches = "saved_search_matches"
+	NotifyAnnouncements      = "announcements"
+)
+
+// Define constants for the channels which notifications are sent over.
+const (
+	ChannelEmail = "email"
+	ChannelInApp = "in_app"
+	ChannelPush  = "push"
+)
+
+// NotificationPreferences control which notifications a user receives. A notification
+// is only sent if both its kind and the channel it would be sent over are enabled.
+// Users who have never changed their preferences receive everything.
+type NotificationPreferences struct {
+	UserID             int64 `json:"-"`
+	Email              bool  `json:"email"`
+	InApp              bool  `json:"in_app"`
+	Push               bool  `json:"push"`
+	Digest             bool  `json:"digest"`
+	SecurityAlerts     bool  `json:"security_alerts"`
+	SavedSearchMatches bool  `json:"saved_search_matches"`
+	Announcements      bool  `json:"announcements"`
+	Version            int32 `json:"version"`
+}
+
+// Allows reports whether a notification of the given kind may be sent over the given
+// channel.
+func (p *NotificationPreferences) Allows(kind, channel string) bool {
+	switch channel {
+	case ChannelEmail:
+		if !p.Email {
+			return false
+		}
+	case ChannelInApp:
+		if !p.InApp {
+			return false
+		}
+	case ChannelPush:
+		if !p.Push {
+			return false
+		}
+	}
+
+	switch kind {
+	case NotifyDigest:
+		return p.Digest
+	case NotifySecurityAlerts:
+		return p.SecurityAlerts
+	case NotifySavedSearchMatches:
+		return p.SavedSearchMatches
+	case NotifyAnnouncements:
+		return p.Announcements
+	}
+
+	return true
+}
+
+// Disable turns off notifications of the given kind, or all optional emails if the
+// kind is ChannelEmail. It returns false if the kind isn't one users can opt out of.
+func (p *NotificationPreferences) Disable(kind string) bool {
+	switch kind {
+	case ChannelEmail:
+		p.Email = false
+	case NotifyDigest:
+		p.Digest = false
+	case NotifySecurityAlerts:
+		p.SecurityAlerts = false
+	case NotifySavedSearchMatches:
+		p.SavedSearchMatches = false
+	case NotifyAnnouncements:
+		p.Announcements = false
+	default:
+		return false
+	}
+
+	return true
+}
+
+type NotificationPreferenceModel struct {
+	DB *sql.DB
+}
+
+// Get returns a user's notification preferences. If the user has never saved any, the
+// defaults are returned with a version of zero.
+func (m NotificationPreferenceModel) Get(userID int64) (*NotificationPreferences, error) {
+	query := `
+		SELECT email, in_app, push, digest, security_alerts, saved_search_matches, announcements, version
+		FROM notification_preferences
+		WHERE user_id = $1`
+
+	prefs := &NotificationPreferences{
+		UserID:             userID,
+		Email:              true,
+		InApp:              true,
+		Push:               true,
+		Digest:             true,
+		SecurityAlerts:     true,
+		SavedSearchMatches: true,
+		Announcements:      true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.Email,
+		&prefs.InApp,
+		&prefs.Push,
+		&prefs.Digest,
+		&prefs.SecurityAlerts,
+		&prefs.SavedSearchMatches,
+		&prefs.Announcements,
+		&prefs.Version,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+// Update saves a user's notification preferences, creating the row the first time.
+// Like the other models, the version field guards against concurrent updates.
+func (m NotificationPreferenceModel) Update(prefs *NotificationPreferences) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, email, in_app, push, digest, security_alerts, saved_search_matches, announcements)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8 WHERE $9 = 0
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = $2, in_app = $3, push = $4, digest = $5, security_alerts = $6, saved_search_matches = $7,
+			announcements = $8, version = notification_preferences.version + 1
+		WHERE notification_preferences.version = $9
+		RETURNING version`
+
+	args := []any{
+		prefs.UserID,
+		prefs.Email,
+		prefs.InApp,
+		prefs.Push,
+		prefs.Digest,
+		prefs.SecurityAlerts,
+		prefs.SavedSearchMatches,
+		prefs.Announcements,
+		prefs.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&prefs.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
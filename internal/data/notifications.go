@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Define constants for the kinds of in-app notification.
+const (
+	NotificationActivationReminder = "activation_reminder"
+	NotificationReviewReply        = "review_reply"
+	NotificationAnnouncement       = "announcement"
+	NotificationSavedSearchMatch   = "saved_search_match"
+	NotificationSecurityAlert      = "security_alert"
+	NotificationWatchlistInvite    = "watchlist_invite"
+	NotificationReviewModerated    = "review_moderated"
+)
+
+// A Notification is a message shown to a user inside the application, for example as
+// a badge on a bell icon.
+type Notification struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    int64           `json:"-"`
+	Kind      string          `json:"kind"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ReadAt    *time.Time      `json:"read_at"`
+}
+
+// NotificationSortSafelist is what notifications can be sorted on. They're always
+// listed most recent first.
+var NotificationSortSafelist = []string{"-created_at"}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+func (m NotificationModel) Insert(notification *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, kind, title, body, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	var data any
+	if len(notification.Data) > 0 {
+		data = []byte(notification.Data)
+	}
+
+	args := []any{notification.UserID, notification.Kind, notification.Title, notification.Body, data}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&notification.ID, &notification.CreatedAt)
+}
+
+// GetAllForUser returns a page of a user's notifications, most recent first. If
+// unreadOnly is set, notifications which have been read are left out.
+func (m NotificationModel) GetAllForUser(userID int64, unreadOnly bool, filters Filters) ([]*Notification, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, created_at, user_id, kind, title, body, data, read_at
+		FROM notifications
+		WHERE user_id = $1 AND (read_at IS NULL OR NOT $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, unreadOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+
+	for rows.Next() {
+		var notification Notification
+
+		err := rows.Scan(
+			&totalRecords,
+			&notification.ID,
+			&notification.CreatedAt,
+			&notification.UserID,
+			&notification.Kind,
+			&notification.Title,
+			&notification.Body,
+			&notification.Data,
+			&notification.ReadAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		notifications = append(notifications, &notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return notifications, metadata, nil
+}
+
+// CountUnread returns the number of notifications a user hasn't read yet.
+func (m NotificationModel) CountUnread(userID int64) (int, error) {
+	query := `SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// SetReadForUser marks one of a user's notifications as read or unread, and returns
+// the updated notification. It returns ErrRecordNotFound if the notification doesn't
+// exist or belongs to someone else.
+func (m NotificationModel) SetReadForUser(userID, id int64, read bool) (*Notification, error) {
+	query := `
+		UPDATE notifications
+		SET read_at = CASE WHEN $3 THEN COALESCE(read_at, NOW()) END
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, created_at, user_id, kind, title, body, data, read_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var notification Notification
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID, read).Scan(
+		&notification.ID,
+		&notification.CreatedAt,
+		&notification.UserID,
+		&notification.Kind,
+		&notification.Title,
+		&notification.Body,
+		&notification.Data,
+		&notification.ReadAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &notification, nil
+}
+
+// MarkAllReadForUser marks all of a user's notifications as read, and returns how
+// many were unread.
+func (m NotificationModel) MarkAllReadForUser(userID int64) (int64, error) {
+	query := `UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteForUser removes one of a user's notifications. It returns ErrRecordNotFound
+// if the notification doesn't exist or belongs to someone else.
+func (m NotificationModel) DeleteForUser(userID, id int64) error {
+	query := `DELETE FROM notifications WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteKindForUser removes all of a user's notifications of the given kind. It's
+// used to clear reminders once they no longer apply.
+func (m NotificationModel) DeleteKindForUser(userID int64, kind string) error {
+	query := `DELETE FROM notifications WHERE user_id = $1 AND kind = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, kind)
+	return err
+}
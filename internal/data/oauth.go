@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// An OAuthClient is a third-party application which has been registered to request
+// access to users' data through the authorization-code flow.
+type OAuthClient struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       int64     `json:"-"` // The user who registered the application
+	ClientID     string    `json:"client_id"`
+	Secret       string    `json:"-"` // Only set when the client is first created
+	SecretHash   []byte    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"` // The permission codes the client may request
+}
+
+// MatchesSecret reports whether the secret is the client's secret.
+func (c *OAuthClient) MatchesSecret(secret string) bool {
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// An OAuthCode is an authorization code, which a client exchanges for an access token
+// once the user has approved its request.
+type OAuthCode struct {
+	PlainText     string
+	ClientID      int64
+	UserID        int64
+	RedirectURI   string
+	Permissions   []string
+	CodeChallenge string // The PKCE S256 code challenge, if the client sent one
+	Expiry        time.Time
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+	for _, raw := range client.RedirectURIs {
+		u, err := url.Parse(raw)
+		ok := err == nil && u.IsAbs() && u.Host != "" && u.Fragment == ""
+		// Plain http is only allowed for apps running on the user's own machine.
+		if ok && u.Scheme == "http" {
+			ok = u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+		}
+		v.Check(ok, "redirect_uris", "must only contain absolute https URIs without fragments")
+	}
+
+	v.Check(len(client.Scopes) >= 1, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range client.Scopes {
+		v.Check(v.Matches(scope, PermissionCodeRX), "scopes", "must only contain permission codes")
+	}
+}
+
+type OAuthModel struct {
+	DB   *sql.DB
+	Keys *TokenKeyring
+}
+
+// InsertClient stores a new client, generating its client ID and secret.
+func (m OAuthModel) InsertClient(client *OAuthClient) error {
+	id := make([]byte, 16)
+	secret := make([]byte, 32)
+
+	for _, b := range [][]byte{id, secret} {
+		_, err := rand.Read(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	client.ClientID = hex.EncodeToString(id)
+	client.Secret = base64.RawURLEncoding.EncodeToString(secret)
+
+	hash := sha256.Sum256([]byte(client.Secret))
+	client.SecretHash = hash[:]
+
+	query := `
+		INSERT INTO oauth_clients (user_id, client_id, secret_hash, name, redirect_uris, scopes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []any{client.UserID, client.ClientID, client.SecretHash, client.Name, pq.Array(client.RedirectURIs), pq.Array(client.Scopes)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.ID, &client.CreatedAt)
+}
+
+const oauthClientColumns = `id, created_at, user_id, client_id, secret_hash, name, redirect_uris, scopes`
+
+func scanOAuthClient(row interface{ Scan(...any) error }) (*OAuthClient, error) {
+	var client OAuthClient
+
+	err := row.Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.UserID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// GetClient returns the client with the given internal ID.
+func (m OAuthModel) GetClient(id int64) (*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanOAuthClient(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetClientByClientID returns the client with the given public client ID.
+func (m OAuthModel) GetClientByClientID(clientID string) (*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE client_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanOAuthClient(m.DB.QueryRowContext(ctx, query, clientID))
+}
+
+// GetClientsForUser returns the clients registered by a user.
+func (m OAuthModel) GetClientsForUser(userID int64) ([]*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE user_id = $1 ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteClient removes a client. Its codes and access tokens are removed by the
+// ON DELETE CASCADE constraints.
+func (m OAuthModel) DeleteClient(id int64) error {
+	query := `DELETE FROM oauth_clients WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertCode generates an authorization code, which is stored hashed like a token.
+func (m OAuthModel) InsertCode(code *OAuthCode) error {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	code.PlainText = base64.RawURLEncoding.EncodeToString(randomBytes)
+	version, hash := m.Keys.Hash(code.PlainText)
+
+	query := `
+		INSERT INTO oauth_codes (hash, key_version, client_id, user_id, redirect_uri, permissions, code_challenge, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{hash, version, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Permissions), code.CodeChallenge, code.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeCode looks up an unexpired authorization code and deletes it, so that it can
+// only be exchanged once.
+func (m OAuthModel) ConsumeCode(plaintext string) (*OAuthCode, error) {
+	versions, hashes := m.Keys.Candidates(plaintext)
+
+	query := `
+		DELETE FROM oauth_codes
+		WHERE (hash, key_version) IN (SELECT * FROM unnest($1::bytea[], $2::integer[]))
+		RETURNING client_id, user_id, redirect_uri, permissions, code_challenge, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code := OAuthCode{PlainText: plaintext}
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(hashes), pq.Array(versions)).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Permissions),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredCodes removes authorization codes which were never exchanged.
+func (m OAuthModel) DeleteExpiredCodes() (int64, error) {
+	query := `DELETE FROM oauth_codes WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,419 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"regexp"
+	"time"
+)
+
+// DefaultOrganizationID is the organization which owned everything before
+// organizations were introduced. New users join it, and anonymous requests (like the
+// public feed) see its catalog.
+const DefaultOrganizationID = 1
+
+// Define constants for the roles a member can have in an organization. Owners can do
+// everything, admins can manage the organization and its members (except owners), and
+// members can only use it.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	ErrDuplicateSlug   = errors.New("duplicate slug")
+	ErrDuplicateMember = errors.New("duplicate member")
+)
+
+var SlugRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// An Organization owns a catalog of movies which only its members can see. Role is
+// the role of the user the organization was looked up for, if any.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Role      string    `json:"role,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// An OrganizationMember is a user's membership of an organization.
+type OrganizationMember struct {
+	UserID   int64     `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(org.Slug != "", "slug", "must be provided")
+	v.Check(len(org.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(v.Matches(org.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValues(role, RoleOwner, RoleAdmin, RoleMember), "role", "must be owner, admin or member")
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of min.
+func RoleAtLeast(role, min string) bool {
+	rank := map[string]int{RoleMember: 1, RoleAdmin: 2, RoleOwner: 3}
+	return rank[role] >= rank[min]
+}
+
+// CanGrantRole reports whether a member with the given role can grant (or take away)
+// another role. Admins and owners can manage members, but only owners can manage
+// owners.
+func CanGrantRole(role, granted string) bool {
+	return RoleAtLeast(role, RoleAdmin) && (granted != RoleOwner || role == RoleOwner)
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates an organization with the given user as its owner.
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name, slug)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, org.Name, org.Slug).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	query = `INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, org.ID, ownerID, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	org.Role = RoleOwner
+
+	return tx.Commit()
+}
+
+// GetForUser returns an organization along with the user's role in it. It returns
+// ErrRecordNotFound if the organization doesn't exist or the user isn't a member.
+func (m OrganizationModel) GetForUser(id, userID int64) (*Organization, error) {
+	query := `
+		SELECT organizations.id, organizations.created_at, organizations.name, organizations.slug,
+			organization_members.role, organizations.version
+		FROM organizations
+		INNER JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organizations.id = $1 AND organization_members.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var org Organization
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Role, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &org, nil
+}
+
+// GetAllForUser returns the organizations a user is a member of, along with their role
+// in each.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+		SELECT organizations.id, organizations.created_at, organizations.name, organizations.slug,
+			organization_members.role, organizations.version
+		FROM organizations
+		INNER JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organization_members.user_id = $1
+		ORDER BY organizations.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		var org Organization
+
+		err := rows.Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Role, &org.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, &org)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// GetAllIDs returns the ID of every organization.
+func (m OrganizationModel) GetAllIDs() ([]int64, error) {
+	query := `SELECT id FROM organizations ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Update renames an organization, using the version field to prevent concurrent
+// updates from overwriting each other.
+func (m OrganizationModel) Update(org *Organization) error {
+	query := `
+		UPDATE organizations
+		SET name = $1, slug = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, org.Name, org.Slug, org.ID, org.Version).Scan(&org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an organization. Its movies, memberships and the tokens scoped to it
+// are removed by the ON DELETE CASCADE constraints.
+func (m OrganizationModel) Delete(id int64) error {
+	query := `DELETE FROM organizations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetRole returns a user's role in an organization. It returns ErrRecordNotFound if
+// the user isn't a member.
+func (m OrganizationModel) GetRole(id, userID int64) (string, error) {
+	query := `SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role string
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// AddMember adds a user to an organization with the member's role. It returns
+// ErrDuplicateMember if they're already a member.
+func (m OrganizationModel) AddMember(id int64, member *OrganizationMember) error {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, member.UserID, member.Role).Scan(&member.JoinedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organization_members_pkey"`:
+			return ErrDuplicateMember
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetRole changes a member's role. It returns ErrRecordNotFound if the user isn't a
+// member.
+func (m OrganizationModel) SetRole(id, userID int64, role string) error {
+	query := `UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND user_id = $2`
+
+	return m.execMember(query, id, userID, role)
+}
+
+// RemoveMember removes a user from an organization. It returns ErrRecordNotFound if
+// the user isn't a member.
+func (m OrganizationModel) RemoveMember(id, userID int64) error {
+	query := `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+
+	return m.execMember(query, id, userID)
+}
+
+func (m OrganizationModel) execMember(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CountOwners returns the number of owners an organization has.
+func (m OrganizationModel) CountOwners(id int64) (int, error) {
+	query := `SELECT count(*) FROM organization_members WHERE organization_id = $1 AND role = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, id, RoleOwner).Scan(&count)
+	return count, err
+}
+
+// GetOrganizationMembers returns the members of an organization, oldest first. It's a
+// UsersModel method because the members' names and email addresses may need to be
+// decrypted.
+func (m UsersModel) GetOrganizationMembers(organizationID int64) ([]*OrganizationMember, error) {
+	query := `SELECT ` + userColumns + `, organization_members.role, organization_members.created_at
+			FROM users
+			INNER JOIN organization_members ON organization_members.user_id = users.id
+			WHERE organization_members.organization_id = $1
+			ORDER BY organization_members.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*OrganizationMember{}
+
+	for rows.Next() {
+		var member OrganizationMember
+
+		user, err := m.scanUser(rows, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		member.UserID, member.Name, member.Email = user.ID, user.Name, user.Email
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// GetAllActivatedInOrganization returns every activated member of an organization,
+// leaving out those who have deleted their account.
+func (m UsersModel) GetAllActivatedInOrganization(organizationID int64) ([]*User, error) {
+	query := `SELECT ` + userColumns + `
+			FROM users
+			INNER JOIN organization_members ON organization_members.user_id = users.id
+			WHERE users.activated = true AND users.deleted_at IS NULL
+			AND organization_members.organization_id = $1
+			ORDER BY users.id`
+
+	return m.getAll(query, organizationID)
+}
@@ -3,6 +3,9 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"regexp"
 	"time"
 
 	"github.com/lib/pq"
@@ -24,21 +27,54 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+var (
+	ErrDuplicatePermission = errors.New("duplicate permission code")
+)
+
+// A Permission is an entry in the catalog of permission codes. Deactivating a
+// permission stops it from granting access, without removing it from the users who
+// hold it, so it can be reactivated later.
+type Permission struct {
+	ID          int64  `json:"id"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+	UserCount   int    `json:"user_count"`
+}
+
+// Permission codes look like "resource:action", for example "reviews:moderate".
+var PermissionCodeRX = regexp.MustCompile(`^[a-z][a-z0-9_-]*:[a-z][a-z0-9_-]*$`)
+
+func ValidatePermission(v *validator.Validator, permission *Permission) {
+	v.Check(permission.Code != "", "code", "must be provided")
+	v.Check(len(permission.Code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(v.Matches(permission.Code, PermissionCodeRX), "code", "must be in the form resource:action")
+	v.Check(len(permission.Description) <= 500, "description", "must not be more than 500 bytes long")
+}
+
 type PermissionModel struct {
 	DB *sql.DB
 }
 
+// permissionHolders is a subquery of the user_id and permission_id of each permission a
+// user holds, whether directly or through one of their roles.
+const permissionHolders = `
+		SELECT user_id, permission_id FROM users_permissions
+		UNION
+		SELECT users_roles.user_id, roles_permissions.permission_id
+		FROM users_roles
+		INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id`
+
 // The GetAllForUser() method returns all permission codes for a specific user in a
-// Permissions slice. The code in this method should feel very familiar --- it uses the
-// standard pattern that we've already seen before for retrieving multiple data rows in
-// an SQL query.
+// Permissions slice, both those the user holds directly and those of their roles. The
+// code in this method should feel very familiar --- it uses the standard pattern that
+// we've already seen before for retrieving multiple data rows in an SQL query.
 func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id 
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		INNER JOIN (` + permissionHolders + `) holders ON holders.permission_id = permissions.id
+		WHERE holders.user_id = $1 AND permissions.active`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -49,6 +85,7 @@ func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var permission string
@@ -75,10 +112,12 @@ func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 // So what’s happening here is that the SELECT ... statement on the second line creates
 // an ‘interim’ table with rows made up of the user ID and the corresponding IDs for the
 // permission codes in the array. Then we insert the contents of this interim table
-// into our user_permissions table.
+// into our user_permissions table. Codes the user already holds are skipped, so
+// granting a permission twice isn't an error.
 func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `INSERT INTO users_permissions
-			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2) AND permissions.active
+			ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -86,3 +125,148 @@ func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// DeleteForUser takes permission codes away from a user. Only the codes the user holds
+// directly are removed, so they keep any which one of their roles gives them. It
+// returns ErrRecordNotFound if the user held none of the codes directly.
+func (m *PermissionModel) DeleteForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE permissions.id = users_permissions.permission_id
+		AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll returns the permission catalog, with the number of users holding each code
+// either directly or through a role.
+func (m *PermissionModel) GetAll() ([]*Permission, error) {
+	query := `
+		SELECT permissions.id, permissions.code, permissions.description, permissions.active, count(holders.user_id)
+		FROM permissions
+		LEFT JOIN (` + permissionHolders + `) holders ON holders.permission_id = permissions.id
+		GROUP BY permissions.id
+		ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+
+	for rows.Next() {
+		var p Permission
+
+		err := rows.Scan(&p.ID, &p.Code, &p.Description, &p.Active, &p.UserCount)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (m *PermissionModel) Get(id int64) (*Permission, error) {
+	query := `
+		SELECT permissions.id, permissions.code, permissions.description, permissions.active, count(holders.user_id)
+		FROM permissions
+		LEFT JOIN (` + permissionHolders + `) holders ON holders.permission_id = permissions.id
+		WHERE permissions.id = $1
+		GROUP BY permissions.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p Permission
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&p.ID, &p.Code, &p.Description, &p.Active, &p.UserCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+func (m *PermissionModel) Insert(permission *Permission) error {
+	query := `
+		INSERT INTO permissions (code, description, active)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, permission.Code, permission.Description, permission.Active).Scan(&permission.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicatePermission
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update saves the description and active flag of a permission. The code itself can't
+// be changed, since it's referenced from the application.
+func (m *PermissionModel) Update(permission *Permission) error {
+	query := `
+		UPDATE permissions
+		SET description = $1, active = $2
+		WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, permission.Description, permission.Active, permission.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
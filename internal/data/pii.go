@@ -0,0 +1,102 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrDecryption = errors.New("unable to decrypt field")
+
+// PIICipher encrypts personal data, like users' names and email addresses, before it
+// is written to the database. Fields are encrypted with AES-256-GCM, using the column
+// name as additional data so that a value can't be copied from one column to another.
+//
+// Encrypted values can't be searched, so alongside an encrypted email address we store
+// a "blind index": an HMAC of the normalized address. Looking a user up by email means
+// calculating the same HMAC and searching for that instead.
+//
+// Both the encryption and index keys are derived from a single 32-byte master key.
+type PIICipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// NewPIICipher returns a cipher using the given base64-encoded 32-byte key. If the key
+// is empty it returns nil, which disables encryption.
+func NewPIICipher(encodedKey string) (*PIICipher, error) {
+	if encodedKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, errors.New("PII key is not valid base64")
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("PII key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(deriveKey(key, "greenlight pii encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PIICipher{
+		aead:     aead,
+		indexKey: deriveKey(key, "greenlight pii blind index"),
+	}, nil
+}
+
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// Encrypt seals a value for storing in the given column. The nonce is prepended to
+// the ciphertext.
+func (c *PIICipher) Encrypt(column, plaintext string) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(column)), nil
+}
+
+// Decrypt opens a value which was encrypted for the given column.
+func (c *PIICipher) Decrypt(column string, ciphertext []byte) (string, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return "", ErrDecryption
+	}
+
+	plaintext, err := c.aead.Open(nil, ciphertext[:size], ciphertext[size:], []byte(column))
+	if err != nil {
+		return "", ErrDecryption
+	}
+
+	return string(plaintext), nil
+}
+
+// EmailIndex returns the blind index for an email address. Addresses are compared
+// case-insensitively, like the citext email column.
+func (c *PIICipher) EmailIndex(email string) []byte {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(strings.ToLower(email)))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/anaplo/internal/push"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// A PushDevice is an install of a mobile app which a user has registered to receive
+// push notifications on. The token is the one the platform (FCM or APNs) issued to the
+// install, and isn't shown again after registration.
+type PushDevice struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	Platform  string    `json:"platform"`
+	Token     string    `json:"-"`
+	Name      string    `json:"name,omitempty"`
+}
+
+func ValidatePushDevice(v *validator.Validator, device *PushDevice) {
+	v.Check(validator.PermittedValues(device.Platform, push.PlatformFCM, push.PlatformAPNs), "platform", "must be fcm or apns")
+	v.Check(device.Token != "", "token", "must be provided")
+	v.Check(len(device.Token) <= 4096, "token", "must not be more than 4096 bytes long")
+	v.Check(len(device.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+type PushDeviceModel struct {
+	DB *sql.DB
+}
+
+// Insert registers a device for a user. If the device's token is already registered,
+// to this user or another, the registration is moved over to this one.
+func (m PushDeviceModel) Insert(device *PushDevice) error {
+	query := `
+		INSERT INTO push_devices (user_id, platform, token, name)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (platform, token) DO UPDATE
+		SET user_id = EXCLUDED.user_id, name = EXCLUDED.name, created_at = NOW()
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{device.UserID, device.Platform, device.Token, device.Name}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&device.ID, &device.CreatedAt)
+}
+
+// Get returns a device by ID, for delivering a queued notification to it.
+func (m PushDeviceModel) Get(id int64) (*PushDevice, error) {
+	query := `
+		SELECT id, created_at, user_id, platform, token, name
+		FROM push_devices
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var device PushDevice
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&device.ID,
+		&device.CreatedAt,
+		&device.UserID,
+		&device.Platform,
+		&device.Token,
+		&device.Name,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &device, nil
+}
+
+// GetAllForUser returns the devices a user has registered, most recent first.
+func (m PushDeviceModel) GetAllForUser(userID int64) ([]*PushDevice, error) {
+	query := `
+		SELECT id, created_at, user_id, platform, token, name
+		FROM push_devices
+		WHERE user_id = $1
+		ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*PushDevice{}
+
+	for rows.Next() {
+		var device PushDevice
+
+		err := rows.Scan(
+			&device.ID,
+			&device.CreatedAt,
+			&device.UserID,
+			&device.Platform,
+			&device.Token,
+			&device.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, &device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// DeleteForUser unregisters one of a user's devices. It returns ErrRecordNotFound if
+// the device doesn't exist or belongs to someone else.
+func (m PushDeviceModel) DeleteForUser(userID, id int64) error {
+	query := `DELETE FROM push_devices WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete forgets a device whose token the platform no longer accepts.
+func (m PushDeviceModel) Delete(id int64) error {
+	query := `DELETE FROM push_devices WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
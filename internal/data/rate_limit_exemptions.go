@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Define constants for the kinds of client which can be exempted from rate limiting.
+const (
+	ExemptUser   = "user"   // A user ID
+	ExemptClient = "client" // An OAuth application's client_id
+	ExemptCIDR   = "cidr"   // A range of IP addresses
+)
+
+var ErrDuplicateExemption = errors.New("duplicate rate limit exemption")
+
+// A RateLimitExemption lets a trusted client bypass the general rate limiter. If RPS
+// is nil the client isn't limited at all, and otherwise it gets its own, more
+// generous, limit which is shared by all of its requests.
+type RateLimitExemption struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	RPS       *float64  `json:"rps,omitempty"`
+	Burst     *int      `json:"burst,omitempty"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// Key returns a string which identifies the client the exemption applies to.
+func (e *RateLimitExemption) Key() string {
+	return e.Kind + ":" + e.Value
+}
+
+func ValidateRateLimitExemption(v *validator.Validator, e *RateLimitExemption) {
+	v.Check(validator.PermittedValues(e.Kind, ExemptUser, ExemptClient, ExemptCIDR), "kind", "must be user, client or cidr")
+	v.Check(e.Value != "", "value", "must be provided")
+
+	switch e.Kind {
+	case ExemptUser:
+		id, err := strconv.ParseInt(e.Value, 10, 64)
+		v.Check(err == nil && id > 0, "value", "must be a user ID")
+	case ExemptCIDR:
+		_, _, err := net.ParseCIDR(e.Value)
+		v.Check(err == nil, "value", "must be a CIDR range, like 10.0.0.0/8")
+	}
+
+	if e.RPS != nil || e.Burst != nil {
+		v.Check(e.RPS != nil && *e.RPS > 0, "rps", "must be greater than zero")
+		v.Check(e.Burst != nil && *e.Burst > 0, "burst", "must be greater than zero")
+	}
+
+	v.Check(len(e.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+// ParseRateLimitExemptions parses a comma-separated list of exemptions in the form
+// <kind>:<value>[=<rps>/<burst>], like "user:42,cidr:10.0.0.0/8=50/100". Exemptions
+// without a limit aren't rate limited at all.
+func ParseRateLimitExemptions(s string) ([]*RateLimitExemption, error) {
+	exemptions := []*RateLimitExemption{}
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		client, limit, hasLimit := strings.Cut(field, "=")
+
+		kind, value, ok := strings.Cut(client, ":")
+		if !ok {
+			return nil, fmt.Errorf("rate limit exemption %q must be in the form <kind>:<value>[=<rps>/<burst>]", field)
+		}
+
+		e := &RateLimitExemption{Kind: kind, Value: value}
+
+		if hasLimit {
+			rawRPS, rawBurst, _ := strings.Cut(limit, "/")
+
+			rps, err := strconv.ParseFloat(rawRPS, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rate limit exemption %q has an invalid rps", field)
+			}
+
+			burst, err := strconv.Atoi(rawBurst)
+			if err != nil {
+				return nil, fmt.Errorf("rate limit exemption %q has an invalid burst", field)
+			}
+
+			e.RPS, e.Burst = &rps, &burst
+		}
+
+		v := validator.New()
+
+		if ValidateRateLimitExemption(v, e); !v.Valid() {
+			problems := []string{}
+			for key, message := range v.Errors {
+				problems = append(problems, key+" "+message)
+			}
+			sort.Strings(problems)
+
+			return nil, fmt.Errorf("rate limit exemption %q: %s", field, strings.Join(problems, "; "))
+		}
+
+		exemptions = append(exemptions, e)
+	}
+
+	return exemptions, nil
+}
+
+type RateLimitExemptionModel struct {
+	DB *sql.DB
+}
+
+func (m RateLimitExemptionModel) Insert(e *RateLimitExemption) error {
+	query := `
+		INSERT INTO rate_limit_exemptions (kind, value, rps, burst, note)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, e.Kind, e.Value, e.RPS, e.Burst, e.Note).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "rate_limit_exemptions_kind_value_key"`:
+			return ErrDuplicateExemption
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m RateLimitExemptionModel) GetAll() ([]*RateLimitExemption, error) {
+	query := `
+		SELECT id, created_at, kind, value, rps, burst, note
+		FROM rate_limit_exemptions
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exemptions := []*RateLimitExemption{}
+
+	for rows.Next() {
+		var e RateLimitExemption
+
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Kind, &e.Value, &e.RPS, &e.Burst, &e.Note)
+		if err != nil {
+			return nil, err
+		}
+
+		exemptions = append(exemptions, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return exemptions, nil
+}
+
+func (m RateLimitExemptionModel) Delete(id int64) error {
+	query := `DELETE FROM rate_limit_exemptions WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
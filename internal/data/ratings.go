@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// A Rating is a user's score for a movie, from 1 to 10. Each user has at most one
+// rating per movie; rating it again replaces the old score.
+type Rating struct {
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"-"`
+	Rating    int       `json:"rating"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Rating >= 1 && rating.Rating <= 10, "rating", "must be between 1 and 10")
+}
+
+// RatingModel reads and writes users' ratings. The per-movie count and sum which the
+// movie's average is worked out from are kept up to date by a database trigger.
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Set records a user's rating for a movie, replacing any rating they gave it before.
+func (m RatingModel) Set(rating *Rating) error {
+	query := `
+		INSERT INTO ratings (movie_id, user_id, rating)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, user_id) DO UPDATE
+		SET rating = EXCLUDED.rating, updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, rating.MovieID, rating.UserID, rating.Rating).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+}
+
+// Get returns a user's rating for a movie. It returns ErrRecordNotFound if they
+// haven't rated it.
+func (m RatingModel) Get(movieID, userID int64) (*Rating, error) {
+	query := `
+		SELECT movie_id, user_id, rating, created_at, updated_at
+		FROM ratings
+		WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rating Rating
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&rating.MovieID,
+		&rating.UserID,
+		&rating.Rating,
+		&rating.CreatedAt,
+		&rating.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rating, nil
+}
+
+// Delete removes a user's rating for a movie. It returns ErrRecordNotFound if they
+// hadn't rated it.
+func (m RatingModel) Delete(movieID, userID int64) error {
+	query := `DELETE FROM ratings WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
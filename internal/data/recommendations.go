@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A Recommendation is a movie suggested to a user, with how strongly it's suggested.
+// The score is the user's mean affinity for the movie's genres, so higher is better.
+type Recommendation struct {
+	MovieID   int64     `json:"movie_id"`
+	Title     string    `json:"title"` // The movie's title, year and genres, for convenience when listing
+	Year      int32     `json:"year,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+	Score     float64   `json:"score"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecommendationsPerUser is how many movies are kept for each user when the
+// recommendations are refreshed.
+const RecommendationsPerUser = 100
+
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser returns a page of the movies recommended to a user from the given
+// organization's catalog, best first. Movies the user has rated or put on their
+// watchlist since the recommendations were worked out are left out.
+func (m RecommendationModel) GetAllForUser(organizationID, userID int64, filters Filters) ([]*Recommendation, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), recommendations.movie_id, movies.title, movies.year, movies.genres,
+			recommendations.score, recommendations.created_at
+		FROM recommendations
+		INNER JOIN movies ON movies.id = recommendations.movie_id
+		WHERE recommendations.user_id = $1 AND movies.organization_id = $4 AND movies.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM ratings WHERE ratings.movie_id = movies.id AND ratings.user_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM watchlist WHERE watchlist.movie_id = movies.id AND watchlist.user_id = $1)
+		ORDER BY recommendations.score DESC, recommendations.movie_id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	recommendations := []*Recommendation{}
+
+	for rows.Next() {
+		var r Recommendation
+
+		err := rows.Scan(&totalRecords, &r.MovieID, &r.Title, &r.Year, pq.Array(&r.Genres), &r.Score, &r.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		recommendations = append(recommendations, &r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return recommendations, calculateMetadata(totalRecords, filters), nil
+}
+
+// RefreshForOrganization works out the recommendations for every user who has rated
+// or saved movies in the organization's catalog, replacing the ones from before. It
+// returns how many were stored.
+//
+// Each user gets an affinity for each genre: a rating adds its distance from the
+// middle of the scale (so a 10 adds 4.5 and a 1 takes away 4.5) to each of the rated
+// movie's genres, and putting a movie on the watchlist adds 2. Every movie the user
+// hasn't rated or saved is scored by the mean affinity for its genres, and the best
+// RecommendationsPerUser movies with a positive score are kept.
+func (m RecommendationModel) RefreshForOrganization(ctx context.Context, organizationID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM recommendations
+		USING movies
+		WHERE movies.id = recommendations.movie_id AND movies.organization_id = $1`, organizationID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		WITH signals AS (
+			SELECT ratings.user_id, genre, ratings.rating - 5.5 AS weight
+			FROM ratings
+			INNER JOIN movies ON movies.id = ratings.movie_id, unnest(movies.genres) AS genre
+			WHERE movies.organization_id = $1 AND movies.deleted_at IS NULL AND ratings.user_id IS NOT NULL
+			UNION ALL
+			SELECT watchlist.user_id, genre, 2
+			FROM watchlist
+			INNER JOIN movies ON movies.id = watchlist.movie_id, unnest(movies.genres) AS genre
+			WHERE movies.organization_id = $1 AND movies.deleted_at IS NULL
+		), affinities AS (
+			SELECT user_id, genre, sum(weight) AS weight
+			FROM signals
+			GROUP BY user_id, genre
+		), scores AS (
+			SELECT affinities.user_id, movies.id AS movie_id,
+				sum(affinities.weight) / cardinality(movies.genres) AS score
+			FROM affinities
+			INNER JOIN movies ON affinities.genre = ANY(movies.genres)
+			WHERE movies.organization_id = $1 AND movies.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM ratings WHERE ratings.movie_id = movies.id AND ratings.user_id = affinities.user_id)
+			AND NOT EXISTS (SELECT 1 FROM watchlist WHERE watchlist.movie_id = movies.id AND watchlist.user_id = affinities.user_id)
+			GROUP BY affinities.user_id, movies.id
+			HAVING sum(affinities.weight) > 0
+		), ranked AS (
+			SELECT user_id, movie_id, score,
+				row_number() OVER (PARTITION BY user_id ORDER BY score DESC, movie_id DESC) AS rank
+			FROM scores
+		)
+		INSERT INTO recommendations (user_id, movie_id, score)
+		SELECT user_id, movie_id, score
+		FROM ranked
+		WHERE rank <= $2`
+
+	result, err := tx.ExecContext(ctx, query, organizationID, RecommendationsPerUser)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// ReleaseDates maps the countries a movie was released in, as ISO 3166-1 alpha-2
+// codes like RegionRX, to the dates it was released there, like 2010-07-16. They're
+// stored as a JSON object in the movies table.
+type ReleaseDates map[string]string
+
+// ReleaseQuery narrows a movie list down to the movies released in a country between
+// two dates. Either date can be left zero.
+type ReleaseQuery struct {
+	Country string
+	After   time.Time
+	Before  time.Time
+}
+
+// Earliest returns the first date the movie was released anywhere, or the zero time if
+// there are no (valid) dates.
+func (d ReleaseDates) Earliest() time.Time {
+	var earliest time.Time
+
+	for _, s := range d {
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			continue
+		}
+
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+
+	return earliest
+}
+
+// Value stores the dates as a JSON object, which is empty if there aren't any.
+func (d ReleaseDates) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(map[string]string(d))
+}
+
+// Scan reads the dates from their JSON object.
+func (d *ReleaseDates) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New("release dates must be scanned from a JSON object")
+	}
+
+	return json.Unmarshal(b, d)
+}
+
+// dateArg returns the query argument for a date which may be zero, meaning no date.
+func dateArg(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.Format(time.DateOnly)
+}
+
+func ValidateReleaseDates(v *validator.Validator, dates ReleaseDates) {
+	v.Check(len(dates) <= 250, "release_dates", "must not contain more than 250 countries")
+
+	for country, s := range dates {
+		key := "release_dates." + country
+
+		t, err := time.Parse(time.DateOnly, s)
+
+		v.Check(v.Matches(country, RegionRX), key, "must be keyed by a two-letter country code, like US")
+		v.Check(err == nil, key, "must be a date like 2010-07-16")
+		v.Check(err != nil || t.Year() >= 1888, key, "must not be before 1888")
+		v.Check(err != nil || !t.After(time.Now().AddDate(5, 0, 0)), key, "must not be more than 5 years in the future")
+	}
+}
+
+func ValidateReleaseQuery(v *validator.Validator, q ReleaseQuery) {
+	if q.Country == "" {
+		v.Check(q.After.IsZero(), "released_after", "can only be used with released_in")
+		v.Check(q.Before.IsZero(), "released_before", "can only be used with released_in")
+		return
+	}
+
+	v.Check(v.Matches(q.Country, RegionRX), "released_in", "must be a two-letter country code, like US")
+
+	if !q.After.IsZero() && !q.Before.IsZero() {
+		v.Check(q.After.Before(q.Before), "released_before", "must be after released_after")
+	}
+}
@@ -0,0 +1,519 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+	ErrDuplicateReport = errors.New("duplicate report")
+)
+
+// Define constants for the moderation states of a review. Only approved reviews are
+// shown to other users.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// ReviewStatuses are the moderation states a review can be in.
+var ReviewStatuses = []string{ReviewPending, ReviewApproved, ReviewRejected}
+
+// A Review is a user's written opinion of a movie. Each user can review a movie once,
+// and edit the review afterwards. The reviews of users who have deleted their account
+// are kept anonymously, without a user ID or name. Reviews are held for moderation
+// while they're pending, and only shown to other users once they've been approved.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	UserName  string    `json:"user_name,omitempty"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	Version   int32     `json:"version"`
+}
+
+// ReviewSortSafelist is what a movie's reviews can be sorted on.
+var ReviewSortSafelist = SortSafelist("created_at", "updated_at")
+
+// ReviewQueueSortSafelist is what the moderation queue can be sorted on.
+var ReviewQueueSortSafelist = SortSafelist("created_at", "updated_at", "report_count")
+
+// ReportReasons are the reasons a review can be reported for.
+var ReportReasons = []string{"spam", "offensive", "spoilers", "off_topic", "other"}
+
+// A ReviewReport is a user's report that a review breaks the rules.
+type ReviewReport struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ReviewID  int64     `json:"review_id"`
+	UserID    int64     `json:"-"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details,omitempty"`
+}
+
+func ValidateReviewReport(v *validator.Validator, report *ReviewReport) {
+	v.Check(validator.PermittedValues(report.Reason, ReportReasons...), "reason", "must be one of "+strings.Join(ReportReasons, ", "))
+	v.Check(report.Reason != "other" || report.Details != "", "details", "must be provided when the reason is other")
+	v.Check(utf8.RuneCountInString(report.Details) <= 1000, "details", "must not be more than 1000 characters long")
+}
+
+// A QueuedReview is a review in the moderation queue, along with how many times it
+// has been reported since it was last approved, and for which reasons.
+type QueuedReview struct {
+	*Review
+	ReportCount   int            `json:"report_count"`
+	ReportReasons map[string]int `json:"report_reasons,omitempty"`
+}
+
+// A ReviewQueueFilter picks the reviews in the moderation queue. If Reported is set,
+// only reviews which have been reported since they were last approved are included.
+type ReviewQueueFilter struct {
+	Status   string
+	Reported bool
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Body != "", "body", "must be provided")
+	v.Check(utf8.RuneCountInString(review.Body) <= 10000, "body", "must not be more than 10000 characters long")
+}
+
+// ReviewModel needs the PII cipher to decrypt the names of the reviews' authors.
+type ReviewModel struct {
+	DB  *sql.DB
+	PII *PIICipher
+}
+
+const reviewColumns = `reviews.id, reviews.created_at, reviews.updated_at, reviews.movie_id, COALESCE(reviews.user_id, 0),
+		COALESCE(users.name, ''), users.name_encrypted, reviews.body, reviews.status, reviews.version`
+
+// Insert adds a review in the moderation state given by review.Status. It returns
+// ErrDuplicateReview if the user has already reviewed the movie.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, body, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, review.MovieID, review.UserID, review.Body, review.Status).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a review of any movie, in any moderation state, for moderators.
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM reviews
+		LEFT JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	review, err := m.scan(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
+
+// GetForMovie returns one of a movie's reviews, whatever its moderation state. It
+// returns ErrRecordNotFound if the review doesn't exist or is of another movie.
+func (m ReviewModel) GetForMovie(movieID, id int64) (*Review, error) {
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM reviews
+		LEFT JOIN users ON users.id = reviews.user_id
+		WHERE reviews.id = $1 AND reviews.movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	review, err := m.scan(m.DB.QueryRowContext(ctx, query, id, movieID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
+
+// GetAllForMovie returns a page of a movie's approved reviews, sorted by
+// filters.Sort.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+reviewColumns+`
+		FROM reviews
+		LEFT JOIN users ON users.id = reviews.user_id
+		WHERE reviews.movie_id = $1 AND reviews.status = 'approved'
+		ORDER BY reviews.%s %s, reviews.id %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		review, err := m.scan(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters), nil
+}
+
+// GetRecentForMovies returns up to limit of the most recent approved reviews of each
+// of the movies, keyed by movie ID, in one query for a whole page of movies.
+func (m ReviewModel) GetRecentForMovies(movieIDs []int64, limit int) (map[int64][]*Review, error) {
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM (
+			SELECT *, row_number() OVER (PARTITION BY movie_id ORDER BY created_at DESC, id DESC) AS n
+			FROM reviews
+			WHERE movie_id = ANY($1) AND status = 'approved'
+		) reviews
+		LEFT JOIN users ON users.id = reviews.user_id
+		WHERE reviews.n <= $2
+		ORDER BY reviews.movie_id, reviews.n`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := map[int64][]*Review{}
+
+	for rows.Next() {
+		review, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews[review.MovieID] = append(reviews[review.MovieID], review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// Update changes a review's body and moderation state, using the version field to
+// prevent concurrent updates from overwriting each other.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET body = $1, status = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, review.Body, review.Status, review.ID, review.Version).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Moderate sets a review's moderation state. It uses the version field too, so that a
+// review which was edited after the moderator read it isn't approved unseen.
+// Approving a review dismisses the reports made about it so far.
+func (m ReviewModel) Moderate(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE reviews
+		SET status = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, review.Status, review.ID, review.Version).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if review.Status == ReviewApproved {
+		for _, query := range []string{
+			`UPDATE review_reports SET dismissed_at = NOW() WHERE review_id = $1 AND dismissed_at IS NULL`,
+			`UPDATE reviews SET report_count = 0 WHERE id = $1`,
+		} {
+			_, err = tx.ExecContext(ctx, query, review.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Report records a user's report of a review and counts it. If the review is
+// approved and has now been reported at least threshold times since then, it's
+// hidden by putting it back in the moderation queue, and hidden is true. A threshold
+// of zero never hides reviews. It returns ErrDuplicateReport if the user has already
+// reported the review.
+func (m ReviewModel) Report(report *ReviewReport, threshold int) (hidden bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO review_reports (review_id, user_id, reason, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, report.ReviewID, report.UserID, report.Reason, report.Details).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "review_reports_review_id_user_id_key"`:
+			return false, ErrDuplicateReport
+		default:
+			return false, err
+		}
+	}
+
+	// The review's row is locked by the subquery, so that two reports which both reach
+	// the threshold don't both see it approved.
+	query = `
+		UPDATE reviews
+		SET report_count = reviews.report_count + 1,
+			status = CASE WHEN $2 > 0 AND reviews.status = 'approved' AND reviews.report_count + 1 >= $2
+				THEN 'pending' ELSE reviews.status END
+		FROM (SELECT id, status FROM reviews WHERE id = $1 FOR UPDATE) old
+		WHERE reviews.id = old.id
+		RETURNING reviews.status <> old.status`
+
+	err = tx.QueryRowContext(ctx, query, report.ReviewID, threshold).Scan(&hidden)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return hidden, tx.Commit()
+}
+
+// GetQueue returns a page of the moderation queue: the reviews of every movie which
+// match filter, sorted by filters.Sort, with their reports.
+func (m ReviewModel) GetQueue(filter ReviewQueueFilter, filters Filters) ([]*QueuedReview, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.report_count, (
+			SELECT json_object_agg(reason, n)
+			FROM (
+				SELECT reason, count(*) AS n
+				FROM review_reports
+				WHERE review_reports.review_id = reviews.id AND review_reports.dismissed_at IS NULL
+				GROUP BY reason
+			) reasons
+		), `+reviewColumns+`
+		FROM reviews
+		LEFT JOIN users ON users.id = reviews.user_id
+		WHERE reviews.status = $1 AND (NOT $2 OR reviews.report_count > 0)
+		ORDER BY reviews.%s %s, reviews.id %s
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.Status, filter.Reported, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*QueuedReview{}
+
+	for rows.Next() {
+		var queued QueuedReview
+		var reasons []byte
+
+		queued.Review, err = m.scan(rows, &totalRecords, &queued.ReportCount, &reasons)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if reasons != nil {
+			err = json.Unmarshal(reasons, &queued.ReportReasons)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+
+		reviews = append(reviews, &queued)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters), nil
+}
+
+// CountApprovedForUser returns how many of a user's reviews have been approved, which
+// is how they come to be trusted to post reviews without moderation.
+func (m ReviewModel) CountApprovedForUser(userID int64) (int, error) {
+	query := `SELECT count(*) FROM reviews WHERE user_id = $1 AND status = 'approved'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	query := `DELETE FROM reviews WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// scan scans a row of reviewColumns, decrypting the author's name if it was stored
+// encrypted. Any extra destinations are scanned from the columns before
+// reviewColumns.
+func (m ReviewModel) scan(row interface{ Scan(...any) error }, extra ...any) (*Review, error) {
+	var review Review
+	var nameEncrypted []byte
+
+	dest := append(extra,
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&nameEncrypted,
+		&review.Body,
+		&review.Status,
+		&review.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if nameEncrypted != nil {
+		if m.PII == nil {
+			return nil, errors.New("user data is encrypted but no PII key is configured")
+		}
+
+		review.UserName, err = m.PII.Decrypt("name", nameEncrypted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultRole is the role every new user is given when they register.
+const DefaultRole = "member"
+
+// A Role bundles permission codes, so that users can be given the role rather than
+// each of its permissions. The roles are seeded by the migrations.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every role along with its permission codes.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT roles.id, roles.name, roles.description,
+			array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAllForUser returns the names of a user's roles.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// AddForUser gives a user a role. Giving a user a role they already have does nothing.
+// It returns ErrRecordNotFound if there's no role with the name.
+func (m RoleModel) AddForUser(userID int64, name string) error {
+	query := `
+		WITH role AS (
+			SELECT id FROM roles WHERE name = $2
+		), added AS (
+			INSERT INTO users_roles (user_id, role_id)
+			SELECT $1, role.id FROM role
+			ON CONFLICT DO NOTHING
+		)
+		SELECT count(*) FROM role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var found int
+
+	err := m.DB.QueryRowContext(ctx, query, userID, name).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if found == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemoveForUser takes a role away from a user. It returns ErrRecordNotFound if the user
+// doesn't have the role.
+func (m RoleModel) RemoveForUser(userID int64, name string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE roles.id = users_roles.role_id AND users_roles.user_id = $1 AND roles.name = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
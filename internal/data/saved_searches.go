@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/filter"
+	"greenlight/anaplo/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A SavedSearch is a named set of movie list filters which a user can re-run, and
+// optionally be notified about when new movies match.
+type SavedSearch struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	// The organization whose movies are searched. A user's searches in each of their
+	// organizations are kept separate.
+	OrganizationID int64    `json:"-"`
+	Name           string   `json:"name"`
+	Title          string   `json:"title"`
+	Genres         []string `json:"genres"`
+	YearMin        *int32   `json:"year_min,omitempty"`
+	YearMax        *int32   `json:"year_max,omitempty"`
+	NotifyEmail    bool     `json:"notify_email"`
+	NotifyWebhook  bool     `json:"notify_webhook"`
+	LastMovieID    int64    `json:"-"` // The newest movie considered for notifications
+	Version        int32    `json:"version"`
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.Check(search.Name != "", "name", "must be provided")
+	v.Check(len(search.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(search.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(search.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(validator.Unique(search.Genres), "genres", "must not contain duplicate values")
+
+	if search.YearMin != nil {
+		v.Check(*search.YearMin >= 1888, "year_min", "must be greater than 1888")
+	}
+
+	if search.YearMax != nil {
+		v.Check(*search.YearMax >= 1888, "year_max", "must be greater than 1888")
+	}
+
+	if search.YearMin != nil && search.YearMax != nil {
+		v.Check(*search.YearMin <= *search.YearMax, "year_max", "must not be less than year_min")
+	}
+}
+
+// Expression returns the search's year range as a filter expression over
+// MovieFilterFields. If afterID is greater than zero, only movies with a larger ID
+// match, which is how the notifier finds movies added since it last ran. It returns
+// nil if there is nothing to filter on.
+func (s *SavedSearch) Expression(afterID int64) (*filter.Expr, error) {
+	var terms []string
+
+	if afterID > 0 {
+		terms = append(terms, fmt.Sprintf("id>%d", afterID))
+	}
+
+	if s.YearMin != nil {
+		terms = append(terms, fmt.Sprintf("year>=%d", *s.YearMin))
+	}
+
+	if s.YearMax != nil {
+		terms = append(terms, fmt.Sprintf("year<=%d", *s.YearMax))
+	}
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return filter.Parse(strings.Join(terms, " AND "), MovieFilterFields)
+}
+
+type SavedSearchModel struct {
+	DB *sql.DB
+}
+
+const savedSearchColumns = `id, created_at, user_id, organization_id, name, title, genres, year_min, year_max,
+		notify_email, notify_webhook, last_movie_id, version`
+
+// Insert adds a saved search. Only movies added after the search was saved are
+// notified about, so last_movie_id starts at the organization's newest movie.
+func (m SavedSearchModel) Insert(search *SavedSearch) error {
+	query := `
+		INSERT INTO saved_searches (user_id, organization_id, name, title, genres, year_min, year_max, notify_email, notify_webhook, last_movie_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, (SELECT COALESCE(max(id), 0) FROM movies WHERE organization_id = $2))
+		RETURNING id, created_at, last_movie_id, version`
+
+	args := []any{
+		search.UserID,
+		search.OrganizationID,
+		search.Name,
+		search.Title,
+		pq.Array(search.Genres),
+		search.YearMin,
+		search.YearMax,
+		search.NotifyEmail,
+		search.NotifyWebhook,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&search.ID, &search.CreatedAt, &search.LastMovieID, &search.Version)
+}
+
+// GetForUser returns one of a user's saved searches in an organization. It returns
+// ErrRecordNotFound if the search doesn't exist, belongs to someone else or belongs
+// to another organization.
+func (m SavedSearchModel) GetForUser(organizationID, userID, id int64) (*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches WHERE id = $1 AND user_id = $2 AND organization_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	search, err := scanSavedSearch(m.DB.QueryRowContext(ctx, query, id, userID, organizationID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return search, nil
+}
+
+// GetAllForUser returns a user's saved searches in an organization, oldest first.
+func (m SavedSearchModel) GetAllForUser(organizationID, userID int64) ([]*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches WHERE user_id = $1 AND organization_id = $2 ORDER BY id`
+
+	return m.query(query, userID, organizationID)
+}
+
+// GetNotifiable returns the saved searches of activated users which have email or
+// webhook notifications turned on. Searches in organizations the user has since left,
+// and those of users who have deleted their account, are skipped.
+func (m SavedSearchModel) GetNotifiable() ([]*SavedSearch, error) {
+	query := `
+		SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+		WHERE (notify_email OR notify_webhook)
+		AND user_id IN (SELECT id FROM users WHERE activated = true AND deleted_at IS NULL)
+		AND (organization_id, user_id) IN (SELECT organization_id, user_id FROM organization_members)
+		ORDER BY id`
+
+	return m.query(query)
+}
+
+// Update changes a saved search, using the version field to prevent concurrent
+// updates from overwriting each other.
+func (m SavedSearchModel) Update(search *SavedSearch) error {
+	query := `
+		UPDATE saved_searches
+		SET name = $1, title = $2, genres = $3, year_min = $4, year_max = $5,
+			notify_email = $6, notify_webhook = $7, version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING version`
+
+	args := []any{
+		search.Name,
+		search.Title,
+		pq.Array(search.Genres),
+		search.YearMin,
+		search.YearMax,
+		search.NotifyEmail,
+		search.NotifyWebhook,
+		search.ID,
+		search.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&search.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetLastMovieID records the newest movie which the notifier has considered for a
+// saved search.
+func (m SavedSearchModel) SetLastMovieID(id, movieID int64) error {
+	query := `UPDATE saved_searches SET last_movie_id = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, id)
+	return err
+}
+
+// DeleteForUser removes one of a user's saved searches in an organization. It returns
+// ErrRecordNotFound if the search doesn't exist, belongs to someone else or belongs to
+// another organization.
+func (m SavedSearchModel) DeleteForUser(organizationID, userID, id int64) error {
+	query := `DELETE FROM saved_searches WHERE id = $1 AND user_id = $2 AND organization_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m SavedSearchModel) query(query string, args ...any) ([]*SavedSearch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		searches = append(searches, search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+func scanSavedSearch(row interface{ Scan(...any) error }) (*SavedSearch, error) {
+	var search SavedSearch
+
+	err := row.Scan(
+		&search.ID,
+		&search.CreatedAt,
+		&search.UserID,
+		&search.OrganizationID,
+		&search.Name,
+		&search.Title,
+		pq.Array(&search.Genres),
+		&search.YearMin,
+		&search.YearMax,
+		&search.NotifyEmail,
+		&search.NotifyWebhook,
+		&search.LastMovieID,
+		&search.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &search, nil
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type SchedulerModel struct {
+	DB *sql.DB
+}
+
+// TryLock attempts to take the lock for a run of the named scheduled job. The slot is
+// the time the run was scheduled for: every instance of the application computes the
+// same slot, and only the first one to record it gets the lock, so each run happens
+// exactly once across the cluster. The lease stops a crashed instance from holding
+// the lock forever.
+func (m SchedulerModel) TryLock(name string, slot time.Time, lease time.Duration, owner string) (bool, error) {
+	query := `
+		INSERT INTO scheduled_jobs (name, last_slot, locked_until, locked_by)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3), $4)
+		ON CONFLICT (name) DO UPDATE
+		SET last_slot = EXCLUDED.last_slot, locked_until = EXCLUDED.locked_until, locked_by = EXCLUDED.locked_by
+		WHERE scheduled_jobs.last_slot < EXCLUDED.last_slot AND scheduled_jobs.locked_until < NOW()
+		RETURNING name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name, slot, lease.Seconds(), owner).Scan(&name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Unlock releases the lock for the named job once its run has finished.
+func (m SchedulerModel) Unlock(name, owner string) error {
+	query := `
+		UPDATE scheduled_jobs SET locked_until = NOW()
+		WHERE name = $1 AND locked_by = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, name, owner)
+	return err
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of security event which are recorded.
+const (
+	SecurityLoginSucceeded       = "login_succeeded"
+	SecurityLoginFailed          = "login_failed"
+	SecurityLockout              = "lockout"
+	SecurityAccountActivated     = "account_activated"
+	SecurityPermissionsGranted   = "permissions_granted"
+	SecurityPermissionsRevoked   = "permissions_revoked"
+	SecurityPasswordChanged      = "password_changed"
+	SecurityPasswordChangeFailed = "password_change_failed"
+	SecurityEmailChangeRequested = "email_change_requested"
+	SecurityEmailChanged         = "email_changed"
+	SecurityAccountDeleted       = "account_deleted"
+	SecurityAccountSuspended     = "account_suspended"
+	SecurityAccountUnsuspended   = "account_unsuspended"
+	SecurityRoleAssigned         = "role_assigned"
+	SecurityRoleRemoved          = "role_removed"
+)
+
+// The severities of security events, from least to most serious.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// A SecurityEvent records something which happened to a user's account that they (or
+// a security team) might want to know about, like a failed login.
+type SecurityEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    *int64          `json:"-"` // Nil for events which aren't tied to an account
+	Event     string          `json:"event"`
+	Severity  string          `json:"severity"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// LoginEvents are the kinds of security event which record an attempt to sign in.
+var LoginEvents = []string{SecurityLoginSucceeded, SecurityLoginFailed}
+
+// SecurityEventSortSafelist is what security events can be sorted on. They're always
+// listed most recent first.
+var SecurityEventSortSafelist = []string{"-created_at"}
+
+type SecurityEventModel struct {
+	DB *sql.DB
+}
+
+func (m SecurityEventModel) Insert(event *SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (user_id, event, severity, ip, user_agent, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	details := event.Details
+	if len(details) == 0 {
+		details = json.RawMessage("{}")
+	}
+
+	args := []any{event.UserID, event.Event, event.Severity, event.IP, event.UserAgent, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAllForUser returns a page of a user's security events, most recent first. If any
+// kinds of event are given, only those kinds are returned.
+func (m SecurityEventModel) GetAllForUser(userID int64, filters Filters, kinds ...string) ([]*SecurityEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, event, severity, ip, user_agent, details
+		FROM security_events
+		WHERE user_id = $1 AND (COALESCE(cardinality($2::text[]), 0) = 0 OR event = ANY($2))
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(kinds), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*SecurityEvent{}
+
+	for rows.Next() {
+		var event SecurityEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.UserID,
+			&event.Event,
+			&event.Severity,
+			&event.IP,
+			&event.UserAgent,
+			&event.Details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return events, metadata, nil
+}
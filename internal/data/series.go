@@ -0,0 +1,410 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A Series is a TV series in an organization's catalog. Its genres come from the same
+// list as movies' genres.
+type Series struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Title     string    `json:"title"`
+	StartYear int32     `json:"start_year"`
+	EndYear   *int32    `json:"end_year,omitempty"` // Nil while the series is still running
+	Genres    []string  `json:"genres"`
+	Synopsis  string    `json:"synopsis,omitempty"`
+	Version   int32     `json:"version"`
+	Seasons   []*Season `json:"seasons,omitempty"` // Only filled in when showing a single series
+}
+
+// A Season is one season of a series, identified by its number within the series.
+// Season 0 is conventionally used for specials.
+type Season struct {
+	Number   int32      `json:"number"`
+	Title    string     `json:"title,omitempty"`
+	Year     int32      `json:"year,omitempty"`
+	Episodes []*Episode `json:"episodes"`
+}
+
+// An Episode is one episode of a season, identified by its number within the season.
+type Episode struct {
+	Number   int32   `json:"number"`
+	Title    string  `json:"title"`
+	Runtime  Runtime `json:"runtime"`
+	AirDate  string  `json:"air_date,omitempty"` // Like 2008-01-20
+	Synopsis string  `json:"synopsis,omitempty"`
+}
+
+// SeriesSortSafelist is what a list of series can be sorted on.
+var SeriesSortSafelist = SortSafelist("id", "title", "start_year")
+
+func ValidateSeries(v *validator.Validator, series *Series) {
+	v.Check(series.Title != "", "title", "must be provided")
+	v.Check(len(series.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(series.StartYear != 0, "start_year", "must be provided")
+	v.Check(series.StartYear >= 1928, "start_year", "must be greater than 1928")
+	v.Check(series.StartYear <= int32(time.Now().Year()), "start_year", "must not be in the future")
+
+	if series.EndYear != nil {
+		v.Check(*series.EndYear >= series.StartYear, "end_year", "must not be before start_year")
+		v.Check(*series.EndYear <= int32(time.Now().Year()), "end_year", "must not be in the future")
+	}
+
+	v.Check(series.Genres != nil, "genres", "must be provided")
+	v.Check(len(series.Genres) >= 1, "genres", "must contain at least 1 genre")
+	v.Check(len(series.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(validator.Unique(series.Genres), "genres", "must not contain duplicate values")
+	v.Check(len(series.Synopsis) <= 10000, "synopsis", "must not be more than 10000 bytes long")
+}
+
+func ValidateSeason(v *validator.Validator, season *Season) {
+	v.Check(season.Number >= 0, "number", "must not be negative")
+	v.Check(len(season.Title) <= 500, "title", "must not be more than 500 bytes long")
+
+	if season.Year != 0 {
+		v.Check(season.Year >= 1928, "year", "must be greater than 1928")
+		v.Check(season.Year <= int32(time.Now().Year())+5, "year", "must not be more than 5 years in the future")
+	}
+
+	v.Check(season.Episodes != nil, "episodes", "must be provided")
+	v.Check(len(season.Episodes) <= 500, "episodes", "must not contain more than 500 episodes")
+
+	numbers := make([]int32, len(season.Episodes))
+
+	for i, episode := range season.Episodes {
+		key := fmt.Sprintf("episodes[%d]", i)
+		numbers[i] = episode.Number
+
+		v.Check(episode.Number > 0, key+".number", "must be a positive integer")
+		v.Check(episode.Title != "", key+".title", "must be provided")
+		v.Check(len(episode.Title) <= 500, key+".title", "must not be more than 500 bytes long")
+		v.Check(episode.Runtime > 0, key+".runtime", "must be a positive integer")
+		v.Check(len(episode.Synopsis) <= 10000, key+".synopsis", "must not be more than 10000 bytes long")
+
+		if episode.AirDate != "" {
+			_, err := time.Parse(time.DateOnly, episode.AirDate)
+			v.Check(err == nil, key+".air_date", "must be a date like 2008-01-20")
+		}
+	}
+
+	v.Check(validator.Unique(numbers), "episodes", "must not contain more than one episode with the same number")
+}
+
+// The SeriesModel only reads and writes the series of one organization, like the
+// MovieModel. Use ForOrganization() to get a model for the organization a request is
+// made on behalf of.
+type SeriesModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the model which is scoped to the
+// given organization.
+func (m SeriesModel) ForOrganization(id int64) SeriesModel {
+	m.OrganizationID = id
+	return m
+}
+
+func (m SeriesModel) Insert(series *Series) error {
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	query := `
+		INSERT INTO series (organization_id, title, start_year, end_year, genres, synopsis)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{m.OrganizationID, series.Title, series.StartYear, series.EndYear, pq.Array(series.Genres), series.Synopsis}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&series.ID, &series.CreatedAt, &series.Version)
+}
+
+// Get returns a series without its seasons. Use GetSeasons() for those.
+func (m SeriesModel) Get(id int64) (*Series, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, title, start_year, end_year, genres, synopsis, version
+		FROM series
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var series Series
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
+		&series.ID,
+		&series.CreatedAt,
+		&series.Title,
+		&series.StartYear,
+		&series.EndYear,
+		pq.Array(&series.Genres),
+		&series.Synopsis,
+		&series.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &series, nil
+}
+
+// GetAll returns a page of series matching a title search and having all of the given
+// genres, like MovieModel.GetAll().
+func (m SeriesModel) GetAll(title string, genres []string, filters Filters) ([]*Series, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, start_year, end_year, genres, synopsis, version
+		FROM series
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND organization_id = $5
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), filters.limit(), filters.offset(), m.OrganizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	list := []*Series{}
+
+	for rows.Next() {
+		var series Series
+
+		err := rows.Scan(
+			&totalRecords,
+			&series.ID,
+			&series.CreatedAt,
+			&series.Title,
+			&series.StartYear,
+			&series.EndYear,
+			pq.Array(&series.Genres),
+			&series.Synopsis,
+			&series.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		list = append(list, &series)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return list, calculateMetadata(totalRecords, filters), nil
+}
+
+// Update saves a series' fields, as long as its version hasn't changed since it was
+// read. Otherwise it returns ErrEditConflict.
+func (m SeriesModel) Update(series *Series) error {
+	query := `
+		UPDATE series
+		SET title = $1, start_year = $2, end_year = $3, genres = $4, synopsis = $5, version = version + 1
+		WHERE id = $6 AND organization_id = $7 AND version = $8
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{series.Title, series.StartYear, series.EndYear, pq.Array(series.Genres), series.Synopsis,
+		series.ID, m.OrganizationID, series.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&series.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a series along with its seasons and episodes.
+func (m SeriesModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM series WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetSeasons returns a series' seasons with their episodes, both in order of their
+// numbers. It doesn't check that the series exists, so callers should look it up first.
+func (m SeriesModel) GetSeasons(seriesID int64) ([]*Season, error) {
+	query := `
+		SELECT seasons.id, seasons.number, seasons.title, COALESCE(seasons.year, 0),
+			episodes.number, episodes.title, episodes.runtime,
+			COALESCE(to_char(episodes.air_date, 'YYYY-MM-DD'), ''), episodes.synopsis
+		FROM seasons
+		LEFT JOIN episodes ON episodes.season_id = seasons.id
+		WHERE seasons.series_id = $1
+		ORDER BY seasons.number, episodes.number`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seasons := []*Season{}
+	var seasonID int64
+
+	for rows.Next() {
+		var id int64
+		var season Season
+		var number, runtime sql.NullInt32
+		var title, airDate, synopsis sql.NullString
+
+		err := rows.Scan(&id, &season.Number, &season.Title, &season.Year, &number, &title, &runtime, &airDate, &synopsis)
+		if err != nil {
+			return nil, err
+		}
+
+		// The rows for a season's episodes come together, so a new season starts
+		// whenever the season's ID changes.
+		if len(seasons) == 0 || id != seasonID {
+			season.Episodes = []*Episode{}
+			seasons = append(seasons, &season)
+			seasonID = id
+		}
+
+		// A season without any episodes has a single row with no episode.
+		if !number.Valid {
+			continue
+		}
+
+		current := seasons[len(seasons)-1]
+		current.Episodes = append(current.Episodes, &Episode{
+			Number:   number.Int32,
+			Title:    title.String,
+			Runtime:  Runtime(runtime.Int32),
+			AirDate:  airDate.String,
+			Synopsis: synopsis.String,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return seasons, nil
+}
+
+// ReplaceSeason adds a season to a series, or replaces the season with the same number
+// and all of its episodes. It doesn't check that the series exists, so callers should
+// look it up first.
+func (m SeriesModel) ReplaceSeason(seriesID int64, season *Season) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var seasonID int64
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO seasons (series_id, number, title, year)
+		VALUES ($1, $2, $3, NULLIF($4, 0))
+		ON CONFLICT (series_id, number) DO UPDATE SET title = EXCLUDED.title, year = EXCLUDED.year
+		RETURNING id`, seriesID, season.Number, season.Title, season.Year).Scan(&seasonID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM episodes WHERE season_id = $1`, seasonID)
+	if err != nil {
+		return err
+	}
+
+	for _, episode := range season.Episodes {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO episodes (season_id, number, title, runtime, air_date, synopsis)
+			VALUES ($1, $2, $3, $4, NULLIF($5, '')::date, $6)`,
+			seasonID, episode.Number, episode.Title, episode.Runtime, episode.AirDate, episode.Synopsis)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSeason removes a season of a series along with its episodes. It returns
+// ErrRecordNotFound if the series has no season with that number.
+func (m SeriesModel) DeleteSeason(seriesID int64, number int32) error {
+	query := `DELETE FROM seasons WHERE series_id = $1 AND number = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, seriesID, number)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
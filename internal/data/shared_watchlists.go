@@ -0,0 +1,552 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// Define constants for the roles a member can have on a shared watchlist. Owners can
+// do everything, editors can add and remove movies, and viewers can only see the list.
+const (
+	WatchlistOwner  = "owner"
+	WatchlistEditor = "editor"
+	WatchlistViewer = "viewer"
+)
+
+var (
+	ErrWatchlistRole     = errors.New("watchlist role not sufficient")
+	ErrLastOwner         = errors.New("last owner")
+	ErrNotInOrganization = errors.New("user not in organization")
+	ErrMovieNotFound     = errors.New("movie not found")
+)
+
+// A SharedWatchlist is a watchlist which belongs to a group of users rather than just
+// one. It belongs to an organization, and only holds movies from its catalog. Role is
+// the role of the user the list was looked up for, and Pending is set if they've been
+// invited but haven't joined yet.
+type SharedWatchlist struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Pending   bool      `json:"pending,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// A SharedWatchlistMember is a user's membership of a shared watchlist. Members who
+// have been invited but haven't joined are pending, and can't see the list yet.
+type SharedWatchlistMember struct {
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	InvitedBy *int64     `json:"invited_by,omitempty"`
+	JoinedAt  *time.Time `json:"joined_at"`
+}
+
+func ValidateSharedWatchlist(v *validator.Validator, list *SharedWatchlist) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+func ValidateWatchlistRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValues(role, WatchlistOwner, WatchlistEditor, WatchlistViewer), "role", "must be owner, editor or viewer")
+}
+
+// WatchlistRoleAtLeast reports whether role grants at least the privileges of min.
+func WatchlistRoleAtLeast(role, min string) bool {
+	rank := map[string]int{WatchlistViewer: 1, WatchlistEditor: 2, WatchlistOwner: 3}
+	return rank[role] >= rank[min]
+}
+
+// The SharedWatchlistModel acts on behalf of one user in one organization, and checks
+// their role on a list before every read or change, so callers can't forget to. Use
+// ForUser() to get a model for the user a request is made by. Lists the user isn't a
+// member of, or hasn't joined yet, are reported as ErrRecordNotFound so that they
+// can't find out which lists exist, and actions their role doesn't allow as
+// ErrWatchlistRole.
+type SharedWatchlistModel struct {
+	DB             *sql.DB
+	PII            *PIICipher
+	OrganizationID int64
+	UserID         int64
+}
+
+// The ForUser() method returns a copy of the model which acts on behalf of the given
+// user in the given organization.
+func (m SharedWatchlistModel) ForUser(organizationID, userID int64) SharedWatchlistModel {
+	m.OrganizationID = organizationID
+	m.UserID = userID
+	return m
+}
+
+// Insert creates a shared watchlist with the model's user as its owner.
+func (m SharedWatchlistModel) Insert(list *SharedWatchlist) error {
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO shared_watchlists (organization_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, m.OrganizationID, list.Name).Scan(&list.ID, &list.CreatedAt, &list.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO shared_watchlist_members (watchlist_id, user_id, role, joined_at)
+		VALUES ($1, $2, $3, NOW())`
+
+	_, err = tx.ExecContext(ctx, query, list.ID, m.UserID, WatchlistOwner)
+	if err != nil {
+		return err
+	}
+
+	list.Role = WatchlistOwner
+
+	return tx.Commit()
+}
+
+// Get returns a shared watchlist which the user has joined.
+func (m SharedWatchlistModel) Get(id int64) (*SharedWatchlist, error) {
+	query := `
+		SELECT shared_watchlists.id, shared_watchlists.created_at, shared_watchlists.name,
+			shared_watchlist_members.role, shared_watchlists.version
+		FROM shared_watchlists
+		INNER JOIN shared_watchlist_members ON shared_watchlist_members.watchlist_id = shared_watchlists.id
+		WHERE shared_watchlists.id = $1 AND shared_watchlists.organization_id = $2
+		AND shared_watchlist_members.user_id = $3 AND shared_watchlist_members.joined_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list SharedWatchlist
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID, m.UserID).Scan(&list.ID, &list.CreatedAt, &list.Name, &list.Role, &list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+// GetAll returns the shared watchlists the user is a member of, including those
+// they've been invited to but haven't joined yet.
+func (m SharedWatchlistModel) GetAll() ([]*SharedWatchlist, error) {
+	query := `
+		SELECT shared_watchlists.id, shared_watchlists.created_at, shared_watchlists.name,
+			shared_watchlist_members.role, shared_watchlist_members.joined_at IS NULL, shared_watchlists.version
+		FROM shared_watchlists
+		INNER JOIN shared_watchlist_members ON shared_watchlist_members.watchlist_id = shared_watchlists.id
+		WHERE shared_watchlists.organization_id = $1 AND shared_watchlist_members.user_id = $2
+		ORDER BY shared_watchlists.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, m.OrganizationID, m.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*SharedWatchlist{}
+
+	for rows.Next() {
+		var list SharedWatchlist
+
+		err := rows.Scan(&list.ID, &list.CreatedAt, &list.Name, &list.Role, &list.Pending, &list.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// Update renames a shared watchlist, which only its owners can do. It uses the
+// version field to prevent concurrent updates from overwriting each other.
+func (m SharedWatchlistModel) Update(list *SharedWatchlist) error {
+	return m.within(list.ID, WatchlistOwner, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			UPDATE shared_watchlists
+			SET name = $1, version = version + 1
+			WHERE id = $2 AND version = $3
+			RETURNING version`
+
+		err := tx.QueryRowContext(ctx, query, list.Name, list.ID, list.Version).Scan(&list.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete removes a shared watchlist, which only its owners can do. Its members and
+// movies are removed by the ON DELETE CASCADE constraints.
+func (m SharedWatchlistModel) Delete(id int64) error {
+	return m.within(id, WatchlistOwner, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM shared_watchlists WHERE id = $1`, id)
+		return err
+	})
+}
+
+// GetMovies returns a page of the movies on a shared watchlist.
+func (m SharedWatchlistModel) GetMovies(id int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	_, err := m.Get(id)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), shared_watchlist_movies.movie_id, movies.title, movies.year,
+			shared_watchlist_movies.added_by, shared_watchlist_movies.added_at
+		FROM shared_watchlist_movies
+		INNER JOIN movies ON movies.id = shared_watchlist_movies.movie_id
+		WHERE shared_watchlist_movies.watchlist_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY shared_watchlist_movies.%s %s, shared_watchlist_movies.movie_id %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(&totalRecords, &entry.MovieID, &entry.Title, &entry.Year, &entry.AddedBy, &entry.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters), nil
+}
+
+// AddMovie adds a movie to a shared watchlist, which editors and owners can do. It
+// returns ErrMovieNotFound if the movie isn't in the list's organization's catalog,
+// and ErrDuplicateWatchlistEntry if it's already on the list.
+func (m SharedWatchlistModel) AddMovie(id int64, entry *WatchlistEntry) error {
+	return m.within(id, WatchlistEditor, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			INSERT INTO shared_watchlist_movies (watchlist_id, movie_id, added_by)
+			SELECT $1, movies.id, $2 FROM movies WHERE movies.id = $3 AND movies.organization_id = $4 AND movies.deleted_at IS NULL
+			RETURNING added_by, added_at, (SELECT title FROM movies WHERE id = $3), (SELECT year FROM movies WHERE id = $3)`
+
+		err := tx.QueryRowContext(ctx, query, id, m.UserID, entry.MovieID, m.OrganizationID).Scan(&entry.AddedBy, &entry.AddedAt, &entry.Title, &entry.Year)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrMovieNotFound
+			case err.Error() == `pq: duplicate key value violates unique constraint "shared_watchlist_movies_pkey"`:
+				return ErrDuplicateWatchlistEntry
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RemoveMovie takes a movie off a shared watchlist, which editors and owners can do.
+// It returns ErrRecordNotFound if the movie wasn't on it.
+func (m SharedWatchlistModel) RemoveMovie(id, movieID int64) error {
+	return m.within(id, WatchlistEditor, func(ctx context.Context, tx *sql.Tx) error {
+		query := `DELETE FROM shared_watchlist_movies WHERE watchlist_id = $1 AND movie_id = $2`
+
+		return execOne(ctx, tx, ErrRecordNotFound, query, id, movieID)
+	})
+}
+
+// GetMembers returns the members of a shared watchlist, including those who haven't
+// joined yet, oldest first.
+func (m SharedWatchlistModel) GetMembers(id int64) ([]*SharedWatchlistMember, error) {
+	_, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT shared_watchlist_members.user_id, users.name, users.name_encrypted, shared_watchlist_members.role,
+			shared_watchlist_members.invited_by, shared_watchlist_members.joined_at
+		FROM shared_watchlist_members
+		INNER JOIN users ON users.id = shared_watchlist_members.user_id
+		WHERE shared_watchlist_members.watchlist_id = $1
+		ORDER BY shared_watchlist_members.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*SharedWatchlistMember{}
+
+	for rows.Next() {
+		var member SharedWatchlistMember
+		var nameEncrypted []byte
+
+		err := rows.Scan(&member.UserID, &member.Name, &nameEncrypted, &member.Role, &member.InvitedBy, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if nameEncrypted != nil {
+			if m.PII == nil {
+				return nil, errors.New("user data is encrypted but no PII key is configured")
+			}
+
+			member.Name, err = m.PII.Decrypt("name", nameEncrypted)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Invite adds a user to a shared watchlist as a pending member, which only owners can
+// do. The user has to be a member of the list's organization, or it returns
+// ErrNotInOrganization. It returns ErrDuplicateMember if they're already a member or
+// have already been invited.
+func (m SharedWatchlistModel) Invite(id int64, member *SharedWatchlistMember) error {
+	return m.within(id, WatchlistOwner, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			INSERT INTO shared_watchlist_members (watchlist_id, user_id, role, invited_by)
+			SELECT $1, user_id, $3, $4 FROM organization_members WHERE organization_id = $5 AND user_id = $2
+			RETURNING invited_by`
+
+		err := tx.QueryRowContext(ctx, query, id, member.UserID, member.Role, m.UserID, m.OrganizationID).Scan(&member.InvitedBy)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotInOrganization
+			case err.Error() == `pq: duplicate key value violates unique constraint "shared_watchlist_members_pkey"`:
+				return ErrDuplicateMember
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Join accepts the user's invitation to a shared watchlist. It returns
+// ErrRecordNotFound if they haven't been invited, or have already joined.
+func (m SharedWatchlistModel) Join(id int64) error {
+	query := `
+		UPDATE shared_watchlist_members
+		SET joined_at = NOW()
+		WHERE watchlist_id = $1 AND user_id = $2 AND joined_at IS NULL
+		AND watchlist_id IN (SELECT id FROM shared_watchlists WHERE organization_id = $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, ErrRecordNotFound, query, id, m.UserID, m.OrganizationID)
+}
+
+// Decline turns down the user's invitation to a shared watchlist. It returns
+// ErrRecordNotFound if they haven't been invited, or have already joined.
+func (m SharedWatchlistModel) Decline(id int64) error {
+	query := `
+		DELETE FROM shared_watchlist_members
+		WHERE watchlist_id = $1 AND user_id = $2 AND joined_at IS NULL
+		AND watchlist_id IN (SELECT id FROM shared_watchlists WHERE organization_id = $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, ErrRecordNotFound, query, id, m.UserID, m.OrganizationID)
+}
+
+// SetRole changes a member's role, which only owners can do. It returns
+// ErrRecordNotFound if the user isn't a member, and ErrLastOwner if it would leave
+// the list without an owner.
+func (m SharedWatchlistModel) SetRole(id, userID int64, role string) error {
+	return m.within(id, WatchlistOwner, func(ctx context.Context, tx *sql.Tx) error {
+		if role != WatchlistOwner {
+			err := m.checkOtherOwner(ctx, tx, id, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		query := `UPDATE shared_watchlist_members SET role = $3 WHERE watchlist_id = $1 AND user_id = $2`
+
+		return execOne(ctx, tx, ErrRecordNotFound, query, id, userID, role)
+	})
+}
+
+// RemoveMember removes a user from a shared watchlist, or withdraws their invitation.
+// Owners can remove anyone, and any member can remove themselves to leave, or to
+// decline an invitation. It returns ErrRecordNotFound if the user isn't a member,
+// and ErrLastOwner if it would leave the list without an owner.
+func (m SharedWatchlistModel) RemoveMember(id, userID int64) error {
+	min := WatchlistOwner
+	if userID == m.UserID {
+		err := m.Decline(id)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+
+		min = WatchlistViewer
+	}
+
+	return m.within(id, min, func(ctx context.Context, tx *sql.Tx) error {
+		err := m.checkOtherOwner(ctx, tx, id, userID)
+		if err != nil {
+			return err
+		}
+
+		query := `DELETE FROM shared_watchlist_members WHERE watchlist_id = $1 AND user_id = $2`
+
+		return execOne(ctx, tx, ErrRecordNotFound, query, id, userID)
+	})
+}
+
+// checkOtherOwner returns ErrLastOwner if the given user is the only owner of a shared
+// watchlist who has joined it.
+func (m SharedWatchlistModel) checkOtherOwner(ctx context.Context, tx *sql.Tx, id, userID int64) error {
+	query := `
+		SELECT count(*) FILTER (WHERE user_id <> $2), count(*) FILTER (WHERE user_id = $2)
+		FROM shared_watchlist_members
+		WHERE watchlist_id = $1 AND role = $3 AND joined_at IS NOT NULL`
+
+	var others, self int
+
+	err := tx.QueryRowContext(ctx, query, id, userID, WatchlistOwner).Scan(&others, &self)
+	if err != nil {
+		return err
+	}
+
+	if self > 0 && others == 0 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+// within runs fn in a transaction once it has checked that the user has joined the
+// shared watchlist with at least the given role. The list is
+// locked first, so that changes to its members are made one at a time and a member
+// can't lose their role while fn runs.
+func (m SharedWatchlistModel) within(id int64, min string, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT shared_watchlist_members.role
+		FROM shared_watchlists
+		INNER JOIN shared_watchlist_members ON shared_watchlist_members.watchlist_id = shared_watchlists.id
+		WHERE shared_watchlists.id = $1 AND shared_watchlists.organization_id = $2
+		AND shared_watchlist_members.user_id = $3 AND shared_watchlist_members.joined_at IS NOT NULL
+		FOR UPDATE OF shared_watchlists`
+
+	var role string
+
+	err = tx.QueryRowContext(ctx, query, id, m.OrganizationID, m.UserID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if !WatchlistRoleAtLeast(role, min) {
+		return ErrWatchlistRole
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execOne runs a query which should change exactly one row, returning notFound if it
+// didn't change any.
+func execOne(ctx context.Context, db DBTX, notFound error, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return notFound
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DailyCount is the number of things which happened on a day.
+type DailyCount struct {
+	Date  string `json:"date"` // In YYYY-MM-DD format
+	Count int64  `json:"count"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int64  `json:"count"`
+}
+
+type UserStats struct {
+	Total          int64   `json:"total"`
+	Activated      int64   `json:"activated"`
+	ActivationRate float64 `json:"activation_rate"` // Between 0 and 1
+}
+
+// TableStats describes the size of a database table. Rows is PostgreSQL's estimate of
+// the number of live rows, which avoids a full scan of every table.
+type TableStats struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	TotalBytes int64  `json:"total_bytes"` // Including indexes and TOAST data
+}
+
+// StatsModel runs the aggregate queries behind the admin statistics.
+type StatsModel struct {
+	DB *sql.DB
+}
+
+func (m StatsModel) Users() (UserStats, error) {
+	query := `SELECT count(*), count(*) FILTER (WHERE activated) FROM users`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stats UserStats
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.Total, &stats.Activated)
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	if stats.Total > 0 {
+		stats.ActivationRate = float64(stats.Activated) / float64(stats.Total)
+	}
+
+	return stats, nil
+}
+
+// TokensPerDay returns the number of tokens issued on each of the past days, including
+// today.
+func (m StatsModel) TokensPerDay(days int) ([]DailyCount, error) {
+	query := `
+		SELECT to_char(d, 'YYYY-MM-DD'), COALESCE(sum(t.issued), 0)
+		FROM generate_series(current_date - ($1::int - 1), current_date, interval '1 day') AS d
+		LEFT JOIN token_issuance t ON t.day = d::date
+		GROUP BY d
+		ORDER BY d`
+
+	return m.daily(query, days)
+}
+
+// RequestsPerDay returns the number of API requests made on each of the past days,
+// including today, from the daily metrics. Days which haven't been rolled up yet count
+// as zero.
+func (m StatsModel) RequestsPerDay(days int) ([]DailyCount, error) {
+	query := `
+		SELECT to_char(d, 'YYYY-MM-DD'), COALESCE(sum(md.requests), 0)
+		FROM generate_series(current_date - ($1::int - 1), current_date, interval '1 day') AS d
+		LEFT JOIN metrics_daily md ON md.day = d::date
+		GROUP BY d
+		ORDER BY d`
+
+	return m.daily(query, days)
+}
+
+// RollupDailyMetrics recomputes the daily metrics for each day since the given date
+// from the endpoint usage, returning the number of days rolled up. Rolling a day up
+// again replaces its totals, so days which are still in progress can be rolled up
+// repeatedly.
+func (m StatsModel) RollupDailyMetrics(since time.Time) (int64, error) {
+	query := `
+		INSERT INTO metrics_daily (day, requests, errors, active_users)
+		SELECT day, sum(requests), sum(errors), count(DISTINCT user_id)
+		FROM api_usage_endpoints
+		WHERE day >= $1
+		GROUP BY day
+		ON CONFLICT (day) DO UPDATE
+		SET requests = EXCLUDED.requests, errors = EXCLUDED.errors, active_users = EXCLUDED.active_users, updated_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// MoviesPerDay returns the number of movies added on each of the past days, including
+// today.
+func (m StatsModel) MoviesPerDay(days int) ([]DailyCount, error) {
+	query := `
+		SELECT to_char(d, 'YYYY-MM-DD'), count(m.id)
+		FROM generate_series(current_date - ($1::int - 1), current_date, interval '1 day') AS d
+		LEFT JOIN movies m ON m.created_at::date = d::date
+		GROUP BY d
+		ORDER BY d`
+
+	return m.daily(query, days)
+}
+
+func (m StatsModel) daily(query string, days int) ([]DailyCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []DailyCount{}
+
+	for rows.Next() {
+		var c DailyCount
+
+		err := rows.Scan(&c.Date, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// TopGenres returns the genres with the most movies, most popular first.
+func (m StatsModel) TopGenres(limit int) ([]GenreCount, error) {
+	query := `
+		SELECT genre, count(*)
+		FROM movies, unnest(genres) AS genre
+		WHERE movies.deleted_at IS NULL
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []GenreCount{}
+
+	for rows.Next() {
+		var g GenreCount
+
+		err := rows.Scan(&g.Genre, &g.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Tables returns the row estimates and on-disk sizes of the application's tables,
+// largest first.
+func (m StatsModel) Tables() ([]TableStats, error) {
+	query := `
+		SELECT relname, n_live_tup, pg_total_relation_size(relid)
+		FROM pg_stat_user_tables
+		ORDER BY pg_total_relation_size(relid) DESC, relname`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []TableStats{}
+
+	for rows.Next() {
+		var t TableStats
+
+		err := rows.Scan(&t.Name, &t.Rows, &t.TotalBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenKeyring holds the secret keys (or "peppers") used to hash tokens. Tokens are
+// stored as an HMAC-SHA-256 of the plaintext using the current key, so someone with a
+// copy of the database but not the keys can't work out a valid token from a hash, or
+// forge a hash for a token of their own.
+//
+// Keys are numbered, and the highest version is the one used for new tokens. When a
+// new version is introduced, the keys it replaces are still accepted for the grace
+// period, so that tokens issued shortly before the rotation keep working until they
+// expire. Version 0 is the plain SHA-256 hash used before keys were introduced.
+type TokenKeyring struct {
+	mu      sync.RWMutex
+	grace   time.Duration
+	current int
+	keys    map[int][]byte
+	retired map[int]time.Time // When each previous version stopped being current
+}
+
+// NewTokenKeyring returns a keyring with no keys, which hashes tokens with plain
+// SHA-256 until keys are set.
+func NewTokenKeyring(grace time.Duration) *TokenKeyring {
+	return &TokenKeyring{
+		grace:   grace,
+		keys:    map[int][]byte{},
+		retired: map[int]time.Time{},
+	}
+}
+
+// ParseTokenKeys parses a comma-separated list of keys in the form
+// <version>:<base64 secret>, like "2:c2VjcmV0LXR3by4uLg==,1:b2xkLXNlY3JldC4uLg==".
+// Versions must be positive, and secrets must be at least 32 bytes long.
+func ParseTokenKeys(s string) (map[int][]byte, error) {
+	keys := map[int][]byte{}
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		rawVersion, rawSecret, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, errors.New("token keys must be in the form <version>:<base64 secret>")
+		}
+
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("token key version %q must be a positive integer", rawVersion)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(rawSecret)
+		if err != nil {
+			return nil, fmt.Errorf("token key %d is not valid base64", version)
+		}
+
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("token key %d must be at least 32 bytes long", version)
+		}
+
+		if _, exists := keys[version]; exists {
+			return nil, fmt.Errorf("token key %d is listed more than once", version)
+		}
+
+		keys[version] = secret
+	}
+
+	return keys, nil
+}
+
+// Set replaces the keys, making the highest version current. Versions which are no
+// longer current start their grace period now, unless it has already started. Versions
+// which have been removed entirely are no longer accepted, with the exception of
+// version 0, which needs no key.
+func (k *TokenKeyring) Set(keys map[int][]byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	current := 0
+	for version := range keys {
+		current = max(current, version)
+	}
+
+	now := time.Now()
+
+	if current != k.current {
+		if _, ok := k.retired[k.current]; !ok {
+			k.retired[k.current] = now
+		}
+	}
+
+	// Keys which are listed alongside the current one when they are first loaded
+	// (for example, when the application starts) also start their grace period.
+	for version := range keys {
+		if _, ok := k.retired[version]; !ok && version != current {
+			k.retired[version] = now
+		}
+	}
+
+	delete(k.retired, current)
+
+	k.current = current
+	k.keys = keys
+}
+
+// hash returns the hash of a plaintext token using the given key version.
+func (k *TokenKeyring) hash(version int, plaintext string) []byte {
+	if version == 0 {
+		sum := sha256.Sum256([]byte(plaintext))
+		return sum[:]
+	}
+
+	mac := hmac.New(sha256.New, k.keys[version])
+	mac.Write([]byte(plaintext))
+	return mac.Sum(nil)
+}
+
+// Hash returns the hash of a plaintext token using the current key, along with the
+// key's version.
+func (k *TokenKeyring) Hash(plaintext string) (int, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.current, k.hash(k.current, plaintext)
+}
+
+// Candidates returns the hashes of a plaintext token under every key version that is
+// currently accepted, and the matching versions, for looking the token up.
+func (k *TokenKeyring) Candidates(plaintext string) (versions []int, hashes [][]byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, version := range k.accepted() {
+		versions = append(versions, version)
+		hashes = append(hashes, k.hash(version, plaintext))
+	}
+
+	return versions, hashes
+}
+
+// Accepted returns the key versions which tokens can currently be verified against.
+func (k *TokenKeyring) Accepted() []int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.accepted()
+}
+
+func (k *TokenKeyring) accepted() []int {
+	versions := []int{k.current}
+
+	for version, retiredAt := range k.retired {
+		if time.Since(retiredAt) > k.grace {
+			continue
+		}
+
+		if _, ok := k.keys[version]; ok || version == 0 {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions
+}
+
+var ErrNoSigningKey = errors.New("no token key is configured for signing")
+
+// Sign returns an HMAC of a message made with the current key, along with the key's
+// version, for data which is handed out rather than stored, like unsubscribe links.
+// Unlike Hash(), it refuses to work without a key, since anyone could forge a plain
+// SHA-256 hash.
+func (k *TokenKeyring) Sign(message string) (int, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.current == 0 {
+		return 0, nil, ErrNoSigningKey
+	}
+
+	return k.current, k.hash(k.current, message), nil
+}
+
+// Verify reports whether mac is the HMAC of a message under the given key version,
+// and that version is currently accepted.
+func (k *TokenKeyring) Verify(message string, version int, mac []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if version == 0 || !slices.Contains(k.accepted(), version) {
+		return false
+	}
+
+	return hmac.Equal(mac, k.hash(version, message))
+}
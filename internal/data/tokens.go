@@ -3,11 +3,13 @@ package data
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"greenlight/anaplo/internal/validator"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Define constants for the token scope. For now we just define the scope "activation"
@@ -15,6 +17,9 @@ import (
 const (
 	ScopeActivation    = "activation"
 	ScopeAuthorization = "authorization"
+	ScopeOAuth         = "oauth" // An access token issued to a third-party application
+	ScopeMagicLink     = "magic_link"
+	ScopeEmailChange   = "email_change"
 )
 
 // var (
@@ -27,37 +32,249 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// The version of the key the hash was made with. See TokenKeyring.
+	KeyVersion int `json:"-"`
+	// For ScopeOAuth tokens, the application the token was issued to and the
+	// permissions the user granted it.
+	ClientID    *int64   `json:"-"`
+	Permissions []string `json:"-"`
+	// For ScopeAuthorization and ScopeMagicLink tokens, the organization the user
+	// signed in to. If it's nil the token is scoped to the user's first organization.
+	OrganizationID *int64 `json:"-"`
+	// For ScopeEmailChange tokens, the new email address the token confirms.
+	Email *string `json:"-"`
+	// For tokens which are emailed to the user, the job which sends them. See
+	// InsertForJob().
+	JobID *int64 `json:"-"`
+}
+
+// A TokenGrant describes the restrictions on an access token which was issued to a
+// third-party application.
+type TokenGrant struct {
+	ClientID    int64
+	Permissions Permissions
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB   DBTX
+	Keys *TokenKeyring
+	// If PII is set, the email addresses of email change tokens are encrypted.
+	PII *PIICipher
+}
+
+// The WithTx() method returns a copy of the model which runs its queries inside the
+// given transaction.
+func (m TokenModel) WithTx(tx *sql.Tx) TokenModel {
+	m.DB = tx
+	return m
 }
 
 // generate a new token
 // and call TOkenModel.Insert()
 func (m *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	token, err := generateToken(userID, ttl, scope, m.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewForOrganization generates an authentication token which is scoped to one of the
+// user's organizations.
+func (m *TokenModel) NewForOrganization(userID, organizationID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthorization, m.Keys)
 	if err != nil {
 		return nil, err
 	}
 
+	token.OrganizationID = &organizationID
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// InsertForJob stores a token which is going to be emailed to the user by the given
+// job. It's stored with the hash of a random plaintext which is thrown away, so it
+// can't be used until the job calls Reissue() to get a plaintext for the email. Since
+// the token exists from the time it's requested, only the job can make it usable, and
+// deleting it (when a newer one replaces it, for example) stops the email being sent.
+func (m *TokenModel) InsertForJob(token *Token, jobID int64) error {
+	placeholder, err := generateToken(token.UserID, 0, token.Scope, m.Keys)
+	if err != nil {
+		return err
+	}
+
+	token.KeyVersion, token.Hash = placeholder.KeyVersion, placeholder.Hash
+	token.JobID = &jobID
+
+	return m.Insert(token)
+}
+
+// Reissue gives the unexpired token belonging to a job a new random plaintext and
+// returns it, so that every attempt at sending an email has a token to put in it. The
+// plaintext from any earlier attempt stops working, so there's never more than one
+// usable token per email. It returns ErrRecordNotFound if the token has been used,
+// deleted or has expired.
+func (m *TokenModel) Reissue(jobID int64) (*Token, error) {
+	token, err := generateToken(0, 0, "", m.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE tokens
+				SET hash = $1, key_version = $2
+				WHERE job_id = $3 AND expiry > $4
+				RETURNING user_id, expiry, scope, organization_id, email, email_encrypted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email *string
+	var emailEncrypted []byte
+
+	args := []any{token.Hash, token.KeyVersion, jobID, time.Now()}
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&token.UserID, &token.Expiry, &token.Scope, &token.OrganizationID, &email, &emailEncrypted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	token.JobID = &jobID
+
+	token.Email, err = m.openEmail(email, emailEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// NewWithPlaintext stores a token with a chosen plaintext rather than a random one.
+// It's only for loading test fixtures, where clients need to know the tokens in
+// advance.
+func (m *TokenModel) NewWithPlaintext(userID int64, plainText string, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		PlainText: plainText,
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+
+	token.KeyVersion, token.Hash = m.Keys.Hash(token.PlainText)
+
+	err := m.Insert(token)
+	return token, err
+}
+
+// NewForClient generates an access token for a third-party application, which only
+// carries the given permissions.
+func (m *TokenModel) NewForClient(userID, clientID int64, permissions []string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeOAuth, m.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientID = &clientID
+	token.Permissions = permissions
+
 	err = m.Insert(token)
 	return token, err
 }
 
 func (m *TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO tokens (hash, user_id, expiry, scope) 
-				VALUES ($1, $2, $3, $4)`
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope, key_version, oauth_client_id, permissions, organization_id, email, email_encrypted, email_index, job_id) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+
+	email := token.Email
+	var emailEncrypted, emailIndex []byte
+
+	// Like users' email addresses, the address an email change token confirms is
+	// encrypted if PII encryption is enabled, with a blind index for looking it up.
+	if m.PII != nil && token.Email != nil {
+		var err error
+
+		emailEncrypted, err = m.PII.Encrypt("email", *token.Email)
+		if err != nil {
+			return err
+		}
+
+		emailIndex = m.PII.EmailIndex(*token.Email)
+		email = nil
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.KeyVersion, token.ClientID, pq.Array(token.Permissions), token.OrganizationID, email, emailEncrypted, emailIndex, token.JobID}
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
+// openEmail returns a token's email address from whichever of its columns is set.
+func (m *TokenModel) openEmail(email *string, emailEncrypted []byte) (*string, error) {
+	if emailEncrypted == nil {
+		return email, nil
+	}
+
+	if m.PII == nil {
+		return nil, errors.New("token data is encrypted but no PII key is configured")
+	}
+
+	plaintext, err := m.PII.Decrypt("email", emailEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plaintext, nil
+}
+
+// Consume deletes an unexpired token with the given scope and returns it, so that it
+// can only be used once. It returns ErrRecordNotFound if there's no such token.
+func (m *TokenModel) Consume(scope, plainText string) (*Token, error) {
+	versions, hashes := m.Keys.Candidates(plainText)
+
+	query := `DELETE FROM tokens
+				WHERE (hash, key_version) IN (SELECT * FROM unnest($1::bytea[], $2::integer[]))
+				AND scope = $3
+				AND expiry > $4
+				RETURNING hash, user_id, expiry, scope, key_version, organization_id, email, email_encrypted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{PlainText: plainText}
+
+	var email *string
+	var emailEncrypted []byte
+
+	args := []any{pq.Array(hashes), pq.Array(versions), scope, time.Now()}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&token.Hash, &token.UserID, &token.Expiry, &token.Scope, &token.KeyVersion, &token.OrganizationID, &email, &emailEncrypted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	token.Email, err = m.openEmail(email, emailEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
 func (m *TokenModel) DeleteAllForUser(userID int64, scope string) error {
 	query := `DELETE FROM tokens WHERE user_id=$1 AND scope=$2`
 
@@ -68,13 +285,45 @@ func (m *TokenModel) DeleteAllForUser(userID int64, scope string) error {
 	return err
 }
 
+// DeleteExpired removes every token whose expiry time has passed, returning the number
+// of tokens deleted.
+func (m *TokenModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM tokens WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteForRetiredKeys removes every token which was hashed with a key version that
+// is no longer accepted, since those tokens can't be used any more.
+func (m *TokenModel) DeleteForRetiredKeys() (int64, error) {
+	query := `DELETE FROM tokens WHERE NOT key_version = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, pq.Array(m.Keys.Accepted()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
 // Check that the plaintext token has been provided and is exactly 26 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func generateToken(userID int64, ttl time.Duration, scope string, keys *TokenKeyring) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// Notice that we add the provided ttl (time-to-live) duration parameter to the
 	// current time to get the expiry time?
@@ -105,12 +354,9 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// we use the WithPadding(base32.NoPadding) method in the line below to omit them.
 	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// Generate a SHA-256 hash of the plaintext token string. This will be the value
-	// that we store in the `hash` field of our database table. Note that the
-	// sha256.Sum256() function returns an *array* of length 32, so to make it easier to
-	// work with we convert it to a slice using the [:] operator before storing it.
-	hash := sha256.Sum256([]byte(token.PlainText))
-	token.Hash = hash[:]
+	// Hash the plaintext token string with the current key. This will be the value
+	// that we store in the `hash` field of our database table.
+	token.KeyVersion, token.Hash = keys.Hash(token.PlainText)
 
 	return token, nil
 }
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LanguageRX matches BCP 47 language tags, like fr, pt-BR or zh-Hant.
+var LanguageRX = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// A Translation is a movie's title in another language.
+type Translation struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+// NormalizeLanguage puts a language tag in its usual case: the language in lowercase,
+// a region in uppercase and a script in title case, like pt-BR or zh-Hant.
+func NormalizeLanguage(tag string) string {
+	parts := strings.Split(tag, "-")
+
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part)
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// LanguageFallbacks returns the tags to look for when the given one is wanted, from
+// the most specific to the least, like pt-BR and then pt.
+func LanguageFallbacks(tag string) []string {
+	tags := []string{tag}
+
+	for {
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			return tags
+		}
+
+		tag = tag[:i]
+		tags = append(tags, tag)
+	}
+}
+
+func ValidateTranslations(v *validator.Validator, translations []Translation) {
+	v.Check(len(translations) <= 200, "translations", "must not contain more than 200 translations")
+
+	languages := make([]string, len(translations))
+
+	for i, t := range translations {
+		key := fmt.Sprintf("translations[%d]", i)
+		languages[i] = NormalizeLanguage(t.Language)
+
+		v.Check(len(t.Language) <= 35 && v.Matches(t.Language, LanguageRX), key+".language", "must be a language tag, like fr or pt-BR")
+		v.Check(t.Title != "", key+".title", "must be provided")
+		v.Check(len(t.Title) <= 500, key+".title", "must not be more than 500 bytes long")
+	}
+
+	v.Check(validator.Unique(languages), "translations", "must not contain more than one title per language")
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+// GetForMovie returns a movie's translated titles, ordered by language. It doesn't
+// check that the movie exists, so callers should look it up first.
+func (m TranslationModel) GetForMovie(movieID int64) ([]Translation, error) {
+	query := `
+		SELECT language, title
+		FROM movie_translations
+		WHERE movie_id = $1
+		ORDER BY language`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []Translation{}
+
+	for rows.Next() {
+		var t Translation
+
+		err := rows.Scan(&t.Language, &t.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		translations = append(translations, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// GetForMovies returns the best title for each of the given movies in one of the
+// languages, which should be ordered from the most wanted to the least, like the
+// result of LanguageFallbacks(). Movies without a title in any of them are left out.
+func (m TranslationModel) GetForMovies(movieIDs []int64, languages []string) (map[int64]Translation, error) {
+	query := `
+		SELECT DISTINCT ON (movie_id) movie_id, language, title
+		FROM movie_translations
+		WHERE movie_id = ANY($1) AND language = ANY($2)
+		ORDER BY movie_id, array_position($2::text[], language)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(languages))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := map[int64]Translation{}
+
+	for rows.Next() {
+		var movieID int64
+		var t Translation
+
+		err := rows.Scan(&movieID, &t.Language, &t.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		translations[movieID] = t
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// Replace sets a movie's translated titles, replacing whatever was there before. The
+// language tags are stored normalized.
+func (m TranslationModel) Replace(movieID int64, translations []Translation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_translations WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range translations {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movie_translations (movie_id, language, title)
+			VALUES ($1, $2, $3)`, movieID, NormalizeLanguage(t.Language), t.Title)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// An unsubscribe token lets the recipient of an optional email turn that kind of email
+// off without signing in. It's in the form <user id>.<kind>.<key version>.<signature>,
+// and is signed rather than stored, so that every email can carry one without filling
+// up the tokens table.
+
+// NewUnsubscribeToken returns a token which turns off the given kind of email for a
+// user. It returns ErrNoSigningKey if no token keys are configured.
+func NewUnsubscribeToken(keys *TokenKeyring, userID int64, kind string) (string, error) {
+	version, mac, err := keys.Sign(unsubscribeMessage(userID, kind))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d.%s.%d.%s", userID, kind, version, base64.RawURLEncoding.EncodeToString(mac)), nil
+}
+
+// ParseUnsubscribeToken checks an unsubscribe token's signature, and returns the user
+// and kind of email it's for.
+func ParseUnsubscribeToken(keys *TokenKeyring, token string) (int64, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || userID < 1 {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	kind := parts[1]
+
+	version, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	if !keys.Verify(unsubscribeMessage(userID, kind), version, mac) {
+		return 0, "", ErrInvalidUnsubscribeToken
+	}
+
+	return userID, kind, nil
+}
+
+// The message is prefixed so that a signature made for an unsubscribe token can't be
+// passed off as one for anything else signed with the same keys.
+func unsubscribeMessage(userID int64, kind string) string {
+	return fmt.Sprintf("unsubscribe:%d:%s", userID, kind)
+}
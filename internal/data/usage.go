@@ -0,0 +1,252 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UsageCounts are the number of requests made with a user's (or an application's)
+// tokens so far today and so far this month, in UTC.
+type UsageCounts struct {
+	Day   int64 `json:"day"`
+	Month int64 `json:"month"`
+}
+
+// A UsageDay is the number of requests made on one day with a user's own tokens
+// (ClientID 0) or by one of the applications they've authorized.
+type UsageDay struct {
+	Day        time.Time `json:"day"`
+	ClientID   int64     `json:"client_id,omitempty"`
+	ClientName string    `json:"client,omitempty"`
+	Requests   int64     `json:"requests"`
+}
+
+type UsageModel struct {
+	DB *sql.DB
+}
+
+// Record counts a request made at the given time, and returns the updated counts for
+// the day and month it was made in.
+func (m UsageModel) Record(userID, clientID int64, at time.Time) (UsageCounts, error) {
+	query := `
+		WITH bumped AS (
+			INSERT INTO api_usage (user_id, client_id, day, requests)
+			VALUES ($1, $2, $3, 1)
+			ON CONFLICT (user_id, client_id, day) DO UPDATE SET requests = api_usage.requests + 1
+			RETURNING requests
+		)
+		SELECT bumped.requests, bumped.requests + COALESCE((
+			SELECT sum(requests) FROM api_usage
+			WHERE user_id = $1 AND client_id = $2 AND day >= date_trunc('month', $3::date) AND day < $3
+		), 0)
+		FROM bumped`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var counts UsageCounts
+
+	day := at.UTC().Format(time.DateOnly)
+
+	err := m.DB.QueryRowContext(ctx, query, userID, clientID, day).Scan(&counts.Day, &counts.Month)
+	return counts, err
+}
+
+// GetForUser returns a user's usage on each day since the given date, most recent
+// first, broken down by application.
+func (m UsageModel) GetForUser(userID int64, since time.Time) ([]*UsageDay, error) {
+	query := `
+		SELECT api_usage.day, api_usage.client_id, COALESCE(oauth_clients.name, ''), api_usage.requests
+		FROM api_usage
+		LEFT JOIN oauth_clients ON oauth_clients.id = api_usage.client_id
+		WHERE api_usage.user_id = $1 AND api_usage.day >= $2
+		ORDER BY api_usage.day DESC, api_usage.client_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []*UsageDay{}
+
+	for rows.Next() {
+		var day UsageDay
+
+		err := rows.Scan(&day.Day, &day.ClientID, &day.ClientName, &day.Requests)
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, &day)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// EndpointUsage is the usage of one endpoint on one day, attributed like a UsageDay.
+// The route is the endpoint's pattern, like /v1/movies/:id.
+type EndpointUsage struct {
+	UserID       int64
+	ClientID     int64
+	Day          time.Time
+	Method       string
+	Route        string
+	Requests     int64
+	Errors       int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// EndpointStats summarizes the requests to one endpoint over a period, as reported to
+// users.
+type EndpointStats struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	ClientID     int64   `json:"client_id,omitempty"`
+	ClientName   string  `json:"client,omitempty"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	MaxLatencyMS float64 `json:"max_latency_ms"`
+}
+
+// UserUsageStats summarizes one user's requests over a period, for finding the
+// heaviest consumers of the API.
+type UserUsageStats struct {
+	UserID       int64   `json:"user_id"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	Endpoints    int64   `json:"endpoints"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	MaxLatencyMS float64 `json:"max_latency_ms"`
+}
+
+// RecordEndpoints adds a batch of endpoint usage to the totals, in one transaction.
+// Usage by users who have since been deleted is skipped.
+func (m UsageModel) RecordEndpoints(usage []EndpointUsage) error {
+	query := `
+		INSERT INTO api_usage_endpoints (user_id, client_id, day, method, route, requests, errors, total_latency_us, max_latency_us)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+		WHERE EXISTS (SELECT 1 FROM users WHERE id = $1)
+		ON CONFLICT (user_id, client_id, day, method, route) DO UPDATE
+		SET requests = api_usage_endpoints.requests + EXCLUDED.requests,
+			errors = api_usage_endpoints.errors + EXCLUDED.errors,
+			total_latency_us = api_usage_endpoints.total_latency_us + EXCLUDED.total_latency_us,
+			max_latency_us = GREATEST(api_usage_endpoints.max_latency_us, EXCLUDED.max_latency_us)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range usage {
+		_, err = stmt.ExecContext(ctx, u.UserID, u.ClientID, u.Day.UTC().Format(time.DateOnly), u.Method, u.Route,
+			u.Requests, u.Errors, u.TotalLatency.Microseconds(), u.MaxLatency.Microseconds())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetEndpointsForUser returns a user's usage of each endpoint since the given date,
+// broken down by application, busiest first.
+func (m UsageModel) GetEndpointsForUser(userID int64, since time.Time) ([]*EndpointStats, error) {
+	query := `
+		SELECT e.method, e.route, e.client_id, COALESCE(oauth_clients.name, ''), sum(e.requests), sum(e.errors),
+			sum(e.total_latency_us)::float8 / sum(e.requests) / 1000, max(e.max_latency_us)::float8 / 1000
+		FROM api_usage_endpoints e
+		LEFT JOIN oauth_clients ON oauth_clients.id = e.client_id
+		WHERE e.user_id = $1 AND e.day >= $2
+		GROUP BY e.method, e.route, e.client_id, oauth_clients.name
+		ORDER BY sum(e.requests) DESC, e.route, e.method, e.client_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*EndpointStats{}
+
+	for rows.Next() {
+		var s EndpointStats
+
+		err := rows.Scan(&s.Method, &s.Route, &s.ClientID, &s.ClientName, &s.Requests, &s.Errors, &s.AvgLatencyMS, &s.MaxLatencyMS)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+// TopUsers returns the users who made the most requests since the given date, up to
+// limit of them.
+func (m UsageModel) TopUsers(since time.Time, limit int) ([]*UserUsageStats, error) {
+	query := `
+		SELECT user_id, sum(requests), sum(errors), count(DISTINCT (method, route)),
+			sum(total_latency_us)::float8 / sum(requests) / 1000, max(max_latency_us)::float8 / 1000
+		FROM api_usage_endpoints
+		WHERE day >= $1
+		GROUP BY user_id
+		ORDER BY sum(requests) DESC, user_id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since.UTC().Format(time.DateOnly), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*UserUsageStats{}
+
+	for rows.Next() {
+		var s UserUsageStats
+
+		err := rows.Scan(&s.UserID, &s.Requests, &s.Errors, &s.Endpoints, &s.AvgLatencyMS, &s.MaxLatencyMS)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -2,12 +2,14 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"greenlight/anaplo/internal/validator"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,21 +18,40 @@ var (
 )
 
 type UsersModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *TokenKeyring
+	// If PII is set, names and email addresses are encrypted in the database.
+	PII *PIICipher
 }
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Password   password   `json:"-"`
+	Activated  bool       `json:"activated"`
+	Suspended  bool       `json:"suspended"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	Version    int        `json:"-"`
 }
 
 var AnonymousUser = &User{}
 
+// UserFilter narrows down the users listed by GetAll. The zero value matches every
+// user.
+type UserFilter struct {
+	Activated *bool
+	// Email only matches whole addresses, since they may be encrypted.
+	Email string
+	// SeenBefore matches users who haven't been seen since then, including those
+	// who have never been seen.
+	SeenBefore time.Time
+}
+
+// UserSortSafelist is what the list of users can be sorted on.
+var UserSortSafelist = SortSafelist("id", "created_at", "last_seen_at")
+
 // Check if a User instance is the AnonymousUser.
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
@@ -75,28 +96,175 @@ func (p *password) Matches(plainTextPassword string) (bool, error) {
 	return true, nil
 }
 
+// The columns selected for every query which returns a full User. The name and email
+// are decrypted by scanUser().
+const userColumns = `users.id, users.created_at, users.name, COALESCE(users.email, ''), users.name_encrypted,
+		users.email_encrypted, users.password_hash, users.activated, users.suspended, users.last_seen_at, users.version`
+
+// scanUser scans a row of userColumns, decrypting the name and email if they were
+// stored encrypted.
+// Any extra destinations are scanned from the columns following userColumns.
+func (m UsersModel) scanUser(row interface{ Scan(...any) error }, extra ...any) (*User, error) {
+	var user User
+	var nameEncrypted, emailEncrypted []byte
+
+	dest := []any{
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&nameEncrypted,
+		&emailEncrypted,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.LastSeenAt,
+		&user.Version,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if nameEncrypted == nil && emailEncrypted == nil {
+		return &user, nil
+	}
+
+	if m.PII == nil {
+		return nil, errors.New("user data is encrypted but no PII key is configured")
+	}
+
+	user.Name, err = m.PII.Decrypt("name", nameEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email, err = m.PII.Decrypt("email", emailEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// sealedPII holds the values written to the name and email columns of a user.
+type sealedPII struct {
+	name           string
+	email          *string
+	nameEncrypted  []byte
+	emailEncrypted []byte
+	emailIndex     []byte
+}
+
+// seal returns the column values for a user's name and email, encrypting them if PII
+// encryption is enabled.
+func (m UsersModel) seal(user *User) (sealedPII, error) {
+	if m.PII == nil {
+		return sealedPII{name: user.Name, email: &user.Email}, nil
+	}
+
+	nameEncrypted, err := m.PII.Encrypt("name", user.Name)
+	if err != nil {
+		return sealedPII{}, err
+	}
+
+	emailEncrypted, err := m.PII.Encrypt("email", user.Email)
+	if err != nil {
+		return sealedPII{}, err
+	}
+
+	return sealedPII{
+		nameEncrypted:  nameEncrypted,
+		emailEncrypted: emailEncrypted,
+		emailIndex:     m.PII.EmailIndex(user.Email),
+	}, nil
+}
+
+func isDuplicateEmail(err error) bool {
+	switch err.Error() {
+	case `pq: duplicate key value violates unique constraint "users_email_key"`,
+		`pq: duplicate key value violates unique constraint "users_email_index_key"`:
+		return true
+	}
+	return false
+}
+
 // Insert a new record in the database for the user. Note that the id, created_at and
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the User struct after the insert, in the same way
 // that we did when creating a movie.
 func (m UsersModel) Insert(user *User) error {
-	query := `
-		INSERT INTO users (name, email, password_hash, activated) VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	return m.insert(ctx, m.DB, user)
+}
+
+// InsertWithInvite inserts a user who registered with an invite code, using up the
+// invite in the same transaction, so that an invite can't be used twice and isn't
+// wasted if the insert fails. It returns ErrRecordNotFound if there's no unexpired
+// invite with the code.
+func (m UsersModel) InsertWithInvite(user *User, inviteCode string) error {
+	versions, hashes := m.Keys.Candidates(inviteCode)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM invites
+		WHERE (hash, key_version) IN (SELECT * FROM unnest($1::bytea[], $2::integer[]))
+		AND expiry > NOW()`, pq.Array(hashes), pq.Array(versions))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = m.insert(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m UsersModel) insert(ctx context.Context, db DBTX, user *User) error {
+	query := `
+		INSERT INTO users (name, email, name_encrypted, email_encrypted, email_index, password_hash, activated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, version`
+
+	pii, err := m.seal(user)
+	if err != nil {
+		return err
+	}
+
+	args := []any{pii.name, pii.email, pii.nameEncrypted, pii.emailEncrypted, pii.emailIndex, user.Password.hash, user.Activated}
+
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
-	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	// specifically, and return custom ErrDuplicateEmail error instead. When the email
+	// is encrypted it's the "users_email_index_key" constraint on the blind index
+	// which is violated instead.
+	err = db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -109,25 +277,22 @@ func (m UsersModel) Insert(user *User) error {
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
+// If PII encryption is enabled, we search the blind index as well, since users who
+// registered before it was enabled may not have been encrypted yet.
 func (m UsersModel) GetByEmail(email string) (*User, error) {
-	query := `SELECT id, created_at, name, email, password_hash, activated, version 
+	query := `SELECT ` + userColumns + ` 
 			FROM users 
-			WHERE email=$1`
+			WHERE (email=$1 OR email_index=$2) AND deleted_at IS NULL`
 
-	var user User
+	var index []byte
+	if m.PII != nil {
+		index = m.PII.EmailIndex(email)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	user, err := m.scanUser(m.DB.QueryRowContext(ctx, query, email, index))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -137,7 +302,27 @@ func (m UsersModel) GetByEmail(email string) (*User, error) {
 		}
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// Get returns the user with the given ID.
+func (m UsersModel) Get(id int64) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := m.scanUser(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
 }
 
 // Update the details for a specific user. Notice that we check against the version
@@ -148,15 +333,25 @@ func (m UsersModel) GetByEmail(email string) (*User, error) {
 func (m UsersModel) Update(user *User) error {
 	query := `
         UPDATE users 
-        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        SET name = $1, email = $2, name_encrypted = $3, email_encrypted = $4, email_index = $5,
+            password_hash = $6, activated = $7, suspended = $8, version = version + 1
+        WHERE id = $9 AND version = $10
         RETURNING version`
 
+	pii, err := m.seal(user)
+	if err != nil {
+		return err
+	}
+
 	args := []any{
-		user.Name,
-		user.Email,
+		pii.name,
+		pii.email,
+		pii.nameEncrypted,
+		pii.emailEncrypted,
+		pii.emailIndex,
 		user.Password.hash,
 		user.Activated,
+		user.Suspended,
 		user.ID,
 		user.Version,
 	}
@@ -164,10 +359,10 @@ func (m UsersModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -180,33 +375,26 @@ func (m UsersModel) Update(user *User) error {
 }
 
 func (m *UsersModel) GetForToken(tokenScope string, plainTextToken string) (*User, error) {
-	// Calculate the SHA-256 hash of the plaintext token provided by the client.
-	// Remember that this returns a byte *array* with length 32, not a slice.
-	hashToken := sha256.Sum256([]byte(plainTextToken))
+	// Calculate the hashes of the plaintext token provided by the client under each of
+	// the accepted keys. The token matches if one of them is stored with the key
+	// version it was made with.
+	versions, hashes := m.Keys.Candidates(plainTextToken)
 
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	query := `SELECT ` + userColumns + `
 				FROM users
 				INNER JOIN tokens
 				ON users.id = tokens.user_id
-				WHERE tokens.hash = $1
-				AND tokens.scope = $2
-				AND tokens.expiry > $3`
+				WHERE (tokens.hash, tokens.key_version) IN (SELECT * FROM unnest($1::bytea[], $2::integer[]))
+				AND tokens.scope = $3
+				AND tokens.expiry > $4
+				AND users.deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{hashToken[:], tokenScope, time.Now()}
-	var user User
+	args := []any{pq.Array(hashes), pq.Array(versions), tokenScope, time.Now()}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	user, err := m.scanUser(m.DB.QueryRowContext(ctx, query, args...))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -216,7 +404,353 @@ func (m *UsersModel) GetForToken(tokenScope string, plainTextToken string) (*Use
 		}
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// GetForAccessToken returns the user for an access token, and the organization the
+// token is scoped to. The organization is 0 if the user isn't a member of it (or of
+// any organization) any more. The grant is nil for tokens issued directly to the
+// user, and otherwise describes the restrictions on a token which was issued to a
+// third-party application.
+func (m *UsersModel) GetForAccessToken(plainTextToken string) (*User, int64, *TokenGrant, error) {
+	versions, hashes := m.Keys.Candidates(plainTextToken)
+
+	query := `SELECT ` + userColumns + `, tokens.oauth_client_id, tokens.permissions,
+				COALESCE((
+					SELECT organization_members.organization_id FROM organization_members
+					WHERE organization_members.user_id = users.id
+					AND organization_members.organization_id = COALESCE(tokens.organization_id, organization_members.organization_id)
+					ORDER BY organization_members.organization_id
+					LIMIT 1
+				), 0)
+				FROM users
+				INNER JOIN tokens
+				ON users.id = tokens.user_id
+				WHERE (tokens.hash, tokens.key_version) IN (SELECT * FROM unnest($1::bytea[], $2::integer[]))
+				AND tokens.scope = ANY($3)
+				AND tokens.expiry > $4
+				AND users.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{pq.Array(hashes), pq.Array(versions), pq.Array([]string{ScopeAuthorization, ScopeOAuth}), time.Now()}
+
+	var clientID sql.NullInt64
+	var permissions []string
+	var organizationID int64
+
+	user, err := m.scanUser(m.DB.QueryRowContext(ctx, query, args...), &clientID, pq.Array(&permissions), &organizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, nil, ErrRecordNotFound
+		default:
+			return nil, 0, nil, err
+		}
+	}
+
+	if !clientID.Valid {
+		return user, organizationID, nil, nil
+	}
+
+	return user, organizationID, &TokenGrant{ClientID: clientID.Int64, Permissions: permissions}, nil
+}
+
+// DeleteUnactivated removes users who registered before the given time but never
+// activated their account, returning the number of users deleted. Their tokens and
+// permissions are removed by the ON DELETE CASCADE constraints.
+func (m UsersModel) DeleteUnactivated(registeredBefore time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE activated = false AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, registeredBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Delete soft-deletes a user who has asked for their account to be erased. Their
+// reviews and ratings are kept but no longer attributed to them, all of their tokens
+// are revoked and their webhooks are deactivated, so that deliveries which are already
+// queued aren't made. The account itself is removed for good by PurgeDeleted() once
+// the grace period is over. It returns ErrRecordNotFound if the user doesn't exist or
+// has already been deleted.
+func (m UsersModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	for _, query := range []string{
+		`UPDATE reviews SET user_id = NULL WHERE user_id = $1`,
+		`UPDATE ratings SET user_id = NULL WHERE user_id = $1`,
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`UPDATE webhooks SET active = false, version = version + 1 WHERE user_id = $1 AND active`,
+	} {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RecordLastSeen sets when each of the users was last seen. A time which is earlier
+// than the one already recorded is ignored.
+func (m UsersModel) RecordLastSeen(seen map[int64]time.Time) error {
+	query := `
+		UPDATE users SET last_seen_at = $2
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, t := range seen {
+		_, err = stmt.ExecContext(ctx, id, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PurgeDeleted removes users who deleted their account before the given time,
+// returning the number of users removed. Everything else of theirs is removed by the
+// ON DELETE CASCADE constraints.
+func (m UsersModel) PurgeDeleted(deletedBefore time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// EncryptPlaintext encrypts the names and email addresses of up to limit users whose
+// details are still stored in plaintext, which is the case for users who registered
+// before PII encryption was enabled. It returns the number of users encrypted.
+func (m UsersModel) EncryptPlaintext(limit int) (int, error) {
+	if m.PII == nil {
+		return 0, nil
+	}
+
+	query := `SELECT id, name, email FROM users WHERE email IS NOT NULL ORDER BY id LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&user.ID, &user.Name, &user.Email)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		users = append(users, &user)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The version isn't changed, since the user's details haven't, and the email
+	// IS NOT NULL condition means we don't overwrite a concurrent update.
+	update := `
+		UPDATE users
+		SET name = '', email = NULL, name_encrypted = $1, email_encrypted = $2, email_index = $3
+		WHERE id = $4 AND email IS NOT NULL`
+
+	for _, user := range users {
+		pii, err := m.seal(user)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = m.DB.ExecContext(ctx, update, pii.nameEncrypted, pii.emailEncrypted, pii.emailIndex, user.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(users), nil
+}
+
+// GetAll returns a page of the users matching the filter, sorted by filters.Sort.
+// Deleted users are left out.
+func (m UsersModel) GetAll(filter UserFilter, filters Filters) ([]*User, Metadata, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []any
+
+	if filter.Activated != nil {
+		args = append(args, *filter.Activated)
+		conditions = append(conditions, fmt.Sprintf("activated = $%d", len(args)))
+	}
+
+	if filter.Email != "" {
+		var index []byte
+		if m.PII != nil {
+			index = m.PII.EmailIndex(filter.Email)
+		}
+
+		args = append(args, filter.Email, index)
+		conditions = append(conditions, fmt.Sprintf("(email = $%d OR email_index = $%d)", len(args)-1, len(args)))
+	}
+
+	if !filter.SeenBefore.IsZero() {
+		args = append(args, filter.SeenBefore)
+		conditions = append(conditions, fmt.Sprintf("(last_seen_at < $%d OR last_seen_at IS NULL)", len(args)))
+	}
+
+	// Users who have never been seen sort as if they were seen before anyone else.
+	nulls := "FIRST"
+	if filters.sortDirection() == "DESC" {
+		nulls = "LAST"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT `+userColumns+`, count(*) OVER()
+		FROM users
+		WHERE %s
+		ORDER BY users.%s %s NULLS %s, users.id %s
+		LIMIT $%d OFFSET $%d`,
+		strings.Join(conditions, " AND "), filters.sortColumn(), filters.sortDirection(), nulls, filters.sortDirection(), len(args)+1, len(args)+2)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		user, err := m.scanUser(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters), nil
+}
+
+// GetAllActivated returns every user with an activated account.
+func (m UsersModel) GetAllActivated() ([]*User, error) {
+	query := `SELECT ` + userColumns + `
+			FROM users
+			WHERE activated = true AND deleted_at IS NULL
+			ORDER BY id`
+
+	return m.getAll(query)
+}
+
+// GetAllActivatedWithPermission returns every activated user who holds the given
+// permission code, directly or through one of their roles.
+func (m UsersModel) GetAllActivatedWithPermission(code string) ([]*User, error) {
+	query := `SELECT ` + userColumns + `
+			FROM users
+			WHERE users.activated = true AND users.deleted_at IS NULL AND users.id IN (
+				SELECT holders.user_id
+				FROM (` + permissionHolders + `) holders
+				INNER JOIN permissions ON permissions.id = holders.permission_id
+				WHERE permissions.code = $1 AND permissions.active
+			)
+			ORDER BY users.id`
+
+	return m.getAll(query, code)
+}
+
+func (m UsersModel) getAll(query string, args ...any) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user, err := m.scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"time"
+)
+
+// A WatchEntry records that a user watched a movie, and optionally how they rated it.
+type WatchEntry struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Title     string    `json:"title"` // The movie's title, for convenience when listing
+	WatchedAt time.Time `json:"watched_at"`
+	Rating    *int      `json:"rating,omitempty"` // From 1 to 5 stars
+}
+
+func ValidateWatchEntry(v *validator.Validator, entry *WatchEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+	v.Check(!entry.WatchedAt.After(time.Now().Add(time.Minute)), "watched_at", "must not be in the future")
+
+	if entry.Rating != nil {
+		v.Check(*entry.Rating >= 1 && *entry.Rating <= 5, "rating", "must be between 1 and 5")
+	}
+}
+
+// WatchHistorySortSafelist is what watch history can be sorted on.
+var WatchHistorySortSafelist = SortSafelist("watched_at")
+
+type WatchHistoryModel struct {
+	DB *sql.DB
+}
+
+// Insert records a watch. It returns ErrRecordNotFound if the movie doesn't exist in
+// the given organization's catalog.
+func (m WatchHistoryModel) Insert(organizationID int64, entry *WatchEntry) error {
+	query := `
+		INSERT INTO watch_history (user_id, movie_id, watched_at, rating)
+		SELECT $1, movies.id, $3, $4 FROM movies WHERE movies.id = $2 AND movies.organization_id = $5 AND movies.deleted_at IS NULL
+		RETURNING id, (SELECT title FROM movies WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{entry.UserID, entry.MovieID, entry.WatchedAt, entry.Rating, organizationID}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.Title)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetAllForUser returns a page of a user's watch history of the given organization's
+// movies, sorted by filters.Sort.
+func (m WatchHistoryModel) GetAllForUser(organizationID, userID int64, filters Filters) ([]*WatchEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watch_history.id, watch_history.user_id, watch_history.movie_id, movies.title,
+			watch_history.watched_at, watch_history.rating
+		FROM watch_history
+		INNER JOIN movies ON movies.id = watch_history.movie_id
+		WHERE watch_history.user_id = $1 AND movies.organization_id = $4
+		ORDER BY watch_history.%s %s, watch_history.id %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchEntry{}
+
+	for rows.Next() {
+		var entry WatchEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.UserID,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.WatchedAt,
+			&entry.Rating,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return entries, metadata, nil
+}
+
+// A WatchExport is a watch history entry together with the movie details other
+// services need to match it, as it appears in an export.
+type WatchExport struct {
+	TMDBID    *int64
+	Title     string
+	Year      int32
+	WatchedAt time.Time
+	Rating    *int
+	Rewatch   bool // Whether the user had already watched the movie before this
+}
+
+// Export calls fn for each entry in a user's watch history of the given organization's
+// movies, oldest first. The rows are read as they're needed, so that large histories
+// don't have to be held in memory. If fn returns an error, Export stops and returns it.
+func (m WatchHistoryModel) Export(organizationID, userID int64, fn func(*WatchExport) error) error {
+	query := `
+		SELECT movies.tmdb_id, movies.title, movies.year, watch_history.watched_at, watch_history.rating,
+			row_number() OVER (PARTITION BY watch_history.movie_id ORDER BY watch_history.watched_at, watch_history.id) > 1
+		FROM watch_history
+		INNER JOIN movies ON movies.id = watch_history.movie_id
+		WHERE watch_history.user_id = $1 AND movies.organization_id = $2
+		ORDER BY watch_history.watched_at, watch_history.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, organizationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry WatchExport
+
+		err := rows.Scan(&entry.TMDBID, &entry.Title, &entry.Year, &entry.WatchedAt, &entry.Rating, &entry.Rewatch)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// DeleteForUser removes an entry from a user's watch history. It returns
+// ErrRecordNotFound if the entry doesn't exist or belongs to someone else.
+func (m WatchHistoryModel) DeleteForUser(userID, id int64) error {
+	query := `DELETE FROM watch_history WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
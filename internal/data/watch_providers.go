@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"greenlight/anaplo/internal/validator"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define constants for the ways a provider can offer a movie.
+const (
+	WatchStream = "stream"
+	WatchRent   = "rent"
+	WatchBuy    = "buy"
+)
+
+// RegionRX matches ISO 3166-1 alpha-2 country codes, like US or GB.
+var RegionRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// A WatchProvider is a service where a movie can be watched in one region, like
+// streaming on netflix in the US. Providers are identified by slugs, like SlugRX.
+type WatchProvider struct {
+	Provider string `json:"provider"`
+	Region   string `json:"region"`
+	Type     string `json:"type"`
+}
+
+// WatchProviderQuery narrows a movie list down to the movies available on a provider.
+// The region and type are optional.
+type WatchProviderQuery struct {
+	Provider string
+	Region   string
+	Type     string
+}
+
+func ValidateWatchProviders(v *validator.Validator, providers []WatchProvider) {
+	v.Check(len(providers) <= 100, "watch_providers", "must not contain more than 100 providers")
+	v.Check(validator.Unique(providers), "watch_providers", "must not contain duplicate values")
+
+	for i, p := range providers {
+		key := fmt.Sprintf("watch_providers[%d]", i)
+
+		v.Check(len(p.Provider) <= 50 && v.Matches(p.Provider, SlugRX), key+".provider", "must be a slug of lowercase letters, digits and hyphens")
+		v.Check(v.Matches(p.Region, RegionRX), key+".region", "must be a two-letter country code, like US")
+		v.Check(validator.PermittedValues(p.Type, WatchStream, WatchRent, WatchBuy), key+".type", "must be stream, rent or buy")
+	}
+}
+
+func ValidateWatchProviderQuery(v *validator.Validator, q WatchProviderQuery) {
+	if q.Provider == "" {
+		v.Check(q.Region == "", "region", "can only be used with provider")
+		v.Check(q.Type == "", "provider_type", "can only be used with provider")
+		return
+	}
+
+	v.Check(len(q.Provider) <= 50 && v.Matches(q.Provider, SlugRX), "provider", "must be a slug of lowercase letters, digits and hyphens")
+
+	if q.Region != "" {
+		v.Check(v.Matches(q.Region, RegionRX), "region", "must be a two-letter country code, like US")
+	}
+
+	if q.Type != "" {
+		v.Check(validator.PermittedValues(q.Type, WatchStream, WatchRent, WatchBuy), "provider_type", "must be stream, rent or buy")
+	}
+}
+
+type WatchProviderModel struct {
+	DB *sql.DB
+}
+
+// GetForMovie returns where a movie can be watched. It doesn't check that the movie
+// exists, so callers should look it up first.
+func (m WatchProviderModel) GetForMovie(movieID int64) ([]WatchProvider, error) {
+	providers, err := m.GetForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if providers[movieID] == nil {
+		return []WatchProvider{}, nil
+	}
+
+	return providers[movieID], nil
+}
+
+// GetForMovies returns where each of the given movies can be watched, for adding to a
+// page of movies with a single query. Movies without any providers are left out.
+func (m WatchProviderModel) GetForMovies(movieIDs []int64) (map[int64][]WatchProvider, error) {
+	query := `
+		SELECT movie_id, provider, region, type
+		FROM movie_watch_providers
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, region, provider, type`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := map[int64][]WatchProvider{}
+
+	for rows.Next() {
+		var movieID int64
+		var p WatchProvider
+
+		err := rows.Scan(&movieID, &p.Provider, &p.Region, &p.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		providers[movieID] = append(providers[movieID], p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+// Replace sets where a movie can be watched, replacing whatever was there before.
+func (m WatchProviderModel) Replace(movieID int64, providers []WatchProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_watch_providers WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range providers {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO movie_watch_providers (movie_id, provider, region, type)
+			VALUES ($1, $2, $3, $4)`, movieID, p.Provider, p.Region, p.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
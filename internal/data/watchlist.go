@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrDuplicateWatchlistEntry = errors.New("duplicate watchlist entry")
+
+// A WatchlistEntry is a movie which a user has saved to watch later.
+type WatchlistEntry struct {
+	MovieID int64     `json:"movie_id"`
+	Title   string    `json:"title"` // The movie's title and year, for convenience when listing
+	Year    int32     `json:"year,omitempty"`
+	AddedBy *int64    `json:"added_by,omitempty"` // On a shared watchlist, the member who added it
+	AddedAt time.Time `json:"added_at"`
+}
+
+// WatchlistSortSafelist is what a watchlist can be sorted on.
+var WatchlistSortSafelist = SortSafelist("added_at")
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a movie to a user's watchlist. It returns ErrRecordNotFound if the movie
+// doesn't exist in the given organization's catalog, and ErrDuplicateWatchlistEntry if
+// it's already on the watchlist.
+func (m WatchlistModel) Insert(organizationID, userID int64, entry *WatchlistEntry) error {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		SELECT $1, movies.id FROM movies WHERE movies.id = $2 AND movies.organization_id = $3 AND movies.deleted_at IS NULL
+		RETURNING added_at, (SELECT title FROM movies WHERE id = $2), (SELECT year FROM movies WHERE id = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, entry.MovieID, organizationID).Scan(&entry.AddedAt, &entry.Title, &entry.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_pkey"`:
+			return ErrDuplicateWatchlistEntry
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser returns a page of the movies on a user's watchlist which are in the
+// given organization's catalog.
+func (m WatchlistModel) GetAllForUser(organizationID, userID int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watchlist.movie_id, movies.title, movies.year, watchlist.added_at
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id
+		WHERE watchlist.user_id = $1 AND movies.organization_id = $4 AND movies.deleted_at IS NULL
+		ORDER BY watchlist.%s %s, watchlist.movie_id %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset(), organizationID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(&totalRecords, &entry.MovieID, &entry.Title, &entry.Year, &entry.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters), nil
+}
+
+// DeleteForUser takes a movie off a user's watchlist. It returns ErrRecordNotFound if
+// the movie wasn't on it.
+func (m WatchlistModel) DeleteForUser(userID, movieID int64) error {
+	query := `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"greenlight/anaplo/internal/validator"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define constants for the events which webhooks can subscribe to.
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventMovieRestored = "movie.restored"
+	EventUserActivated = "user.activated"
+	// Sent only to the webhooks of the user who saved the search.
+	EventSavedSearchMatched = "saved_search.matched"
+)
+
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventMovieRestored, EventUserActivated, EventSavedSearchMatched}
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"` // Only revealed once, when the webhook is created
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+// A WebhookDelivery records a single attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	WebhookID  int64           `json:"webhook_id"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempt    int             `json:"attempt"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Success    bool            `json:"success"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int             `json:"duration_ms"`
+}
+
+// WebhookDeliverySortSafelist is what a webhook's deliveries can be sorted on.
+var WebhookDeliverySortSafelist = []string{"id"}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// GenerateWebhookSecret returns a random secret for signing webhook payloads.
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, events, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version`
+
+	args := []any{webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	var webhook Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// GetAllForUser returns the webhooks created by a user.
+func (m WebhookModel) GetAllForUser(userID int64) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id`
+
+	return m.query(query, userID)
+}
+
+// GetActiveForEvent returns the active webhooks which are subscribed to an event.
+func (m WebhookModel) GetActiveForEvent(event string) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE active = true AND events @> ARRAY[$1]
+		AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		ORDER BY id`
+
+	return m.query(query, event)
+}
+
+// GetActiveForUserEvent returns a user's active webhooks which are subscribed to an
+// event.
+func (m WebhookModel) GetActiveForUserEvent(userID int64, event string) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE user_id = $1 AND active = true AND events @> ARRAY[$2]
+		AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		ORDER BY id`
+
+	return m.query(query, userID, event)
+}
+
+// GetActiveForOrganizationEvent returns the active webhooks which are subscribed to an
+// event and belong to members of the given organization.
+func (m WebhookModel) GetActiveForOrganizationEvent(organizationID int64, event string) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE active = true AND events @> ARRAY[$2]
+		AND user_id IN (SELECT user_id FROM organization_members WHERE organization_id = $1)
+		AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		ORDER BY id`
+
+	return m.query(query, organizationID, event)
+}
+
+func (m WebhookModel) query(query string, args ...any) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.UserID,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{webhook.URL, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m WebhookModel) Delete(id int64) error {
+	query := `DELETE FROM webhooks WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertDelivery records an attempt to deliver an event.
+func (m WebhookModel) InsertDelivery(delivery *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempt, status_code, success, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []any{
+		delivery.WebhookID,
+		delivery.Event,
+		delivery.Payload,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.Success,
+		delivery.Error,
+		delivery.DurationMS,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+// GetDeliveries returns a page of the delivery log for a webhook, newest first.
+func (m WebhookModel) GetDeliveries(webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, created_at, webhook_id, event, payload, attempt, status_code, success, error, duration_ms
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&d.ID,
+			&d.CreatedAt,
+			&d.WebhookID,
+			&d.Event,
+			&d.Payload,
+			&d.Attempt,
+			&d.StatusCode,
+			&d.Success,
+			&d.Error,
+			&d.DurationMS,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	return deliveries, metadata, nil
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	// Host names are checked again when a delivery connects, once they've been resolved.
+	if err == nil {
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		v.Check(!strings.EqualFold(host, "localhost") && (ip == nil || WebhookAddressAllowed(ip)), "url", "must not point to a private or local address")
+	}
+
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValues(event, WebhookEvents...), "events", "must only contain supported event types")
+	}
+}
+
+// WebhookAddressAllowed reports whether webhooks may be delivered to an IP address.
+// Loopback, private, link-local (which includes cloud metadata services like
+// 169.254.169.254) and other non-public addresses are refused, so that webhooks can't
+// be used to probe the network the application runs in.
+func WebhookAddressAllowed(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	// The shared address space used for carrier-grade NAT, 100.64.0.0/10.
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+
+	return true
+}
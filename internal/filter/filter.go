@@ -0,0 +1,354 @@
+// Package filter parses filter expressions like
+//
+//	year>=2000 AND (genre:"sci-fi" OR runtime<90)
+//
+// and compiles them to parameterized SQL. Only the fields in an allowlist can be
+// referenced, and values are always passed as query arguments, so an expression can
+// never inject SQL.
+//
+// Comparisons are written as field, operator, value. The operators are =, !=, <, <=, >,
+// >= and : (which means "contains" for text and array fields, and "equals" for
+// numbers and booleans). Values are numbers, true or false, double-quoted strings, or
+// bare words. Comparisons can be combined with AND, OR and NOT (which are
+// case-insensitive) and grouped with parentheses. AND binds more tightly than OR.
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Define the types of field which can be filtered on.
+const (
+	Int   = "int"
+	Text  = "text"
+	Array = "array" // A text[] column
+	Bool  = "bool"
+)
+
+// A Field maps a name used in expressions to a database column.
+type Field struct {
+	Column string
+	Type   string
+}
+
+// MaxLength is the longest expression which will be parsed.
+const MaxLength = 1000
+
+// maxDepth limits how deeply expressions can be nested.
+const maxDepth = 20
+
+// An Expr is a parsed filter expression.
+type Expr struct {
+	op          string // "AND", "OR", "NOT" or a comparison operator
+	left, right *Expr
+	field       Field
+	value       any
+}
+
+// Parse parses an expression, checking that it only uses the given fields and that
+// their operators and values make sense for the field types.
+func Parse(s string, fields map[string]Field) (*Expr, error) {
+	if len(s) > MaxLength {
+		return nil, fmt.Errorf("must not be more than %d bytes long", MaxLength)
+	}
+
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, fields: fields}
+
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return expr, nil
+}
+
+// SQL returns the expression as an SQL condition. Placeholders are numbered from
+// firstArg, and the returned arguments should be appended to the query's others.
+func (e *Expr) SQL(firstArg int) (string, []any) {
+	var args []any
+	return e.sql(firstArg, &args), args
+}
+
+func (e *Expr) sql(firstArg int, args *[]any) string {
+	placeholder := func(v any) string {
+		*args = append(*args, v)
+		return "$" + strconv.Itoa(firstArg+len(*args)-1)
+	}
+
+	switch e.op {
+	case "AND", "OR":
+		return "(" + e.left.sql(firstArg, args) + " " + e.op + " " + e.right.sql(firstArg, args) + ")"
+	case "NOT":
+		return "(NOT " + e.left.sql(firstArg, args) + ")"
+	}
+
+	col := e.field.Column
+
+	switch e.field.Type {
+	case Text:
+		switch e.op {
+		case ":":
+			return col + " ILIKE " + placeholder("%"+escapeLike(e.value.(string))+"%")
+		case "=":
+			return "lower(" + col + ") = lower(" + placeholder(e.value) + ")"
+		case "!=":
+			return "lower(" + col + ") <> lower(" + placeholder(e.value) + ")"
+		}
+	case Array:
+		switch e.op {
+		case ":", "=":
+			return placeholder(e.value) + " = ANY(" + col + ")"
+		case "!=":
+			return "NOT (" + placeholder(e.value) + " = ANY(" + col + "))"
+		}
+	}
+
+	op := e.op
+	switch op {
+	case ":":
+		op = "="
+	case "!=":
+		op = "<>"
+	}
+
+	return col + " " + op + " " + placeholder(e.value)
+}
+
+// escapeLike escapes the wildcard characters in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields map[string]Field
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{kind: tokenEOF}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the given keyword, consuming it if so.
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr(depth int) (*Expr, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{op: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (*Expr, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{op: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot(depth int) (*Expr, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("must not be nested more than %d levels deep", maxDepth)
+	}
+
+	if p.keyword("NOT") {
+		expr, err := p.parseNot(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{op: "NOT", left: expr}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" but found %s", t.describe())
+		}
+
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (*Expr, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("expected a field name but found %s", t.describe())
+	}
+
+	field, ok := p.fields[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", t.text)
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOp {
+		return nil, fmt.Errorf("expected an operator after %q but found %s", t.text, opTok.describe())
+	}
+	op := opTok.text
+
+	valTok := p.next()
+	if valTok.kind != tokenWord && valTok.kind != tokenString {
+		return nil, fmt.Errorf("expected a value after %s%s but found %s", t.text, op, valTok.describe())
+	}
+
+	expr := &Expr{op: op, field: field}
+
+	switch field.Type {
+	case Int:
+		n, err := strconv.ParseInt(valTok.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be compared with an integer", t.text)
+		}
+		expr.value = n
+	case Bool:
+		b, err := strconv.ParseBool(valTok.text)
+		if err != nil || (op != "=" && op != "!=" && op != ":") {
+			return nil, fmt.Errorf("%s can only be compared with true or false using =, != or :", t.text)
+		}
+		expr.value = b
+	default:
+		if op != "=" && op != "!=" && op != ":" {
+			return nil, fmt.Errorf("%s can only be compared with =, != or :", t.text)
+		}
+		expr.value = valTok.text
+	}
+
+	return expr, nil
+}
+
+// Define the kinds of token produced by the lexer.
+const (
+	tokenEOF = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")"})
+			i++
+		case c == ':' || c == '=':
+			tokens = append(tokens, token{tokenOp, string(c)})
+			i++
+		case c == '<' || c == '>' || c == '!':
+			if i+1 < len(s) && s[i+1] == '=' {
+				tokens = append(tokens, token{tokenOp, s[i : i+2]})
+				i += 2
+				continue
+			}
+			if c == '!' {
+				return nil, fmt.Errorf("unexpected \"!\" at character %d", i)
+			}
+			tokens = append(tokens, token{tokenOp, string(c)})
+			i++
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string starting at character %d", i)
+			}
+			tokens = append(tokens, token{tokenString, sb.String()})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()<>=!:\"", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, s[i:j]})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
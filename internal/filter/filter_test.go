@@ -0,0 +1,132 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFields = map[string]Field{
+	"year":    {Column: "year", Type: Int},
+	"title":   {Column: "title", Type: Text},
+	"genre":   {Column: "genres", Type: Array},
+	"deleted": {Column: "deleted", Type: Bool},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{"int comparison", "year>=2000", "year >= $3", []any{int64(2000)}},
+		{"int contains means equals", "year:2000", "year = $3", []any{int64(2000)}},
+		{"int not equal", "year != 2000", "year <> $3", []any{int64(2000)}},
+		{"text contains", `title:"star wars"`, "title ILIKE $3", []any{"%star wars%"}},
+		{"text contains escapes wildcards", `title:"100%_\\"`, "title ILIKE $3", []any{`%100\%\_\\%`}},
+		{"text equals", "title=Moana", "lower(title) = lower($3)", []any{"Moana"}},
+		{"text not equal", "title!=Moana", "lower(title) <> lower($3)", []any{"Moana"}},
+		{"quoted string with escaped quote", `title="say \"hi\""`, "lower(title) = lower($3)", []any{`say "hi"`}},
+		{"array contains", `genre:"sci-fi"`, "$3 = ANY(genres)", []any{"sci-fi"}},
+		{"array not equal", "genre!=drama", "NOT ($3 = ANY(genres))", []any{"drama"}},
+		{"bool", "deleted=false", "deleted = $3", []any{false}},
+		{
+			"AND binds more tightly than OR",
+			"year<1990 OR year>2000 AND genre:drama",
+			"(year < $3 OR (year > $4 AND $5 = ANY(genres)))",
+			[]any{int64(1990), int64(2000), "drama"},
+		},
+		{
+			"parentheses",
+			`year>=2000 AND (genre:"sci-fi" OR year<1990)`,
+			"(year >= $3 AND ($4 = ANY(genres) OR year < $5))",
+			[]any{int64(2000), "sci-fi", int64(1990)},
+		},
+		{"NOT", "not deleted:true", "(NOT deleted = $3)", []any{true}},
+		{"case-insensitive keywords", "year=1 and year=2", "(year = $3 AND year = $4)", []any{int64(1), int64(2)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.expr, testFields)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sql, args := expr.SQL(3)
+			if sql != tt.wantSQL {
+				t.Errorf("got SQL %q; want %q", sql, tt.wantSQL)
+			}
+
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("got args %#v; want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"empty", "", "expected a field name but found end of expression"},
+		{"unknown field", "rating>5", `unknown field "rating"`},
+		{"missing operator", "year 2000", `expected an operator after "year"`},
+		{"missing value", "year>=", "expected a value after year>= but found end of expression"},
+		{"int with text value", "year=recent", "year must be compared with an integer"},
+		{"bool with ordering", "deleted>true", "deleted can only be compared with true or false"},
+		{"bool with text value", "deleted=maybe", "deleted can only be compared with true or false"},
+		{"text with ordering", "title<M", "title can only be compared with =, != or :"},
+		{"lone bang", "year!2000", `unexpected "!" at character 4`},
+		{"unterminated string", `title:"moana`, "unterminated string starting at character 6"},
+		{"unclosed parenthesis", "(year=1", `expected ")" but found end of expression`},
+		{"trailing tokens", "year=1 year=2", `unexpected "year"`},
+		{"dangling AND", "year=1 AND", "expected a field name but found end of expression"},
+		{"too long", "title:" + strings.Repeat("a", MaxLength), "must not be more than 1000 bytes long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr, testFields)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %q; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"parentheses at the limit", strings.Repeat("(", maxDepth) + "year=1" + strings.Repeat(")", maxDepth), false},
+		{"parentheses over the limit", strings.Repeat("(", maxDepth+1) + "year=1" + strings.Repeat(")", maxDepth+1), true},
+		{"NOT at the limit", strings.Repeat("NOT ", maxDepth) + "year=1", false},
+		{"NOT over the limit", strings.Repeat("NOT ", maxDepth+1) + "year=1", true},
+		{"mixed over the limit", strings.Repeat("NOT (", maxDepth/2+1) + "year=1" + strings.Repeat(")", maxDepth/2+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr, testFields)
+
+			switch {
+			case tt.wantErr && err == nil:
+				t.Fatal("expected an error")
+			case tt.wantErr && !strings.Contains(err.Error(), "must not be nested more than 20 levels deep"):
+				t.Errorf("got error %q; want a nesting error", err)
+			case !tt.wantErr && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,600 @@
+// Package graphql implements the subset of the GraphQL query language needed by the
+// API: a single query operation made up of fields with aliases, arguments, variables
+// and nested selections. Fragments, directives and mutations are not supported.
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Field is a single field in a selection set, with its arguments already resolved
+// to Go values (variables are substituted during parsing).
+type Field struct {
+	Alias      string
+	Name       string
+	Args       map[string]any
+	Selections []*Field
+}
+
+// Key returns the name under which the field's value appears in the response.
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// An Error is a GraphQL error, which is reported in the "errors" array of the response.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+const (
+	// maxDepth limits how deeply selection sets and values can be nested.
+	maxDepth = 20
+	// maxRootFields limits the number of top-level fields in an operation, counting
+	// each alias separately, since every one of them is resolved with its own queries.
+	maxRootFields = 10
+	// maxFields limits the number of fields in a document.
+	maxFields = 500
+)
+
+// Parse parses a query document and returns the top-level fields of the operation to
+// execute. If the document contains several operations, operationName chooses
+// between them.
+func Parse(query, operationName string, variables map[string]any) ([]*Field, error) {
+	p := &parser{lex: newLexer(query), variables: variables}
+
+	err := p.lex.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Field
+	count := 0
+
+	for p.lex.tok.kind != tokEOF {
+		name, fields, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+
+		count++
+		if operationName == "" || operationName == name {
+			found = fields
+		}
+	}
+
+	switch {
+	case count == 0:
+		return nil, errors.New("document does not contain an operation")
+	case count > 1 && operationName == "":
+		return nil, errors.New("operationName is required when the document contains multiple operations")
+	case found == nil:
+		return nil, fmt.Errorf("unknown operation %q", operationName)
+	}
+
+	return found, nil
+}
+
+type parser struct {
+	lex       *lexer
+	variables map[string]any
+	defaults  map[string]any
+	fields    int
+}
+
+func (p *parser) parseOperation() (string, []*Field, error) {
+	var name string
+	p.defaults = map[string]any{}
+
+	if p.lex.tok.kind == tokName {
+		switch p.lex.tok.value {
+		case "query":
+		case "mutation", "subscription":
+			return "", nil, fmt.Errorf("%s operations are not supported", p.lex.tok.value)
+		case "fragment":
+			return "", nil, errors.New("fragments are not supported")
+		default:
+			return "", nil, p.unexpected()
+		}
+
+		err := p.lex.next()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if p.lex.tok.kind == tokName {
+			name = p.lex.tok.value
+			err := p.lex.next()
+			if err != nil {
+				return "", nil, err
+			}
+		}
+
+		if p.lex.is("(") {
+			err := p.parseVariableDefinitions()
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	fields, err := p.parseSelectionSet(0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(fields) > maxRootFields {
+		return "", nil, fmt.Errorf("operation must not select more than %d top-level fields", maxRootFields)
+	}
+
+	return name, fields, nil
+}
+
+// parseVariableDefinitions reads "($id: Int = 1, $title: String)". Only the names and
+// default values matter to us; the types are skipped.
+func (p *parser) parseVariableDefinitions() error {
+	err := p.expect("(")
+	if err != nil {
+		return err
+	}
+
+	for !p.lex.is(")") {
+		err := p.expect("$")
+		if err != nil {
+			return err
+		}
+
+		name := p.lex.tok.value
+		if p.lex.tok.kind != tokName {
+			return p.unexpected()
+		}
+
+		err = p.lex.next()
+		if err != nil {
+			return err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return err
+		}
+
+		// Skip over the type, like "Int", "[String!]!".
+		for p.lex.tok.kind == tokName || p.lex.is("[") || p.lex.is("]") || p.lex.is("!") {
+			err := p.lex.next()
+			if err != nil {
+				return err
+			}
+		}
+
+		if p.lex.is("=") {
+			err := p.lex.next()
+			if err != nil {
+				return err
+			}
+
+			val, err := p.parseValue(0)
+			if err != nil {
+				return err
+			}
+			p.defaults[name] = val
+		}
+
+		if p.lex.tok.kind == tokEOF {
+			return p.unexpected()
+		}
+	}
+
+	return p.lex.next()
+}
+
+func (p *parser) parseSelectionSet(depth int) ([]*Field, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("selections must not be nested more than %d levels deep", maxDepth)
+	}
+
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*Field
+
+	for !p.lex.is("}") {
+		if p.lex.is("...") {
+			return nil, errors.New("fragments are not supported")
+		}
+
+		field, err := p.parseField(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		p.fields++
+		if p.fields > maxFields {
+			return nil, fmt.Errorf("document must not contain more than %d fields", maxFields)
+		}
+
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("selection set must not be empty")
+	}
+
+	return fields, p.lex.next()
+}
+
+func (p *parser) parseField(depth int) (*Field, error) {
+	if p.lex.tok.kind != tokName {
+		return nil, p.unexpected()
+	}
+
+	field := &Field{Name: p.lex.tok.value, Args: map[string]any{}}
+
+	err := p.lex.next()
+	if err != nil {
+		return nil, err
+	}
+
+	// "alias: name"
+	if p.lex.is(":") {
+		err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.lex.tok.kind != tokName {
+			return nil, p.unexpected()
+		}
+
+		field.Alias = field.Name
+		field.Name = p.lex.tok.value
+
+		err = p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.lex.is("(") {
+		err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		for !p.lex.is(")") {
+			if p.lex.tok.kind != tokName {
+				return nil, p.unexpected()
+			}
+			name := p.lex.tok.value
+
+			err := p.lex.next()
+			if err != nil {
+				return nil, err
+			}
+
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := p.parseValue(depth)
+			if err != nil {
+				return nil, err
+			}
+
+			field.Args[name] = val
+		}
+
+		err = p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.lex.is("@") {
+		return nil, errors.New("directives are not supported")
+	}
+
+	if p.lex.is("{") {
+		field.Selections, err = p.parseSelectionSet(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return field, nil
+}
+
+// parseValue reads a literal or variable. Numbers are returned as int64 or float64,
+// lists as []any and objects as map[string]any.
+func (p *parser) parseValue(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("values must not be nested more than %d levels deep", maxDepth)
+	}
+
+	tok := p.lex.tok
+
+	switch {
+	case p.lex.is("$"):
+		err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		name := p.lex.tok.value
+		if p.lex.tok.kind != tokName {
+			return nil, p.unexpected()
+		}
+
+		val, ok := p.variables[name]
+		if !ok {
+			val = p.defaults[name]
+		}
+
+		return val, p.lex.next()
+
+	case p.lex.is("["):
+		err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		list := []any{}
+		for !p.lex.is("]") {
+			if p.lex.tok.kind == tokEOF {
+				return nil, p.unexpected()
+			}
+
+			val, err := p.parseValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+
+		return list, p.lex.next()
+
+	case p.lex.is("{"):
+		err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+
+		obj := map[string]any{}
+		for !p.lex.is("}") {
+			if p.lex.tok.kind != tokName {
+				return nil, p.unexpected()
+			}
+			name := p.lex.tok.value
+
+			err := p.lex.next()
+			if err != nil {
+				return nil, err
+			}
+
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := p.parseValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = val
+		}
+
+		return obj, p.lex.next()
+
+	case tok.kind == tokString:
+		return tok.value, p.lex.next()
+
+	case tok.kind == tokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s", tok.value)
+		}
+		return n, p.lex.next()
+
+	case tok.kind == tokFloat:
+		n, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", tok.value)
+		}
+		return n, p.lex.next()
+
+	case tok.kind == tokName:
+		var val any
+		switch tok.value {
+		case "true":
+			val = true
+		case "false":
+			val = false
+		case "null":
+			val = nil
+		default:
+			// Enum values are represented by their name.
+			val = tok.value
+		}
+		return val, p.lex.next()
+	}
+
+	return nil, p.unexpected()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.lex.is(punct) {
+		return p.unexpected()
+	}
+	return p.lex.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.lex.tok.kind == tokEOF {
+		return errors.New("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at position %d", p.lex.tok.value, p.lex.tok.pos)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src []rune
+	pos int
+	tok token
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: []rune(src)}
+}
+
+func (l *lexer) is(punct string) bool {
+	return l.tok.kind == tokPunct && l.tok.value == punct
+}
+
+// next advances to the next token, skipping whitespace, commas and comments, which
+// are all insignificant in GraphQL.
+func (l *lexer) next() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(c) && c != ',' && c != '\uFEFF' {
+			break
+		}
+		l.pos++
+	}
+
+	start := l.pos
+
+	if l.pos >= len(l.src) {
+		l.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(string(l.src[l.pos:min(l.pos+3, len(l.src))]), "..."):
+		l.pos += 3
+		l.tok = token{kind: tokPunct, value: "...", pos: start}
+
+	case strings.ContainsRune("{}()[]:!$=@|&", c):
+		l.pos++
+		l.tok = token{kind: tokPunct, value: string(c), pos: start}
+
+	case c == '_' || unicode.IsLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || unicode.IsLetter(l.src[l.pos]) || unicode.IsDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		l.tok = token{kind: tokName, value: string(l.src[start:l.pos]), pos: start}
+
+	case c == '-' || unicode.IsDigit(c):
+		kind := tokInt
+		l.pos++
+		for l.pos < len(l.src) && (unicode.IsDigit(l.src[l.pos]) || strings.ContainsRune(".eE+-", l.src[l.pos])) {
+			if !unicode.IsDigit(l.src[l.pos]) {
+				kind = tokFloat
+			}
+			l.pos++
+		}
+		l.tok = token{kind: kind, value: string(l.src[start:l.pos]), pos: start}
+
+	case c == '"':
+		s, err := l.readString()
+		if err != nil {
+			return err
+		}
+		l.tok = token{kind: tokString, value: s, pos: start}
+
+	default:
+		return fmt.Errorf("syntax error: unexpected character %q at position %d", c, start)
+	}
+
+	return nil
+}
+
+func (l *lexer) readString() (string, error) {
+	var sb strings.Builder
+	start := l.pos
+	l.pos++
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\n':
+			return "", fmt.Errorf("syntax error: unterminated string at position %d", start)
+		case '\\':
+			if l.pos >= len(l.src) {
+				break
+			}
+			esc := l.src[l.pos]
+			l.pos++
+
+			switch esc {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			case 'b':
+				sb.WriteRune('\b')
+			case 'f':
+				sb.WriteRune('\f')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return "", fmt.Errorf("syntax error: invalid unicode escape at position %d", l.pos)
+				}
+				n, err := strconv.ParseUint(string(l.src[l.pos:l.pos+4]), 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("syntax error: invalid unicode escape at position %d", l.pos)
+				}
+				sb.WriteRune(rune(n))
+				l.pos += 4
+			default:
+				sb.WriteRune(esc)
+			}
+		default:
+			sb.WriteRune(c)
+		}
+	}
+
+	return "", fmt.Errorf("syntax error: unterminated string at position %d", start)
+}
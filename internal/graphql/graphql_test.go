@@ -0,0 +1,142 @@
+package graphql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		variables     map[string]any
+		want          []*Field
+	}{
+		{
+			name:  "shorthand query",
+			query: `{ movies { id title } }`,
+			want: []*Field{
+				{Name: "movies", Args: map[string]any{}, Selections: []*Field{
+					{Name: "id", Args: map[string]any{}},
+					{Name: "title", Args: map[string]any{}},
+				}},
+			},
+		},
+		{
+			name:  "aliases and arguments",
+			query: `query { first: movie(id: 1) { id } other: movie(id: 2, title: "Moana") { id } }`,
+			want: []*Field{
+				{Alias: "first", Name: "movie", Args: map[string]any{"id": int64(1)}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+				{Alias: "other", Name: "movie", Args: map[string]any{"id": int64(2), "title": "Moana"}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+			},
+		},
+		{
+			name:  "literal values",
+			query: `{ movies(genres: [drama, "sci-fi"], rating: 7.5, deleted: false, sort: null, range: {min: 1, max: -2}) { id } }`,
+			want: []*Field{
+				{Name: "movies", Args: map[string]any{
+					"genres":  []any{"drama", "sci-fi"},
+					"rating":  7.5,
+					"deleted": false,
+					"sort":    nil,
+					"range":   map[string]any{"min": int64(1), "max": int64(-2)},
+				}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+			},
+		},
+		{
+			name:      "variables and defaults",
+			query:     `query Movies($title: String!, $page: Int = 2) { movies(title: $title, page: $page) { id } }`,
+			variables: map[string]any{"title": "Moana"},
+			want: []*Field{
+				{Name: "movies", Args: map[string]any{"title": "Moana", "page": int64(2)}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+			},
+		},
+		{
+			name:          "operation name",
+			query:         `query A { me { id } } query B { movies { id } }`,
+			operationName: "B",
+			want: []*Field{
+				{Name: "movies", Args: map[string]any{}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+			},
+		},
+		{
+			name:  "comments and escapes",
+			query: "# the current user\n{ search(query: \"say \\\"hi\\\"\\u0021\") { id } }",
+			want: []*Field{
+				{Name: "search", Args: map[string]any{"query": `say "hi"!`}, Selections: []*Field{{Name: "id", Args: map[string]any{}}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query, tt.operationName, tt.variables)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %s; want %s", dump(got), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		wantErr       string
+	}{
+		{"empty document", ``, "", "document does not contain an operation"},
+		{"empty selection set", `{ }`, "", "selection set must not be empty"},
+		{"unterminated selection set", `{ movies { id }`, "", "unexpected end of document"},
+		{"unterminated string", `{ search(query: "moana) { id } }`, "", "unterminated string"},
+		{"unexpected character", `{ movies % }`, "", `unexpected character '%'`},
+		{"mutation", `mutation { createMovie { id } }`, "", "mutation operations are not supported"},
+		{"fragment spread", `{ movies { ...MovieFields } }`, "", "fragments are not supported"},
+		{"directive", `{ movies @include(if: true) { id } }`, "", "directives are not supported"},
+		{"missing operation name", `query A { me { id } } query B { me { id } }`, "", "operationName is required"},
+		{"unknown operation", `query A { me { id } }`, "B", `unknown operation "B"`},
+		{"selections too deep", "{ a " + strings.Repeat("{ a ", maxDepth+1) + strings.Repeat("}", maxDepth+2), "", "must not be nested more than 20 levels deep"},
+		{"values too deep", "{ a(b: " + strings.Repeat("[", maxDepth+2) + strings.Repeat("]", maxDepth+2) + ") }", "", "must not be nested more than 20 levels deep"},
+		{"too many top-level fields", "{ " + strings.Repeat("movies { id } ", maxRootFields+1) + "}", "", "must not select more than 10 top-level fields"},
+		{"too many fields", "{ movies { " + strings.Repeat("id ", maxFields) + "} }", "", "must not contain more than 500 fields"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query, tt.operationName, nil)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %q; want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDeepQuery(t *testing.T) {
+	// A deeply nested query is rejected as soon as it passes the limit, rather than
+	// being parsed all the way down.
+	n := 400_000
+	query := strings.Repeat("{ a ", n) + strings.Repeat("}", n)
+
+	_, err := Parse(query, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "levels deep") {
+		t.Fatalf("got error %v; want a nesting error", err)
+	}
+}
+
+// dump formats fields as JSON for test failure messages, since %v only shows the
+// pointers to nested fields.
+func dump(fields []*Field) string {
+	js, _ := json.Marshal(fields)
+	return string(js)
+}
@@ -0,0 +1,163 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// An Object is an object type, which declares the fields that can be selected on it.
+// A field with a nil type is a scalar, or a list of scalars.
+type Object struct {
+	Name   string
+	Fields map[string]*Object
+}
+
+var marshalerType = reflect.TypeFor[json.Marshaler]()
+
+// ObjectOf declares an object type with the fields of the struct v, named by their
+// JSON keys, so the fields available for selection are the same as the keys in the
+// equivalent REST response. Fields holding structs, or pointers to or slices of
+// structs, are object types themselves unless they marshal themselves to JSON.
+func ObjectOf(name string, v any) *Object {
+	return objectOf(name, reflect.TypeOf(v), map[reflect.Type]*Object{})
+}
+
+func objectOf(name string, t reflect.Type, seen map[reflect.Type]*Object) *Object {
+	if obj, ok := seen[t]; ok {
+		return obj
+	}
+
+	obj := &Object{Name: name, Fields: map[string]*Object{}}
+	seen[t] = obj
+
+	addFields(obj, t, seen)
+	return obj
+}
+
+func addFields(obj *Object, t reflect.Type, seen map[reflect.Type]*Object) {
+	for _, sf := range reflect.VisibleFields(t) {
+		tag := sf.Tag.Get("json")
+		if tag == "-" || len(sf.Index) > 1 {
+			continue
+		}
+
+		key, _, _ := strings.Cut(tag, ",")
+
+		// Like encoding/json, the fields of an embedded struct without a name are
+		// promoted to the outer object.
+		if sf.Anonymous && key == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(obj, ft, seen)
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		if key == "" {
+			key = sf.Name
+		}
+
+		obj.Fields[key] = fieldType(sf.Type, seen)
+	}
+}
+
+// fieldType returns the object type for a struct field, or nil for a scalar.
+func fieldType(t reflect.Type, seen map[reflect.Type]*Object) *Object {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Implements(marshalerType) {
+			return nil
+		}
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return nil
+	}
+
+	return objectOf(t.Name(), t, seen)
+}
+
+// Select shapes a resolved value of type t, or a list of them, to match a selection
+// set. The selections are checked against the type first, so selecting a field which
+// doesn't exist, or selecting subfields of a scalar, is an error even when there's no
+// value. Fields which exist but have no value, like those omitted from the JSON when
+// empty, are null.
+func Select(v any, t *Object, selections []*Field, path []any) (any, error) {
+	err := check(t, selections, path)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic any
+	err = json.Unmarshal(js, &generic)
+	if err != nil {
+		return nil, err
+	}
+
+	return project(generic, t, selections), nil
+}
+
+func check(t *Object, selections []*Field, path []any) error {
+	switch {
+	case t == nil && len(selections) > 0:
+		return &Error{Message: "field of scalar type must not have a selection of subfields", Path: path}
+	case t != nil && len(selections) == 0:
+		return &Error{Message: fmt.Sprintf("field of type %q must have a selection of subfields", t.Name), Path: path}
+	}
+
+	for _, f := range selections {
+		fieldPath := append(append([]any{}, path...), f.Key())
+
+		ft, ok := t.Fields[f.Name]
+		if !ok {
+			return &Error{Message: fmt.Sprintf("cannot query field %q on type %q", f.Name, t.Name), Path: fieldPath}
+		}
+
+		err := check(ft, f.Selections, fieldPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func project(v any, t *Object, selections []*Field) any {
+	switch val := v.(type) {
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = project(item, t, selections)
+		}
+		return out
+
+	case map[string]any:
+		// A scalar can also be a JSON object, like a movie's release dates.
+		if t == nil {
+			return val
+		}
+
+		out := make(map[string]any, len(selections))
+		for _, f := range selections {
+			out[f.Key()] = project(val[f.Name], t.Fields[f.Name], f.Selections)
+		}
+		return out
+
+	default:
+		return val
+	}
+}
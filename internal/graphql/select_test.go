@@ -0,0 +1,193 @@
+package graphql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLinks struct {
+	Homepage string `json:"homepage,omitempty"`
+}
+
+type testRuntime int
+
+func (r testRuntime) MarshalJSON() ([]byte, error) {
+	return json.Marshal("90 mins")
+}
+
+type testMovie struct {
+	ID        int64             `json:"id"`
+	Title     string            `json:"title"`
+	Synopsis  string            `json:"synopsis,omitempty"`
+	Genres    []string          `json:"genres,omitempty"`
+	Runtime   testRuntime       `json:"runtime"`
+	CreatedAt time.Time         `json:"created_at"`
+	Links     testLinks         `json:"links"`
+	Dates     map[string]string `json:"release_dates,omitempty"`
+	Reviews   []*testReview     `json:"reviews,omitempty"`
+	Secret    string            `json:"-"`
+}
+
+type testReview struct {
+	Body string `json:"body"`
+}
+
+type testUser struct {
+	*testReview
+	Permissions []string `json:"permissions"`
+}
+
+func TestObjectOf(t *testing.T) {
+	movie := ObjectOf("Movie", testMovie{})
+
+	for _, name := range []string{"id", "title", "synopsis", "genres", "runtime", "created_at", "release_dates"} {
+		if ft, ok := movie.Fields[name]; !ok || ft != nil {
+			t.Errorf("field %q: got %v, %v; want a scalar", name, ft, ok)
+		}
+	}
+
+	for name, typeName := range map[string]string{"links": "testLinks", "reviews": "testReview"} {
+		if ft := movie.Fields[name]; ft == nil || ft.Name != typeName {
+			t.Errorf("field %q: got %v; want object type %s", name, ft, typeName)
+		}
+	}
+
+	if _, ok := movie.Fields["Secret"]; ok {
+		t.Error("field Secret: is declared, but isn't in the JSON")
+	}
+
+	// The fields of an embedded struct are promoted, like in the JSON.
+	user := ObjectOf("User", testUser{})
+	for _, name := range []string{"body", "permissions"} {
+		if _, ok := user.Fields[name]; !ok {
+			t.Errorf("field %q: isn't declared on the embedding struct", name)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	movieType := ObjectOf("Movie", testMovie{})
+
+	movie := &testMovie{
+		ID:      1,
+		Title:   "Moana",
+		Genres:  []string{"animation"},
+		Dates:   map[string]string{"US": "2016-11-23"},
+		Reviews: []*testReview{{Body: "Great"}},
+	}
+
+	tests := []struct {
+		name  string
+		value any
+		query string
+		want  string
+	}{
+		{
+			name:  "scalars",
+			value: movie,
+			query: `{ movie { id title genres runtime } }`,
+			want:  `{"genres":["animation"],"id":1,"runtime":"90 mins","title":"Moana"}`,
+		},
+		{
+			name:  "aliases",
+			value: movie,
+			query: `{ movie { name: title } }`,
+			want:  `{"name":"Moana"}`,
+		},
+		{
+			name:  "empty fields are null",
+			value: &testMovie{ID: 2},
+			query: `{ movie { synopsis genres reviews { body } links { homepage } } }`,
+			want:  `{"genres":null,"links":{"homepage":null},"reviews":null,"synopsis":null}`,
+		},
+		{
+			name:  "nested objects",
+			value: movie,
+			query: `{ movie { reviews { body } release_dates } }`,
+			want:  `{"release_dates":{"US":"2016-11-23"},"reviews":[{"body":"Great"}]}`,
+		},
+		{
+			name:  "lists",
+			value: []*testMovie{movie, {ID: 2}},
+			query: `{ movies { id } }`,
+			want:  `[{"id":1},{"id":2}]`,
+		},
+		{
+			name:  "null value",
+			value: nil,
+			query: `{ movie { id } }`,
+			want:  `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := Parse(tt.query, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Select(tt.value, movieType, fields[0].Selections, []any{fields[0].Key()})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			js, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(js) != tt.want {
+				t.Errorf("got %s; want %s", js, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	movieType := ObjectOf("Movie", testMovie{})
+
+	tests := []struct {
+		name     string
+		query    string
+		wantErr  string
+		wantPath string
+	}{
+		{"unknown field", `{ movie { id rating } }`, `cannot query field "rating" on type "Movie"`, `["movie","rating"]`},
+		{"unknown nested field", `{ movie { links { imdb } } }`, `cannot query field "imdb" on type "testLinks"`, `["movie","links","imdb"]`},
+		{"hidden field", `{ movie { Secret } }`, `cannot query field "Secret"`, `["movie","Secret"]`},
+		{"subfields of a scalar", `{ movie { title { length } } }`, "field of scalar type must not have a selection of subfields", `["movie","title"]`},
+		{"object without subfields", `{ movie { reviews } }`, `field of type "testReview" must have a selection of subfields`, `["movie","reviews"]`},
+		{"aliased path", `{ m: movie { t: tagline } }`, `cannot query field "tagline"`, `["m","t"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := Parse(tt.query, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The selections are checked even if there's no value to select from.
+			for _, value := range []any{&testMovie{ID: 1}, nil, []*testMovie{}} {
+				_, err = Select(value, movieType, fields[0].Selections, []any{fields[0].Key()})
+
+				gqlErr, ok := err.(*Error)
+				if !ok {
+					t.Fatalf("got error %v; want a *graphql.Error", err)
+				}
+
+				if !strings.Contains(gqlErr.Message, tt.wantErr) {
+					t.Errorf("got error %q; want it to contain %q", gqlErr.Message, tt.wantErr)
+				}
+
+				path, _ := json.Marshal(gqlErr.Path)
+				if string(path) != tt.wantPath {
+					t.Errorf("got path %s; want %s", path, tt.wantPath)
+				}
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-mail/mail/v2"
@@ -22,6 +26,10 @@ var templateFS embed.FS
 type Mailer struct {
 	dialer *mail.Dialer
 	sender string
+	// The mutex guards the dialer credentials, which can be swapped at runtime when
+	// the SMTP password is rotated. It's a pointer so that copies of the Mailer
+	// share the same lock.
+	mu *sync.RWMutex
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -31,12 +39,44 @@ func New(host string, port int, username, password, sender string) Mailer {
 	dialer.Timeout = 5 * time.Second
 	// Return a Mailer instance containing the dialer and sender information.
 	return Mailer{dialer: dialer,
-		sender: sender}
+		sender: sender,
+		mu:     new(sync.RWMutex)}
 }
 
-// Define a Send() method on the Mailer type. This takes the recipient email address // as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
+// UpdatePassword replaces the SMTP password used for all subsequent emails.
+func (m Mailer) UpdatePassword(password string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dialer.Password = password
+}
+
+// Ping checks that the SMTP server is reachable, by opening a TCP connection to it
+// and closing it again. It doesn't log in, so it won't catch bad credentials.
+func (m Mailer) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	addr := net.JoinHostPort(m.dialer.Host, strconv.Itoa(m.dialer.Port))
+	m.mu.RUnlock()
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Send sends an email rendered from a template. See SendWithHeaders().
 func (m Mailer) Send(recipient, templateFile string, data any) error {
+	return m.SendWithHeaders(recipient, templateFile, data, nil)
+}
+
+// Define a SendWithHeaders() method on the Mailer type. This takes the recipient email address // as the first parameter, the name of the file containing the templates, and any
+// dynamic data for the templates as an any parameter. Any extra headers, like
+// List-Unsubscribe, are added to the message.
+func (m Mailer) SendWithHeaders(recipient, templateFile string, data any, headers map[string]string) error {
 	// Use the ParseFS() method to parse the required template file from the embedded // file system.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
@@ -67,12 +107,17 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
 	msg.SetHeader("Subject", subject.String())
+	for name, value := range headers {
+		msg.SetHeader(name, value)
+	}
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 	// Call the DialAndSend() method on the dialer, passing in the message to send. This // opens a connection to the SMTP server, sends the message, then closes the
 	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
 	// error.
+	m.mu.RLock()
 	err = m.dialer.DialAndSend(msg)
+	m.mu.RUnlock()
 	if err != nil {
 		return err
 	}
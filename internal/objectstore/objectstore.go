@@ -0,0 +1,288 @@
+// Package objectstore is a minimal client for S3-compatible object storage, like AWS
+// S3, MinIO or Cloudflare R2. It supports just what backups and posters need:
+// uploading, downloading, listing and deleting objects. Requests are signed with AWS Signature Version 4 and use
+// path-style URLs (<endpoint>/<bucket>/<key>), which every provider supports.
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyHash is the SHA-256 hash of an empty request body.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrNotFound is returned by Get if the object doesn't exist.
+var ErrNotFound = errors.New("objectstore: object not found")
+
+// Config holds the location of a bucket and the credentials for it.
+type Config struct {
+	Endpoint  string // Like https://s3.eu-west-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// An Object is an entry in a bucket listing.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Client makes requests to a single bucket.
+type Client struct {
+	cfg        Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// New returns a client for the bucket in cfg.
+func New(cfg Config, httpClient *http.Client) (*Client, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("objectstore: invalid endpoint %q", cfg.Endpoint)
+	}
+
+	return &Client{cfg: cfg, endpoint: endpoint, httpClient: httpClient, now: time.Now}, nil
+}
+
+// Put uploads an object. The body's size and SHA-256 hash (hex encoded) must be known
+// up front, since they're part of the request and its signature.
+func (c *Client) Put(ctx context.Context, key string, body io.Reader, size int64, sha256Hex, contentType string) error {
+	req, err := c.newRequest(ctx, http.MethodPut, key, nil, body, sha256Hex)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	return c.do(req, nil)
+}
+
+// Get downloads an object, returning its body and content type. The caller must close
+// the body.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil, nil, emptyHash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, "", ErrNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("objectstore: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// Delete removes an object. Deleting an object which doesn't exist isn't an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, key, nil, nil, emptyHash)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
+
+// List returns every object whose key starts with prefix, following continuation
+// tokens until the listing is complete.
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+
+	for {
+		qs := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			qs.Set("continuation-token", token)
+		}
+
+		req, err := c.newRequest(ctx, http.MethodGet, "", qs, nil, emptyHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+
+		err = c.do(req, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range result.Contents {
+			objects = append(objects, Object{Key: o.Key, Size: o.Size, LastModified: o.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// newRequest builds a signed request for an object in the bucket, or for the bucket
+// itself if key is empty.
+func (c *Client) newRequest(ctx context.Context, method, key string, qs url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(qs)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sign(req, payloadHash)
+	return req, nil
+}
+
+// do sends a request, decoding an XML response into dst if it isn't nil.
+func (c *Client) do(req *http.Request, dst any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+
+		return fmt.Errorf("objectstore: %s %s: %s %s %s", req.Method, req.URL.Path, resp.Status, e.Code, e.Message)
+	}
+
+	if dst == nil {
+		return nil
+	}
+
+	return xml.NewDecoder(resp.Body).Decode(dst)
+}
+
+// sign adds the AWS Signature Version 4 headers to a request.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath percent-encodes everything in a path apart from the unreserved
+// characters and slashes, as SigV4 requires.
+func escapePath(path string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if ch == '/' || isUnreserved(ch) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	return b.String()
+}
+
+// canonicalQuery encodes a query string with its keys sorted and every character
+// apart from the unreserved ones percent-encoded, as SigV4 requires.
+func canonicalQuery(qs url.Values) string {
+	keys := make([]string, 0, len(qs))
+	for k := range qs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range qs[k] {
+			parts = append(parts, escapeQuery(k)+"="+escapeQuery(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+func isUnreserved(ch byte) bool {
+	return ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+		ch == '-' || ch == '_' || ch == '.' || ch == '~'
+}
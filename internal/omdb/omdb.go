@@ -0,0 +1,139 @@
+// Package omdb is a minimal client for the OMDb API, used to fill in details of movies
+// which were added to the catalog by hand.
+package omdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when OMDb has no movie with the requested title and year.
+var ErrNotFound = errors.New("omdb: movie not found")
+
+// Movie holds the details of a movie from OMDb which we store in the catalog. OMDb
+// says "N/A" for anything it doesn't know, which is left as the zero value here.
+type Movie struct {
+	IMDbID     string
+	Plot       string
+	PosterURL  string
+	IMDbRating *float64
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu     sync.RWMutex
+	apiKey string
+}
+
+// New returns a client for the OMDb API at baseURL (normally
+// https://www.omdbapi.com).
+func New(baseURL, apiKey string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: httpClient,
+	}
+}
+
+// Configured reports whether the client has an API key to make requests with.
+func (c *Client) Configured() bool {
+	return c.key() != ""
+}
+
+// UpdateAPIKey replaces the API key, for when it's rotated.
+func (c *Client) UpdateAPIKey(apiKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apiKey = apiKey
+}
+
+func (c *Client) key() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.apiKey
+}
+
+// GetMovie looks up a movie by its title and release year.
+func (c *Client) GetMovie(ctx context.Context, title string, year int32) (*Movie, error) {
+	qs := url.Values{
+		"apikey": {c.key()},
+		"t":      {title},
+		"y":      {strconv.Itoa(int(year))},
+		"type":   {"movie"},
+		"plot":   {"short"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/?"+qs.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		// Don't include the URL in the error, since it contains the API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("omdb: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("omdb: unexpected status %d", res.StatusCode)
+	}
+
+	// OMDb reports errors, including not finding the movie, with a 200 OK response.
+	var body struct {
+		Response   string `json:"Response"`
+		Error      string `json:"Error"`
+		IMDbID     string `json:"imdbID"`
+		Plot       string `json:"Plot"`
+		Poster     string `json:"Poster"`
+		IMDbRating string `json:"imdbRating"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("omdb: %w", err)
+	}
+
+	if body.Response != "True" {
+		if body.Error == "Movie not found!" {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("omdb: %s", body.Error)
+	}
+
+	movie := &Movie{
+		IMDbID:    body.IMDbID,
+		Plot:      known(body.Plot),
+		PosterURL: known(body.Poster),
+	}
+
+	if rating, err := strconv.ParseFloat(body.IMDbRating, 64); err == nil {
+		movie.IMDbRating = &rating
+	}
+
+	return movie, nil
+}
+
+// known returns s, or an empty string if it's OMDb's "N/A" for a missing value.
+func known(s string) string {
+	if s == "N/A" {
+		return ""
+	}
+	return s
+}
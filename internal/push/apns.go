@@ -0,0 +1,177 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+
+	// Apple rejects provider tokens which are more than an hour old, and throttles
+	// providers which make new ones more often than every 20 minutes.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsConfig holds the credentials for sending to APNs with a token-based (.p8) key.
+type APNsConfig struct {
+	Key     []byte // The PEM-encoded .p8 key downloaded from Apple
+	KeyID   string
+	TeamID  string
+	Topic   string // The app's bundle ID
+	Sandbox bool   // Send to the development environment rather than production
+}
+
+// apnsSender sends messages to APNs, authenticating with a provider token which it
+// signs with the .p8 key and reuses until it's close to expiring. APNs only speaks
+// HTTP/2, which net/http negotiates automatically.
+type apnsSender struct {
+	cfg        APNsConfig
+	key        *ecdsa.PrivateKey
+	baseURL    string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	jwt    string
+	issued time.Time
+}
+
+func newAPNsSender(cfg APNsConfig, httpClient *http.Client) (*apnsSender, error) {
+	block, _ := pem.Decode(cfg.Key)
+	if block == nil {
+		return nil, errors.New("push: APNs key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid APNs key: %w", err)
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("push: APNs key must be an ECDSA key")
+	}
+
+	baseURL := apnsProductionURL
+	if cfg.Sandbox {
+		baseURL = apnsSandboxURL
+	}
+
+	return &apnsSender{cfg: cfg, key: key, baseURL: baseURL, httpClient: httpClient}, nil
+}
+
+func (s *apnsSender) send(ctx context.Context, token string, msg Message) error {
+	jwt, err := s.token()
+	if err != nil {
+		return err
+	}
+
+	// Custom data sits alongside the aps dictionary at the top level of the payload.
+	payload := map[string]any{
+		"aps": map[string]any{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+
+	for k, v := range msg.Data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/3/device/"+url.PathEscape(token), bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+jwt)
+	req.Header.Set("Apns-Topic", s.cfg.Topic)
+	req.Header.Set("Apns-Push-Type", "alert")
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+
+	_ = json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&failure)
+
+	switch {
+	case res.StatusCode == http.StatusGone, failure.Reason == "BadDeviceToken", failure.Reason == "DeviceTokenNotForTopic":
+		return ErrUnregistered
+	case failure.Reason == "ExpiredProviderToken":
+		s.mu.Lock()
+		s.jwt = ""
+		s.mu.Unlock()
+	}
+
+	return fmt.Errorf("push: APNs responded with %s: %s", res.Status, failure.Reason)
+}
+
+// token returns the current provider token, signing a new one if it's missing or too
+// old.
+func (s *apnsSender) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jwt != "" && time.Since(s.issued) < apnsTokenLifetime {
+		return s.jwt, nil
+	}
+
+	now := time.Now()
+
+	// ES256 signatures in a JWT are the two 32-byte integers r and s side by side,
+	// rather than the ASN.1 encoding which ecdsa.PrivateKey.Sign produces.
+	sign := func(digest []byte) ([]byte, error) {
+		r, ss, err := ecdsa.Sign(rand.Reader, s.key, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+		return sig, nil
+	}
+
+	jwt, err := signJWT(
+		map[string]string{"alg": "ES256", "kid": s.cfg.KeyID},
+		map[string]any{"iss": s.cfg.TeamID, "iat": now.Unix()},
+		sign,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	s.jwt = jwt
+	s.issued = now
+
+	return s.jwt, nil
+}
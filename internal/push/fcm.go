@@ -0,0 +1,210 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fcmBaseURL  = "https://fcm.googleapis.com/v1"
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+	googleToken = "https://oauth2.googleapis.com/token"
+)
+
+// serviceAccount holds the fields we need from a Google service account key file.
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// fcmSender sends messages with the FCM HTTP v1 API. It authenticates with an OAuth
+// access token, which it gets by signing an assertion with the service account's key
+// and caches until shortly before it expires.
+type fcmSender struct {
+	account    serviceAccount
+	key        *rsa.PrivateKey
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func newFCMSender(credentials []byte, httpClient *http.Client) (*fcmSender, error) {
+	var account serviceAccount
+
+	err := json.Unmarshal(credentials, &account)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid FCM credentials: %w", err)
+	}
+
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, errors.New("push: FCM credentials must include project_id and client_email")
+	}
+
+	if account.TokenURI == "" {
+		account.TokenURI = googleToken
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("push: FCM credentials have no PEM private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("push: invalid FCM private key: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("push: FCM private key must be an RSA key")
+	}
+
+	return &fcmSender{account: account, key: key, httpClient: httpClient}, nil
+}
+
+func (s *fcmSender) send(ctx context.Context, token string, msg Message) error {
+	accessToken, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Message struct {
+			Token        string            `json:"token"`
+			Notification map[string]string `json:"notification"`
+			Data         map[string]string `json:"data,omitempty"`
+		} `json:"message"`
+	}
+
+	body.Message.Token = token
+	body.Message.Notification = map[string]string{"title": msg.Title, "body": msg.Body}
+	body.Message.Data = msg.Data
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/projects/%s/messages:send", fcmBaseURL, url.PathEscape(s.account.ProjectID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 300 {
+		return nil
+	}
+
+	var failure struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	_ = json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&failure)
+
+	// FCM responds with 404 NOT_FOUND (or the UNREGISTERED error code) once an app
+	// has been uninstalled or its registration token has expired.
+	if res.StatusCode == http.StatusNotFound || strings.Contains(failure.Error.Message, "UNREGISTERED") {
+		return ErrUnregistered
+	}
+
+	// If the access token has been revoked, get a new one on the next attempt.
+	if res.StatusCode == http.StatusUnauthorized {
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+	}
+
+	return fmt.Errorf("push: FCM responded with %s: %s", res.Status, failure.Error.Message)
+}
+
+// token returns a cached OAuth access token, or exchanges a newly signed assertion for
+// one if it's missing or about to expire.
+func (s *fcmSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Until(s.expiry) > time.Minute {
+		return s.accessToken, nil
+	}
+
+	now := time.Now()
+
+	assertion, err := signJWT(
+		map[string]string{"alg": "RS256", "typ": "JWT"},
+		map[string]any{
+			"iss":   s.account.ClientEmail,
+			"scope": fcmScope,
+			"aud":   s.account.TokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		},
+		signer(s.key),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("push: unable to get an FCM access token: %s", res.Status)
+	}
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&grant)
+	if err != nil {
+		return "", err
+	}
+
+	s.accessToken = grant.AccessToken
+	s.expiry = now.Add(time.Duration(grant.ExpiresIn) * time.Second)
+
+	return s.accessToken, nil
+}
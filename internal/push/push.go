@@ -0,0 +1,125 @@
+// Package push sends push notifications to mobile devices, through Firebase Cloud
+// Messaging (FCM) for Android and the Apple Push Notification service (APNs) for iOS.
+package push
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Define constants for the platforms which devices can be registered on.
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+)
+
+var (
+	// ErrUnregistered is returned when the platform reports that a device token is no
+	// longer valid, for example because the app was uninstalled. The device should be
+	// forgotten.
+	ErrUnregistered = errors.New("push: device token is no longer valid")
+	// ErrNotConfigured is returned when sending to a platform which there are no
+	// credentials for.
+	ErrNotConfigured = errors.New("push: platform not configured")
+)
+
+// A Message is a notification shown on a device. Data is passed to the app along
+// with it, for example the ID of the thing the notification is about.
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// Client sends messages to whichever platforms it has credentials for.
+type Client struct {
+	fcm  *fcmSender
+	apns *apnsSender
+}
+
+// New returns a client which sends to FCM if fcmCredentials (the JSON key of a Google
+// service account) isn't empty, and to APNs if apns isn't nil.
+func New(fcmCredentials []byte, apns *APNsConfig, httpClient *http.Client) (*Client, error) {
+	c := &Client{}
+
+	if len(fcmCredentials) > 0 {
+		s, err := newFCMSender(fcmCredentials, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		c.fcm = s
+	}
+
+	if apns != nil {
+		s, err := newAPNsSender(*apns, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		c.apns = s
+	}
+
+	return c, nil
+}
+
+// Configured reports whether the client can send to the given platform.
+func (c *Client) Configured(platform string) bool {
+	switch platform {
+	case PlatformFCM:
+		return c.fcm != nil
+	case PlatformAPNs:
+		return c.apns != nil
+	}
+
+	return false
+}
+
+// Send delivers a message to a single device.
+func (c *Client) Send(ctx context.Context, platform, token string, msg Message) error {
+	switch {
+	case platform == PlatformFCM && c.fcm != nil:
+		return c.fcm.send(ctx, token, msg)
+	case platform == PlatformAPNs && c.apns != nil:
+		return c.apns.send(ctx, token, msg)
+	}
+
+	return ErrNotConfigured
+}
+
+// signJWT builds a JSON Web Token from the header and claims, signing the SHA-256
+// digest of its first two parts with the given signer. Both platforms authenticate
+// with a token like this, FCM with RS256 and APNs with ES256.
+func signJWT(header, claims any, sign func(digest []byte) ([]byte, error)) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	digest := sha256.Sum256([]byte(unsigned))
+
+	sig, err := sign(digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signer adapts a crypto.Signer to signJWT, for keys whose signatures are used as-is.
+func signer(key crypto.Signer) func([]byte) ([]byte, error) {
+	return func(digest []byte) ([]byte, error) {
+		return key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+}
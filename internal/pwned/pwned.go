@@ -0,0 +1,83 @@
+// Package pwned is a minimal client for the Have I Been Pwned Pwned Passwords API,
+// used to stop people choosing passwords which have appeared in data breaches.
+package pwned
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Client struct {
+	baseURL    string
+	userAgent  string
+	httpClient *http.Client
+}
+
+// New returns a client for the Pwned Passwords API at baseURL (normally
+// https://api.pwnedpasswords.com). The API asks that clients identify themselves with
+// a user agent.
+func New(baseURL, userAgent string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		userAgent:  userAgent,
+		httpClient: httpClient,
+	}
+}
+
+// Count returns the number of times the password has appeared in data breaches, which
+// is zero if it never has. Only the first five characters of the password's SHA-1 hash
+// are sent, and the matching suffixes are looked for here, so the API never learns the
+// password or its hash. Responses are padded with fake suffixes, so that their size
+// doesn't give the prefix away either.
+func (c *Client) Count(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("pwned: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("pwned: unexpected status %d", res.StatusCode)
+	}
+
+	// Each line is a hash suffix and its count, like "0018A45C4D1DEF81644B54AB7F969B88D65:10".
+	// The padding has a count of zero.
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || candidate != suffix {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("pwned: invalid count %q", count)
+		}
+
+		return n, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("pwned: %w", err)
+	}
+
+	return 0, nil
+}
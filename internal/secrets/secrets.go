@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+)
+
+// A Fetcher retrieves the current value of a named secret. What the name means
+// depends on the implementation: for files it's a path on disk, for Vault it's a
+// "<path>#<key>" reference.
+type Fetcher interface {
+	Fetch(ctx context.Context, name string) (string, error)
+}
+
+// FileFetcher reads secrets from files, such as those mounted by Docker or
+// Kubernetes secrets. Leading and trailing whitespace (including the trailing
+// newline most editors add) is trimmed from the value.
+type FileFetcher struct{}
+
+func (f FileFetcher) Fetch(ctx context.Context, name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// VaultFetcher reads secrets from a HashiCorp Vault KV secrets engine over its
+// HTTP API. Both version 1 and version 2 of the KV engine are supported; for
+// version 2 the path should include the "data/" segment, for example
+// "secret/data/greenlight#db_dsn".
+type VaultFetcher struct {
+	Addr   string
+	Token  string
+	Client *http.Client
+}
+
+func NewVaultFetcher(addr, token string) *VaultFetcher {
+	return &VaultFetcher{
+		Addr:   strings.TrimSuffix(addr, "/"),
+		Token:  token,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (f *VaultFetcher) Fetch(ctx context.Context, name string) (string, error) {
+	path, key, found := strings.Cut(name, "#")
+	if !found || path == "" || key == "" {
+		return "", fmt.Errorf("invalid vault secret reference %q (expected <path>#<key>)", name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.Addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", f.Token)
+
+	res, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault returned unexpected status %d for %s", res.StatusCode, path)
+	}
+
+	// KV version 1 returns the secret values directly in "data", while version 2
+	// nests them in a second "data" object alongside the version metadata.
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	values := body.Data
+	if nested, ok := body.Data["data"].(map[string]any); ok {
+		values = nested
+	}
+
+	value, ok := values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,139 @@
+// Package storage stores uploaded files, like movie posters, either in a directory on
+// local disk or in an S3-compatible bucket. Files are small enough to be held in
+// memory while they're stored.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"greenlight/anaplo/internal/objectstore"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get if there's no file with the key.
+var ErrNotFound = errors.New("storage: file not found")
+
+// A Store saves and serves files by key. Keys are flat names like "12-ab34.jpg", with
+// an extension which determines the content type served on disk.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DiskStore keeps files in a directory on local disk. It's only suitable for a single
+// instance, or for instances which share the directory.
+type DiskStore struct {
+	Dir string
+}
+
+// NewDiskStore returns a store for the directory, creating it if it doesn't exist.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{Dir: dir}, nil
+}
+
+// Put writes the file to a temporary file first and renames it into place, so that
+// it's never served half written.
+func (s *DiskStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *DiskStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+
+	return f, mime.TypeByExtension(filepath.Ext(key)), nil
+}
+
+// Delete removes a file. Deleting a file which doesn't exist isn't an error.
+func (s *DiskStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns where the file with the key is kept, refusing keys which could point
+// outside the directory.
+func (s *DiskStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", errors.New("storage: invalid key")
+	}
+
+	return filepath.Join(s.Dir, key), nil
+}
+
+// S3Store keeps files in an S3-compatible bucket, under a key prefix.
+type S3Store struct {
+	Client *objectstore.Client
+	Prefix string
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	sum := sha256.Sum256(data)
+	return s.Client.Put(ctx, s.Prefix+key, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]), contentType)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	body, contentType, err := s.Client.Get(ctx, s.Prefix+key)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, "", ErrNotFound
+	}
+
+	return body, contentType, err
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.Client.Delete(ctx, s.Prefix+key)
+}
@@ -0,0 +1,152 @@
+// Package tmdb is a minimal client for The Movie Database (TMDB) API, used to import
+// movies into the catalog.
+package tmdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when TMDB has no movie with the requested ID.
+var ErrNotFound = errors.New("tmdb: movie not found")
+
+// Movie holds the details of a movie from TMDB which we store in the catalog.
+type Movie struct {
+	ID        int64
+	Title     string
+	Year      int32
+	Runtime   int32
+	Genres    []string
+	Synopsis  string
+	PosterURL string
+	// The ISO 639-1 code of the language the movie was made in, like en.
+	OriginalLanguage string
+	// TMDB reports budgets and revenue in whole US dollars, and zero if it doesn't
+	// know them.
+	Budget  int64
+	Revenue int64
+}
+
+type Client struct {
+	baseURL      string
+	imageBaseURL string
+	httpClient   *http.Client
+
+	mu     sync.RWMutex
+	apiKey string
+}
+
+// New returns a client for the TMDB API at baseURL (normally
+// https://api.themoviedb.org/3). Poster URLs are built from imageBaseURL.
+func New(baseURL, imageBaseURL, apiKey string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		imageBaseURL: strings.TrimSuffix(imageBaseURL, "/"),
+		apiKey:       apiKey,
+		httpClient:   httpClient,
+	}
+}
+
+// Configured reports whether the client has an API key to make requests with.
+func (c *Client) Configured() bool {
+	return c.key() != ""
+}
+
+// UpdateAPIKey replaces the API key, for when it's rotated.
+func (c *Client) UpdateAPIKey(apiKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apiKey = apiKey
+}
+
+func (c *Client) key() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.apiKey
+}
+
+// GetMovie fetches the details of the movie with the given TMDB ID.
+func (c *Client) GetMovie(ctx context.Context, id int64) (*Movie, error) {
+	u := fmt.Sprintf("%s/movie/%d?api_key=%s", c.baseURL, id, url.QueryEscape(c.key()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		// Don't include the URL in the error, since it contains the API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("tmdb: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("tmdb: unexpected status %d", res.StatusCode)
+	}
+
+	var body struct {
+		ID          int64  `json:"id"`
+		Title       string `json:"title"`
+		ReleaseDate string `json:"release_date"`
+		Runtime     int32  `json:"runtime"`
+		Genres      []struct {
+			Name string `json:"name"`
+		} `json:"genres"`
+		Overview         string `json:"overview"`
+		PosterPath       string `json:"poster_path"`
+		OriginalLanguage string `json:"original_language"`
+		Budget           int64  `json:"budget"`
+		Revenue          int64  `json:"revenue"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("tmdb: %w", err)
+	}
+
+	movie := &Movie{
+		ID:               body.ID,
+		Title:            body.Title,
+		Runtime:          body.Runtime,
+		Synopsis:         body.Overview,
+		OriginalLanguage: body.OriginalLanguage,
+		Budget:           body.Budget,
+		Revenue:          body.Revenue,
+	}
+
+	if t, err := time.Parse("2006-01-02", body.ReleaseDate); err == nil {
+		movie.Year = int32(t.Year())
+	} else if len(body.ReleaseDate) >= 4 {
+		year, _ := strconv.Atoi(body.ReleaseDate[:4])
+		movie.Year = int32(year)
+	}
+
+	for _, g := range body.Genres {
+		movie.Genres = append(movie.Genres, strings.ToLower(g.Name))
+	}
+
+	if body.PosterPath != "" {
+		movie.PosterURL = c.imageBaseURL + body.PosterPath
+	}
+
+	return movie, nil
+}
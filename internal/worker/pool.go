@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+var (
+	ErrQueueFull = errors.New("worker queue is full")
+	ErrClosed    = errors.New("worker pool is shut down")
+)
+
+type task struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// Pool runs submitted tasks on a fixed number of goroutines. Tasks wait in a bounded
+// queue until a worker is free, so a burst of work (like a flood of sign-ups sending
+// welcome emails) can't spawn an unbounded number of goroutines.
+type Pool struct {
+	tasks  chan task
+	block  bool
+	logger *slog.Logger
+	wg     sync.WaitGroup
+
+	// Every task receives ctx, which is cancelled when the pool runs out of time
+	// while shutting down.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The mutex guards closed, and makes sure that we never send on the tasks
+	// channel after it has been closed by Shutdown().
+	mu     sync.RWMutex
+	closed bool
+
+	// The state mutex guards the bookkeeping of which tasks are running or have been
+	// abandoned. It's separate from mu because Submit() can hold mu for a long time
+	// while blocked on a full queue.
+	stateMu   sync.Mutex
+	nextID    int64
+	running   map[int64]string
+	abandoned []string
+}
+
+// New starts a pool with the given number of workers and queue capacity. When block
+// is true, Submit() waits for space in the queue when it is full; otherwise it
+// returns ErrQueueFull straight away. Without at least one worker nothing would ever
+// run, and in block mode every Submit() would hang, so that's an error.
+func New(concurrency, queueSize int, block bool, logger *slog.Logger) (*Pool, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("worker pool concurrency must be at least 1, got %d", concurrency)
+	}
+	if queueSize < 0 {
+		return nil, fmt.Errorf("worker queue size must not be negative, got %d", queueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &Pool{
+		tasks:   make(chan task, queueSize),
+		block:   block,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[int64]string),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p, nil
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for t := range p.tasks {
+		p.stateMu.Lock()
+
+		// If the shutdown grace period has already run out, don't start any more
+		// tasks. Just record them as abandoned.
+		if p.ctx.Err() != nil {
+			p.abandoned = append(p.abandoned, t.name)
+			p.stateMu.Unlock()
+			continue
+		}
+
+		p.nextID++
+		id := p.nextID
+		p.running[id] = t.name
+		p.stateMu.Unlock()
+
+		p.run(t)
+
+		p.stateMu.Lock()
+		delete(p.running, id)
+		p.stateMu.Unlock()
+	}
+}
+
+// run executes a single task, recovering any panic so that one misbehaving task
+// doesn't take down the worker (or the whole application) with it.
+func (p *Pool) run(t task) {
+	defer func() {
+		if err := recover(); err != nil {
+			p.logger.Error(fmt.Sprintf("%v", err), "task", t.name)
+		}
+	}()
+
+	t.fn(p.ctx)
+}
+
+// Submit adds a task to the queue. The name is used to identify the task in log
+// messages. The task should return promptly when its context is cancelled.
+func (p *Pool) Submit(name string, fn func(ctx context.Context)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	t := task{name: name, fn: fn}
+
+	if p.block {
+		p.tasks <- t
+		return nil
+	}
+
+	select {
+	case p.tasks <- t:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// QueueDepth returns the number of tasks waiting for a free worker.
+func (p *Pool) QueueDepth() int {
+	return len(p.tasks)
+}
+
+// Running returns the number of tasks currently being run by a worker.
+func (p *Pool) Running() int {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	return len(p.running)
+}
+
+// Shutdown stops accepting new tasks and waits for every queued and in-flight task to
+// finish. If ctx is done first, the context passed to the running tasks is cancelled
+// and Shutdown returns the names of the tasks which didn't complete: those still
+// running and those which never started.
+func (p *Pool) Shutdown(ctx context.Context) []string {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.cancel()
+
+	// Take whatever is left in the queue so that it isn't started.
+	var abandoned []string
+	for t := range p.tasks {
+		abandoned = append(abandoned, t.name)
+	}
+
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	abandoned = append(abandoned, p.abandoned...)
+	for _, name := range p.running {
+		abandoned = append(abandoned, name)
+	}
+
+	return abandoned
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name text PRIMARY KEY,
+    last_slot timestamp(0) with time zone NOT NULL,
+    locked_until timestamp(0) with time zone NOT NULL,
+    locked_by text NOT NULL
+);
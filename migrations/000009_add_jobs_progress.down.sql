@@ -0,0 +1,4 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS errors;
+ALTER TABLE jobs DROP COLUMN IF EXISTS progress_total;
+ALTER TABLE jobs DROP COLUMN IF EXISTS progress_done;
+ALTER TABLE jobs DROP COLUMN IF EXISTS user_id;
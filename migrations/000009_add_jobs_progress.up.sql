@@ -0,0 +1,4 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS progress_done integer NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS progress_total integer NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS errors text[] NOT NULL DEFAULT '{}';
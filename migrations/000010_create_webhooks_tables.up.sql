@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active bool NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_events_idx ON webhooks USING GIN (events);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    attempt integer NOT NULL,
+    status_code integer NOT NULL DEFAULT 0,
+    success bool NOT NULL,
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:manage');
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS movie_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    movie_id bigint NOT NULL,
+    movie jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_created_at_idx ON movie_events (created_at);
+
+-- Record every change to the movies table as an event, and notify listeners on the
+-- movie_events channel with the ID of the new event.
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO movie_events (event, movie_id, movie)
+        VALUES ('movie.deleted', OLD.id, to_jsonb(OLD))
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO movie_events (event, movie_id, movie)
+        VALUES (CASE TG_OP WHEN 'INSERT' THEN 'movie.created' ELSE 'movie.updated' END, NEW.id, to_jsonb(NEW))
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('movie_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_record_event
+AFTER INSERT OR UPDATE OR DELETE ON movies
+FOR EACH ROW EXECUTE FUNCTION record_movie_event();
@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_url;
+ALTER TABLE movies DROP COLUMN IF EXISTS synopsis;
+ALTER TABLE movies DROP COLUMN IF EXISTS tmdb_id;
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tmdb_id bigint UNIQUE;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS synopsis text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_url text NOT NULL DEFAULT '';
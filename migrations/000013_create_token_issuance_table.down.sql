@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('admin:read', 'admin:write');
+DROP TRIGGER IF EXISTS tokens_count_issuance ON tokens;
+DROP FUNCTION IF EXISTS count_token_issuance();
+DROP TABLE IF EXISTS token_issuance;
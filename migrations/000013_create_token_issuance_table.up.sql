@@ -0,0 +1,26 @@
+-- Expired tokens are deleted, so we keep a running count of the tokens issued each day
+-- for the admin statistics.
+CREATE TABLE IF NOT EXISTS token_issuance (
+    day date NOT NULL,
+    scope text NOT NULL,
+    issued bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, scope)
+);
+
+CREATE OR REPLACE FUNCTION count_token_issuance() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO token_issuance (day, scope, issued)
+    VALUES (current_date, NEW.scope, 1)
+    ON CONFLICT (day, scope) DO UPDATE SET issued = token_issuance.issued + 1;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tokens_count_issuance
+AFTER INSERT ON tokens
+FOR EACH ROW EXECUTE FUNCTION count_token_issuance();
+
+INSERT INTO permissions (code)
+VALUES
+    ('admin:read'),
+    ('admin:write');
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    resource_type text NOT NULL,
+    resource_id bigint,
+    ip text NOT NULL DEFAULT '',
+    details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_created_at_idx ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS audit_logs_actor_id_idx ON audit_logs (actor_id);
+CREATE INDEX IF NOT EXISTS audit_logs_resource_idx ON audit_logs (resource_type, resource_id);
@@ -0,0 +1,3 @@
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
+ALTER TABLE permissions DROP COLUMN IF EXISTS active;
+ALTER TABLE permissions DROP COLUMN IF EXISTS description;
@@ -0,0 +1,3 @@
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS active bool NOT NULL DEFAULT true;
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
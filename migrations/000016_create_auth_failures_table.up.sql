@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS auth_failures (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    email citext NOT NULL DEFAULT '',
+    ip text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_failures_email_idx ON auth_failures (kind, email, created_at);
+CREATE INDEX IF NOT EXISTS auth_failures_ip_idx ON auth_failures (kind, ip, created_at);
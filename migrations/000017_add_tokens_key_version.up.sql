@@ -0,0 +1,3 @@
+-- Version 0 means the hash is a plain SHA-256 of the token, from before hashes were
+-- peppered.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS key_version integer NOT NULL DEFAULT 0;
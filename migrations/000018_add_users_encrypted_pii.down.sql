@@ -0,0 +1,6 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_check;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_index_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_index;
+ALTER TABLE users DROP COLUMN IF EXISTS email_encrypted;
+ALTER TABLE users DROP COLUMN IF EXISTS name_encrypted;
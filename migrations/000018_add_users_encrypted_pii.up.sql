@@ -0,0 +1,8 @@
+-- When PII encryption is enabled, users' names and email addresses are stored in the
+-- encrypted columns instead, with email set to NULL and name to ''.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS name_encrypted bytea;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_encrypted bytea;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_index bytea;
+ALTER TABLE users ADD CONSTRAINT users_email_index_key UNIQUE (email_index);
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_email_check CHECK (email IS NOT NULL OR email_encrypted IS NOT NULL);
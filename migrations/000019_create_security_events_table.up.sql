@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS security_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    event text NOT NULL,
+    severity text NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON security_events (user_id, created_at);
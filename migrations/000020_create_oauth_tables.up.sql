@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    client_id text UNIQUE NOT NULL,
+    secret_hash bytea NOT NULL,
+    name text NOT NULL,
+    redirect_uris text[] NOT NULL,
+    scopes text[] NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    hash bytea PRIMARY KEY,
+    key_version integer NOT NULL,
+    client_id bigint NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    permissions text[] NOT NULL,
+    code_challenge text NOT NULL DEFAULT '',
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Access tokens issued to a third-party application are limited to the permissions
+-- the user granted it. Tokens issued directly to users have NULL in both columns.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS oauth_client_id bigint REFERENCES oauth_clients ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS permissions text[];
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    title text NOT NULL DEFAULT '',
+    genres text[] NOT NULL DEFAULT '{}',
+    year_min integer,
+    year_max integer,
+    notify_email boolean NOT NULL DEFAULT false,
+    notify_webhook boolean NOT NULL DEFAULT false,
+    last_movie_id bigint NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS saved_searches_user_id_idx ON saved_searches (user_id);
+CREATE INDEX IF NOT EXISTS saved_searches_notify_idx ON saved_searches (id) WHERE notify_email OR notify_webhook;
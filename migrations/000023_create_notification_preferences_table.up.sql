@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    email boolean NOT NULL DEFAULT true,
+    in_app boolean NOT NULL DEFAULT true,
+    digest boolean NOT NULL DEFAULT true,
+    security_alerts boolean NOT NULL DEFAULT true,
+    saved_search_matches boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
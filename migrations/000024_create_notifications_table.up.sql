@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    title text NOT NULL,
+    body text NOT NULL DEFAULT '',
+    data jsonb,
+    read_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, created_at);
+CREATE INDEX IF NOT EXISTS notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
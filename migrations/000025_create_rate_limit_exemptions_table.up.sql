@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS rate_limit_exemptions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    value text NOT NULL,
+    rps double precision,
+    burst integer,
+    note text NOT NULL DEFAULT '',
+    UNIQUE (kind, value)
+);
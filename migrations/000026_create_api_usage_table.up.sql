@@ -0,0 +1,10 @@
+-- client_id is the oauth_clients.id of the application which made the requests, or 0
+-- for requests made with the user's own tokens. It isn't a foreign key, so that usage
+-- is still reported after an application is deleted.
+CREATE TABLE IF NOT EXISTS api_usage (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    client_id bigint NOT NULL DEFAULT 0,
+    day date NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, client_id, day)
+);
@@ -0,0 +1,30 @@
+ALTER TABLE saved_searches DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS organization_id;
+
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO movie_events (event, movie_id, movie)
+        VALUES ('movie.deleted', OLD.id, to_jsonb(OLD))
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO movie_events (event, movie_id, movie)
+        VALUES (CASE TG_OP WHEN 'INSERT' THEN 'movie.created' ELSE 'movie.updated' END, NEW.id, to_jsonb(NEW))
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('movie_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE movie_events DROP COLUMN IF EXISTS organization_id;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_organization_id_tmdb_id_key;
+ALTER TABLE movies DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE movies ADD CONSTRAINT movies_tmdb_id_key UNIQUE (tmdb_id);
+
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
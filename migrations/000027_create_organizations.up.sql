@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug citext UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+-- Everything which existed before organizations were introduced belongs to the
+-- default organization, and every existing user is a member of it.
+INSERT INTO organizations (id, name, slug) VALUES (1, 'Default', 'default');
+SELECT setval('organizations_id_seq', (SELECT max(id) FROM organizations));
+
+INSERT INTO organization_members (organization_id, user_id, role)
+SELECT 1, id, 'member' FROM users;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE movies ALTER COLUMN organization_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
+
+-- A movie can be imported from TMDB once per organization.
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_tmdb_id_key;
+ALTER TABLE movies ADD CONSTRAINT movies_organization_id_tmdb_id_key UNIQUE (organization_id, tmdb_id);
+
+ALTER TABLE movie_events ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1;
+ALTER TABLE movie_events ALTER COLUMN organization_id DROP DEFAULT;
+
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES ('movie.deleted', OLD.id, to_jsonb(OLD), OLD.organization_id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES (CASE TG_OP WHEN 'INSERT' THEN 'movie.created' ELSE 'movie.updated' END, NEW.id, to_jsonb(NEW), NEW.organization_id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('movie_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- An access token is scoped to the organization the user signed in to. Tokens from
+-- before organizations were introduced have NULL, and use the user's first
+-- organization.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+
+ALTER TABLE saved_searches ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE saved_searches ALTER COLUMN organization_id DROP DEFAULT;
@@ -0,0 +1,3 @@
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS push;
+
+DROP TABLE IF EXISTS push_devices;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS push_devices (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    platform text NOT NULL CHECK (platform IN ('fcm', 'apns')),
+    token text NOT NULL,
+    name text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- A device token belongs to one app install, so registering it again (even as
+    -- another user) replaces the existing registration.
+    UNIQUE (platform, token)
+);
+
+CREATE INDEX IF NOT EXISTS push_devices_user_id_idx ON push_devices (user_id);
+
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS push boolean NOT NULL DEFAULT true;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS movie_watch_providers (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    provider text NOT NULL,
+    region text NOT NULL,
+    type text NOT NULL CHECK (type IN ('stream', 'rent', 'buy')),
+    PRIMARY KEY (movie_id, provider, region, type)
+);
+
+-- For finding the movies available on a provider.
+CREATE INDEX IF NOT EXISTS movie_watch_providers_provider_idx ON movie_watch_providers (provider, region);
@@ -0,0 +1,7 @@
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_box_office_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_budget_check;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS box_office_currency;
+ALTER TABLE movies DROP COLUMN IF EXISTS box_office_amount;
+ALTER TABLE movies DROP COLUMN IF EXISTS budget_currency;
+ALTER TABLE movies DROP COLUMN IF EXISTS budget_amount;
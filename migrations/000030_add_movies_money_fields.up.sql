@@ -0,0 +1,9 @@
+-- Amounts are in the currency's minor units. An amount and its currency are either
+-- both set or both NULL.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS budget_amount bigint CHECK (budget_amount >= 0);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS budget_currency char(3);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS box_office_amount bigint CHECK (box_office_amount >= 0);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS box_office_currency char(3);
+
+ALTER TABLE movies ADD CONSTRAINT movies_budget_check CHECK ((budget_amount IS NULL) = (budget_currency IS NULL));
+ALTER TABLE movies ADD CONSTRAINT movies_box_office_check CHECK ((box_office_amount IS NULL) = (box_office_currency IS NULL));
@@ -0,0 +1,18 @@
+-- Per-endpoint usage, aggregated in memory and flushed every minute or so. The route
+-- is the pattern it was registered with, like /v1/movies/:id, so that each movie
+-- doesn't get a row of its own. Latencies are in microseconds, and errors counts the
+-- responses with a 4xx or 5xx status.
+CREATE TABLE IF NOT EXISTS api_usage_endpoints (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    client_id bigint NOT NULL DEFAULT 0,
+    day date NOT NULL,
+    method text NOT NULL,
+    route text NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    errors bigint NOT NULL DEFAULT 0,
+    total_latency_us bigint NOT NULL DEFAULT 0,
+    max_latency_us bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, client_id, day, method, route)
+);
+
+CREATE INDEX IF NOT EXISTS api_usage_endpoints_day_idx ON api_usage_endpoints (day);
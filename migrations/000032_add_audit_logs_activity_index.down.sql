@@ -0,0 +1 @@
+DROP INDEX IF EXISTS audit_logs_actor_activity_idx;
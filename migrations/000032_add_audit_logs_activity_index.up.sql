@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS audit_logs_actor_activity_idx ON audit_logs (actor_id, created_at, id) WHERE resource_type = 'movie';
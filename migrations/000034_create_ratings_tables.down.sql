@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS ratings_update_stats ON ratings;
+DROP FUNCTION IF EXISTS update_movie_rating_stats();
+DROP TABLE IF EXISTS movie_rating_stats;
+DROP TABLE IF EXISTS ratings;
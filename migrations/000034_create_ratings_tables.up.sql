@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS ratings (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS ratings_user_id_idx ON ratings (user_id);
+
+-- The number and sum of each movie's ratings, kept up to date by a trigger so that
+-- listing movies doesn't have to aggregate the ratings table. They're kept apart from
+-- the movies table so that rating a movie doesn't count as changing it, which would
+-- bump it through the movie_events feed and contend with edits for the row lock.
+CREATE TABLE IF NOT EXISTS movie_rating_stats (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    rating_count integer NOT NULL DEFAULT 0,
+    rating_sum bigint NOT NULL DEFAULT 0
+);
+
+-- Deletes only ever update an existing row, so that the cascade from deleting a movie
+-- doesn't try to insert stats for it.
+CREATE OR REPLACE FUNCTION update_movie_rating_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_rating_stats (movie_id, rating_count, rating_sum)
+        VALUES (NEW.movie_id, 1, NEW.rating)
+        ON CONFLICT (movie_id) DO UPDATE
+        SET rating_count = movie_rating_stats.rating_count + 1,
+            rating_sum = movie_rating_stats.rating_sum + NEW.rating;
+    ELSIF TG_OP = 'UPDATE' THEN
+        UPDATE movie_rating_stats
+        SET rating_sum = rating_sum - OLD.rating + NEW.rating
+        WHERE movie_id = NEW.movie_id;
+    ELSE
+        UPDATE movie_rating_stats
+        SET rating_count = rating_count - 1, rating_sum = rating_sum - OLD.rating
+        WHERE movie_id = OLD.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ratings_update_stats
+AFTER INSERT OR UPDATE OF rating OR DELETE ON ratings
+FOR EACH ROW EXECUTE FUNCTION update_movie_rating_stats();
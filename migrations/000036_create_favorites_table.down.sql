@@ -0,0 +1,28 @@
+DROP TRIGGER IF EXISTS favorites_update_stats ON favorites;
+DROP FUNCTION IF EXISTS update_movie_favorite_stats();
+DROP TABLE IF EXISTS favorites;
+
+ALTER TABLE movie_stats DROP COLUMN IF EXISTS favorite_count;
+ALTER TABLE movie_stats RENAME TO movie_rating_stats;
+
+CREATE OR REPLACE FUNCTION update_movie_rating_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_rating_stats (movie_id, rating_count, rating_sum)
+        VALUES (NEW.movie_id, 1, NEW.rating)
+        ON CONFLICT (movie_id) DO UPDATE
+        SET rating_count = movie_rating_stats.rating_count + 1,
+            rating_sum = movie_rating_stats.rating_sum + NEW.rating;
+    ELSIF TG_OP = 'UPDATE' THEN
+        UPDATE movie_rating_stats
+        SET rating_sum = rating_sum - OLD.rating + NEW.rating
+        WHERE movie_id = NEW.movie_id;
+    ELSE
+        UPDATE movie_rating_stats
+        SET rating_count = rating_count - 1, rating_sum = rating_sum - OLD.rating
+        WHERE movie_id = OLD.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
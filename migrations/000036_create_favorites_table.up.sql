@@ -0,0 +1,54 @@
+-- The per-movie stats table now holds more than ratings.
+ALTER TABLE movie_rating_stats RENAME TO movie_stats;
+ALTER TABLE movie_stats ADD COLUMN IF NOT EXISTS favorite_count integer NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION update_movie_rating_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_stats (movie_id, rating_count, rating_sum)
+        VALUES (NEW.movie_id, 1, NEW.rating)
+        ON CONFLICT (movie_id) DO UPDATE
+        SET rating_count = movie_stats.rating_count + 1,
+            rating_sum = movie_stats.rating_sum + NEW.rating;
+    ELSIF TG_OP = 'UPDATE' THEN
+        UPDATE movie_stats
+        SET rating_sum = rating_sum - OLD.rating + NEW.rating
+        WHERE movie_id = NEW.movie_id;
+    ELSE
+        UPDATE movie_stats
+        SET rating_count = rating_count - 1, rating_sum = rating_sum - OLD.rating
+        WHERE movie_id = OLD.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+-- As with ratings, deletes only ever update an existing row.
+CREATE OR REPLACE FUNCTION update_movie_favorite_stats() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_stats (movie_id, favorite_count)
+        VALUES (NEW.movie_id, 1)
+        ON CONFLICT (movie_id) DO UPDATE
+        SET favorite_count = movie_stats.favorite_count + 1;
+    ELSE
+        UPDATE movie_stats
+        SET favorite_count = favorite_count - 1
+        WHERE movie_id = OLD.movie_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER favorites_update_stats
+AFTER INSERT OR DELETE ON favorites
+FOR EACH ROW EXECUTE FUNCTION update_movie_favorite_stats();
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS movies_check_genres ON movies;
+DROP FUNCTION IF EXISTS check_movie_genres();
+DROP TABLE IF EXISTS genres;
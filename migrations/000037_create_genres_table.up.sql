@@ -0,0 +1,66 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL
+);
+
+INSERT INTO genres (slug, name) VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('sci-fi', 'Science Fiction'),
+    ('thriller', 'Thriller'),
+    ('tv-movie', 'TV Movie'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT (slug) DO NOTHING;
+
+-- Movies keep their genres in the genres column, but as slugs from the catalog.
+-- Turn the free-form genres already there into slugs, keeping their order.
+CREATE OR REPLACE FUNCTION genre_slugs(genres text[]) RETURNS text[] AS $$
+    SELECT ARRAY(
+        SELECT slug FROM (
+            SELECT COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(lower(genre), '[^a-z0-9]+', '-', 'g')), ''), 'other') AS slug,
+                   min(position) AS position
+            FROM unnest(genres) WITH ORDINALITY AS g(genre, position)
+            GROUP BY 1
+        ) AS slugs
+        ORDER BY position
+    );
+$$ LANGUAGE sql IMMUTABLE;
+
+UPDATE movies SET genres = genre_slugs(genres) WHERE genres IS DISTINCT FROM genre_slugs(genres);
+
+DROP FUNCTION genre_slugs(text[]);
+
+INSERT INTO genres (slug, name)
+SELECT DISTINCT genre, initcap(replace(genre, '-', ' '))
+FROM movies, unnest(genres) AS genre
+ON CONFLICT (slug) DO NOTHING;
+
+-- An array can't have a foreign key, so check the genres in a trigger instead.
+CREATE OR REPLACE FUNCTION check_movie_genres() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM unnest(NEW.genres) AS genre WHERE genre NOT IN (SELECT slug FROM genres)) THEN
+        RAISE EXCEPTION 'movie genres must be in the genres table' USING ERRCODE = 'foreign_key_violation';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_check_genres
+BEFORE INSERT OR UPDATE OF genres ON movies
+FOR EACH ROW EXECUTE FUNCTION check_movie_genres();
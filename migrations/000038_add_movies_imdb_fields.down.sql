@@ -0,0 +1,2 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_rating;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_id;
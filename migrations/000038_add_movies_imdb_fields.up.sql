@@ -0,0 +1,2 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_id text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_rating numeric(3, 1) CHECK (imdb_rating BETWEEN 0 AND 10);
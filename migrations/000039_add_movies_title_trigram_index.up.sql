@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Titles are compared for duplicates without case, punctuation or a leading article,
+-- so that "The Matrix" and "matrix" are the same.
+CREATE OR REPLACE FUNCTION normalize_title(title text) RETURNS text AS $$
+    SELECT trim(regexp_replace(regexp_replace(lower(title), '^(the|a|an)\s+', ''), '[^[:alnum:]]+', ' ', 'g'));
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (normalize_title(title) gin_trgm_ops);
@@ -0,0 +1,22 @@
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES ('movie.deleted', OLD.id, to_jsonb(OLD), OLD.organization_id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES (CASE TG_OP WHEN 'INSERT' THEN 'movie.created' ELSE 'movie.updated' END, NEW.id, to_jsonb(NEW), NEW.organization_id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('movie_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Movies which were deleted while this was in place are deleted for good.
+DELETE FROM movies WHERE deleted_at IS NOT NULL;
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,27 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Deleting a movie is now an update which sets deleted_at, so record it as a deletion
+-- rather than an update, and clearing deleted_at again as a restore.
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES ('movie.deleted', OLD.id, to_jsonb(OLD), OLD.organization_id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO movie_events (event, movie_id, movie, organization_id)
+        VALUES (CASE
+                    WHEN TG_OP = 'INSERT' THEN 'movie.created'
+                    WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'movie.deleted'
+                    WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'movie.restored'
+                    ELSE 'movie.updated'
+                END, NEW.id, to_jsonb(NEW), NEW.organization_id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('movie_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
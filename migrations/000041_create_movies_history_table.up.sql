@@ -0,0 +1,12 @@
+-- Each row records one update of a movie: the version it produced, who made it, and
+-- the movie's editable fields before and after.
+CREATE TABLE IF NOT EXISTS movies_history (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    changed_by bigint REFERENCES users ON DELETE SET NULL,
+    previous jsonb NOT NULL,
+    current jsonb NOT NULL,
+    UNIQUE (movie_id, version)
+);
@@ -0,0 +1,11 @@
+-- The movies suggested to each user, worked out periodically from their ratings and
+-- watchlist. A movie's recommendations belong to its organization through the movie.
+CREATE TABLE IF NOT EXISTS recommendations (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score real NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS recommendations_movie_id_idx ON recommendations (movie_id);
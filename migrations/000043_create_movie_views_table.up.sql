@@ -0,0 +1,10 @@
+-- How many times each movie's details were viewed, counted by the hour. Only the last
+-- month is kept, which is as far back as trending movies look.
+CREATE TABLE IF NOT EXISTS movie_views (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    hour timestamp(0) with time zone NOT NULL,
+    views bigint NOT NULL,
+    PRIMARY KEY (movie_id, hour)
+);
+
+CREATE INDEX IF NOT EXISTS movie_views_hour_idx ON movie_views (hour);
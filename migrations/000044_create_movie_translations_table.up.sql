@@ -0,0 +1,11 @@
+-- Alternative titles for movies, one per language. Languages are BCP 47 tags like fr
+-- or pt-BR.
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text NOT NULL,
+    title text NOT NULL,
+    PRIMARY KEY (movie_id, language)
+);
+
+-- Title searches look through the translated titles as well as the original ones.
+CREATE INDEX IF NOT EXISTS movie_translations_title_idx ON movie_translations USING GIN (to_tsvector('simple', title));
@@ -0,0 +1,2 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS homepage_url;
+ALTER TABLE movies DROP COLUMN IF EXISTS trailer_url;
@@ -0,0 +1,2 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS trailer_url text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS homepage_url text NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- The dates a movie was released in each country, as a JSON object like
+-- {"US": "2010-07-16"}. The year column is kept as the year of the earliest of them.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS release_dates jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,42 @@
+-- TV series, which like movies belong to an organization's catalog and take their
+-- genres from the genres table.
+CREATE TABLE IF NOT EXISTS series (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    title text NOT NULL,
+    start_year integer NOT NULL,
+    end_year integer,
+    genres text[] NOT NULL,
+    synopsis text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CHECK (end_year IS NULL OR end_year >= start_year)
+);
+
+CREATE INDEX IF NOT EXISTS series_organization_id_idx ON series (organization_id);
+CREATE INDEX IF NOT EXISTS series_title_idx ON series USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS series_genres_idx ON series USING GIN (genres);
+
+CREATE TRIGGER series_check_genres
+BEFORE INSERT OR UPDATE OF genres ON series
+FOR EACH ROW EXECUTE FUNCTION check_movie_genres();
+
+CREATE TABLE IF NOT EXISTS seasons (
+    id bigserial PRIMARY KEY,
+    series_id bigint NOT NULL REFERENCES series ON DELETE CASCADE,
+    number integer NOT NULL CHECK (number >= 0),
+    title text NOT NULL DEFAULT '',
+    year integer,
+    UNIQUE (series_id, number)
+);
+
+CREATE TABLE IF NOT EXISTS episodes (
+    id bigserial PRIMARY KEY,
+    season_id bigint NOT NULL REFERENCES seasons ON DELETE CASCADE,
+    number integer NOT NULL CHECK (number > 0),
+    title text NOT NULL,
+    runtime integer NOT NULL,
+    air_date date,
+    synopsis text NOT NULL DEFAULT '',
+    UNIQUE (season_id, number)
+);
@@ -0,0 +1,19 @@
+-- Collections group movies which belong together, like a franchise or a trilogy, in
+-- order. A movie can only be in one collection.
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_organization_id_idx ON collections (organization_id);
+
+CREATE TABLE IF NOT EXISTS collection_movies (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL UNIQUE REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (collection_id, movie_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS certification text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS movies_certification_idx ON movies (certification);
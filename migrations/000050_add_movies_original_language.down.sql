@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_original_language_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS original_language;
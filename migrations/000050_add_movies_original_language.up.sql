@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS original_language text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS movies_original_language_idx ON movies (original_language);
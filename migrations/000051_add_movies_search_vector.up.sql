@@ -0,0 +1,7 @@
+-- Title searches also look in the synopsis, but words in the title count for more.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', synopsis), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_idx ON movies USING GIN (search);
+DROP INDEX IF EXISTS movies_title_idx;
@@ -0,0 +1,3 @@
+-- The address an email_change token confirms. It's only kept until the token is
+-- used or expires.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS email citext;
@@ -0,0 +1,15 @@
+-- Anonymous reviews and ratings can't be kept without a user, so they're removed.
+DELETE FROM ratings WHERE user_id IS NULL;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_user_id_fkey;
+ALTER TABLE ratings ADD CONSTRAINT ratings_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_movie_id_user_id_key;
+ALTER TABLE ratings ALTER COLUMN user_id SET NOT NULL;
+ALTER TABLE ratings ADD PRIMARY KEY (movie_id, user_id);
+
+DELETE FROM reviews WHERE user_id IS NULL;
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_user_id_fkey;
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+ALTER TABLE reviews ALTER COLUMN user_id SET NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
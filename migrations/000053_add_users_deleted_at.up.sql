@@ -0,0 +1,16 @@
+-- Users who delete their account are soft-deleted, and purged after a grace period.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Reviews and ratings outlive the accounts which wrote them, anonymously, so that
+-- erasing an account doesn't change movies' average ratings. Ratings are keyed by a
+-- unique constraint rather than the primary key, since the user can now be NULL.
+ALTER TABLE reviews ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_user_id_fkey;
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE SET NULL;
+
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_pkey;
+ALTER TABLE ratings ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE ratings ADD CONSTRAINT ratings_movie_id_user_id_key UNIQUE (movie_id, user_id);
+ALTER TABLE ratings DROP CONSTRAINT IF EXISTS ratings_user_id_fkey;
+ALTER TABLE ratings ADD CONSTRAINT ratings_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+INSERT INTO permissions (code, description)
+VALUES ('users:admin', 'List and view user accounts');
@@ -0,0 +1,10 @@
+-- Users keep the permissions their roles gave them, as direct grants.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users_roles.user_id, roles_permissions.permission_id
+FROM users_roles
+INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
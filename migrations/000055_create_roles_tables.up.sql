@@ -0,0 +1,43 @@
+-- Roles bundle permission codes, so that users can be given a role rather than each of
+-- its permissions. A user's permissions are the ones they hold directly plus those of
+-- their roles.
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS users_roles_role_id_idx ON users_roles (role_id);
+
+INSERT INTO roles (name, description)
+VALUES
+    ('admin', 'Operates the service and manages user accounts'),
+    ('moderator', 'Curates the movie catalog'),
+    ('member', 'Browses the movie catalog');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name, permissions.code) IN (
+    ('admin', 'movies:read'),
+    ('admin', 'movies:write'),
+    ('admin', 'webhooks:manage'),
+    ('admin', 'admin:read'),
+    ('admin', 'admin:write'),
+    ('admin', 'users:admin'),
+    ('moderator', 'movies:read'),
+    ('moderator', 'movies:write'),
+    ('member', 'movies:read')
+);
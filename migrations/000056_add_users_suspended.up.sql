@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended bool NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE security_events DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1 @@
+ALTER TABLE security_events ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '';
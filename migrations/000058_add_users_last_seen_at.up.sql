@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at timestamp(0) with time zone;
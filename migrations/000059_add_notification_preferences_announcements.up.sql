@@ -0,0 +1 @@
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS announcements boolean NOT NULL DEFAULT true;
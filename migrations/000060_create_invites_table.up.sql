@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS invites (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- The admin who created the invite.
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    hash bytea UNIQUE NOT NULL,
+    key_version integer NOT NULL,
+    note text NOT NULL DEFAULT '',
+    expiry timestamp(0) with time zone NOT NULL
+);
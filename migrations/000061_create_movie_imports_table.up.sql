@@ -0,0 +1,7 @@
+-- Files uploaded to POST /v1/admin/movies/import, kept until the job which imports
+-- them has finished.
+CREATE TABLE IF NOT EXISTS movie_imports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    file bytea NOT NULL
+);
@@ -0,0 +1,9 @@
+-- Daily totals of the API's usage, rolled up from api_usage_endpoints by a scheduled
+-- job. Unlike the per-user rows they're made from, they're kept when users are deleted.
+CREATE TABLE IF NOT EXISTS metrics_daily (
+    day date PRIMARY KEY,
+    requests bigint NOT NULL,
+    errors bigint NOT NULL,
+    active_users bigint NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
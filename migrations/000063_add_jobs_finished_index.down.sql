@@ -0,0 +1 @@
+DROP INDEX IF EXISTS jobs_finished_updated_at_idx;
@@ -0,0 +1,3 @@
+-- Finished jobs are pruned by age, so the scheduled delete doesn't have to scan the
+-- whole table.
+CREATE INDEX IF NOT EXISTS jobs_finished_updated_at_idx ON jobs (updated_at) WHERE status IN ('completed', 'dead');
@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS job_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS email_index;
+ALTER TABLE tokens DROP COLUMN IF EXISTS email_encrypted;
@@ -0,0 +1,8 @@
+-- When PII encryption is enabled, the address an email_change token confirms is stored
+-- encrypted, with a blind index, like users' email addresses.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS email_encrypted bytea;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS email_index bytea;
+
+-- Tokens which are emailed to users belong to the job which sends them, which gives
+-- the token a new plaintext on each attempt rather than creating another token.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS job_id bigint UNIQUE;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS shared_watchlist_movies;
+DROP TABLE IF EXISTS shared_watchlist_members;
+DROP TABLE IF EXISTS shared_watchlists;
@@ -0,0 +1,32 @@
+-- Watchlists which belong to a group of users, as opposed to each user's own
+-- watchlist. They hold movies from one organization's catalog.
+CREATE TABLE IF NOT EXISTS shared_watchlists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS shared_watchlists_organization_id_idx ON shared_watchlists (organization_id);
+
+-- Members who have been invited but haven't joined yet have no joined_at.
+CREATE TABLE IF NOT EXISTS shared_watchlist_members (
+    watchlist_id bigint NOT NULL REFERENCES shared_watchlists ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL,
+    invited_by bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    joined_at timestamp(0) with time zone,
+    PRIMARY KEY (watchlist_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS shared_watchlist_members_user_id_idx ON shared_watchlist_members (user_id);
+
+CREATE TABLE IF NOT EXISTS shared_watchlist_movies (
+    watchlist_id bigint NOT NULL REFERENCES shared_watchlists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_by bigint REFERENCES users ON DELETE SET NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (watchlist_id, movie_id)
+);
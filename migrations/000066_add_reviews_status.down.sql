@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'reviews:moderate';
+DROP INDEX IF EXISTS reviews_pending_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS status;
@@ -0,0 +1,14 @@
+-- Reviews are held for moderation until they're approved. The reviews which already
+-- exist were shown before moderation was added, so they start out approved.
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved';
+ALTER TABLE reviews ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE INDEX IF NOT EXISTS reviews_pending_idx ON reviews (created_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code, description)
+VALUES ('reviews:moderate', 'Approve and reject reviews before they are shown');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('admin', 'moderator') AND permissions.code = 'reviews:moderate';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS reviews_reported_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS report_count;
+DROP TABLE IF EXISTS review_reports;
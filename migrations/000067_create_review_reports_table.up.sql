@@ -0,0 +1,18 @@
+-- Users report reviews which break the rules. A review's report_count is the number of
+-- its reports which haven't been dismissed, which happens when a moderator approves
+-- it. Dismissed reports are kept, so that the same user can't report it again, and
+-- reports outlive the users who made them so that the count stays right.
+CREATE TABLE IF NOT EXISTS review_reports (
+    id bigserial PRIMARY KEY,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    reason text NOT NULL,
+    details text NOT NULL DEFAULT '',
+    dismissed_at timestamp(0) with time zone,
+    UNIQUE (review_id, user_id)
+);
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS report_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS reviews_reported_idx ON reviews (report_count) WHERE report_count > 0;
@@ -0,0 +1,7 @@
+-- Files made by the jobs started with POST /v1/exports/movies, kept for download until
+-- the job itself is pruned.
+CREATE TABLE IF NOT EXISTS movie_exports (
+    job_id bigint PRIMARY KEY REFERENCES jobs ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    file bytea NOT NULL
+);